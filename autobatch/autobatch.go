@@ -161,6 +161,16 @@ func (d *Datastore) Query(ctx context.Context, q dsq.Query) (dsq.Results, error)
 	return d.child.Query(ctx, q)
 }
 
+// Children implements ds.Shim
+func (d *Datastore) Children() []ds.Datastore {
+	return []ds.Datastore{d.child}
+}
+
+// Capabilities implements ds.Capable
+func (d *Datastore) Capabilities() ds.Feature {
+	return ds.Features(d.child) & ds.FeaturePersistent
+}
+
 // DiskUsage implements the PersistentDatastore interface.
 func (d *Datastore) DiskUsage(ctx context.Context) (uint64, error) {
 	return ds.DiskUsage(ctx, d.child)
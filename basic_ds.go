@@ -6,7 +6,9 @@
 package datastore
 
 import (
+	"bytes"
 	"context"
+	"io"
 	"log"
 
 	"github.com/daotl/go-datastore/key"
@@ -19,6 +21,9 @@ import (
 type MapDatastore struct {
 	ktype  key.KeyType
 	values map[string][]byte
+	// shared is set when values are shared with a snapshot, they must then
+	// be copied before being modified, see writable.
+	shared bool
 }
 
 // NewMapDatastore constructs a MapDatastore. It is _not_ thread-safe by
@@ -36,7 +41,7 @@ func NewMapDatastore(ktype key.KeyType) (d *MapDatastore, err error) {
 
 // Put implements Datastore.Put
 func (d *MapDatastore) Put(ctx context.Context, key key.Key, value []byte) (err error) {
-	d.values[key.String()] = value
+	d.writable()[key.String()] = value
 	return nil
 }
 
@@ -70,10 +75,37 @@ func (d *MapDatastore) GetSize(ctx context.Context, key key.Key) (size int, err
 
 // Delete implements Datastore.Delete
 func (d *MapDatastore) Delete(ctx context.Context, key key.Key) (err error) {
-	delete(d.values, key.String())
+	delete(d.writable(), key.String())
 	return nil
 }
 
+// PutIfAbsent implements CAS.PutIfAbsent
+func (d *MapDatastore) PutIfAbsent(ctx context.Context, key key.Key, value []byte) (ok bool, err error) {
+	if _, found := d.values[key.String()]; found {
+		return false, nil
+	}
+	d.writable()[key.String()] = value
+	return true, nil
+}
+
+// CompareAndSwap implements CAS.CompareAndSwap
+func (d *MapDatastore) CompareAndSwap(ctx context.Context, key key.Key, old, new []byte) (ok bool, err error) {
+	if v, found := d.values[key.String()]; !found || !bytes.Equal(v, old) {
+		return false, nil
+	}
+	d.writable()[key.String()] = new
+	return true, nil
+}
+
+// DeleteIfEqual implements CAS.DeleteIfEqual
+func (d *MapDatastore) DeleteIfEqual(ctx context.Context, key key.Key, value []byte) (ok bool, err error) {
+	if v, found := d.values[key.String()]; !found || !bytes.Equal(v, value) {
+		return false, nil
+	}
+	delete(d.writable(), key.String())
+	return true, nil
+}
+
 // Query implements Datastore.Query
 func (d *MapDatastore) Query(ctx context.Context, q dsq.Query) (dsq.Results, error) {
 	re := make([]dsq.Entry, 0, len(d.values))
@@ -111,6 +143,8 @@ func (d *MapDatastore) Close() error {
 type NullDatastore struct {
 }
 
+var _ Batching = (*NullDatastore)(nil)
+
 // NewNullDatastore constructs a null datastoe
 func NewNullDatastore() *NullDatastore {
 	return &NullDatastore{}
@@ -151,7 +185,7 @@ func (d *NullDatastore) Query(ctx context.Context, q dsq.Query) (dsq.Results, er
 	return dsq.ResultsWithEntries(q, nil), nil
 }
 
-func (d *NullDatastore) Batch() (Batch, error) {
+func (d *NullDatastore) Batch(ctx context.Context) (Batch, error) {
 	return NewBasicBatch(d), nil
 }
 
@@ -159,7 +193,8 @@ func (d *NullDatastore) Close() error {
 	return nil
 }
 
-// LogDatastore logs all accesses through the datastore.
+// LogDatastore logs all accesses through the datastore. See the logging
+// package for structured, leveled logging.
 type LogDatastore struct {
 	Name  string
 	child Datastore
@@ -185,6 +220,11 @@ func (d *LogDatastore) Children() []Datastore {
 	return []Datastore{d.child}
 }
 
+// Capabilities implements Capable
+func (d *LogDatastore) Capabilities() Feature {
+	return Features(d.child) & (FeatureBatching | FeatureMaintenance | FeatureStreaming | FeatureBulk)
+}
+
 // Put implements Datastore.Put
 func (d *LogDatastore) Put(ctx context.Context, key key.Key, value []byte) (err error) {
 	log.Printf("%s: Put %s\n", d.Name, key)
@@ -222,6 +262,42 @@ func (d *LogDatastore) Delete(ctx context.Context, key key.Key) (err error) {
 	return d.child.Delete(ctx, key)
 }
 
+// GetReader implements StreamingRead.GetReader
+func (d *LogDatastore) GetReader(ctx context.Context, key key.Key) (io.ReadCloser, error) {
+	log.Printf("%s: GetReader %s\n", d.Name, key)
+	return GetReader(ctx, d.child, key)
+}
+
+// PutReader implements StreamingWrite.PutReader
+func (d *LogDatastore) PutReader(ctx context.Context, key key.Key, r io.Reader) error {
+	log.Printf("%s: PutReader %s\n", d.Name, key)
+	return PutReader(ctx, d.child, key, r)
+}
+
+// GetMany implements BulkRead.GetMany
+func (d *LogDatastore) GetMany(ctx context.Context, keys []key.Key) ([]ValueResult, error) {
+	log.Printf("%s: GetMany %d keys\n", d.Name, len(keys))
+	return GetMany(ctx, d.child, keys)
+}
+
+// HasMany implements BulkRead.HasMany
+func (d *LogDatastore) HasMany(ctx context.Context, keys []key.Key) ([]HasResult, error) {
+	log.Printf("%s: HasMany %d keys\n", d.Name, len(keys))
+	return HasMany(ctx, d.child, keys)
+}
+
+// GetSizeMany implements BulkRead.GetSizeMany
+func (d *LogDatastore) GetSizeMany(ctx context.Context, keys []key.Key) ([]SizeResult, error) {
+	log.Printf("%s: GetSizeMany %d keys\n", d.Name, len(keys))
+	return GetSizeMany(ctx, d.child, keys)
+}
+
+// PutMany implements BulkWrite.PutMany
+func (d *LogDatastore) PutMany(ctx context.Context, keys []key.Key, values [][]byte) error {
+	log.Printf("%s: PutMany %d keys\n", d.Name, len(keys))
+	return PutMany(ctx, d.child, keys, values)
+}
+
 // DiskUsage implements the PersistentDatastore interface.
 func (d *LogDatastore) DiskUsage(ctx context.Context) (uint64, error) {
 	log.Printf("%s: DiskUsage\n", d.Name)
@@ -0,0 +1,381 @@
+// Copyright (c) 2020 DAOT Labs. All rights reserved. Use of this source
+// code is governed by MIT license that can be found in the LICENSE file.
+
+// Package bloom provides a datastore wrapper which keeps a Bloom filter of
+// the keys of its child, to answer the Has, Get and GetSize misses without
+// reaching the child.
+package bloom
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+
+	ds "github.com/daotl/go-datastore"
+	key "github.com/daotl/go-datastore/key"
+	dsq "github.com/daotl/go-datastore/query"
+)
+
+// DefaultExpectedKeys is the default number of keys the filter is sized
+// for.
+const DefaultExpectedKeys = 1 << 20
+
+// DefaultFalsePositiveRate is the default target false positive rate of the
+// filter.
+const DefaultFalsePositiveRate = 0.01
+
+// Options are the options of a Bloom filter datastore.
+type Options struct {
+	// ExpectedKeys is the number of keys the filter is sized for,
+	// DefaultExpectedKeys if it's zero. The filter is sized for twice the
+	// number of keys found by Rebuild when there are more keys than that.
+	ExpectedKeys uint64
+	// FalsePositiveRate is the target false positive rate of the filter
+	// with ExpectedKeys keys, DefaultFalsePositiveRate if it's zero.
+	FalsePositiveRate float64
+}
+
+// Stats are the statistics of a Bloom filter datastore.
+type Stats struct {
+	// Keys is the number of additions to the filter, which counts the keys
+	// put again and the deleted keys until the filter is rebuilt. Bits is
+	// its size and Hashes its number of hash functions.
+	Keys   uint64
+	Bits   uint64
+	Hashes int
+	// TargetFalsePositiveRate is Options.FalsePositiveRate, and
+	// EstimatedFalsePositiveRate the current rate estimated from the number
+	// of bits set.
+	TargetFalsePositiveRate    float64
+	EstimatedFalsePositiveRate float64
+	// Lookups counts the keys looked up in the filter, Negatives the
+	// lookups answered by the filter alone, and FalsePositives the lookups
+	// passed to the child which didn't find the key.
+	Lookups        uint64
+	Negatives      uint64
+	FalsePositives uint64
+}
+
+// Datastore wraps a datastore with a Bloom filter of its keys. The keys are
+// added to the filter by Put and batches, but never removed: the filter is
+// rebuilt by Rebuild, which should be called from time to time if keys are
+// deleted.
+//
+// The wrapper must be the only writer of the child, or the filter must be
+// rebuilt after writes through other paths.
+type Datastore struct {
+	child ds.Datastore
+	opts  Options
+
+	// rebuildLk serializes Rebuild, so that there's a single next filter.
+	rebuildLk sync.Mutex
+	// built is the number of keys found by the last build, guarded by
+	// rebuildLk.
+	built uint64
+
+	lk     sync.RWMutex
+	filter *filter
+	// next is the filter being rebuilt, the keys put meanwhile are added to
+	// both.
+	next *filter
+
+	lookups, negatives, falsePositives uint64
+}
+
+var _ ds.Batching = (*Datastore)(nil)
+var _ ds.PersistentDatastore = (*Datastore)(nil)
+var _ ds.CheckedDatastore = (*Datastore)(nil)
+var _ ds.ScrubbedDatastore = (*Datastore)(nil)
+var _ ds.GCDatastore = (*Datastore)(nil)
+var _ ds.Shim = (*Datastore)(nil)
+
+func wrap(child ds.Datastore, opts Options) *Datastore {
+	if child == nil {
+		panic("child (ds.Datastore) is nil")
+	}
+	if opts.ExpectedKeys == 0 {
+		opts.ExpectedKeys = DefaultExpectedKeys
+	}
+	if opts.FalsePositiveRate <= 0 || opts.FalsePositiveRate >= 1 {
+		opts.FalsePositiveRate = DefaultFalsePositiveRate
+	}
+	return &Datastore{child: child, opts: opts}
+}
+
+// New wraps the given datastore with a Bloom filter built from a KeysOnly
+// query of all its keys.
+func New(ctx context.Context, child ds.Datastore, opts Options) (*Datastore, error) {
+	d := wrap(child, opts)
+	if err := d.Rebuild(ctx); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Load wraps the given datastore with a Bloom filter loaded from a snapshot
+// written by WriteTo. The snapshot must have been written after the last
+// write to the child.
+func Load(child ds.Datastore, r io.Reader, opts Options) (*Datastore, error) {
+	d := wrap(child, opts)
+	f, err := readFilter(r)
+	if err != nil {
+		return nil, err
+	}
+	d.filter = f
+	return d, nil
+}
+
+// WriteTo writes a snapshot of the filter to `w`, which can be loaded with
+// Load.
+func (d *Datastore) WriteTo(w io.Writer) (int64, error) {
+	d.lk.RLock()
+	defer d.lk.RUnlock()
+	return d.filter.writeTo(w)
+}
+
+// Rebuild rebuilds the filter from a KeysOnly query of all the keys of the
+// child, to drop the deleted keys. The datastore can be used meanwhile,
+// concurrent calls to Rebuild wait for each other.
+//
+// The filter is sized for the number of keys found by the previous build.
+// If the query finds more keys than that, it's built again for twice the
+// number of keys found.
+func (d *Datastore) Rebuild(ctx context.Context) error {
+	d.rebuildLk.Lock()
+	defer d.rebuildLk.Unlock()
+
+	n := d.opts.ExpectedKeys
+	if d.built > n {
+		n = 2 * d.built
+	}
+	for {
+		d.lk.Lock()
+		next := newFilter(n, d.opts.FalsePositiveRate)
+		if d.filter == nil {
+			// Nothing is filtered until the first build is done.
+			d.filter = next
+		}
+		d.next = next
+		d.lk.Unlock()
+
+		count, err := d.build(ctx, next)
+
+		d.lk.Lock()
+		d.next = nil
+		if err != nil {
+			d.lk.Unlock()
+			return err
+		}
+		d.filter = next
+		d.lk.Unlock()
+		d.built = count
+		if count <= n {
+			return nil
+		}
+		n = 2 * count
+	}
+}
+
+// build adds the keys of the child to `f` and returns their number.
+func (d *Datastore) build(ctx context.Context, f *filter) (uint64, error) {
+	res, err := d.child.Query(ctx, dsq.Query{KeysOnly: true})
+	if err != nil {
+		return 0, err
+	}
+	defer res.Close()
+	var count uint64
+	for {
+		r, ok := res.NextSync()
+		if !ok {
+			return count, nil
+		}
+		if r.Error != nil {
+			return count, r.Error
+		}
+		d.lk.Lock()
+		f.add(r.Key.Bytes())
+		d.lk.Unlock()
+		count++
+	}
+}
+
+// Stats returns the statistics of the filter.
+func (d *Datastore) Stats() Stats {
+	d.lk.RLock()
+	defer d.lk.RUnlock()
+	return Stats{
+		Keys:                       d.filter.n,
+		Bits:                       d.filter.m,
+		Hashes:                     int(d.filter.k),
+		TargetFalsePositiveRate:    d.opts.FalsePositiveRate,
+		EstimatedFalsePositiveRate: d.filter.falsePositiveRate(),
+		Lookups:                    atomic.LoadUint64(&d.lookups),
+		Negatives:                  atomic.LoadUint64(&d.negatives),
+		FalsePositives:             atomic.LoadUint64(&d.falsePositives),
+	}
+}
+
+// mayContain returns whether the child may contain the key.
+func (d *Datastore) mayContain(k key.Key) bool {
+	atomic.AddUint64(&d.lookups, 1)
+	d.lk.RLock()
+	// The first build isn't done yet.
+	ok := d.filter == d.next || d.filter.mayContain(k.Bytes())
+	d.lk.RUnlock()
+	if !ok {
+		atomic.AddUint64(&d.negatives, 1)
+	}
+	return ok
+}
+
+func (d *Datastore) add(k key.Key) {
+	d.lk.Lock()
+	defer d.lk.Unlock()
+	d.filter.add(k.Bytes())
+	if d.next != nil && d.next != d.filter {
+		d.next.add(k.Bytes())
+	}
+}
+
+// miss records a lookup of the child which didn't find a key.
+func (d *Datastore) miss() {
+	atomic.AddUint64(&d.falsePositives, 1)
+}
+
+// Children implements Shim
+func (d *Datastore) Children() []ds.Datastore {
+	return []ds.Datastore{d.child}
+}
+
+// Capabilities implements Capable
+func (d *Datastore) Capabilities() ds.Feature {
+	return ds.FeatureBatching | ds.Features(d.child)&ds.FeatureMaintenance
+}
+
+// Get implements Datastore.Get
+func (d *Datastore) Get(ctx context.Context, key key.Key) (value []byte, err error) {
+	if !d.mayContain(key) {
+		return nil, ds.ErrNotFound
+	}
+	value, err = d.child.Get(ctx, key)
+	if ds.IsNotFound(err) {
+		d.miss()
+	}
+	return value, err
+}
+
+// Has implements Datastore.Has
+func (d *Datastore) Has(ctx context.Context, key key.Key) (exists bool, err error) {
+	if !d.mayContain(key) {
+		return false, nil
+	}
+	exists, err = d.child.Has(ctx, key)
+	if err == nil && !exists {
+		d.miss()
+	}
+	return exists, err
+}
+
+// GetSize implements Datastore.GetSize
+func (d *Datastore) GetSize(ctx context.Context, key key.Key) (size int, err error) {
+	if !d.mayContain(key) {
+		return -1, ds.ErrNotFound
+	}
+	size, err = d.child.GetSize(ctx, key)
+	if ds.IsNotFound(err) {
+		d.miss()
+	}
+	return size, err
+}
+
+// Query implements Datastore.Query
+func (d *Datastore) Query(ctx context.Context, q dsq.Query) (dsq.Results, error) {
+	return d.child.Query(ctx, q)
+}
+
+// Put implements Datastore.Put, the key is added to the filter before it's
+// written so that it's never filtered out.
+func (d *Datastore) Put(ctx context.Context, key key.Key, value []byte) error {
+	d.add(key)
+	return d.child.Put(ctx, key, value)
+}
+
+// Delete implements Datastore.Delete, the key stays in the filter until it's
+// rebuilt.
+func (d *Datastore) Delete(ctx context.Context, key key.Key) error {
+	return d.child.Delete(ctx, key)
+}
+
+// Sync implements Datastore.Sync
+func (d *Datastore) Sync(ctx context.Context, prefix key.Key) error {
+	return d.child.Sync(ctx, prefix)
+}
+
+// Close implements Datastore.Close
+func (d *Datastore) Close() error {
+	return d.child.Close()
+}
+
+// DiskUsage implements the PersistentDatastore interface.
+func (d *Datastore) DiskUsage(ctx context.Context) (uint64, error) {
+	return ds.DiskUsage(ctx, d.child)
+}
+
+// Check implements CheckedDatastore.Check
+func (d *Datastore) Check(ctx context.Context) error {
+	if c, ok := d.child.(ds.CheckedDatastore); ok {
+		return c.Check(ctx)
+	}
+	return nil
+}
+
+// Scrub implements ScrubbedDatastore.Scrub
+func (d *Datastore) Scrub(ctx context.Context) error {
+	if c, ok := d.child.(ds.ScrubbedDatastore); ok {
+		return c.Scrub(ctx)
+	}
+	return nil
+}
+
+// CollectGarbage implements GCDatastore.CollectGarbage
+func (d *Datastore) CollectGarbage(ctx context.Context) error {
+	if c, ok := d.child.(ds.GCDatastore); ok {
+		return c.CollectGarbage(ctx)
+	}
+	return nil
+}
+
+// Batch implements Batching.Batch, the keys put are added to the filter when
+// they're added to the batch. Batches are emulated with ds.NewBasicBatch if
+// the child isn't Batching.
+func (d *Datastore) Batch(ctx context.Context) (ds.Batch, error) {
+	var b ds.Batch
+	if bds, ok := d.child.(ds.Batching); ok {
+		var err error
+		if b, err = bds.Batch(ctx); err != nil {
+			return nil, err
+		}
+	} else {
+		b = ds.NewBasicBatch(d.child)
+	}
+	return &bloomBatch{child: b, d: d}, nil
+}
+
+type bloomBatch struct {
+	child ds.Batch
+	d     *Datastore
+}
+
+func (b *bloomBatch) Put(ctx context.Context, key key.Key, value []byte) error {
+	b.d.add(key)
+	return b.child.Put(ctx, key, value)
+}
+
+func (b *bloomBatch) Delete(ctx context.Context, key key.Key) error {
+	return b.child.Delete(ctx, key)
+}
+
+func (b *bloomBatch) Commit(ctx context.Context) error {
+	return b.child.Commit(ctx)
+}
@@ -0,0 +1,278 @@
+// Copyright (c) 2020 DAOT Labs. All rights reserved. Use of this source
+// code is governed by MIT license that can be found in the LICENSE file.
+
+package bloom
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	ds "github.com/daotl/go-datastore"
+	key "github.com/daotl/go-datastore/key"
+	dssync "github.com/daotl/go-datastore/sync"
+	dstest "github.com/daotl/go-datastore/test"
+)
+
+func testSuite(t *testing.T, ktype key.KeyType) {
+	d, err := New(context.Background(), dstest.NewMapDatastoreForTest(t, ktype), Options{ExpectedKeys: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dstest.SubtestAll(t, ktype, d)
+}
+
+func TestSuite(t *testing.T) {
+	testSuite(t, key.KeyTypeString)
+	testSuite(t, key.KeyTypeBytes)
+}
+
+// countingDatastore counts the reads reaching the child.
+type countingDatastore struct {
+	*ds.MapDatastore
+	reads int
+}
+
+func (d *countingDatastore) Get(ctx context.Context, key key.Key) ([]byte, error) {
+	d.reads++
+	return d.MapDatastore.Get(ctx, key)
+}
+
+func (d *countingDatastore) Has(ctx context.Context, key key.Key) (bool, error) {
+	d.reads++
+	return d.MapDatastore.Has(ctx, key)
+}
+
+func (d *countingDatastore) GetSize(ctx context.Context, key key.Key) (int, error) {
+	d.reads++
+	return d.MapDatastore.GetSize(ctx, key)
+}
+
+func fill(t *testing.T, d ds.Write, n int) {
+	for i := 0; i < n; i++ {
+		if err := d.Put(context.Background(), key.NewStrKey(fmt.Sprintf("/k/%d", i)), []byte("v")); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestShortCircuit(t *testing.T) {
+	ctx := context.Background()
+	child := &countingDatastore{MapDatastore: dstest.NewMapDatastoreForTest(t, key.KeyTypeString)}
+	fill(t, child, 100)
+
+	d, err := New(ctx, child, Options{ExpectedKeys: 1000, FalsePositiveRate: 0.001})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		k := key.NewStrKey(fmt.Sprintf("/k/%d", i))
+		if has, err := d.Has(ctx, k); err != nil || !has {
+			t.Fatalf("expected %s to be found, error: %v", k, err)
+		}
+	}
+	if child.reads != 100 {
+		t.Fatalf("expected 100 reads of the child, got %d", child.reads)
+	}
+
+	child.reads = 0
+	for i := 0; i < 1000; i++ {
+		k := key.NewStrKey(fmt.Sprintf("/missing/%d", i))
+		if has, err := d.Has(ctx, k); err != nil || has {
+			t.Fatalf("expected %s not to be found, error: %v", k, err)
+		}
+		if _, err := d.Get(ctx, k); !ds.IsNotFound(err) {
+			t.Fatal("expected ErrNotFound, got: ", err)
+		}
+		if _, err := d.GetSize(ctx, k); !ds.IsNotFound(err) {
+			t.Fatal("expected ErrNotFound, got: ", err)
+		}
+	}
+	s := d.Stats()
+	if s.Lookups != 3100 || s.Negatives+s.FalsePositives != 3000 {
+		t.Fatalf("unexpected stats: %+v", s)
+	}
+	if uint64(child.reads) != s.FalsePositives || s.FalsePositives > 30 {
+		t.Fatalf("expected few false positives, got %d (%d reads)", s.FalsePositives, child.reads)
+	}
+
+	// Keys put through the wrapper are added to the filter.
+	k := key.NewStrKey("/missing/1")
+	if err := d.Put(ctx, k, []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	b, err := d.Batch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Put(ctx, key.NewStrKey("/missing/2"), []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []string{"/missing/1", "/missing/2"} {
+		if has, err := d.Has(ctx, key.NewStrKey(k)); err != nil || !has {
+			t.Fatalf("expected %s to be found, error: %v", k, err)
+		}
+	}
+}
+
+func TestStats(t *testing.T) {
+	ctx := context.Background()
+	d, err := New(ctx, dstest.NewMapDatastoreForTest(t, key.KeyTypeString), Options{ExpectedKeys: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := d.Stats()
+	if s.Keys != 0 || s.EstimatedFalsePositiveRate != 0 || s.TargetFalsePositiveRate != DefaultFalsePositiveRate {
+		t.Fatalf("unexpected stats: %+v", s)
+	}
+	if s.Bits < 1000*9 || s.Hashes != 7 {
+		t.Fatalf("unexpected filter size: %+v", s)
+	}
+	fill(t, d, 1000)
+	s = d.Stats()
+	if s.Keys != 1000 || s.EstimatedFalsePositiveRate < 0.005 || s.EstimatedFalsePositiveRate > 0.02 {
+		t.Fatalf("unexpected stats: %+v", s)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	ctx := context.Background()
+	child := dstest.NewMapDatastoreForTest(t, key.KeyTypeString)
+	fill(t, child, 100)
+	d, err := New(ctx, child, Options{ExpectedKeys: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	n, err := d.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(buf.Len()) {
+		t.Fatalf("expected %d bytes written, got %d", buf.Len(), n)
+	}
+	snapshot := buf.Bytes()
+
+	l, err := Load(child, bytes.NewReader(snapshot), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.Stats().Keys != 100 || l.Stats().Bits != d.Stats().Bits {
+		t.Fatalf("unexpected stats after load: %+v", l.Stats())
+	}
+	for i := 0; i < 100; i++ {
+		k := key.NewStrKey(fmt.Sprintf("/k/%d", i))
+		if has, err := l.Has(ctx, k); err != nil || !has {
+			t.Fatalf("expected %s to be found, error: %v", k, err)
+		}
+	}
+
+	corrupted := append([]byte(nil), snapshot...)
+	corrupted[len(corrupted)-10] ^= 1
+	if _, err := Load(child, bytes.NewReader(corrupted), Options{}); err != ErrCorrupt {
+		t.Fatal("expected ErrCorrupt loading a corrupted snapshot, got: ", err)
+	}
+	if _, err := Load(child, bytes.NewReader(snapshot[:len(snapshot)/2]), Options{}); err != ErrCorrupt {
+		t.Fatal("expected ErrCorrupt loading a truncated snapshot, got: ", err)
+	}
+	versioned := append([]byte(nil), snapshot...)
+	versioned[len(magic)+1]++
+	if _, err := Load(child, bytes.NewReader(versioned), Options{}); err != ErrVersion {
+		t.Fatal("expected ErrVersion loading a snapshot of another version, got: ", err)
+	}
+}
+
+func TestRebuild(t *testing.T) {
+	ctx := context.Background()
+	child := dstest.NewMapDatastoreForTest(t, key.KeyTypeString)
+	d, err := New(ctx, child, Options{ExpectedKeys: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bits := d.Stats().Bits
+	fill(t, d, 500)
+	for i := 0; i < 500; i++ {
+		if err := d.Delete(ctx, key.NewStrKey(fmt.Sprintf("/k/%d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d.Stats().Keys != 500 {
+		t.Fatalf("expected deleted keys to stay in the filter, got %+v", d.Stats())
+	}
+
+	fill(t, child, 10)
+	if err := d.Rebuild(ctx); err != nil {
+		t.Fatal(err)
+	}
+	s := d.Stats()
+	if s.Keys != 10 || s.Bits != bits {
+		t.Fatalf("unexpected stats after rebuild: %+v", s)
+	}
+	if has, err := d.Has(ctx, key.NewStrKey("/k/5")); err != nil || !has {
+		t.Fatal("expected /k/5 to be found, error: ", err)
+	}
+
+	// Overwrites are counted as additions, but don't grow the filter.
+	for i := 0; i < 1000; i++ {
+		if err := d.Put(ctx, key.NewStrKey("/k/5"), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if s := d.Stats(); s.Keys != 1010 {
+		t.Fatalf("unexpected stats: %+v", s)
+	}
+	if err := d.Rebuild(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if s := d.Stats(); s.Keys != 10 || s.Bits != bits {
+		t.Fatalf("unexpected stats after rebuild: %+v", s)
+	}
+
+	// The filter grows with the keys found.
+	fill(t, d, 500)
+	if err := d.Rebuild(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if s := d.Stats(); s.Keys != 500 || s.Bits < 1000*9 {
+		t.Fatalf("unexpected stats after rebuild: %+v", s)
+	}
+}
+
+func TestConcurrentRebuild(t *testing.T) {
+	ctx := context.Background()
+	d, err := New(ctx, dssync.MutexWrap(dstest.NewMapDatastoreForTest(t, key.KeyTypeString)), Options{ExpectedKeys: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fill(t, d, 100)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := d.Rebuild(ctx); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	for i := 0; i < 200; i++ {
+		if err := d.Put(ctx, key.NewStrKey(fmt.Sprintf("/new/%d", i)), []byte("v")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wg.Wait()
+
+	for i := 0; i < 200; i++ {
+		k := key.NewStrKey(fmt.Sprintf("/new/%d", i))
+		if has, err := d.Has(ctx, k); err != nil || !has {
+			t.Fatalf("expected %s to be found, error: %v", k, err)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+// Copyright (c) 2020 DAOT Labs. All rights reserved. Use of this source
+// code is governed by MIT license that can be found in the LICENSE file.
+
+package bloom
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"hash/fnv"
+	"io"
+	"math"
+	"math/bits"
+)
+
+// Version is the version of the snapshot format written by WriteTo.
+const Version = 1
+
+const magic = "DSBLOOM"
+
+var (
+	// ErrCorrupt is returned when loading a snapshot which is not valid or
+	// whose checksum doesn't match.
+	ErrCorrupt = errors.New("bloom: corrupt snapshot")
+
+	// ErrVersion is returned when loading a snapshot whose format version is
+	// not supported.
+	ErrVersion = errors.New("bloom: unsupported version")
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// maxBits is the maximum size of a filter accepted by readFilter, to avoid
+// allocating huge buffers for corrupt sizes.
+const maxBits = 1 << 36
+
+// filter is a Bloom filter, it isn't safe for concurrent use.
+type filter struct {
+	words  []uint64
+	m      uint64
+	k      uint32
+	n      uint64
+	setBit uint64
+}
+
+// newFilter returns a filter sized for `n` keys with the false positive rate
+// `p`.
+func newFilter(n uint64, p float64) *filter {
+	if n == 0 {
+		n = 1
+	}
+	m := uint64(math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2)))
+	if m < 64 {
+		m = 64
+	}
+	k := uint32(math.Round(float64(m) / float64(n) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+	return &filter{words: make([]uint64, (m+63)/64), m: m, k: k}
+}
+
+// hashes returns the two hashes combined to get the positions of a key.
+func hashes(b []byte) (uint64, uint64) {
+	h := fnv.New128a()
+	h.Write(b)
+	sum := h.Sum(nil)
+	return binary.BigEndian.Uint64(sum[:8]), binary.BigEndian.Uint64(sum[8:]) | 1
+}
+
+func (f *filter) add(b []byte) {
+	h1, h2 := hashes(b)
+	for i := uint32(0); i < f.k; i++ {
+		pos := (h1 + uint64(i)*h2) % f.m
+		w, mask := pos/64, uint64(1)<<(pos%64)
+		if f.words[w]&mask == 0 {
+			f.words[w] |= mask
+			f.setBit++
+		}
+	}
+	f.n++
+}
+
+func (f *filter) mayContain(b []byte) bool {
+	h1, h2 := hashes(b)
+	for i := uint32(0); i < f.k; i++ {
+		pos := (h1 + uint64(i)*h2) % f.m
+		if f.words[pos/64]&(uint64(1)<<(pos%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// falsePositiveRate estimates the current false positive rate from the
+// ratio of bits set.
+func (f *filter) falsePositiveRate() float64 {
+	return math.Pow(float64(f.setBit)/float64(f.m), float64(f.k))
+}
+
+// writeTo writes the filter as:
+//
+//	magic    "DSBLOOM"
+//	version  uint16  big-endian
+//	m        uint64  big-endian number of bits
+//	k        uint32  big-endian number of hashes
+//	n        uint64  big-endian number of keys added
+//	words    m/64 rounded up big-endian uint64
+//	checksum uint32  big-endian CRC-32C of all the above
+func (f *filter) writeTo(w io.Writer) (int64, error) {
+	buf := make([]byte, 0, len(magic)+2+8+4+8+8*len(f.words)+4)
+	buf = append(buf, magic...)
+	buf = appendUint16(buf, Version)
+	buf = appendUint64(buf, f.m)
+	buf = appendUint32(buf, f.k)
+	buf = appendUint64(buf, f.n)
+	for _, word := range f.words {
+		buf = appendUint64(buf, word)
+	}
+	buf = appendUint32(buf, crc32.Checksum(buf, crcTable))
+	n, err := w.Write(buf)
+	return int64(n), err
+}
+
+func readFilter(r io.Reader) (*filter, error) {
+	header := make([]byte, len(magic)+2+8+4+8)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, corrupt(err)
+	}
+	if string(header[:len(magic)]) != magic {
+		return nil, ErrCorrupt
+	}
+	b := header[len(magic):]
+	if binary.BigEndian.Uint16(b) != Version {
+		return nil, ErrVersion
+	}
+	f := &filter{
+		m: binary.BigEndian.Uint64(b[2:]),
+		k: binary.BigEndian.Uint32(b[10:]),
+		n: binary.BigEndian.Uint64(b[14:]),
+	}
+	if f.m == 0 || f.m > maxBits || f.k == 0 {
+		return nil, ErrCorrupt
+	}
+
+	body := make([]byte, 8*((f.m+63)/64)+4)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, corrupt(err)
+	}
+	crc := crc32.Update(crc32.Checksum(header, crcTable), crcTable, body[:len(body)-4])
+	if crc != binary.BigEndian.Uint32(body[len(body)-4:]) {
+		return nil, ErrCorrupt
+	}
+	f.words = make([]uint64, (f.m+63)/64)
+	for i := range f.words {
+		f.words[i] = binary.BigEndian.Uint64(body[8*i:])
+		f.setBit += uint64(bits.OnesCount64(f.words[i]))
+	}
+	return f, nil
+}
+
+func corrupt(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrCorrupt
+	}
+	return err
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], v)
+	return append(b, buf[:]...)
+}
@@ -0,0 +1,146 @@
+// Copyright (c) 2020 DAOT Labs. All rights reserved. Use of this source
+// code is governed by MIT license that can be found in the LICENSE file.
+
+package datastore
+
+import (
+	"context"
+	"errors"
+
+	"github.com/daotl/go-datastore/key"
+)
+
+// ValueResult is the result of getting the value of a single key with
+// GetMany, Error is ErrNotFound if the key is not mapped to a value.
+type ValueResult struct {
+	Value []byte
+	Error error
+}
+
+// HasResult is the result of checking a single key with HasMany.
+type HasResult struct {
+	Exists bool
+	Error  error
+}
+
+// SizeResult is the result of getting the size of a single key with
+// GetSizeMany, Size is -1 and Error is ErrNotFound if the key is not mapped
+// to a value.
+type SizeResult struct {
+	Size  int
+	Error error
+}
+
+// BulkRead is implemented by datastores which can read many keys at once
+// more efficiently than one by one, like remote datastores which can save
+// round trips.
+//
+// The results are in the same order as `keys`. Errors concerning a single key
+// are reported in its result, while a non-nil error means the whole operation
+// failed.
+type BulkRead interface {
+	GetMany(ctx context.Context, keys []key.Key) ([]ValueResult, error)
+	HasMany(ctx context.Context, keys []key.Key) ([]HasResult, error)
+	GetSizeMany(ctx context.Context, keys []key.Key) ([]SizeResult, error)
+}
+
+// BulkWrite is implemented by datastores which can write many keys at once
+// more efficiently than one by one.
+type BulkWrite interface {
+	// PutMany stores the `values` under the `keys` of the same index. The
+	// writes are not atomic unless the datastore documents otherwise.
+	PutMany(ctx context.Context, keys []key.Key, values [][]byte) error
+}
+
+// BulkDatastore is an interface that should be implemented by datastores
+// which support reading and writing many keys at once.
+type BulkDatastore interface {
+	Datastore
+	BulkRead
+	BulkWrite
+}
+
+// ErrBulkLength is returned by PutMany if there is not the same number of
+// keys and values.
+var ErrBulkLength = errors.New("datastore: keys and values have different lengths")
+
+// GetMany calls d.GetMany if `d` is a BulkRead, otherwise it falls back to
+// calling d.Get for each key.
+func GetMany(ctx context.Context, d Read, keys []key.Key) ([]ValueResult, error) {
+	if bd, ok := d.(BulkRead); ok {
+		return bd.GetMany(ctx, keys)
+	}
+	res := make([]ValueResult, len(keys))
+	for i, k := range keys {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		res[i].Value, res[i].Error = d.Get(ctx, k)
+	}
+	return res, nil
+}
+
+// HasMany calls d.HasMany if `d` is a BulkRead, otherwise it falls back to
+// calling d.Has for each key.
+func HasMany(ctx context.Context, d Read, keys []key.Key) ([]HasResult, error) {
+	if bd, ok := d.(BulkRead); ok {
+		return bd.HasMany(ctx, keys)
+	}
+	res := make([]HasResult, len(keys))
+	for i, k := range keys {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		res[i].Exists, res[i].Error = d.Has(ctx, k)
+	}
+	return res, nil
+}
+
+// GetSizeMany calls d.GetSizeMany if `d` is a BulkRead, otherwise it falls
+// back to calling d.GetSize for each key.
+func GetSizeMany(ctx context.Context, d Read, keys []key.Key) ([]SizeResult, error) {
+	if bd, ok := d.(BulkRead); ok {
+		return bd.GetSizeMany(ctx, keys)
+	}
+	res := make([]SizeResult, len(keys))
+	for i, k := range keys {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		res[i].Size, res[i].Error = d.GetSize(ctx, k)
+	}
+	return res, nil
+}
+
+// PutMany calls d.PutMany if `d` is a BulkWrite. Otherwise it writes the
+// values in a batch if `d` is Batching, or calls d.Put for each key.
+func PutMany(ctx context.Context, d Write, keys []key.Key, values [][]byte) error {
+	if len(keys) != len(values) {
+		return ErrBulkLength
+	}
+	if bd, ok := d.(BulkWrite); ok {
+		return bd.PutMany(ctx, keys, values)
+	}
+
+	w := d
+	var b Batch
+	if bds, ok := d.(Batching); ok {
+		var err error
+		switch b, err = bds.Batch(ctx); {
+		case err == nil:
+			w = b
+		case errors.Is(err, ErrBatchUnsupported):
+		default:
+			return err
+		}
+	}
+	for i, k := range keys {
+		if err := w.Put(ctx, k, values[i]); err != nil {
+			return err
+		}
+	}
+	if b != nil {
+		return b.Commit(ctx)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+// Copyright (c) 2020 DAOT Labs. All rights reserved. Use of this source
+// code is governed by MIT license that can be found in the LICENSE file.
+
+package datastore_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	dstore "github.com/daotl/go-datastore"
+	"github.com/daotl/go-datastore/key"
+)
+
+func TestBulkHelpers(t *testing.T) {
+	ctx := context.Background()
+	ds := newTxnMapDatastore(t, key.KeyTypeBytes)
+
+	keys := []key.Key{key.NewBytesKeyFromString("a"), key.NewBytesKeyFromString("b")}
+	if err := dstore.PutMany(ctx, ds, keys, [][]byte{[]byte("1")}); err != dstore.ErrBulkLength {
+		t.Fatal("expected ErrBulkLength, got: ", err)
+	}
+	if err := dstore.PutMany(ctx, ds, keys[:1], [][]byte{[]byte("1")}); err != nil {
+		t.Fatal(err)
+	}
+
+	vres, err := dstore.GetMany(ctx, ds, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vres[0].Error != nil || string(vres[0].Value) != "1" || !dstore.IsNotFound(vres[1].Error) {
+		t.Fatalf("unexpected results: %v", vres)
+	}
+	hres, err := dstore.HasMany(ctx, ds, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !hres[0].Exists || hres[1].Exists {
+		t.Fatalf("unexpected results: %v", hres)
+	}
+	sres, err := dstore.GetSizeMany(ctx, ds, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sres[0].Size != 1 || sres[1].Size != -1 || !dstore.IsNotFound(sres[1].Error) {
+		t.Fatalf("unexpected results: %v", sres)
+	}
+
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := dstore.GetMany(cctx, ds, keys); err != context.Canceled {
+		t.Fatal("expected context.Canceled, got: ", err)
+	}
+}
+
+// wrappedBatchless is a Batching datastore which returns a wrapped
+// ErrBatchUnsupported from Batch.
+type wrappedBatchless struct {
+	dstore.Datastore
+}
+
+func (wrappedBatchless) Batch(ctx context.Context) (dstore.Batch, error) {
+	return nil, fmt.Errorf("no batches: %w", dstore.ErrBatchUnsupported)
+}
+
+func TestPutManyWithoutBatch(t *testing.T) {
+	ctx := context.Background()
+	ds := wrappedBatchless{newTxnMapDatastore(t, key.KeyTypeBytes)}
+
+	keys := []key.Key{key.NewBytesKeyFromString("a"), key.NewBytesKeyFromString("b")}
+	if err := dstore.PutMany(ctx, ds, keys, [][]byte{[]byte("1"), []byte("2")}); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := ds.Get(ctx, keys[1]); err != nil || string(v) != "2" {
+		t.Fatalf("unexpected value %q, error: %v", v, err)
+	}
+}
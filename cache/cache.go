@@ -0,0 +1,461 @@
+// Copyright (c) 2020 DAOT Labs. All rights reserved. Use of this source
+// code is governed by MIT license that can be found in the LICENSE file.
+
+// Package cache provides a read-through caching datastore wrapper bounded
+// by a number of entries and bytes, with the LRU or ARC eviction policy.
+package cache
+
+import (
+	"context"
+	"sync"
+
+	ds "github.com/daotl/go-datastore"
+	key "github.com/daotl/go-datastore/key"
+	dsq "github.com/daotl/go-datastore/query"
+)
+
+// DefaultMaxEntries is the maximum number of cached entries if neither
+// Options.MaxEntries nor Options.MaxBytes is set.
+const DefaultMaxEntries = 1024
+
+// WriteMode is how a cache handles writes.
+type WriteMode int
+
+const (
+	// WriteThrough caches the values written.
+	WriteThrough WriteMode = iota
+	// Invalidate removes the keys written from the cache, the values are
+	// cached on the next read.
+	Invalidate
+)
+
+// Options are the options of a cache.
+type Options struct {
+	// MaxEntries and MaxBytes bound the number of cached entries and the
+	// sum of the sizes of their keys and values, 0 is unlimited. MaxEntries
+	// is DefaultMaxEntries if both are 0. Values larger than MaxBytes are not
+	// cached.
+	MaxEntries int
+	MaxBytes   int64
+
+	// Policy is the eviction policy, LRU by default.
+	Policy Policy
+
+	// WriteMode is how writes are handled, WriteThrough by default.
+	WriteMode WriteMode
+
+	// NegativeCache, if set, also caches the keys which are not found.
+	NegativeCache bool
+}
+
+// Stats are the statistics of a cache.
+type Stats struct {
+	// Hits and Misses count the reads served from the cache or from the
+	// child, including the negative hits.
+	Hits   uint64
+	Misses uint64
+	// NegativeHits count the hits of keys cached as not found.
+	NegativeHits uint64
+	// Evictions count the entries evicted to meet the limits.
+	Evictions uint64
+	// Entries and Bytes are the current number of cached entries and the
+	// sum of the sizes of their keys and values.
+	Entries int
+	Bytes   int64
+}
+
+// Datastore is a read-through cache over a child datastore. Get, Has and
+// GetSize are served from the cache, queries always go to the child.
+//
+// It's safe for concurrent use if the child is. The cache must be the only
+// writer of the child, or the writes through other paths must be followed by
+// Invalidate or Purge.
+//
+// Values with an expiration are cached past it, stack a TTL datastore on
+// top of the cache rather than below it.
+type Datastore struct {
+	child ds.Datastore
+	opts  Options
+
+	lk    sync.Mutex
+	store store
+	stats Stats
+	// gen is incremented by each write, values read from the child are not
+	// cached if a write happened meanwhile as they may be stale.
+	gen uint64
+}
+
+var _ ds.Batching = (*Datastore)(nil)
+var _ ds.TxnDatastore = (*Datastore)(nil)
+var _ ds.PersistentDatastore = (*Datastore)(nil)
+var _ ds.CheckedDatastore = (*Datastore)(nil)
+var _ ds.ScrubbedDatastore = (*Datastore)(nil)
+var _ ds.GCDatastore = (*Datastore)(nil)
+var _ ds.CASDatastore = (*Datastore)(nil)
+var _ ds.SnapshotDatastore = (*Datastore)(nil)
+var _ ds.WatchDatastore = (*Datastore)(nil)
+var _ ds.Shim = (*Datastore)(nil)
+
+// Wrap wraps the given datastore with a cache.
+func Wrap(child ds.Datastore, opts Options) *Datastore {
+	if child == nil {
+		panic("child (ds.Datastore) is nil")
+	}
+	if opts.MaxEntries <= 0 && opts.MaxBytes <= 0 {
+		opts.MaxEntries = DefaultMaxEntries
+	}
+	return &Datastore{
+		child: child,
+		opts:  opts,
+		store: newStore(opts.Policy, limits{maxEntries: opts.MaxEntries, maxBytes: opts.MaxBytes}),
+	}
+}
+
+// Stats returns the statistics of the cache.
+func (d *Datastore) Stats() Stats {
+	d.lk.Lock()
+	defer d.lk.Unlock()
+	s := d.stats
+	s.Entries = d.store.len()
+	s.Bytes = d.store.bytes()
+	return s
+}
+
+// Invalidate removes the given key from the cache.
+func (d *Datastore) Invalidate(k key.Key) {
+	d.lk.Lock()
+	defer d.lk.Unlock()
+	d.gen++
+	d.store.remove(k.String())
+}
+
+// Purge removes all the entries from the cache.
+func (d *Datastore) Purge() {
+	d.lk.Lock()
+	defer d.lk.Unlock()
+	d.gen++
+	d.store.clear()
+}
+
+// lookup returns the cached entry of a key, and the generation to pass to
+// fill on a miss.
+func (d *Datastore) lookup(k key.Key) (*entry, uint64) {
+	d.lk.Lock()
+	defer d.lk.Unlock()
+	e, ok := d.store.get(k.String())
+	if !ok {
+		d.stats.Misses++
+		return nil, d.gen
+	}
+	d.stats.Hits++
+	if e.notFound {
+		d.stats.NegativeHits++
+	}
+	return e, 0
+}
+
+// fill caches a value read from the child if no write happened since
+// generation `gen`. `err` is the error of the read.
+func (d *Datastore) fill(gen uint64, k key.Key, value []byte, err error) {
+	if err != nil && !(ds.IsNotFound(err) && d.opts.NegativeCache) {
+		return
+	}
+	d.lk.Lock()
+	defer d.lk.Unlock()
+	if gen != d.gen {
+		return
+	}
+	d.addLocked(&entry{key: k.String(), value: value, notFound: err != nil})
+}
+
+// addLocked caches an entry, the caller must hold d.lk.
+func (d *Datastore) addLocked(e *entry) {
+	if d.opts.MaxBytes > 0 && e.size() > d.opts.MaxBytes {
+		d.store.remove(e.key)
+		return
+	}
+	d.stats.Evictions += uint64(d.store.add(e))
+}
+
+// written updates the cache after writes to the child, `values` are nil for
+// deletions. If `err` is not nil, the writes may have partially failed and
+// the keys are invalidated.
+func (d *Datastore) written(err error, keys []key.Key, values [][]byte) {
+	d.lk.Lock()
+	defer d.lk.Unlock()
+	d.gen++
+	for i, k := range keys {
+		switch {
+		case err != nil || d.opts.WriteMode == Invalidate:
+			d.store.remove(k.String())
+		case values[i] != nil:
+			d.addLocked(&entry{key: k.String(), value: values[i]})
+		case d.opts.NegativeCache:
+			d.addLocked(&entry{key: k.String(), notFound: true})
+		default:
+			d.store.remove(k.String())
+		}
+	}
+}
+
+// Children implements Shim
+func (d *Datastore) Children() []ds.Datastore {
+	return []ds.Datastore{d.child}
+}
+
+// Capabilities implements Capable
+func (d *Datastore) Capabilities() ds.Feature {
+	return ds.FeatureBatching | ds.Features(d.child)&(ds.FeatureTxn|
+		ds.FeatureMaintenance|ds.FeatureSnapshot|ds.FeatureWatch) | ds.CASFeature(d.child)
+}
+
+// Get implements Datastore.Get
+func (d *Datastore) Get(ctx context.Context, key key.Key) (value []byte, err error) {
+	e, gen := d.lookup(key)
+	if e != nil {
+		if e.notFound {
+			return nil, ds.ErrNotFound
+		}
+		return e.value, nil
+	}
+	value, err = d.child.Get(ctx, key)
+	d.fill(gen, key, value, err)
+	return value, err
+}
+
+// Has implements Datastore.Has
+func (d *Datastore) Has(ctx context.Context, key key.Key) (exists bool, err error) {
+	e, gen := d.lookup(key)
+	if e != nil {
+		return !e.notFound, nil
+	}
+	exists, err = d.child.Has(ctx, key)
+	if err == nil && !exists {
+		d.fill(gen, key, nil, ds.ErrNotFound)
+	}
+	return exists, err
+}
+
+// GetSize implements Datastore.GetSize
+func (d *Datastore) GetSize(ctx context.Context, key key.Key) (size int, err error) {
+	e, gen := d.lookup(key)
+	if e != nil {
+		if e.notFound {
+			return -1, ds.ErrNotFound
+		}
+		return len(e.value), nil
+	}
+	size, err = d.child.GetSize(ctx, key)
+	if ds.IsNotFound(err) {
+		d.fill(gen, key, nil, err)
+	}
+	return size, err
+}
+
+// Query implements Datastore.Query
+func (d *Datastore) Query(ctx context.Context, q dsq.Query) (dsq.Results, error) {
+	return d.child.Query(ctx, q)
+}
+
+// Put implements Datastore.Put
+func (d *Datastore) Put(ctx context.Context, k key.Key, value []byte) error {
+	err := d.child.Put(ctx, k, value)
+	if value == nil {
+		value = []byte{}
+	}
+	d.written(err, []key.Key{k}, [][]byte{value})
+	return err
+}
+
+// Delete implements Datastore.Delete
+func (d *Datastore) Delete(ctx context.Context, k key.Key) error {
+	err := d.child.Delete(ctx, k)
+	d.written(err, []key.Key{k}, [][]byte{nil})
+	return err
+}
+
+// Sync implements Datastore.Sync
+func (d *Datastore) Sync(ctx context.Context, prefix key.Key) error {
+	return d.child.Sync(ctx, prefix)
+}
+
+// Close implements Datastore.Close
+func (d *Datastore) Close() error {
+	d.Purge()
+	return d.child.Close()
+}
+
+// PutIfAbsent implements CAS.PutIfAbsent
+func (d *Datastore) PutIfAbsent(ctx context.Context, key key.Key, value []byte) (bool, error) {
+	ok, err := ds.PutIfAbsent(ctx, d.child, key, value)
+	d.Invalidate(key)
+	return ok, err
+}
+
+// CompareAndSwap implements CAS.CompareAndSwap
+func (d *Datastore) CompareAndSwap(ctx context.Context, key key.Key, old, new []byte) (bool, error) {
+	ok, err := ds.CompareAndSwap(ctx, d.child, key, old, new)
+	d.Invalidate(key)
+	return ok, err
+}
+
+// DeleteIfEqual implements CAS.DeleteIfEqual
+func (d *Datastore) DeleteIfEqual(ctx context.Context, key key.Key, value []byte) (bool, error) {
+	ok, err := ds.DeleteIfEqual(ctx, d.child, key, value)
+	d.Invalidate(key)
+	return ok, err
+}
+
+// Snapshot implements SnapshotDatastore.Snapshot, snapshots are read from
+// the child.
+func (d *Datastore) Snapshot(ctx context.Context) (ds.Snapshot, error) {
+	return ds.NewSnapshot(ctx, d.child)
+}
+
+// Watch implements Watcher.Watch
+func (d *Datastore) Watch(ctx context.Context, prefix key.Key) (<-chan ds.Event, error) {
+	return ds.Watch(ctx, d.child, prefix)
+}
+
+// DiskUsage implements the PersistentDatastore interface.
+func (d *Datastore) DiskUsage(ctx context.Context) (uint64, error) {
+	return ds.DiskUsage(ctx, d.child)
+}
+
+// Check implements CheckedDatastore.Check
+func (d *Datastore) Check(ctx context.Context) error {
+	if c, ok := d.child.(ds.CheckedDatastore); ok {
+		return c.Check(ctx)
+	}
+	return nil
+}
+
+// Scrub implements ScrubbedDatastore.Scrub, the cache is purged as the
+// child may repair entries.
+func (d *Datastore) Scrub(ctx context.Context) error {
+	if c, ok := d.child.(ds.ScrubbedDatastore); ok {
+		defer d.Purge()
+		return c.Scrub(ctx)
+	}
+	return nil
+}
+
+// CollectGarbage implements GCDatastore.CollectGarbage
+func (d *Datastore) CollectGarbage(ctx context.Context) error {
+	if c, ok := d.child.(ds.GCDatastore); ok {
+		return c.CollectGarbage(ctx)
+	}
+	return nil
+}
+
+// Batch implements Batching.Batch, the cache is updated when the batch is
+// committed. Batches are emulated with ds.NewBasicBatch if the child isn't
+// Batching.
+func (d *Datastore) Batch(ctx context.Context) (ds.Batch, error) {
+	var b ds.Batch
+	if bds, ok := d.child.(ds.Batching); ok {
+		var err error
+		if b, err = bds.Batch(ctx); err != nil {
+			return nil, err
+		}
+	} else {
+		b = ds.NewBasicBatch(d.child)
+	}
+	return &cacheBatch{child: b, d: d, ops: make(map[string]int)}, nil
+}
+
+// cacheBatch records the keys written to update the cache on Commit.
+type cacheBatch struct {
+	child ds.Batch
+	d     *Datastore
+
+	keys   []key.Key
+	values [][]byte
+	// ops are the indexes in keys of the last operation on each key.
+	ops map[string]int
+}
+
+func (b *cacheBatch) record(k key.Key, value []byte) {
+	if i, ok := b.ops[k.String()]; ok {
+		b.values[i] = value
+		return
+	}
+	b.ops[k.String()] = len(b.keys)
+	b.keys = append(b.keys, k)
+	b.values = append(b.values, value)
+}
+
+func (b *cacheBatch) Put(ctx context.Context, key key.Key, value []byte) error {
+	if err := b.child.Put(ctx, key, value); err != nil {
+		return err
+	}
+	if value == nil {
+		value = []byte{}
+	}
+	b.record(key, value)
+	return nil
+}
+
+func (b *cacheBatch) Delete(ctx context.Context, key key.Key) error {
+	if err := b.child.Delete(ctx, key); err != nil {
+		return err
+	}
+	b.record(key, nil)
+	return nil
+}
+
+func (b *cacheBatch) Commit(ctx context.Context) error {
+	err := b.child.Commit(ctx)
+	b.d.written(err, b.keys, b.values)
+	b.keys, b.values, b.ops = nil, nil, make(map[string]int)
+	return err
+}
+
+// NewTransaction implements TxnDatastore.NewTransaction, transactions read
+// from the child and the keys they write are invalidated on Commit.
+func (d *Datastore) NewTransaction(ctx context.Context, readOnly bool) (ds.Txn, error) {
+	tds, ok := d.child.(ds.TxnDatastore)
+	if !ok {
+		return nil, ds.ErrTxnUnsupported
+	}
+	t, err := tds.NewTransaction(ctx, readOnly)
+	if err != nil {
+		return nil, err
+	}
+	return &cacheTxn{Txn: t, d: d}, nil
+}
+
+type cacheTxn struct {
+	ds.Txn
+	d    *Datastore
+	keys []key.Key
+}
+
+func (t *cacheTxn) Put(ctx context.Context, key key.Key, value []byte) error {
+	if err := t.Txn.Put(ctx, key, value); err != nil {
+		return err
+	}
+	t.keys = append(t.keys, key)
+	return nil
+}
+
+func (t *cacheTxn) Delete(ctx context.Context, key key.Key) error {
+	if err := t.Txn.Delete(ctx, key); err != nil {
+		return err
+	}
+	t.keys = append(t.keys, key)
+	return nil
+}
+
+func (t *cacheTxn) Commit(ctx context.Context) error {
+	err := t.Txn.Commit(ctx)
+	if err == nil {
+		t.d.lk.Lock()
+		t.d.gen++
+		for _, k := range t.keys {
+			t.d.store.remove(k.String())
+		}
+		t.d.lk.Unlock()
+	}
+	return err
+}
@@ -0,0 +1,337 @@
+// Copyright (c) 2020 DAOT Labs. All rights reserved. Use of this source
+// code is governed by MIT license that can be found in the LICENSE file.
+
+package cache
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	ds "github.com/daotl/go-datastore"
+	key "github.com/daotl/go-datastore/key"
+	dstest "github.com/daotl/go-datastore/test"
+)
+
+func testSuite(t *testing.T, ktype key.KeyType) {
+	for _, opts := range []Options{
+		{},
+		{Policy: ARC, MaxEntries: 16, NegativeCache: true},
+		{MaxBytes: 256, WriteMode: Invalidate, NegativeCache: true},
+		{Policy: ARC, MaxBytes: 256, WriteMode: Invalidate},
+	} {
+		d := Wrap(dstest.NewMapDatastoreForTest(t, ktype), opts)
+		dstest.SubtestAll(t, ktype, d)
+	}
+}
+
+func TestSuite(t *testing.T) {
+	testSuite(t, key.KeyTypeString)
+	testSuite(t, key.KeyTypeBytes)
+}
+
+// countingDatastore counts the reads.
+type countingDatastore struct {
+	*ds.MapDatastore
+	reads int
+}
+
+func (d *countingDatastore) Get(ctx context.Context, key key.Key) ([]byte, error) {
+	d.reads++
+	return d.MapDatastore.Get(ctx, key)
+}
+
+func (d *countingDatastore) Has(ctx context.Context, key key.Key) (bool, error) {
+	d.reads++
+	return d.MapDatastore.Has(ctx, key)
+}
+
+func (d *countingDatastore) GetSize(ctx context.Context, key key.Key) (int, error) {
+	d.reads++
+	return d.MapDatastore.GetSize(ctx, key)
+}
+
+func newCounting(t *testing.T) *countingDatastore {
+	return &countingDatastore{MapDatastore: dstest.NewMapDatastoreForTest(t, key.KeyTypeString)}
+}
+
+func k(i int) key.Key {
+	return key.NewStrKey(fmt.Sprintf("/%d", i))
+}
+
+func TestReadThrough(t *testing.T) {
+	ctx := context.Background()
+	child := newCounting(t)
+	child.Put(ctx, k(1), []byte("value"))
+	d := Wrap(child, Options{})
+
+	for i := 0; i < 3; i++ {
+		v, err := d.Get(ctx, k(1))
+		if err != nil || string(v) != "value" {
+			t.Fatalf("unexpected value %q, error: %v", v, err)
+		}
+	}
+	if has, err := d.Has(ctx, k(1)); err != nil || !has {
+		t.Fatal("expected the key to exist, error: ", err)
+	}
+	if size, err := d.GetSize(ctx, k(1)); err != nil || size != 5 {
+		t.Fatalf("unexpected size %d, error: %v", size, err)
+	}
+	if child.reads != 1 {
+		t.Fatalf("expected 1 read from the child, got %d", child.reads)
+	}
+	if s := d.Stats(); s.Hits != 4 || s.Misses != 1 || s.Entries != 1 || s.Bytes != 7 {
+		t.Fatalf("unexpected stats: %+v", s)
+	}
+}
+
+func TestNegativeCache(t *testing.T) {
+	ctx := context.Background()
+	for _, negative := range []bool{false, true} {
+		child := newCounting(t)
+		d := Wrap(child, Options{NegativeCache: negative})
+		for i := 0; i < 3; i++ {
+			if _, err := d.Get(ctx, k(1)); !ds.IsNotFound(err) {
+				t.Fatal("expected ErrNotFound, got: ", err)
+			}
+			if has, _ := d.Has(ctx, k(1)); has {
+				t.Fatal("expected the key not to exist")
+			}
+		}
+		expected := 6
+		if negative {
+			expected = 1
+		}
+		if child.reads != expected {
+			t.Fatalf("expected %d reads from the child, got %d", expected, child.reads)
+		}
+
+		// The negative entry is replaced by writes.
+		if err := d.Put(ctx, k(1), []byte("value")); err != nil {
+			t.Fatal(err)
+		}
+		if v, err := d.Get(ctx, k(1)); err != nil || string(v) != "value" {
+			t.Fatalf("unexpected value %q, error: %v", v, err)
+		}
+	}
+}
+
+func TestWriteModes(t *testing.T) {
+	ctx := context.Background()
+	for _, mode := range []WriteMode{WriteThrough, Invalidate} {
+		child := newCounting(t)
+		d := Wrap(child, Options{WriteMode: mode})
+		d.Get(ctx, k(1))
+		d.Put(ctx, k(1), []byte("a"))
+		if v, err := d.Get(ctx, k(1)); err != nil || string(v) != "a" {
+			t.Fatalf("unexpected value %q, error: %v", v, err)
+		}
+		d.Delete(ctx, k(1))
+		if _, err := d.Get(ctx, k(1)); !ds.IsNotFound(err) {
+			t.Fatal("expected ErrNotFound, got: ", err)
+		}
+
+		expected := 3
+		if mode == WriteThrough {
+			expected = 2
+		}
+		if child.reads != expected {
+			t.Fatalf("%v: expected %d reads from the child, got %d", mode, expected, child.reads)
+		}
+	}
+}
+
+func TestBatch(t *testing.T) {
+	ctx := context.Background()
+	for _, mode := range []WriteMode{WriteThrough, Invalidate} {
+		d := Wrap(newCounting(t), Options{WriteMode: mode, NegativeCache: true})
+		d.Put(ctx, k(1), []byte("1"))
+		d.Get(ctx, k(2))
+
+		b, err := d.Batch(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b.Put(ctx, k(2), []byte("2"))
+		b.Delete(ctx, k(1))
+		b.Put(ctx, k(3), []byte("3"))
+		b.Delete(ctx, k(3))
+
+		// Nothing changes before Commit.
+		if v, err := d.Get(ctx, k(1)); err != nil || string(v) != "1" {
+			t.Fatalf("unexpected value %q, error: %v", v, err)
+		}
+		if err := b.Commit(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := d.Get(ctx, k(1)); !ds.IsNotFound(err) {
+			t.Fatal("expected ErrNotFound, got: ", err)
+		}
+		if v, err := d.Get(ctx, k(2)); err != nil || string(v) != "2" {
+			t.Fatalf("unexpected value %q, error: %v", v, err)
+		}
+		if has, _ := d.Has(ctx, k(3)); has {
+			t.Fatal("expected the key not to exist")
+		}
+	}
+}
+
+func TestTxn(t *testing.T) {
+	ctx := context.Background()
+	child, err := ds.NewTxnMapDatastore(key.KeyTypeString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := Wrap(child, Options{NegativeCache: true})
+	d.Get(ctx, k(1))
+
+	txn, err := d.NewTransaction(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	txn.Put(ctx, k(1), []byte("1"))
+	if err := txn.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := d.Get(ctx, k(1)); err != nil || string(v) != "1" {
+		t.Fatalf("unexpected value %q, error: %v", v, err)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	ctx := context.Background()
+	for _, policy := range []Policy{LRU, ARC} {
+		d := Wrap(newCounting(t), Options{Policy: policy, MaxEntries: 10})
+		for i := 0; i < 100; i++ {
+			d.Put(ctx, k(i), []byte("value"))
+		}
+		if s := d.Stats(); s.Entries != 10 || s.Evictions != 90 {
+			t.Fatalf("%v: unexpected stats: %+v", policy, s)
+		}
+
+		// The keys are 2 or 3 bytes long.
+		d = Wrap(newCounting(t), Options{Policy: policy, MaxBytes: 100})
+		for i := 0; i < 100; i++ {
+			d.Put(ctx, k(i), []byte("value"))
+		}
+		if s := d.Stats(); s.Bytes > 100 || s.Entries != 12 {
+			t.Fatalf("%v: unexpected stats: %+v", policy, s)
+		}
+
+		// Values larger than MaxBytes are not cached.
+		d.Put(ctx, k(0), make([]byte, 200))
+		if s := d.Stats(); s.Bytes > 100 || s.Entries != 12 {
+			t.Fatalf("%v: unexpected stats: %+v", policy, s)
+		}
+		if v, err := d.Get(ctx, k(0)); err != nil || len(v) != 200 {
+			t.Fatalf("%v: unexpected value, error: %v", policy, err)
+		}
+	}
+}
+
+func TestLRU(t *testing.T) {
+	ctx := context.Background()
+	child := newCounting(t)
+	d := Wrap(child, Options{MaxEntries: 3})
+	for i := 0; i < 3; i++ {
+		d.Put(ctx, k(i), []byte("value"))
+	}
+	d.Get(ctx, k(0))
+	d.Put(ctx, k(3), []byte("value"))
+
+	// 1 is the least recently used.
+	for _, i := range []int{0, 2, 3} {
+		d.Get(ctx, k(i))
+	}
+	if child.reads != 0 {
+		t.Fatalf("expected no reads from the child, got %d", child.reads)
+	}
+	d.Get(ctx, k(1))
+	if child.reads != 1 {
+		t.Fatal("expected 1 to have been evicted")
+	}
+}
+
+func TestARCScan(t *testing.T) {
+	ctx := context.Background()
+	child := newCounting(t)
+	for i := 0; i < 1000; i++ {
+		child.Put(ctx, k(i), []byte("value"))
+	}
+	d := Wrap(child, Options{Policy: ARC, MaxEntries: 10})
+
+	// Use a hot set twice, then scan many keys once.
+	for j := 0; j < 2; j++ {
+		for i := 0; i < 5; i++ {
+			d.Get(ctx, k(i))
+		}
+	}
+	for i := 100; i < 1000; i++ {
+		d.Get(ctx, k(i))
+	}
+
+	// The hot set survives the scan.
+	child.reads = 0
+	for i := 0; i < 5; i++ {
+		d.Get(ctx, k(i))
+	}
+	if child.reads != 0 {
+		t.Fatalf("expected the hot set to be cached, %d reads from the child", child.reads)
+	}
+}
+
+func TestPurge(t *testing.T) {
+	ctx := context.Background()
+	child := newCounting(t)
+	d := Wrap(child, Options{})
+	d.Put(ctx, k(1), []byte("a"))
+
+	// Writes through other paths need to be invalidated.
+	child.Put(ctx, k(1), []byte("b"))
+	if v, _ := d.Get(ctx, k(1)); string(v) != "a" {
+		t.Fatalf("unexpected value %q", v)
+	}
+	d.Invalidate(k(1))
+	if v, _ := d.Get(ctx, k(1)); string(v) != "b" {
+		t.Fatalf("unexpected value %q", v)
+	}
+	d.Purge()
+	if s := d.Stats(); s.Entries != 0 || s.Bytes != 0 {
+		t.Fatalf("unexpected stats: %+v", s)
+	}
+}
+
+func TestRandom(t *testing.T) {
+	ctx := context.Background()
+	for _, opts := range []Options{
+		{Policy: LRU, MaxEntries: 20, NegativeCache: true},
+		{Policy: ARC, MaxEntries: 20, NegativeCache: true},
+		{Policy: ARC, MaxBytes: 100},
+	} {
+		child := dstest.NewMapDatastoreForTest(t, key.KeyTypeString)
+		d := Wrap(child, opts)
+		r := rand.New(rand.NewSource(1))
+		for i := 0; i < 10000; i++ {
+			kk := k(r.Intn(50))
+			switch r.Intn(4) {
+			case 0:
+				d.Put(ctx, kk, make([]byte, r.Intn(10)))
+			case 1:
+				d.Delete(ctx, kk)
+			default:
+				v, err := d.Get(ctx, kk)
+				cv, cerr := child.Get(ctx, kk)
+				if !bytes.Equal(v, cv) || ds.IsNotFound(err) != ds.IsNotFound(cerr) {
+					t.Fatalf("%v: got %v, %v, expected %v, %v", opts.Policy, v, err, cv, cerr)
+				}
+			}
+			s := d.Stats()
+			if (opts.MaxEntries > 0 && s.Entries > opts.MaxEntries) || (opts.MaxBytes > 0 && s.Bytes > opts.MaxBytes) {
+				t.Fatalf("%v: over the limits: %+v", opts.Policy, s)
+			}
+		}
+	}
+}
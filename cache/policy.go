@@ -0,0 +1,328 @@
+// Copyright (c) 2020 DAOT Labs. All rights reserved. Use of this source
+// code is governed by MIT license that can be found in the LICENSE file.
+
+package cache
+
+import (
+	"container/list"
+)
+
+// Policy is the eviction policy of a cache.
+type Policy int
+
+const (
+	// LRU evicts the least recently used entries.
+	LRU Policy = iota
+	// ARC is the Adaptive Replacement Cache policy, which balances between
+	// recently and frequently used entries, and resists scans better than
+	// LRU.
+	ARC
+)
+
+func (p Policy) String() string {
+	switch p {
+	case LRU:
+		return "LRU"
+	case ARC:
+		return "ARC"
+	default:
+		return "unknown"
+	}
+}
+
+// entry is a cached entry, `value` is nil if the key is not found.
+type entry struct {
+	key      string
+	value    []byte
+	notFound bool
+}
+
+func (e *entry) size() int64 {
+	return int64(len(e.key) + len(e.value))
+}
+
+// limits are the limits of a store, 0 is unlimited.
+type limits struct {
+	maxEntries int
+	maxBytes   int64
+}
+
+// store is a set of cached entries bounded by limits, it isn't safe for
+// concurrent use.
+type store interface {
+	// get returns the entry of the key and marks it as used.
+	get(k string) (*entry, bool)
+	// add adds or replaces an entry and evicts entries until the limits
+	// are met, it returns the number of entries evicted.
+	add(e *entry) int
+	remove(k string)
+	clear()
+	len() int
+	bytes() int64
+}
+
+func newStore(p Policy, l limits) store {
+	if p == ARC {
+		return newARC(l)
+	}
+	return newLRU(l)
+}
+
+// over returns whether the store is over the limits.
+func (l limits) over(entries int, bytes int64) bool {
+	return (l.maxEntries > 0 && entries > l.maxEntries) ||
+		(l.maxBytes > 0 && bytes > l.maxBytes)
+}
+
+// lru is a store with the LRU policy.
+type lru struct {
+	limits
+	ll    *list.List
+	items map[string]*list.Element
+	size  int64
+}
+
+func newLRU(l limits) *lru {
+	return &lru{limits: l, ll: list.New(), items: make(map[string]*list.Element)}
+}
+
+func (c *lru) get(k string) (*entry, bool) {
+	el, ok := c.items[k]
+	if !ok {
+		return nil, false
+	}
+	c.ll.MoveToFront(el)
+	return el.Value.(*entry), true
+}
+
+func (c *lru) add(e *entry) int {
+	if el, ok := c.items[e.key]; ok {
+		c.size += e.size() - el.Value.(*entry).size()
+		el.Value = e
+		c.ll.MoveToFront(el)
+	} else {
+		c.items[e.key] = c.ll.PushFront(e)
+		c.size += e.size()
+	}
+
+	evicted := 0
+	for c.over(c.ll.Len(), c.size) && c.ll.Len() > 1 {
+		c.removeElement(c.ll.Back())
+		evicted++
+	}
+	return evicted
+}
+
+func (c *lru) remove(k string) {
+	if el, ok := c.items[k]; ok {
+		c.removeElement(el)
+	}
+}
+
+func (c *lru) removeElement(el *list.Element) {
+	e := c.ll.Remove(el).(*entry)
+	delete(c.items, e.key)
+	c.size -= e.size()
+}
+
+func (c *lru) clear() {
+	c.ll.Init()
+	c.items = make(map[string]*list.Element)
+	c.size = 0
+}
+
+func (c *lru) len() int {
+	return c.ll.Len()
+}
+
+func (c *lru) bytes() int64 {
+	return c.size
+}
+
+// arc is a store with the ARC policy, see "ARC: A Self-Tuning, Low Overhead
+// Replacement Cache" by Megiddo and Modha.
+//
+// t1 holds the entries seen once recently and t2 the ones seen at least
+// twice, b1 and b2 are the ghost lists of the keys recently evicted from t1
+// and t2. A hit in b1 grows the target size `p` of t1 and a hit in b2
+// shrinks it. The ghost lists are bounded by maxEntries, or by the number of
+// cached entries if it's unlimited.
+type arc struct {
+	limits
+	p      int
+	t1, t2 *list.List
+	b1, b2 *list.List
+	items  map[string]*list.Element
+	// lists are the lists the elements of items are in.
+	lists map[*list.Element]*list.List
+	size  int64
+}
+
+func newARC(l limits) *arc {
+	return &arc{
+		limits: l,
+		t1:     list.New(),
+		t2:     list.New(),
+		b1:     list.New(),
+		b2:     list.New(),
+		items:  make(map[string]*list.Element),
+		lists:  make(map[*list.Element]*list.List),
+	}
+}
+
+// capacity is the target number of cached entries.
+func (c *arc) capacity() int {
+	if c.maxEntries > 0 {
+		return c.maxEntries
+	}
+	if n := c.t1.Len() + c.t2.Len(); n > 0 {
+		return n
+	}
+	return 1
+}
+
+func (c *arc) get(k string) (*entry, bool) {
+	el, ok := c.items[k]
+	if !ok {
+		return nil, false
+	}
+	l := c.lists[el]
+	if l != c.t1 && l != c.t2 {
+		// A ghost.
+		return nil, false
+	}
+	e := el.Value.(*entry)
+	c.move(el, c.t2, e)
+	return e, true
+}
+
+func (c *arc) add(e *entry) int {
+	inB2 := false
+	if el, ok := c.items[e.key]; ok {
+		switch l := c.lists[el]; l {
+		case c.t1, c.t2:
+			c.size += e.size() - el.Value.(*entry).size()
+			el.Value = e
+			if l == c.t1 {
+				c.move(el, c.t2, e)
+			} else {
+				c.t2.MoveToFront(el)
+			}
+			return c.evict(e, false)
+		case c.b1:
+			c.p = min(c.capacity(), c.p+max(c.b2.Len()/c.b1.Len(), 1))
+		case c.b2:
+			c.p = max(0, c.p-max(c.b1.Len()/c.b2.Len(), 1))
+			inB2 = true
+		}
+		c.drop(el)
+		c.items[e.key] = c.t2.PushFront(e)
+		c.lists[c.items[e.key]] = c.t2
+	} else {
+		c.items[e.key] = c.t1.PushFront(e)
+		c.lists[c.items[e.key]] = c.t1
+	}
+	c.size += e.size()
+
+	evicted := c.evict(e, inB2)
+
+	// Bound the ghost lists.
+	capacity := c.capacity()
+	for c.b1.Len() > 0 && c.t1.Len()+c.b1.Len() > capacity {
+		c.drop(c.b1.Back())
+	}
+	for c.b2.Len() > 0 && c.t1.Len()+c.t2.Len()+c.b1.Len()+c.b2.Len() > 2*capacity {
+		c.drop(c.b2.Back())
+	}
+	return evicted
+}
+
+// evict moves entries from t1 and t2 to the ghost lists until the limits are
+// met, without evicting `added`.
+func (c *arc) evict(added *entry, inB2 bool) int {
+	evicted := 0
+	for c.over(c.t1.Len()+c.t2.Len(), c.size) && c.t1.Len()+c.t2.Len() > 1 {
+		fromT1 := c.t2.Len() == 0 ||
+			(c.t1.Len() > 0 && (c.t1.Len() > c.p || (inB2 && c.t1.Len() == c.p)))
+		switch {
+		case fromT1 && c.t1.Back().Value.(*entry) == added:
+			fromT1 = false
+		case !fromT1 && c.t2.Back().Value.(*entry) == added:
+			fromT1 = true
+		}
+		if fromT1 {
+			el := c.t1.Back()
+			c.move(el, c.b1, el.Value.(*entry))
+		} else {
+			el := c.t2.Back()
+			c.move(el, c.b2, el.Value.(*entry))
+		}
+		evicted++
+	}
+	return evicted
+}
+
+// move moves an element to the front of another list, ghosts don't keep
+// their values.
+func (c *arc) move(el *list.Element, to *list.List, e *entry) {
+	from := c.lists[el]
+	if from == to {
+		to.MoveToFront(el)
+		return
+	}
+	from.Remove(el)
+	delete(c.lists, el)
+	if to == c.b1 || to == c.b2 {
+		c.size -= e.size()
+		e = &entry{key: e.key}
+	}
+	nel := to.PushFront(e)
+	c.items[e.key] = nel
+	c.lists[nel] = to
+}
+
+// drop removes an element from its list.
+func (c *arc) drop(el *list.Element) {
+	l := c.lists[el]
+	e := l.Remove(el).(*entry)
+	delete(c.lists, el)
+	delete(c.items, e.key)
+	if l == c.t1 || l == c.t2 {
+		c.size -= e.size()
+	}
+}
+
+func (c *arc) remove(k string) {
+	// Keep the ghosts, they only record the history.
+	if el, ok := c.items[k]; ok {
+		if l := c.lists[el]; l == c.t1 || l == c.t2 {
+			c.drop(el)
+		}
+	}
+}
+
+func (c *arc) clear() {
+	*c = *newARC(c.limits)
+}
+
+func (c *arc) len() int {
+	return c.t1.Len() + c.t2.Len()
+}
+
+func (c *arc) bytes() int64 {
+	return c.size
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,166 @@
+// Copyright (c) 2020 DAOT Labs. All rights reserved. Use of this source
+// code is governed by MIT license that can be found in the LICENSE file.
+
+package datastore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+
+	"github.com/daotl/go-datastore/key"
+)
+
+// CAS encapsulates the conditional write methods, each of which checks the
+// current value of a key and writes it in a single atomic step.
+type CAS interface {
+	// PutIfAbsent stores `value` under `key` only if `key` is not mapped to a
+	// value yet, and reports whether it did.
+	PutIfAbsent(ctx context.Context, key key.Key, value []byte) (ok bool, err error)
+
+	// CompareAndSwap replaces the value named by `key` with `new` only if it
+	// is currently `old`, and reports whether it did. It does nothing if
+	// `key` is not mapped to a value, use PutIfAbsent for that.
+	CompareAndSwap(ctx context.Context, key key.Key, old, new []byte) (ok bool, err error)
+
+	// DeleteIfEqual removes the value named by `key` only if it is currently
+	// `value`, and reports whether it did.
+	DeleteIfEqual(ctx context.Context, key key.Key, value []byte) (ok bool, err error)
+}
+
+// CASDatastore is an interface that should be implemented by datastores
+// which support atomic conditional writes.
+type CASDatastore interface {
+	Datastore
+	CAS
+}
+
+// ErrCASUnsupported is returned by the conditional write methods of wrappers
+// whose child doesn't support them.
+var ErrCASUnsupported = errors.New("this datastore does not support conditional writes")
+
+// PutIfAbsent calls d.PutIfAbsent if `d` is a CASDatastore, or emulates it
+// with a transaction if `d` is a TxnDatastore, otherwise it returns
+// ErrCASUnsupported.
+func PutIfAbsent(ctx context.Context, d Datastore, key key.Key, value []byte) (bool, error) {
+	switch d := d.(type) {
+	case CAS:
+		return d.PutIfAbsent(ctx, key, value)
+	case TxnDatastore:
+		return (&TxnCAS{d}).PutIfAbsent(ctx, key, value)
+	}
+	return false, ErrCASUnsupported
+}
+
+// CompareAndSwap calls d.CompareAndSwap if `d` is a CASDatastore, or
+// emulates it with a transaction if `d` is a TxnDatastore, otherwise it
+// returns ErrCASUnsupported.
+func CompareAndSwap(ctx context.Context, d Datastore, key key.Key, old, new []byte) (bool, error) {
+	switch d := d.(type) {
+	case CAS:
+		return d.CompareAndSwap(ctx, key, old, new)
+	case TxnDatastore:
+		return (&TxnCAS{d}).CompareAndSwap(ctx, key, old, new)
+	}
+	return false, ErrCASUnsupported
+}
+
+// DeleteIfEqual calls d.DeleteIfEqual if `d` is a CASDatastore, or emulates
+// it with a transaction if `d` is a TxnDatastore, otherwise it returns
+// ErrCASUnsupported.
+func DeleteIfEqual(ctx context.Context, d Datastore, key key.Key, value []byte) (bool, error) {
+	switch d := d.(type) {
+	case CAS:
+		return d.DeleteIfEqual(ctx, key, value)
+	case TxnDatastore:
+		return (&TxnCAS{d}).DeleteIfEqual(ctx, key, value)
+	}
+	return false, ErrCASUnsupported
+}
+
+// TxnCAS implements the conditional writes on top of a TxnDatastore: the
+// value is read and written in the same transaction, which is retried as long
+// as it fails to commit with ErrTxnConflict.
+type TxnCAS struct {
+	TxnDatastore
+}
+
+var _ CASDatastore = (*TxnCAS)(nil)
+
+// NewTxnCAS wraps `d` into a CASDatastore.
+func NewTxnCAS(d TxnDatastore) *TxnCAS {
+	return &TxnCAS{d}
+}
+
+// Children implements Shim
+func (d *TxnCAS) Children() []Datastore {
+	return []Datastore{d.TxnDatastore}
+}
+
+// update runs `fn` with the current value of `key` in a new transaction and
+// commits it if `fn` returns true, until it commits without conflict.
+func (d *TxnCAS) update(ctx context.Context, key key.Key,
+	fn func(txn Txn, value []byte, found bool) (bool, error)) (bool, error) {
+	for {
+		ok, err := d.tryUpdate(ctx, key, fn)
+		if !IsTxnConflict(err) {
+			return ok, err
+		}
+		if err := ctx.Err(); err != nil {
+			return false, err
+		}
+	}
+}
+
+func (d *TxnCAS) tryUpdate(ctx context.Context, key key.Key,
+	fn func(txn Txn, value []byte, found bool) (bool, error)) (bool, error) {
+	txn, err := d.NewTransaction(ctx, false)
+	if err != nil {
+		return false, err
+	}
+	defer txn.Discard(ctx)
+
+	value, err := txn.Get(ctx, key)
+	found := err == nil
+	if err != nil && !IsNotFound(err) {
+		return false, err
+	}
+	ok, err := fn(txn, value, found)
+	if err != nil || !ok {
+		return false, err
+	}
+	if err := txn.Commit(ctx); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// PutIfAbsent implements CAS.PutIfAbsent
+func (d *TxnCAS) PutIfAbsent(ctx context.Context, key key.Key, value []byte) (bool, error) {
+	return d.update(ctx, key, func(txn Txn, _ []byte, found bool) (bool, error) {
+		if found {
+			return false, nil
+		}
+		return true, txn.Put(ctx, key, value)
+	})
+}
+
+// CompareAndSwap implements CAS.CompareAndSwap
+func (d *TxnCAS) CompareAndSwap(ctx context.Context, key key.Key, old, new []byte) (bool, error) {
+	return d.update(ctx, key, func(txn Txn, value []byte, found bool) (bool, error) {
+		if !found || !bytes.Equal(value, old) {
+			return false, nil
+		}
+		return true, txn.Put(ctx, key, new)
+	})
+}
+
+// DeleteIfEqual implements CAS.DeleteIfEqual
+func (d *TxnCAS) DeleteIfEqual(ctx context.Context, key key.Key, value []byte) (bool, error) {
+	return d.update(ctx, key, func(txn Txn, current []byte, found bool) (bool, error) {
+		if !found || !bytes.Equal(current, value) {
+			return false, nil
+		}
+		return true, txn.Delete(ctx, key)
+	})
+}
@@ -0,0 +1,76 @@
+// Copyright (c) 2020 DAOT Labs. All rights reserved. Use of this source
+// code is governed by MIT license that can be found in the LICENSE file.
+
+package datastore_test
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+
+	dstore "github.com/daotl/go-datastore"
+	"github.com/daotl/go-datastore/key"
+	dstest "github.com/daotl/go-datastore/test"
+)
+
+func testTxnCAS(t *testing.T, ktype key.KeyType) {
+	dstest.SubtestAll(t, ktype, dstore.NewTxnCAS(newTxnMapDatastore(t, ktype)))
+}
+
+func TestTxnCAS(t *testing.T) {
+	testTxnCAS(t, key.KeyTypeString)
+	testTxnCAS(t, key.KeyTypeBytes)
+}
+
+func TestTxnCASConcurrent(t *testing.T) {
+	ctx := context.Background()
+	ds := newTxnMapDatastore(t, key.KeyTypeString)
+
+	k := key.NewStrKey("/counter")
+	if ok, err := dstore.PutIfAbsent(ctx, ds, k, []byte("0")); err != nil || !ok {
+		t.Fatal("expected PutIfAbsent to succeed: ", err)
+	}
+
+	const workers, increments = 8, 50
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < increments; {
+				v, err := ds.Get(ctx, k)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				n, _ := strconv.Atoi(string(v))
+				ok, err := dstore.CompareAndSwap(ctx, ds, k, v, []byte(strconv.Itoa(n+1)))
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if ok {
+					j++
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	v, err := ds.Get(ctx, k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(v) != strconv.Itoa(workers*increments) {
+		t.Fatalf("expected counter to be %d, got %s", workers*increments, v)
+	}
+}
+
+func TestCASUnsupported(t *testing.T) {
+	ctx := context.Background()
+	ds := dstore.NewNullDatastore()
+	if _, err := dstore.PutIfAbsent(ctx, ds, key.NewStrKey("/foo"), nil); err != dstore.ErrCASUnsupported {
+		t.Fatal("expected ErrCASUnsupported, got: ", err)
+	}
+}
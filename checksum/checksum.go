@@ -0,0 +1,198 @@
+// Copyright (c) 2020 DAOT Labs. All rights reserved. Use of this source
+// code is governed by MIT license that can be found in the LICENSE file.
+
+// Package checksum provides a datastore wrapper which stores a CRC-32C
+// checksum with each value and verifies it when the value is read, to
+// detect the values corrupted by the child.
+//
+// Values are stored as:
+//
+//	checksum  uint32 (big-endian CRC-32C of the key and the value)
+//	value     []byte
+//
+// The key is part of the checksum so that values moved to another key are
+// detected too.
+package checksum
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+
+	"go.uber.org/multierr"
+
+	ds "github.com/daotl/go-datastore"
+	key "github.com/daotl/go-datastore/key"
+	dsq "github.com/daotl/go-datastore/query"
+	"github.com/daotl/go-datastore/valuetransform"
+)
+
+// Overhead is the size added to values by the checksum.
+const Overhead = 4
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// ErrCorrupt is matched by errors.Is for all the CorruptionErrors.
+var ErrCorrupt = errors.New("checksum: corrupt value")
+
+// CorruptionError is returned when the checksum of a value doesn't match.
+type CorruptionError struct {
+	// Key is the key of the corrupt value.
+	Key key.Key
+	// RepairErr is the error repairing the value by Scrub, if any.
+	RepairErr error
+}
+
+func (e *CorruptionError) Error() string {
+	if e.RepairErr != nil {
+		return fmt.Sprintf("checksum: corrupt value for %s, repairing: %s", e.Key, e.RepairErr)
+	}
+	return fmt.Sprintf("checksum: corrupt value for %s", e.Key)
+}
+
+func (e *CorruptionError) Unwrap() error {
+	return ErrCorrupt
+}
+
+// Transform is a ValueTransform adding checksums to values.
+type Transform struct{}
+
+var _ valuetransform.ValueTransform = Transform{}
+var _ valuetransform.Sizer = Transform{}
+
+func checksum(k key.Key, value []byte) uint32 {
+	return crc32.Update(crc32.Checksum(k.Bytes(), crcTable), crcTable, value)
+}
+
+// ConvertValue implements ValueTransform.ConvertValue
+func (Transform) ConvertValue(k key.Key, value []byte) ([]byte, error) {
+	v := make([]byte, Overhead, Overhead+len(value))
+	binary.BigEndian.PutUint32(v, checksum(k, value))
+	return append(v, value...), nil
+}
+
+// InvertValue implements ValueTransform.InvertValue, it returns a
+// *CorruptionError if the checksum doesn't match.
+func (Transform) InvertValue(k key.Key, value []byte) ([]byte, error) {
+	if len(value) < Overhead || binary.BigEndian.Uint32(value) != checksum(k, value[Overhead:]) {
+		return nil, &CorruptionError{Key: k}
+	}
+	return value[Overhead:], nil
+}
+
+// InvertedSize implements Sizer.InvertedSize, the size is computed without
+// verifying the checksum.
+func (Transform) InvertedSize(k key.Key, value []byte) (int, error) {
+	if len(value) < Overhead {
+		return -1, &CorruptionError{Key: k}
+	}
+	return len(value) - Overhead, nil
+}
+
+// Options are the options of a checksumming datastore.
+type Options struct {
+	// Replica, if set, is a replica of the datastore, from which Scrub
+	// repairs the corrupt values. Its values are trusted, so it should
+	// verify them itself, for example by being a checksumming datastore
+	// too.
+	Replica ds.Read
+}
+
+// Datastore is a checksumming datastore, which returns a *CorruptionError
+// when reading a corrupt value.
+type Datastore struct {
+	*valuetransform.Datastore
+
+	child ds.Datastore
+	opts  Options
+}
+
+var _ ds.CheckedDatastore = (*Datastore)(nil)
+var _ ds.ScrubbedDatastore = (*Datastore)(nil)
+
+// Wrap wraps the given datastore to checksum its values.
+func Wrap(child ds.Datastore, opts Options) *Datastore {
+	return &Datastore{
+		Datastore: valuetransform.Wrap(child, Transform{}),
+		child:     child,
+		opts:      opts,
+	}
+}
+
+// Capabilities implements ds.Capable
+func (d *Datastore) Capabilities() ds.Feature {
+	return d.Datastore.Capabilities() | ds.FeatureChecked | ds.FeatureScrubbed
+}
+
+// corrupt returns the keys of the corrupt values.
+func (d *Datastore) corrupt(ctx context.Context) ([]key.Key, error) {
+	res, err := d.child.Query(ctx, dsq.Query{})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	var keys []key.Key
+	for {
+		r, ok := res.NextSync()
+		if !ok {
+			return keys, nil
+		}
+		if r.Error != nil {
+			return keys, r.Error
+		}
+		if _, err := (Transform{}).InvertValue(r.Key, r.Value); err != nil {
+			keys = append(keys, r.Key)
+		}
+	}
+}
+
+// Check implements CheckedDatastore.Check, it checks the child if it's a
+// CheckedDatastore, and verifies the checksums of all the values. The
+// corrupt values are reported as a *CorruptionError each, combined with
+// multierr.
+func (d *Datastore) Check(ctx context.Context) error {
+	var merr error
+	if c, ok := d.child.(ds.CheckedDatastore); ok {
+		merr = c.Check(ctx)
+	}
+	keys, err := d.corrupt(ctx)
+	for _, k := range keys {
+		merr = multierr.Append(merr, &CorruptionError{Key: k})
+	}
+	return multierr.Append(merr, err)
+}
+
+// Scrub implements ScrubbedDatastore.Scrub, it scrubs the child if it's a
+// ScrubbedDatastore, verifies the checksums of all the values, and repairs
+// the corrupt values from Options.Replica if it's set. The values which
+// couldn't be repaired are reported like by Check.
+func (d *Datastore) Scrub(ctx context.Context) error {
+	var merr error
+	if c, ok := d.child.(ds.ScrubbedDatastore); ok {
+		merr = c.Scrub(ctx)
+	}
+	keys, err := d.corrupt(ctx)
+	for _, k := range keys {
+		cerr := &CorruptionError{Key: k}
+		if d.opts.Replica == nil {
+			merr = multierr.Append(merr, cerr)
+			continue
+		}
+		if cerr.RepairErr = d.repair(ctx, k); cerr.RepairErr != nil {
+			merr = multierr.Append(merr, cerr)
+		}
+	}
+	return multierr.Append(merr, err)
+}
+
+// repair replaces the value of `k` by the value of the replica.
+func (d *Datastore) repair(ctx context.Context, k key.Key) error {
+	v, err := d.opts.Replica.Get(ctx, k)
+	if err != nil {
+		return err
+	}
+	return d.Put(ctx, k, v)
+}
@@ -0,0 +1,147 @@
+// Copyright (c) 2020 DAOT Labs. All rights reserved. Use of this source
+// code is governed by MIT license that can be found in the LICENSE file.
+
+package checksum
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"go.uber.org/multierr"
+
+	ds "github.com/daotl/go-datastore"
+	key "github.com/daotl/go-datastore/key"
+	dsq "github.com/daotl/go-datastore/query"
+	dstest "github.com/daotl/go-datastore/test"
+)
+
+func testSuite(t *testing.T, ktype key.KeyType) {
+	dstest.SubtestAll(t, ktype, Wrap(dstest.NewMapDatastoreForTest(t, ktype), Options{}))
+}
+
+func TestSuite(t *testing.T) {
+	testSuite(t, key.KeyTypeString)
+	testSuite(t, key.KeyTypeBytes)
+}
+
+// fill puts 10 values into `d` and corrupts /3 and /7 in `child`.
+func fill(t *testing.T, d ds.Datastore, child ds.Datastore) {
+	ctx := context.Background()
+	for i := 0; i < 10; i++ {
+		if err := d.Put(ctx, key.NewStrKey(fmt.Sprintf("/%d", i)), []byte(fmt.Sprint("value", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, k := range []string{"/3", "/7"} {
+		v, err := child.Get(ctx, key.NewStrKey(k))
+		if err != nil {
+			t.Fatal(err)
+		}
+		v[len(v)-1] ^= 1
+		if err := child.Put(ctx, key.NewStrKey(k), v); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func expectCorrupt(t *testing.T, err error, keys ...string) {
+	t.Helper()
+	errs := multierr.Errors(err)
+	if len(errs) != len(keys) {
+		t.Fatalf("expected %d corrupt values, got: %v", len(keys), err)
+	}
+	found := make(map[string]bool)
+	for _, err := range errs {
+		var cerr *CorruptionError
+		if !errors.As(err, &cerr) {
+			t.Fatal("expected a CorruptionError, got: ", err)
+		}
+		found[cerr.Key.String()] = true
+	}
+	for _, k := range keys {
+		if !found[k] {
+			t.Fatalf("expected %s to be reported, got: %v", k, err)
+		}
+	}
+}
+
+func TestCorruption(t *testing.T) {
+	ctx := context.Background()
+	child := dstest.NewMapDatastoreForTest(t, key.KeyTypeString)
+	d := Wrap(child, Options{})
+	fill(t, d, child)
+
+	if v, err := d.Get(ctx, key.NewStrKey("/2")); err != nil || string(v) != "value2" {
+		t.Fatalf("unexpected value %q, error: %v", v, err)
+	}
+	_, err := d.Get(ctx, key.NewStrKey("/3"))
+	var cerr *CorruptionError
+	if !errors.As(err, &cerr) || cerr.Key.String() != "/3" || !errors.Is(err, ErrCorrupt) {
+		t.Fatal("expected a CorruptionError for /3, got: ", err)
+	}
+
+	// A value moved to another key is corrupt too.
+	v, _ := child.Get(ctx, key.NewStrKey("/1"))
+	if err := child.Put(ctx, key.NewStrKey("/moved"), v); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Get(ctx, key.NewStrKey("/moved")); !errors.Is(err, ErrCorrupt) {
+		t.Fatal("expected ErrCorrupt for a moved value, got: ", err)
+	}
+
+	res, err := d.Query(ctx, dsq.Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for r := range res.Next() {
+		if errors.Is(r.Error, ErrCorrupt) {
+			n++
+		}
+	}
+	if n != 3 {
+		t.Fatalf("expected an error for each of the 3 corrupt values, got %d", n)
+	}
+	expectCorrupt(t, d.Check(ctx), "/3", "/7", "/moved")
+}
+
+func TestScrub(t *testing.T) {
+	ctx := context.Background()
+	child := dstest.NewMapDatastoreForTest(t, key.KeyTypeString)
+	d := Wrap(child, Options{})
+	fill(t, d, child)
+
+	// Without a replica, Scrub reports like Check.
+	expectCorrupt(t, d.Scrub(ctx), "/3", "/7")
+
+	rchild := dstest.NewMapDatastoreForTest(t, key.KeyTypeString)
+	replica := Wrap(rchild, Options{})
+	fill(t, replica, rchild)
+	if err := rchild.Delete(ctx, key.NewStrKey("/3")); err != nil {
+		t.Fatal(err)
+	}
+	if err := replica.Put(ctx, key.NewStrKey("/7"), []byte("value7")); err != nil {
+		t.Fatal(err)
+	}
+
+	d = Wrap(child, Options{Replica: replica})
+	err := d.Scrub(ctx)
+	expectCorrupt(t, err, "/3")
+	var cerr *CorruptionError
+	if errors.As(err, &cerr); !ds.IsNotFound(cerr.RepairErr) {
+		t.Fatal("expected the repair to fail with ErrNotFound, got: ", cerr.RepairErr)
+	}
+	if v, err := d.Get(ctx, key.NewStrKey("/7")); err != nil || string(v) != "value7" {
+		t.Fatalf("expected /7 to be repaired, got %q, error: %v", v, err)
+	}
+	expectCorrupt(t, d.Check(ctx), "/3")
+}
+
+func TestFeatures(t *testing.T) {
+	f := ds.Features(Wrap(dstest.NewMapDatastoreForTest(t, key.KeyTypeString), Options{}))
+	if f&ds.FeatureChecked == 0 || f&ds.FeatureScrubbed == 0 || f&ds.FeatureBatching == 0 {
+		t.Fatalf("unexpected features %s", f)
+	}
+}
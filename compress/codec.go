@@ -0,0 +1,125 @@
+// Copyright (c) 2020 DAOT Labs. All rights reserved. Use of this source
+// code is governed by MIT license that can be found in the LICENSE file.
+
+package compress
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"sync"
+)
+
+// Codec compresses and decompresses values.
+type Codec interface {
+	// ID identifies the codec in the headers of the values it compressed, it
+	// must not be NoneID and must never change.
+	ID() byte
+	// Compress appends the compressed `src` to `dst` and returns it.
+	Compress(dst, src []byte) ([]byte, error)
+	// Decompress decompresses `src`, whose decompressed size should be
+	// `size`. It may stop after `size`+1 bytes, as a value of another size
+	// is corrupted anyway.
+	Decompress(src []byte, size int) ([]byte, error)
+}
+
+// The IDs of the built-in codecs.
+const (
+	// NoneID is the ID of the values stored uncompressed with a header.
+	NoneID byte = iota
+	FlateID
+	GzipID
+)
+
+// Flate returns a Codec compressing with compress/flate at the given level.
+func Flate(level int) Codec {
+	return newStreamCodec(FlateID, level, func(w io.Writer, level int) (io.WriteCloser, error) {
+		return flate.NewWriter(w, level)
+	}, func(r io.Reader) (io.ReadCloser, error) {
+		return flate.NewReader(r), nil
+	})
+}
+
+// Gzip returns a Codec compressing with compress/gzip at the given level.
+func Gzip(level int) Codec {
+	return newStreamCodec(GzipID, level, func(w io.Writer, level int) (io.WriteCloser, error) {
+		return gzip.NewWriterLevel(w, level)
+	}, func(r io.Reader) (io.ReadCloser, error) {
+		return gzip.NewReader(r)
+	})
+}
+
+// maxPrealloc is the maximum size of the buffers allocated upfront for
+// decompressed values.
+const maxPrealloc = 1 << 20
+
+// resetter is implemented by the writers of compress/flate and compress/gzip.
+type resetter interface {
+	io.WriteCloser
+	Reset(w io.Writer)
+}
+
+// streamCodec is a Codec built on the streaming compressors of the standard
+// library, whose writers are reused as they're expensive to allocate.
+type streamCodec struct {
+	id        byte
+	level     int
+	newWriter func(w io.Writer, level int) (io.WriteCloser, error)
+	newReader func(r io.Reader) (io.ReadCloser, error)
+	writers   sync.Pool
+}
+
+func newStreamCodec(id byte, level int,
+	newWriter func(w io.Writer, level int) (io.WriteCloser, error),
+	newReader func(r io.Reader) (io.ReadCloser, error)) *streamCodec {
+	return &streamCodec{id: id, level: level, newWriter: newWriter, newReader: newReader}
+}
+
+func (c *streamCodec) ID() byte {
+	return c.id
+}
+
+func (c *streamCodec) Compress(dst, src []byte) ([]byte, error) {
+	buf := bytes.NewBuffer(dst)
+	var w io.WriteCloser
+	if pw, ok := c.writers.Get().(resetter); ok {
+		pw.Reset(buf)
+		w = pw
+	} else {
+		var err error
+		if w, err = c.newWriter(buf, c.level); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := w.Write(src); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	if rw, ok := w.(resetter); ok {
+		c.writers.Put(rw)
+	}
+	return buf.Bytes(), nil
+}
+
+func (c *streamCodec) Decompress(src []byte, size int) ([]byte, error) {
+	r, err := c.newReader(bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	// Don't trust the size for allocating more than maxPrealloc bytes
+	// upfront, and read one more byte to detect values larger than it
+	// without decompressing them entirely.
+	prealloc := size + 1
+	if prealloc > maxPrealloc {
+		prealloc = maxPrealloc
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, prealloc))
+	if _, err := buf.ReadFrom(io.LimitReader(r, int64(size)+1)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
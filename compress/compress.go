@@ -0,0 +1,170 @@
+// Copyright (c) 2020 DAOT Labs. All rights reserved. Use of this source
+// code is governed by MIT license that can be found in the LICENSE file.
+
+// Package compress provides a ValueTransform which compresses values
+// transparently, and a wrapper using it.
+//
+// Compressed values carry a header:
+//
+//	magic  "\xc0\xde"
+//	codec  byte    (the ID of the Codec)
+//	size   uvarint (the size of the decompressed value)
+//
+// Values without the header are returned as is, so that the values written
+// before wrapping a datastore remain readable. 0xc0 can't start a UTF-8
+// string, so text values are never mistaken for compressed values. Values
+// which are stored uncompressed but start with the magic are written with
+// the header and the NoneID codec.
+package compress
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	ds "github.com/daotl/go-datastore"
+	key "github.com/daotl/go-datastore/key"
+	"github.com/daotl/go-datastore/valuetransform"
+)
+
+// DefaultMinSize is the default minimum size of the values to compress.
+const DefaultMinSize = 64
+
+const magic = "\xc0\xde"
+
+// ErrCorrupt is returned when a compressed value can't be decompressed.
+var ErrCorrupt = errors.New("compress: corrupt value")
+
+// Options are the options of a compression Transform.
+type Options struct {
+	// Codec compresses the values written, Flate(flate.DefaultCompression)
+	// if it's nil.
+	Codec Codec
+	// Codecs are the other codecs used to decompress the values read,
+	// besides Codec and the built-in Flate and Gzip codecs, so that values
+	// written with them remain readable after switching Codec.
+	Codecs []Codec
+	// MinSize is the minimum size of the values to compress,
+	// DefaultMinSize if it's zero. Values which don't shrink when compressed
+	// are stored uncompressed anyway.
+	MinSize int
+}
+
+// Transform is a ValueTransform compressing values.
+type Transform struct {
+	codec   Codec
+	codecs  map[byte]Codec
+	minSize int
+}
+
+var _ valuetransform.ValueTransform = (*Transform)(nil)
+var _ valuetransform.Sizer = (*Transform)(nil)
+
+// New returns a Transform compressing values with the given options.
+func New(opts Options) *Transform {
+	if opts.Codec == nil {
+		opts.Codec = Flate(flate.DefaultCompression)
+	}
+	if opts.MinSize == 0 {
+		opts.MinSize = DefaultMinSize
+	}
+	t := &Transform{
+		codec:   opts.Codec,
+		codecs:  make(map[byte]Codec),
+		minSize: opts.MinSize,
+	}
+	for _, c := range []Codec{Flate(flate.DefaultCompression), Gzip(flate.DefaultCompression)} {
+		t.codecs[c.ID()] = c
+	}
+	for _, c := range append(opts.Codecs, opts.Codec) {
+		if c.ID() == NoneID {
+			panic(fmt.Sprintf("compress: codec %T uses the reserved ID %d", c, NoneID))
+		}
+		t.codecs[c.ID()] = c
+	}
+	return t
+}
+
+// Wrap wraps the given datastore to compress its values.
+func Wrap(child ds.Datastore, opts Options) *valuetransform.Datastore {
+	return valuetransform.Wrap(child, New(opts))
+}
+
+// header appends the header of a value to `dst`.
+func header(dst []byte, id byte, size int) []byte {
+	dst = append(dst, magic...)
+	dst = append(dst, id)
+	var buf [binary.MaxVarintLen64]byte
+	return append(dst, buf[:binary.PutUvarint(buf[:], uint64(size))]...)
+}
+
+// ConvertValue implements ValueTransform.ConvertValue
+func (t *Transform) ConvertValue(k key.Key, value []byte) ([]byte, error) {
+	if len(value) >= t.minSize {
+		h := header(nil, t.codec.ID(), len(value))
+		v, err := t.codec.Compress(h, value)
+		if err != nil {
+			return nil, err
+		}
+		if len(v) < len(value) {
+			return v, nil
+		}
+	}
+	if !bytes.HasPrefix(value, []byte(magic)) {
+		return value, nil
+	}
+	return append(header(nil, NoneID, len(value)), value...), nil
+}
+
+// parse parses the header of a value, ok is false if it has none.
+func (t *Transform) parse(value []byte) (id byte, size int, body []byte, ok bool, err error) {
+	if !bytes.HasPrefix(value, []byte(magic)) {
+		return 0, len(value), value, false, nil
+	}
+	b := value[len(magic):]
+	if len(b) == 0 {
+		return 0, 0, nil, false, ErrCorrupt
+	}
+	id = b[0]
+	s, n := binary.Uvarint(b[1:])
+	if n <= 0 || s > uint64(int(^uint(0)>>1)) {
+		return 0, 0, nil, false, ErrCorrupt
+	}
+	return id, int(s), b[1+n:], true, nil
+}
+
+// InvertValue implements ValueTransform.InvertValue
+func (t *Transform) InvertValue(k key.Key, value []byte) ([]byte, error) {
+	id, size, body, ok, err := t.parse(value)
+	if err != nil || !ok {
+		return body, err
+	}
+	var v []byte
+	if id == NoneID {
+		v = body
+	} else {
+		c, ok := t.codecs[id]
+		if !ok {
+			return nil, fmt.Errorf("compress: unknown codec %d", id)
+		}
+		if v, err = c.Decompress(body, size); err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrCorrupt, err)
+		}
+	}
+	if len(v) != size {
+		return nil, ErrCorrupt
+	}
+	return v, nil
+}
+
+// InvertedSize implements Sizer.InvertedSize, the size is read from the
+// header without decompressing the value.
+func (t *Transform) InvertedSize(k key.Key, value []byte) (int, error) {
+	_, size, _, _, err := t.parse(value)
+	if err != nil {
+		return -1, err
+	}
+	return size, nil
+}
@@ -0,0 +1,158 @@
+// Copyright (c) 2020 DAOT Labs. All rights reserved. Use of this source
+// code is governed by MIT license that can be found in the LICENSE file.
+
+package compress
+
+import (
+	"bytes"
+	"compress/flate"
+	"context"
+	"errors"
+	"testing"
+
+	key "github.com/daotl/go-datastore/key"
+	dsq "github.com/daotl/go-datastore/query"
+	dstest "github.com/daotl/go-datastore/test"
+)
+
+func testSuite(t *testing.T, ktype key.KeyType) {
+	dstest.SubtestAll(t, ktype, Wrap(dstest.NewMapDatastoreForTest(t, ktype), Options{MinSize: 1}))
+}
+
+func TestSuite(t *testing.T) {
+	testSuite(t, key.KeyTypeString)
+	testSuite(t, key.KeyTypeBytes)
+}
+
+var json = bytes.Repeat([]byte(`{"name":"value","list":[1,2,3]},`), 100)
+
+func TestCompression(t *testing.T) {
+	ctx := context.Background()
+	for _, c := range []Codec{Flate(flate.BestSpeed), Gzip(flate.BestCompression)} {
+		child := dstest.NewMapDatastoreForTest(t, key.KeyTypeString)
+		d := Wrap(child, Options{Codec: c})
+
+		k := key.NewStrKey("/json")
+		if err := d.Put(ctx, k, json); err != nil {
+			t.Fatal(err)
+		}
+		stored, err := child.Get(ctx, k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.HasPrefix(stored, []byte(magic)) || stored[len(magic)] != c.ID() || len(stored) >= len(json)/10 {
+			t.Fatalf("expected the value to be compressed with codec %d, stored %d bytes", c.ID(), len(stored))
+		}
+		if v, err := d.Get(ctx, k); err != nil || !bytes.Equal(v, json) {
+			t.Fatal("unexpected value, error: ", err)
+		}
+		if size, err := d.GetSize(ctx, k); err != nil || size != len(json) {
+			t.Fatalf("expected the logical size %d, got %d, error: %v", len(json), size, err)
+		}
+	}
+}
+
+func TestUncompressed(t *testing.T) {
+	ctx := context.Background()
+	child := dstest.NewMapDatastoreForTest(t, key.KeyTypeString)
+	d := Wrap(child, Options{})
+
+	// Values written before wrapping, too small or incompressible values
+	// are stored as is, unless they start with the magic.
+	values := map[string][]byte{
+		"/legacy": json,
+		"/small":  []byte("small"),
+		"/random": []byte("\x8f\x12\xa7\x01\x99\x3c\xe4\x5d\x10\x77\xb2\x4e\x08\xf1\x6a\xd3"),
+		"/magic":  []byte(magic + "\x01\x05hello"),
+	}
+	if err := child.Put(ctx, key.NewStrKey("/legacy"), json); err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range values {
+		if k != "/legacy" {
+			if err := d.Put(ctx, key.NewStrKey(k), v); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	for k, v := range values {
+		if got, err := d.Get(ctx, key.NewStrKey(k)); err != nil || !bytes.Equal(got, v) {
+			t.Fatalf("unexpected value %q for %s, error: %v", got, k, err)
+		}
+		if size, err := d.GetSize(ctx, key.NewStrKey(k)); err != nil || size != len(v) {
+			t.Fatalf("expected size %d for %s, got %d, error: %v", len(v), k, size, err)
+		}
+	}
+	if stored, _ := child.Get(ctx, key.NewStrKey("/small")); string(stored) != "small" {
+		t.Fatalf("expected a small value to be stored as is, got %q", stored)
+	}
+	if stored, _ := child.Get(ctx, key.NewStrKey("/magic")); stored[len(magic)] != NoneID {
+		t.Fatalf("expected a value starting with the magic to have a header, got %q", stored)
+	}
+}
+
+func TestCorrupt(t *testing.T) {
+	ctx := context.Background()
+	child := dstest.NewMapDatastoreForTest(t, key.KeyTypeString)
+	d := Wrap(child, Options{})
+
+	k := key.NewStrKey("/json")
+	if err := d.Put(ctx, k, json); err != nil {
+		t.Fatal(err)
+	}
+	stored, _ := child.Get(ctx, k)
+	for name, v := range map[string][]byte{
+		"truncated":  stored[:len(stored)-4],
+		"no header":  []byte(magic),
+		"wrong size": append(header(nil, FlateID, len(json)+1), stored[len(header(nil, FlateID, len(json))):]...),
+	} {
+		if err := child.Put(ctx, k, v); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := d.Get(ctx, k); !errors.Is(err, ErrCorrupt) {
+			t.Fatalf("%s: expected ErrCorrupt, got: %v", name, err)
+		}
+	}
+
+	if err := child.Put(ctx, k, header(nil, 42, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Get(ctx, k); err == nil {
+		t.Fatal("expected an error for an unknown codec")
+	}
+}
+
+func TestQueryValues(t *testing.T) {
+	ctx := context.Background()
+	d := Wrap(dstest.NewMapDatastoreForTest(t, key.KeyTypeString), Options{MinSize: 1})
+	for k, v := range map[string][]byte{
+		"/a": bytes.Repeat([]byte("c"), 100),
+		"/b": bytes.Repeat([]byte("a"), 100),
+		"/c": bytes.Repeat([]byte("b"), 100),
+	} {
+		if err := d.Put(ctx, key.NewStrKey(k), v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	res, err := d.Query(ctx, dsq.Query{
+		Filters:      []dsq.Filter{dsq.FilterValueCompare{Op: dsq.GreaterThan, Value: []byte("az")}},
+		Orders:       []dsq.Order{dsq.OrderByValue{}},
+		ReturnsSizes: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	es, err := res.Rest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(es) != 2 || es[0].Key.String() != "/c" || es[1].Key.String() != "/a" {
+		t.Fatalf("expected /c and /a, got %v", es)
+	}
+	for _, e := range es {
+		if e.Size != 100 || len(e.Value) != 100 {
+			t.Fatalf("expected decompressed values, got %d bytes", e.Size)
+		}
+	}
+}
@@ -72,7 +72,8 @@ type Write interface {
 // Read is the read-side of the Datastore interface.
 type Read interface {
 	// Get retrieves the object `value` named by `key`.
-	// Get will return ErrNotFound if the key is not mapped to a value.
+	// Get will return ErrNotFound if the key is not mapped to a value. Callers
+	// should test for it with IsNotFound as wrappers may wrap it.
 	Get(ctx context.Context, key key.Key) (value []byte, err error)
 
 	// Has returns whether the `key` is mapped to a `value`.
@@ -176,6 +177,10 @@ type TTL interface {
 	GetExpiration(ctx context.Context, key key.Key) (time.Time, error)
 }
 
+// ErrTTLUnsupported is returned by the TTL methods of wrappers whose child
+// doesn't support entries with time-to-live.
+var ErrTTLUnsupported = errors.New("this datastore does not support TTL")
+
 // Txn extends the Datastore type. Txns allow users to batch queries and
 // mutations to the Datastore into atomic groups, or transactions. Actions
 // performed on a transaction will not take hold until a successful call to
@@ -204,20 +209,9 @@ type TxnDatastore interface {
 	NewTransaction(ctx context.Context, readOnly bool) (Txn, error)
 }
 
-// Errors
-
-type dsError struct {
-	error
-	isNotFound bool
-}
-
-func (e *dsError) NotFound() bool {
-	return e.isNotFound
-}
-
-// ErrNotFound is returned by Get and GetSize when a datastore does not map the
-// given key to a value.
-var ErrNotFound error = &dsError{error: errors.New("datastore: key not found"), isNotFound: true}
+// ErrTxnUnsupported is returned by NewTransaction on wrappers whose child
+// doesn't support transactions.
+var ErrTxnUnsupported = errors.New("this datastore does not support transactions")
 
 // GetBackedHas provides a default Datastore.Has implementation.
 // It exists so Datastore.Has implementations can use it, like so:
@@ -227,10 +221,10 @@ var ErrNotFound error = &dsError{error: errors.New("datastore: key not found"),
 // }
 func GetBackedHas(ctx context.Context, ds Read, key key.Key) (bool, error) {
 	_, err := ds.Get(ctx, key)
-	switch err {
-	case nil:
+	switch {
+	case err == nil:
 		return true, nil
-	case ErrNotFound:
+	case IsNotFound(err):
 		return false, nil
 	default:
 		return false, err
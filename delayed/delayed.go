@@ -78,6 +78,16 @@ func (dds *Delayed) Batch(ctx context.Context) (ds.Batch, error) {
 	return ds.NewBasicBatch(dds), nil
 }
 
+// Children implements the ds.Shim interface.
+func (dds *Delayed) Children() []ds.Datastore {
+	return []ds.Datastore{dds.ds}
+}
+
+// Capabilities implements the ds.Capable interface.
+func (dds *Delayed) Capabilities() ds.Feature {
+	return ds.FeatureBatching | ds.Features(dds.ds)&ds.FeaturePersistent
+}
+
 // DiskUsage implements the ds.PersistentDatastore interface.
 func (dds *Delayed) DiskUsage(ctx context.Context) (uint64, error) {
 	dds.delay.Wait()
@@ -0,0 +1,482 @@
+// Copyright (c) 2020 DAOT Labs. All rights reserved. Use of this source
+// code is governed by MIT license that can be found in the LICENSE file.
+
+// Package dump implements a portable, streaming binary format to dump the
+// entries of a datastore and restore them into any other datastore.
+//
+// A dump starts with a header made of the magic bytes "DSDUMP" followed by
+// the format version as a big-endian uint16. Each entry is then written as a
+// record:
+//
+//	tag        byte    recordEntry
+//	key type   byte    key.KeyType
+//	flags      byte    flagExpiration if an expiration follows the value
+//	key        uvarint length followed by the bytes of the key
+//	value      uvarint length followed by the value
+//	expiration int64   big-endian Unix time in nanoseconds, if flagged
+//	checksum   uint32  big-endian CRC-32C of all the above
+//
+// The dump ends with a trailer:
+//
+//	tag        byte    recordEnd
+//	count      uvarint the number of entries in the dump
+//	checksum   uint32  big-endian CRC-32C of all the above
+//
+// so that truncated dumps are detected.
+package dump
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"time"
+
+	ds "github.com/daotl/go-datastore"
+	key "github.com/daotl/go-datastore/key"
+	dsq "github.com/daotl/go-datastore/query"
+)
+
+// Version is the version of the dump format written by Writer.
+const Version = 1
+
+const magic = "DSDUMP"
+
+const (
+	recordEnd   byte = 0
+	recordEntry byte = 1
+)
+
+const flagExpiration byte = 1
+
+// maxLength is the maximum length of a key or value accepted by Reader, to
+// avoid allocating huge buffers for corrupt lengths.
+const maxLength = 1 << 30
+
+var (
+	// ErrCorrupt is returned when reading a dump which is not valid or
+	// whose checksums don't match.
+	ErrCorrupt = errors.New("dump: corrupt input")
+
+	// ErrTruncated is returned when a dump ends before its trailer.
+	ErrTruncated = errors.New("dump: truncated input")
+
+	// ErrVersion is returned when reading a dump whose format version is not
+	// supported.
+	ErrVersion = errors.New("dump: unsupported version")
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Writer writes entries in the dump format.
+type Writer struct {
+	w     *bufio.Writer
+	count uint64
+	buf   []byte
+	err   error
+}
+
+// NewWriter writes the header of a dump to `w` and returns a Writer of
+// entries. The dump is only complete once Close has been called.
+func NewWriter(w io.Writer) (*Writer, error) {
+	dw := &Writer{w: bufio.NewWriter(w)}
+	hdr := make([]byte, len(magic)+2)
+	copy(hdr, magic)
+	binary.BigEndian.PutUint16(hdr[len(magic):], Version)
+	if _, err := dw.w.Write(hdr); err != nil {
+		return nil, err
+	}
+	return dw, nil
+}
+
+// Write writes an entry to the dump, its key, value and expiration are
+// recorded.
+func (dw *Writer) Write(e dsq.Entry) error {
+	if dw.err != nil {
+		return dw.err
+	}
+
+	var flags byte
+	if !e.Expiration.IsZero() {
+		flags |= flagExpiration
+	}
+	kb := e.Key.Bytes()
+	b := append(dw.buf[:0], recordEntry, byte(e.Key.KeyType()), flags)
+	b = appendBytes(b, kb)
+	b = appendBytes(b, e.Value)
+	if flags&flagExpiration != 0 {
+		var exp [8]byte
+		binary.BigEndian.PutUint64(exp[:], uint64(e.Expiration.UnixNano()))
+		b = append(b, exp[:]...)
+	}
+	b = appendChecksum(b)
+	dw.buf = b
+
+	if _, err := dw.w.Write(b); err != nil {
+		dw.err = err
+		return err
+	}
+	dw.count++
+	return nil
+}
+
+// Close writes the trailer of the dump and flushes it, it doesn't close the
+// underlying writer.
+func (dw *Writer) Close() error {
+	if dw.err != nil {
+		return dw.err
+	}
+	b := append(dw.buf[:0], recordEnd)
+	b = appendUvarint(b, dw.count)
+	b = appendChecksum(b)
+	if _, err := dw.w.Write(b); err != nil {
+		dw.err = err
+		return err
+	}
+	dw.err = errors.New("dump: writer closed")
+	return dw.w.Flush()
+}
+
+func appendUvarint(b []byte, x uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], x)
+	return append(b, buf[:n]...)
+}
+
+func appendBytes(b, v []byte) []byte {
+	b = appendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+func appendChecksum(b []byte) []byte {
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], crc32.Checksum(b, crcTable))
+	return append(b, sum[:]...)
+}
+
+// Reader reads entries in the dump format.
+type Reader struct {
+	r     *bufio.Reader
+	crc   uint32
+	count uint64
+	done  bool
+}
+
+// NewReader reads the header of a dump from `r` and returns a Reader of its
+// entries.
+func NewReader(r io.Reader) (*Reader, error) {
+	dr := &Reader{r: bufio.NewReader(r)}
+	hdr := make([]byte, len(magic)+2)
+	if _, err := io.ReadFull(dr.r, hdr); err != nil {
+		return nil, dr.wrap(err)
+	}
+	if string(hdr[:len(magic)]) != magic {
+		return nil, fmt.Errorf("%w: bad magic", ErrCorrupt)
+	}
+	if v := binary.BigEndian.Uint16(hdr[len(magic):]); v != Version {
+		return nil, fmt.Errorf("%w: %d", ErrVersion, v)
+	}
+	return dr, nil
+}
+
+// wrap converts the errors of reading past the end of the input to
+// ErrTruncated.
+func (dr *Reader) wrap(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrTruncated
+	}
+	return err
+}
+
+func (dr *Reader) readByte() (byte, error) {
+	c, err := dr.r.ReadByte()
+	if err != nil {
+		return 0, dr.wrap(err)
+	}
+	dr.crc = crc32.Update(dr.crc, crcTable, []byte{c})
+	return c, nil
+}
+
+func (dr *Reader) readFull(b []byte) error {
+	if _, err := io.ReadFull(dr.r, b); err != nil {
+		return dr.wrap(err)
+	}
+	dr.crc = crc32.Update(dr.crc, crcTable, b)
+	return nil
+}
+
+func (dr *Reader) readUvarint() (uint64, error) {
+	var x uint64
+	var s uint
+	for i := 0; i < binary.MaxVarintLen64; i++ {
+		c, err := dr.readByte()
+		if err != nil {
+			return 0, err
+		}
+		if c < 0x80 {
+			return x | uint64(c)<<s, nil
+		}
+		x |= uint64(c&0x7f) << s
+		s += 7
+	}
+	return 0, fmt.Errorf("%w: bad varint", ErrCorrupt)
+}
+
+func (dr *Reader) readBytes() ([]byte, error) {
+	n, err := dr.readUvarint()
+	if err != nil {
+		return nil, err
+	}
+	if n > maxLength {
+		return nil, fmt.Errorf("%w: length %d too large", ErrCorrupt, n)
+	}
+	b := make([]byte, n)
+	if err := dr.readFull(b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (dr *Reader) checkChecksum() error {
+	sum := dr.crc
+	var b [4]byte
+	if _, err := io.ReadFull(dr.r, b[:]); err != nil {
+		return dr.wrap(err)
+	}
+	if binary.BigEndian.Uint32(b[:]) != sum {
+		return fmt.Errorf("%w: checksum mismatch", ErrCorrupt)
+	}
+	return nil
+}
+
+// Next returns the next entry of the dump, with its Key, Value, Size and
+// Expiration set. It returns io.EOF after the last entry, once the trailer
+// has been checked.
+func (dr *Reader) Next() (dsq.Entry, error) {
+	if dr.done {
+		return dsq.Entry{}, io.EOF
+	}
+	dr.crc = 0
+
+	tag, err := dr.readByte()
+	if err != nil {
+		return dsq.Entry{}, err
+	}
+	switch tag {
+	case recordEnd:
+		count, err := dr.readUvarint()
+		if err != nil {
+			return dsq.Entry{}, err
+		}
+		if err := dr.checkChecksum(); err != nil {
+			return dsq.Entry{}, err
+		}
+		if count != dr.count {
+			return dsq.Entry{}, fmt.Errorf("%w: expected %d entries, read %d", ErrCorrupt, count, dr.count)
+		}
+		dr.done = true
+		return dsq.Entry{}, io.EOF
+	case recordEntry:
+	default:
+		return dsq.Entry{}, fmt.Errorf("%w: unknown record %d", ErrCorrupt, tag)
+	}
+
+	ktype, err := dr.readByte()
+	if err != nil {
+		return dsq.Entry{}, err
+	}
+	flags, err := dr.readByte()
+	if err != nil {
+		return dsq.Entry{}, err
+	}
+	kb, err := dr.readBytes()
+	if err != nil {
+		return dsq.Entry{}, err
+	}
+	value, err := dr.readBytes()
+	if err != nil {
+		return dsq.Entry{}, err
+	}
+	var exp time.Time
+	if flags&flagExpiration != 0 {
+		var b [8]byte
+		if err := dr.readFull(b[:]); err != nil {
+			return dsq.Entry{}, err
+		}
+		exp = time.Unix(0, int64(binary.BigEndian.Uint64(b[:])))
+	}
+	if err := dr.checkChecksum(); err != nil {
+		return dsq.Entry{}, err
+	}
+	if !key.KeyType(ktype).Available() {
+		return dsq.Entry{}, fmt.Errorf("%w: unknown key type %d", ErrCorrupt, ktype)
+	}
+
+	dr.count++
+	return dsq.Entry{
+		Key:        key.NewKeyFromTypeAndBytes(key.KeyType(ktype), kb),
+		Value:      value,
+		Size:       len(value),
+		Expiration: exp,
+	}, nil
+}
+
+// restoreBatchSize is the number of entries Restore commits at once.
+const restoreBatchSize = 1000
+
+// Dump writes the entries of `d` matching `q` to `w` as a complete dump, and
+// returns the number of entries written. Partial dumps are made with the
+// Prefix, Range and Filters of `q`. Expirations are recorded if `d` returns
+// them.
+func Dump(ctx context.Context, d ds.Read, w io.Writer, q dsq.Query) (int, error) {
+	q.KeysOnly = false
+	q.ReturnExpirations = true
+	res, err := d.Query(ctx, q)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Close()
+
+	dw, err := NewWriter(w)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for {
+		r, ok := res.NextSync()
+		if !ok {
+			break
+		}
+		if r.Error != nil {
+			return n, r.Error
+		}
+		if err := ctx.Err(); err != nil {
+			return n, err
+		}
+		if err := dw.Write(r.Entry); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, dw.Close()
+}
+
+// Restore reads a dump from `r` into `d`, and returns the number of entries
+// restored. Entries are written in batches if `d` is Batching, a batch is
+// only committed once all its entries have been read and checked, but the
+// batches committed before a corrupt entry is found are kept.
+//
+// Entries with an expiration are written with PutWithTTL if `d` is a
+// TTLDatastore, and skipped if they have already expired. Otherwise the
+// expirations are dropped. As batches don't support TTLs, these entries are
+// held back and written right after the batch they belong to is committed.
+func Restore(ctx context.Context, r io.Reader, d ds.Datastore) (int, error) {
+	dr, err := NewReader(r)
+	if err != nil {
+		return 0, err
+	}
+	ttlds, _ := d.(ds.TTLDatastore)
+
+	var w ds.Write = d
+	var b ds.Batch
+	newBatch := func() error {
+		w, b = d, nil
+		bds, ok := d.(ds.Batching)
+		if !ok {
+			return nil
+		}
+		nb, err := bds.Batch(ctx)
+		switch {
+		case err == nil:
+			w, b = nb, nb
+			return nil
+		case errors.Is(err, ds.ErrBatchUnsupported):
+			return nil
+		default:
+			return err
+		}
+	}
+	if err := newBatch(); err != nil {
+		return 0, err
+	}
+
+	// n counts the entries written to `d`, pending the entries of the
+	// current batch, and ttls the entries with an expiration held back
+	// until it's committed.
+	n, pending := 0, 0
+	var ttls []dsq.Entry
+	putWithTTL := func(e dsq.Entry) error {
+		ttl := time.Until(e.Expiration)
+		if ttl <= 0 {
+			return nil
+		}
+		if err := ttlds.PutWithTTL(ctx, e.Key, e.Value, ttl); err != nil {
+			return err
+		}
+		n++
+		return nil
+	}
+	commit := func() error {
+		if b != nil {
+			if err := b.Commit(ctx); err != nil {
+				return err
+			}
+			n += pending
+			pending = 0
+		}
+		for len(ttls) > 0 {
+			if err := putWithTTL(ttls[0]); err != nil {
+				return err
+			}
+			ttls = ttls[1:]
+		}
+		return nil
+	}
+
+	for {
+		e, err := dr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return n, err
+		}
+		if err := ctx.Err(); err != nil {
+			return n, err
+		}
+
+		switch {
+		case e.Expiration.IsZero() || ttlds == nil:
+			if err := w.Put(ctx, e.Key, e.Value); err != nil {
+				return n, err
+			}
+			if b != nil {
+				pending++
+			} else {
+				n++
+			}
+		case !time.Now().Before(e.Expiration):
+			continue
+		case b != nil:
+			ttls = append(ttls, e)
+		default:
+			if err := putWithTTL(e); err != nil {
+				return n, err
+			}
+		}
+
+		if b != nil && pending+len(ttls) == restoreBatchSize {
+			if err := commit(); err != nil {
+				return n, err
+			}
+			if err := newBatch(); err != nil {
+				return n, err
+			}
+		}
+	}
+	err = commit()
+	return n, err
+}
@@ -0,0 +1,231 @@
+// Copyright (c) 2020 DAOT Labs. All rights reserved. Use of this source
+// code is governed by MIT license that can be found in the LICENSE file.
+
+package dump
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	ds "github.com/daotl/go-datastore"
+	key "github.com/daotl/go-datastore/key"
+	dsq "github.com/daotl/go-datastore/query"
+	dstest "github.com/daotl/go-datastore/test"
+	"github.com/daotl/go-datastore/ttl"
+)
+
+func fill(t *testing.T, ktype key.KeyType, n int) *ds.MapDatastore {
+	ctx := context.Background()
+	d := dstest.NewMapDatastoreForTest(t, ktype)
+	for i := 0; i < n; i++ {
+		s := fmt.Sprintf("/a/%04d", i)
+		if i%2 == 1 {
+			s = fmt.Sprintf("/b/%04d", i)
+		}
+		if err := d.Put(ctx, key.NewKeyFromTypeAndString(ktype, s), []byte(s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return d
+}
+
+func entries(t *testing.T, d ds.Datastore) []dsq.Entry {
+	res, err := d.Query(context.Background(), dsq.Query{Orders: []dsq.Order{dsq.OrderByKey{}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	es, err := res.Rest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return es
+}
+
+func dump(t *testing.T, d ds.Datastore, q dsq.Query) []byte {
+	var buf bytes.Buffer
+	if _, err := Dump(context.Background(), d, &buf, q); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func testRoundTrip(t *testing.T, ktype key.KeyType) {
+	ctx := context.Background()
+	src := fill(t, ktype, 2500)
+
+	var buf bytes.Buffer
+	n, err := Dump(ctx, src, &buf, dsq.Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2500 {
+		t.Fatalf("expected to dump 2500 entries, dumped %d", n)
+	}
+
+	dst := dstest.NewMapDatastoreForTest(t, ktype)
+	n, err = Restore(ctx, &buf, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2500 {
+		t.Fatalf("expected to restore 2500 entries, restored %d", n)
+	}
+
+	se, de := entries(t, src), entries(t, dst)
+	if len(se) != len(de) {
+		t.Fatalf("expected %d entries, got %d", len(se), len(de))
+	}
+	for i := range se {
+		if !se[i].Key.Equal(de[i].Key) || !bytes.Equal(se[i].Value, de[i].Value) {
+			t.Fatalf("entry %d differs: %s != %s", i, se[i].Key, de[i].Key)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	testRoundTrip(t, key.KeyTypeString)
+	testRoundTrip(t, key.KeyTypeBytes)
+}
+
+func TestPrefix(t *testing.T) {
+	ctx := context.Background()
+	src := fill(t, key.KeyTypeBytes, 100)
+	b := dump(t, src, dsq.Query{Prefix: key.NewBytesKeyFromString("/b")})
+
+	dst := dstest.NewMapDatastoreForTest(t, key.KeyTypeBytes)
+	n, err := Restore(ctx, bytes.NewReader(b), dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 50 {
+		t.Fatalf("expected to restore 50 entries, restored %d", n)
+	}
+	for _, e := range entries(t, dst) {
+		if !bytes.HasPrefix(e.Key.Bytes(), []byte("/b")) {
+			t.Fatalf("unexpected key %s", e.Key)
+		}
+	}
+}
+
+func TestExpiration(t *testing.T) {
+	ctx := context.Background()
+	src := ttl.New(dstest.NewMapDatastoreForTest(t, key.KeyTypeString), ttl.Options{SweepInterval: -1})
+	defer src.Close()
+
+	if err := src.PutWithTTL(ctx, key.NewStrKey("/ttl"), []byte("a"), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := src.Put(ctx, key.NewStrKey("/plain"), []byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	exp, err := src.GetExpiration(ctx, key.NewStrKey("/ttl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := dump(t, src, dsq.Query{})
+
+	dr, err := NewReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for {
+		e, err := dr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch e.Key.String() {
+		case "/ttl":
+			if !e.Expiration.Equal(exp) {
+				t.Fatalf("expected expiration %s, got %s", exp, e.Expiration)
+			}
+		case "/plain":
+			if !e.Expiration.IsZero() {
+				t.Fatal("expected no expiration, got: ", e.Expiration)
+			}
+		}
+	}
+
+	dst := ttl.New(dstest.NewMapDatastoreForTest(t, key.KeyTypeString), ttl.Options{SweepInterval: -1})
+	defer dst.Close()
+	if _, err := Restore(ctx, bytes.NewReader(b), dst); err != nil {
+		t.Fatal(err)
+	}
+	got, err := dst.GetExpiration(ctx, key.NewStrKey("/ttl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := got.Sub(exp); d < -time.Minute || d > time.Minute {
+		t.Fatalf("expected expiration close to %s, got %s", exp, got)
+	}
+
+	// Entries with an expiration aren't written before their batch is.
+	dst = ttl.New(dstest.NewMapDatastoreForTest(t, key.KeyTypeString), ttl.Options{SweepInterval: -1})
+	defer dst.Close()
+	n, err := Restore(ctx, bytes.NewReader(b[:len(b)-1]), dst)
+	if !errors.Is(err, ErrTruncated) {
+		t.Fatal("expected ErrTruncated, got: ", err)
+	}
+	if es := entries(t, dst); n != 0 || len(es) != 0 {
+		t.Fatalf("expected nothing to be restored, got %d entries", len(es))
+	}
+}
+
+func TestTruncated(t *testing.T) {
+	ctx := context.Background()
+	b := dump(t, fill(t, key.KeyTypeString, 10), dsq.Query{})
+
+	for _, n := range []int{3, len(magic) + 2, len(magic) + 10, len(b) - 1} {
+		dst := dstest.NewMapDatastoreForTest(t, key.KeyTypeString)
+		_, err := Restore(ctx, bytes.NewReader(b[:n]), dst)
+		if !errors.Is(err, ErrTruncated) {
+			t.Fatalf("expected ErrTruncated for %d bytes, got: %v", n, err)
+		}
+	}
+
+	// The entries written without a batch before the error are counted.
+	dst := dstest.NewMapDatastoreForTest(t, key.KeyTypeString)
+	n, err := Restore(ctx, bytes.NewReader(b[:len(b)-1]), struct{ ds.Datastore }{dst})
+	if !errors.Is(err, ErrTruncated) {
+		t.Fatal("expected ErrTruncated, got: ", err)
+	}
+	if n != 10 || len(entries(t, dst)) != 10 {
+		t.Fatalf("expected 10 entries to be restored, got %d", n)
+	}
+}
+
+func TestCorrupt(t *testing.T) {
+	ctx := context.Background()
+	b := dump(t, fill(t, key.KeyTypeString, 10), dsq.Query{})
+
+	for i := len(magic) + 2; i < len(b); i++ {
+		c := append([]byte(nil), b...)
+		c[i] ^= 0x40
+		dst := dstest.NewMapDatastoreForTest(t, key.KeyTypeString)
+		_, err := Restore(ctx, bytes.NewReader(c), dst)
+		if !errors.Is(err, ErrCorrupt) && !errors.Is(err, ErrTruncated) {
+			t.Fatalf("expected ErrCorrupt for byte %d flipped, got: %v", i, err)
+		}
+	}
+
+	c := append([]byte(nil), b...)
+	c[0] = 'X'
+	if _, err := NewReader(bytes.NewReader(c)); !errors.Is(err, ErrCorrupt) {
+		t.Fatal("expected ErrCorrupt for bad magic, got: ", err)
+	}
+}
+
+func TestVersion(t *testing.T) {
+	b := dump(t, fill(t, key.KeyTypeString, 1), dsq.Query{})
+	b[len(magic)+1] = Version + 1
+	if _, err := NewReader(bytes.NewReader(b)); !errors.Is(err, ErrVersion) {
+		t.Fatal("expected ErrVersion, got: ", err)
+	}
+}
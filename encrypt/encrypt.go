@@ -0,0 +1,163 @@
+// Copyright (c) 2020 DAOT Labs. All rights reserved. Use of this source
+// code is governed by MIT license that can be found in the LICENSE file.
+
+// Package encrypt provides a ValueTransform which encrypts values with
+// AES-GCM, and a wrapper using it.
+//
+// Encrypted values are stored as:
+//
+//	version  byte   (Version)
+//	key ID   uint32 (big-endian, the ID of the key in the KeyProvider)
+//	nonce    [12]byte
+//	sealed   []byte (the ciphertext and the GCM tag)
+//
+// The header and the datastore key are authenticated as the additional data
+// of the AEAD, so that values can't be swapped between keys, nor their key ID
+// tampered with.
+package encrypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+
+	ds "github.com/daotl/go-datastore"
+	key "github.com/daotl/go-datastore/key"
+	"github.com/daotl/go-datastore/valuetransform"
+)
+
+// Version is the version of the format of the values.
+const Version = 1
+
+const (
+	nonceSize  = 12
+	headerSize = 1 + 4
+	// Overhead is the size added to values by encryption.
+	Overhead = headerSize + nonceSize + 16
+)
+
+// ErrDecrypt is returned when a value can't be decrypted, because it's
+// corrupted, has been tampered with or moved to another key.
+var ErrDecrypt = errors.New("encrypt: cannot decrypt value")
+
+// Transform is a ValueTransform encrypting values.
+type Transform struct {
+	kp KeyProvider
+
+	lk    sync.RWMutex
+	aeads map[uint32]cipher.AEAD
+}
+
+var _ valuetransform.ValueTransform = (*Transform)(nil)
+var _ valuetransform.Sizer = (*Transform)(nil)
+
+// New returns a Transform encrypting values with the keys of `kp`.
+func New(kp KeyProvider) *Transform {
+	if kp == nil {
+		panic("kp (KeyProvider) is nil")
+	}
+	return &Transform{kp: kp, aeads: make(map[uint32]cipher.AEAD)}
+}
+
+// Wrap wraps the given datastore to encrypt its values with the keys of
+// `kp`.
+func Wrap(child ds.Datastore, kp KeyProvider) *valuetransform.Datastore {
+	return valuetransform.Wrap(child, New(kp))
+}
+
+// aead returns the AEAD of the key with the given ID, they're cached as the
+// key of an ID never changes.
+func (t *Transform) aead(id uint32, k []byte) (cipher.AEAD, error) {
+	t.lk.RLock()
+	a, ok := t.aeads[id]
+	t.lk.RUnlock()
+	if ok {
+		return a, nil
+	}
+
+	if k == nil {
+		var err error
+		if k, err = t.kp.Key(id); err != nil {
+			return nil, fmt.Errorf("key ID %d: %w", id, err)
+		}
+	}
+	b, err := aes.NewCipher(k)
+	if err != nil {
+		return nil, err
+	}
+	if a, err = cipher.NewGCM(b); err != nil {
+		return nil, err
+	}
+	t.lk.Lock()
+	t.aeads[id] = a
+	t.lk.Unlock()
+	return a, nil
+}
+
+// additionalData returns the data authenticated with the value of `k`.
+func additionalData(header []byte, k key.Key) []byte {
+	return append(append([]byte(nil), header...), k.Bytes()...)
+}
+
+// ConvertValue implements ValueTransform.ConvertValue
+func (t *Transform) ConvertValue(k key.Key, value []byte) ([]byte, error) {
+	id, kb, err := t.kp.CurrentKey()
+	if err != nil {
+		return nil, err
+	}
+	return t.encrypt(id, kb, k, value)
+}
+
+func (t *Transform) encrypt(id uint32, kb []byte, k key.Key, value []byte) ([]byte, error) {
+	a, err := t.aead(id, kb)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, headerSize+nonceSize, Overhead+len(value))
+	out[0] = Version
+	binary.BigEndian.PutUint32(out[1:], id)
+	if _, err := rand.Read(out[headerSize:]); err != nil {
+		return nil, err
+	}
+	return a.Seal(out, out[headerSize:], value, additionalData(out[:headerSize], k)), nil
+}
+
+// KeyID returns the ID of the key which encrypted `value`, as stored by the
+// child of the wrapper.
+func KeyID(value []byte) (uint32, error) {
+	if len(value) < Overhead || value[0] != Version {
+		return 0, ErrDecrypt
+	}
+	return binary.BigEndian.Uint32(value[1:]), nil
+}
+
+// InvertValue implements ValueTransform.InvertValue
+func (t *Transform) InvertValue(k key.Key, value []byte) ([]byte, error) {
+	id, err := KeyID(value)
+	if err != nil {
+		return nil, err
+	}
+	a, err := t.aead(id, nil)
+	if err != nil {
+		return nil, err
+	}
+	nonce := value[headerSize : headerSize+nonceSize]
+	v, err := a.Open(nil, nonce, value[headerSize+nonceSize:], additionalData(value[:headerSize], k))
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return v, nil
+}
+
+// InvertedSize implements Sizer.InvertedSize, the size is computed without
+// decrypting, nor authenticating the value.
+func (t *Transform) InvertedSize(k key.Key, value []byte) (int, error) {
+	if _, err := KeyID(value); err != nil {
+		return -1, err
+	}
+	return len(value) - Overhead, nil
+}
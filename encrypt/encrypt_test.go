@@ -0,0 +1,252 @@
+// Copyright (c) 2020 DAOT Labs. All rights reserved. Use of this source
+// code is governed by MIT license that can be found in the LICENSE file.
+
+package encrypt
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	ds "github.com/daotl/go-datastore"
+	key "github.com/daotl/go-datastore/key"
+	dsq "github.com/daotl/go-datastore/query"
+	dstest "github.com/daotl/go-datastore/test"
+)
+
+func newKeyring(t *testing.T) *Keyring {
+	r, err := NewKeyring(1, bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func testSuite(t *testing.T, ktype key.KeyType) {
+	dstest.SubtestAll(t, ktype, Wrap(dstest.NewMapDatastoreForTest(t, ktype), newKeyring(t)))
+}
+
+func TestSuite(t *testing.T) {
+	testSuite(t, key.KeyTypeString)
+	testSuite(t, key.KeyTypeBytes)
+}
+
+func TestEncryption(t *testing.T) {
+	ctx := context.Background()
+	child := dstest.NewMapDatastoreForTest(t, key.KeyTypeString)
+	d := Wrap(child, newKeyring(t))
+
+	a, b := key.NewStrKey("/a"), key.NewStrKey("/b")
+	if err := d.Put(ctx, a, []byte("secret a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Put(ctx, b, []byte("secret b")); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := child.Get(ctx, a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(stored, []byte("secret")) || len(stored) != len("secret a")+Overhead {
+		t.Fatalf("expected an encrypted value, got %q", stored)
+	}
+	if v, err := d.Get(ctx, a); err != nil || string(v) != "secret a" {
+		t.Fatalf("unexpected value %q, error: %v", v, err)
+	}
+	if size, err := d.GetSize(ctx, a); err != nil || size != len("secret a") {
+		t.Fatalf("unexpected size %d, error: %v", size, err)
+	}
+
+	// The value of /a can't be read as /b's.
+	if err := child.Put(ctx, b, stored); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Get(ctx, b); err != ErrDecrypt {
+		t.Fatal("expected ErrDecrypt for a swapped value, got: ", err)
+	}
+
+	for i := range stored {
+		tampered := append([]byte(nil), stored...)
+		tampered[i] ^= 1
+		if err := child.Put(ctx, a, tampered); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := d.Get(ctx, a); err == nil {
+			t.Fatalf("expected an error for a value tampered at %d", i)
+		}
+	}
+
+	if err := Wrap(child, newKeyring(t)).Put(ctx, a, []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	other, err := NewKeyring(2, bytes.Repeat([]byte{2}, 16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Wrap(child, other).Get(ctx, a); !errors.Is(err, ErrUnknownKey) {
+		t.Fatal("expected ErrUnknownKey, got: ", err)
+	}
+}
+
+func TestQuery(t *testing.T) {
+	ctx := context.Background()
+	d := Wrap(dstest.NewMapDatastoreForTest(t, key.KeyTypeString), newKeyring(t))
+	for k, v := range map[string]string{"/a": "3", "/b": "1", "/c": "2"} {
+		if err := d.Put(ctx, key.NewStrKey(k), []byte(v)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	res, err := d.Query(ctx, dsq.Query{
+		Filters: []dsq.Filter{dsq.FilterValueCompare{Op: dsq.GreaterThanOrEqual, Value: []byte("2")}},
+		Orders:  []dsq.Order{dsq.OrderByValue{}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	es, err := res.Rest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(es) != 2 || es[0].Key.String() != "/c" || string(es[1].Value) != "3" {
+		t.Fatalf("expected /c and /a in plaintext, got %v", es)
+	}
+}
+
+func TestTxn(t *testing.T) {
+	ctx := context.Background()
+	child, err := ds.NewTxnMapDatastore(key.KeyTypeString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := Wrap(child, newKeyring(t))
+
+	txn, err := d.NewTransaction(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k := key.NewStrKey("/a")
+	if err := txn.Put(ctx, k, []byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := txn.Get(ctx, k); err != nil || string(v) != "secret" {
+		t.Fatalf("unexpected value %q in the transaction, error: %v", v, err)
+	}
+	if err := txn.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := child.Get(ctx, k); err != nil || bytes.Contains(v, []byte("secret")) {
+		t.Fatalf("expected an encrypted value, got %q, error: %v", v, err)
+	}
+	if v, err := d.Get(ctx, k); err != nil || string(v) != "secret" {
+		t.Fatalf("unexpected value %q, error: %v", v, err)
+	}
+}
+
+func testReencrypt(t *testing.T, child ds.Datastore) {
+	ctx := context.Background()
+	kr := newKeyring(t)
+	d := Wrap(child, kr)
+	for i := 0; i < 25; i++ {
+		if err := d.Put(ctx, key.NewStrKey(fmt.Sprintf("/%d", i)), []byte(fmt.Sprint(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := kr.Add(2, bytes.Repeat([]byte{2}, 24)); err != nil {
+		t.Fatal(err)
+	}
+	if err := kr.Rotate(2); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Put(ctx, key.NewStrKey("/0"), []byte("0")); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := Reencrypt(ctx, child, kr, ReencryptOptions{BatchSize: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 24 {
+		t.Fatalf("expected 24 values to be re-encrypted, got %d", n)
+	}
+
+	// Only the new key is needed now.
+	only, err := NewKeyring(2, bytes.Repeat([]byte{2}, 24))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d = Wrap(child, only)
+	for i := 0; i < 25; i++ {
+		k := key.NewStrKey(fmt.Sprintf("/%d", i))
+		if v, err := d.Get(ctx, k); err != nil || string(v) != fmt.Sprint(i) {
+			t.Fatalf("unexpected value %q for %s, error: %v", v, k, err)
+		}
+	}
+	if n, err := Reencrypt(ctx, child, only, ReencryptOptions{}); err != nil || n != 0 {
+		t.Fatalf("expected nothing to re-encrypt, got %d, error: %v", n, err)
+	}
+}
+
+func TestReencrypt(t *testing.T) {
+	testReencrypt(t, dstest.NewMapDatastoreForTest(t, key.KeyTypeString))
+
+	// With conditional writes.
+	child, err := ds.NewTxnMapDatastore(key.KeyTypeString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testReencrypt(t, child)
+
+	// Without writes while a query is in progress.
+	testReencrypt(t, &queryLocked{Datastore: dstest.NewMapDatastoreForTest(t, key.KeyTypeString)})
+}
+
+// queryLocked is a Datastore which fails the writes while a query is open.
+type queryLocked struct {
+	ds.Datastore
+	open int
+}
+
+type lockedResults struct {
+	dsq.Results
+	d *queryLocked
+}
+
+func (r *lockedResults) Close() error {
+	if r.d != nil {
+		r.d.open--
+		r.d = nil
+	}
+	return r.Results.Close()
+}
+
+func (d *queryLocked) Put(ctx context.Context, k key.Key, value []byte) error {
+	if d.open > 0 {
+		return errors.New("write during a query")
+	}
+	return d.Datastore.Put(ctx, k, value)
+}
+
+func (d *queryLocked) Query(ctx context.Context, q dsq.Query) (dsq.Results, error) {
+	res, err := d.Datastore.Query(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	d.open++
+	return &lockedResults{Results: res, d: d}, nil
+}
+
+func TestKeyring(t *testing.T) {
+	if _, err := NewKeyring(1, []byte("short")); err == nil {
+		t.Fatal("expected an error for an invalid key size")
+	}
+	kr := newKeyring(t)
+	if err := kr.Add(1, bytes.Repeat([]byte{3}, 32)); err == nil {
+		t.Fatal("expected an error reusing a key ID")
+	}
+	if err := kr.Rotate(3); err != ErrUnknownKey {
+		t.Fatal("expected ErrUnknownKey, got: ", err)
+	}
+}
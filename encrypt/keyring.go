@@ -0,0 +1,96 @@
+// Copyright (c) 2020 DAOT Labs. All rights reserved. Use of this source
+// code is governed by MIT license that can be found in the LICENSE file.
+
+package encrypt
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrUnknownKey is returned by KeyProviders for unknown key IDs.
+var ErrUnknownKey = errors.New("encrypt: unknown key")
+
+// KeyProvider provides the AES keys used to encrypt and decrypt values,
+// which must be 16, 24 or 32 bytes long. Keys are identified by IDs stored
+// in the headers of the values they encrypted, so the key of an ID must
+// never change, and must remain available as long as values encrypted with
+// it are stored.
+type KeyProvider interface {
+	// CurrentKey returns the key used to encrypt the values written, and its
+	// ID.
+	CurrentKey() (id uint32, key []byte, err error)
+	// Key returns the key with the given ID, ErrUnknownKey if there is none.
+	Key(id uint32) ([]byte, error)
+}
+
+// Keyring is an in-memory KeyProvider, safe for concurrent use.
+type Keyring struct {
+	lk      sync.RWMutex
+	current uint32
+	keys    map[uint32][]byte
+}
+
+var _ KeyProvider = (*Keyring)(nil)
+
+// NewKeyring returns a Keyring whose current key is `key` with ID `id`.
+func NewKeyring(id uint32, key []byte) (*Keyring, error) {
+	r := &Keyring{keys: make(map[uint32][]byte)}
+	if err := r.Add(id, key); err != nil {
+		return nil, err
+	}
+	r.current = id
+	return r, nil
+}
+
+// Add adds a key with the given ID to the keyring, it fails if the ID is
+// already used by another key.
+func (r *Keyring) Add(id uint32, key []byte) error {
+	switch len(key) {
+	case 16, 24, 32:
+	default:
+		return fmt.Errorf("encrypt: invalid key size %d", len(key))
+	}
+	r.lk.Lock()
+	defer r.lk.Unlock()
+	if k, ok := r.keys[id]; ok {
+		if string(k) != string(key) {
+			return fmt.Errorf("encrypt: key ID %d is already used", id)
+		}
+		return nil
+	}
+	r.keys[id] = append([]byte(nil), key...)
+	return nil
+}
+
+// Rotate makes the key with the given ID the current key, the values
+// written afterwards are encrypted with it. See Reencrypt to rotate the
+// values already written.
+func (r *Keyring) Rotate(id uint32) error {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+	if _, ok := r.keys[id]; !ok {
+		return ErrUnknownKey
+	}
+	r.current = id
+	return nil
+}
+
+// CurrentKey implements KeyProvider.CurrentKey
+func (r *Keyring) CurrentKey() (uint32, []byte, error) {
+	r.lk.RLock()
+	defer r.lk.RUnlock()
+	return r.current, r.keys[r.current], nil
+}
+
+// Key implements KeyProvider.Key
+func (r *Keyring) Key(id uint32) ([]byte, error) {
+	r.lk.RLock()
+	defer r.lk.RUnlock()
+	k, ok := r.keys[id]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	return k, nil
+}
@@ -0,0 +1,127 @@
+// Copyright (c) 2020 DAOT Labs. All rights reserved. Use of this source
+// code is governed by MIT license that can be found in the LICENSE file.
+
+package encrypt
+
+import (
+	"context"
+	"fmt"
+
+	ds "github.com/daotl/go-datastore"
+	key "github.com/daotl/go-datastore/key"
+	dsq "github.com/daotl/go-datastore/query"
+)
+
+// DefaultReencryptBatchSize is the number of values written at once by
+// Reencrypt if ReencryptOptions.BatchSize is not set.
+const DefaultReencryptBatchSize = 1000
+
+// ReencryptOptions are the options of Reencrypt.
+type ReencryptOptions struct {
+	// Prefix selects the values to re-encrypt, like in query.Query.
+	Prefix key.Key
+	// BatchSize is the number of values written at once, when the child
+	// doesn't support conditional writes.
+	BatchSize int
+}
+
+// Reencrypt re-encrypts the values of `child`, the child of a wrapper
+// encrypting with the keys of `kp`, which aren't encrypted with the current
+// key of `kp`, and returns the number of values re-encrypted. The old keys
+// can be removed from `kp` once it succeeded.
+//
+// The values to re-encrypt are all read before any is written, as not all
+// the datastores support writes while a query is in progress.
+//
+// If the child supports conditional writes (see ds.CompareAndSwap), each
+// value is only replaced if it hasn't changed since it was read, so that
+// the wrapper can be used meanwhile. Otherwise the values are written in
+// batches, and must not be written concurrently.
+func Reencrypt(ctx context.Context, child ds.Datastore, kp KeyProvider, opts ReencryptOptions) (int, error) {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultReencryptBatchSize
+	}
+	t := New(kp)
+	id, kb, err := kp.CurrentKey()
+	if err != nil {
+		return 0, err
+	}
+	cas := ds.CASFeature(child) != 0
+
+	entries, err := stale(ctx, child, opts.Prefix, id)
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	var keys []key.Key
+	var values [][]byte
+	flush := func() error {
+		if len(keys) == 0 {
+			return nil
+		}
+		if err := ds.PutMany(ctx, child, keys, values); err != nil {
+			return err
+		}
+		n += len(keys)
+		keys, values = keys[:0], values[:0]
+		return nil
+	}
+
+	for _, e := range entries {
+		v, err := t.InvertValue(e.Key, e.Value)
+		if err != nil {
+			return n, fmt.Errorf("decrypting %s: %w", e.Key, err)
+		}
+		if v, err = t.encrypt(id, kb, e.Key, v); err != nil {
+			return n, err
+		}
+
+		if cas {
+			swapped, err := ds.CompareAndSwap(ctx, child, e.Key, e.Value, v)
+			if err != nil {
+				return n, err
+			}
+			if swapped {
+				n++
+			}
+			continue
+		}
+		keys = append(keys, e.Key)
+		values = append(values, v)
+		if len(keys) == opts.BatchSize {
+			if err := flush(); err != nil {
+				return n, err
+			}
+		}
+	}
+	err = flush()
+	return n, err
+}
+
+// stale returns the entries of `child` under `prefix` which aren't encrypted
+// with the key `id`.
+func stale(ctx context.Context, child ds.Datastore, prefix key.Key, id uint32) ([]dsq.Entry, error) {
+	res, err := child.Query(ctx, dsq.Query{Prefix: prefix})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	var entries []dsq.Entry
+	for {
+		r, ok := res.NextSync()
+		if !ok {
+			break
+		}
+		if r.Error != nil {
+			return nil, r.Error
+		}
+		if old, err := KeyID(r.Value); err != nil {
+			return nil, fmt.Errorf("reading %s: %w", r.Key, err)
+		} else if old != id {
+			entries = append(entries, r.Entry)
+		}
+	}
+	return entries, nil
+}
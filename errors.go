@@ -0,0 +1,77 @@
+// Copyright (c) 2020 DAOT Labs. All rights reserved. Use of this source
+// code is governed by MIT license that can be found in the LICENSE file.
+
+package datastore
+
+import (
+	"errors"
+)
+
+// Datastores and wrappers may wrap the errors below with additional context,
+// so they should be tested with errors.Is or the helpers below rather than
+// compared with ==.
+
+type dsError struct {
+	error
+	isNotFound bool
+}
+
+func (e *dsError) NotFound() bool {
+	return e.isNotFound
+}
+
+var (
+	// ErrNotFound is returned by Get and GetSize when a datastore does not map
+	// the given key to a value.
+	ErrNotFound error = &dsError{error: errors.New("datastore: key not found"), isNotFound: true}
+
+	// ErrClosed is returned when using a datastore which has been closed.
+	ErrClosed error = &dsError{error: errors.New("datastore: closed")}
+
+	// ErrReadOnly is returned when trying to write through a read-only
+	// transaction or datastore.
+	ErrReadOnly error = &dsError{error: errors.New("datastore: read-only")}
+
+	// ErrTxnConflict is returned by Txn.Commit if another transaction has
+	// written a key read by the transaction since it was started.
+	ErrTxnConflict error = &dsError{error: errors.New("datastore: transaction conflict")}
+
+	// ErrKeyTooLarge is returned by datastores which limit the size of keys
+	// when a key exceeds the limit.
+	ErrKeyTooLarge error = &dsError{error: errors.New("datastore: key too large")}
+
+	// ErrValueTooLarge is returned by datastores which limit the size of
+	// values when a value exceeds the limit.
+	ErrValueTooLarge error = &dsError{error: errors.New("datastore: value too large")}
+)
+
+// notFound is implemented by errors from other packages which may report
+// themselves as not found, like ErrNotFound.
+type notFound interface {
+	NotFound() bool
+}
+
+// IsNotFound reports whether err is, or wraps, ErrNotFound or an error
+// which reports itself as not found with a `NotFound() bool` method.
+func IsNotFound(err error) bool {
+	if errors.Is(err, ErrNotFound) {
+		return true
+	}
+	var nf notFound
+	return errors.As(err, &nf) && nf.NotFound()
+}
+
+// IsClosed reports whether err is, or wraps, ErrClosed.
+func IsClosed(err error) bool {
+	return errors.Is(err, ErrClosed)
+}
+
+// IsReadOnly reports whether err is, or wraps, ErrReadOnly.
+func IsReadOnly(err error) bool {
+	return errors.Is(err, ErrReadOnly)
+}
+
+// IsTxnConflict reports whether err is, or wraps, ErrTxnConflict.
+func IsTxnConflict(err error) bool {
+	return errors.Is(err, ErrTxnConflict)
+}
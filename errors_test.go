@@ -0,0 +1,50 @@
+// Copyright (c) 2020 DAOT Labs. All rights reserved. Use of this source
+// code is governed by MIT license that can be found in the LICENSE file.
+
+package datastore_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	dstore "github.com/daotl/go-datastore"
+)
+
+type notFoundError struct{}
+
+func (notFoundError) Error() string  { return "missing" }
+func (notFoundError) NotFound() bool { return true }
+
+func TestErrorHelpers(t *testing.T) {
+	wrap := func(err error) error {
+		return fmt.Errorf("wrapped: %w", err)
+	}
+
+	if !dstore.IsNotFound(dstore.ErrNotFound) || !dstore.IsNotFound(wrap(dstore.ErrNotFound)) {
+		t.Fatal("expected ErrNotFound to be not found")
+	}
+	if !dstore.IsNotFound(wrap(notFoundError{})) {
+		t.Fatal("expected an error with a NotFound method to be not found")
+	}
+	if dstore.IsNotFound(nil) || dstore.IsNotFound(errors.New("datastore: key not found")) {
+		t.Fatal("unexpected not found error")
+	}
+	if dstore.IsNotFound(wrap(dstore.ErrClosed)) {
+		t.Fatal("ErrClosed is not a not found error")
+	}
+
+	if !dstore.IsClosed(wrap(dstore.ErrClosed)) || dstore.IsClosed(dstore.ErrReadOnly) {
+		t.Fatal("IsClosed failed")
+	}
+	if !dstore.IsReadOnly(wrap(dstore.ErrReadOnly)) || dstore.IsReadOnly(dstore.ErrClosed) {
+		t.Fatal("IsReadOnly failed")
+	}
+	if !dstore.IsTxnConflict(wrap(dstore.ErrTxnConflict)) || dstore.IsTxnConflict(dstore.ErrNotFound) {
+		t.Fatal("IsTxnConflict failed")
+	}
+	if !errors.Is(wrap(dstore.ErrKeyTooLarge), dstore.ErrKeyTooLarge) ||
+		errors.Is(dstore.ErrKeyTooLarge, dstore.ErrValueTooLarge) {
+		t.Fatal("errors.Is failed")
+	}
+}
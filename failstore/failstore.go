@@ -104,6 +104,16 @@ func (d *Failstore) Query(ctx context.Context, q dsq.Query) (dsq.Results, error)
 	return d.child.Query(ctx, q)
 }
 
+// Children implements the Shim interface.
+func (d *Failstore) Children() []ds.Datastore {
+	return []ds.Datastore{d.child}
+}
+
+// Capabilities implements the Capable interface.
+func (d *Failstore) Capabilities() ds.Feature {
+	return ds.Features(d.child) & (ds.FeatureBatching | ds.FeaturePersistent)
+}
+
 // DiskUsage implements the PersistentDatastore interface.
 func (d *Failstore) DiskUsage(ctx context.Context) (uint64, error) {
 	if err := d.errfunc("disk-usage"); err != nil {
@@ -129,7 +139,12 @@ func (d *Failstore) Batch(ctx context.Context) (ds.Batch, error) {
 		return nil, err
 	}
 
-	b, err := d.child.(ds.Batching).Batch(ctx)
+	bds, ok := d.child.(ds.Batching)
+	if !ok {
+		return nil, ds.ErrBatchUnsupported
+	}
+
+	b, err := bds.Batch(ctx)
 	if err != nil {
 		return nil, err
 	}
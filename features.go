@@ -0,0 +1,147 @@
+// Copyright (c) 2020 DAOT Labs. All rights reserved. Use of this source
+// code is governed by MIT license that can be found in the LICENSE file.
+
+package datastore
+
+import (
+	"strings"
+)
+
+// Feature is a set of optional capabilities of a datastore, each of which
+// corresponds to one of the optional interfaces.
+type Feature uint32
+
+const (
+	// FeatureBatching means the datastore is Batching.
+	FeatureBatching Feature = 1 << iota
+	// FeatureTxn means the datastore is a TxnDatastore.
+	FeatureTxn
+	// FeatureTTL means the datastore is a TTLDatastore.
+	FeatureTTL
+	// FeaturePersistent means the datastore is a PersistentDatastore.
+	FeaturePersistent
+	// FeatureChecked means the datastore is a CheckedDatastore.
+	FeatureChecked
+	// FeatureScrubbed means the datastore is a ScrubbedDatastore.
+	FeatureScrubbed
+	// FeatureGC means the datastore is a GCDatastore.
+	FeatureGC
+	// FeatureStreaming means the datastore is a StreamingDatastore which
+	// streams values without buffering them in memory.
+	FeatureStreaming
+	// FeatureCAS means the datastore is a CASDatastore.
+	FeatureCAS
+	// FeatureBulk means the datastore is a BulkDatastore which reads and
+	// writes many keys more efficiently than one by one.
+	FeatureBulk
+	// FeatureSnapshot means the datastore is a SnapshotDatastore.
+	FeatureSnapshot
+	// FeatureWatch means the datastore is a WatchDatastore.
+	FeatureWatch
+)
+
+var featureNames = []struct {
+	f    Feature
+	name string
+}{
+	{FeatureBatching, "Batching"},
+	{FeatureTxn, "Txn"},
+	{FeatureTTL, "TTL"},
+	{FeaturePersistent, "Persistent"},
+	{FeatureChecked, "Checked"},
+	{FeatureScrubbed, "Scrubbed"},
+	{FeatureGC, "GC"},
+	{FeatureStreaming, "Streaming"},
+	{FeatureCAS, "CAS"},
+	{FeatureBulk, "Bulk"},
+	{FeatureSnapshot, "Snapshot"},
+	{FeatureWatch, "Watch"},
+}
+
+// Has reports whether `f` includes all the features in `other`.
+func (f Feature) Has(other Feature) bool {
+	return f&other == other
+}
+
+// String returns the names of the features separated by '|'.
+func (f Feature) String() string {
+	var names []string
+	for _, fn := range featureNames {
+		if f.Has(fn.f) {
+			names = append(names, fn.name)
+		}
+	}
+	if len(names) == 0 {
+		return "None"
+	}
+	return strings.Join(names, "|")
+}
+
+// Capable is an interface that should be implemented by datastores whose
+// method set doesn't tell what they really support, typically wrappers which
+// implement the optional interfaces by delegating to their children.
+type Capable interface {
+	// Capabilities returns the features the datastore really supports.
+	Capabilities() Feature
+}
+
+// Features returns the features supported by `d`. If `d` is Capable it's
+// asked for its capabilities, otherwise they are inferred from the optional
+// interfaces it implements.
+func Features(d Datastore) Feature {
+	if c, ok := d.(Capable); ok {
+		return c.Capabilities()
+	}
+
+	var f Feature
+	if _, ok := d.(Batching); ok {
+		f |= FeatureBatching
+	}
+	if _, ok := d.(TxnDatastore); ok {
+		f |= FeatureTxn
+	}
+	if _, ok := d.(TTLDatastore); ok {
+		f |= FeatureTTL
+	}
+	if _, ok := d.(PersistentDatastore); ok {
+		f |= FeaturePersistent
+	}
+	if _, ok := d.(CheckedDatastore); ok {
+		f |= FeatureChecked
+	}
+	if _, ok := d.(ScrubbedDatastore); ok {
+		f |= FeatureScrubbed
+	}
+	if _, ok := d.(GCDatastore); ok {
+		f |= FeatureGC
+	}
+	if _, ok := d.(StreamingDatastore); ok {
+		f |= FeatureStreaming
+	}
+	if _, ok := d.(CASDatastore); ok {
+		f |= FeatureCAS
+	}
+	if _, ok := d.(BulkDatastore); ok {
+		f |= FeatureBulk
+	}
+	if _, ok := d.(SnapshotDatastore); ok {
+		f |= FeatureSnapshot
+	}
+	if _, ok := d.(WatchDatastore); ok {
+		f |= FeatureWatch
+	}
+	return f
+}
+
+// FeatureMaintenance is the set of maintenance features which wrappers
+// usually pass through from their children.
+const FeatureMaintenance = FeaturePersistent | FeatureChecked | FeatureScrubbed | FeatureGC
+
+// CASFeature returns FeatureCAS if the conditional write helpers like
+// PutIfAbsent work on `d`, natively or emulated with transactions.
+func CASFeature(d Datastore) Feature {
+	if Features(d)&(FeatureCAS|FeatureTxn) != 0 {
+		return FeatureCAS
+	}
+	return 0
+}
@@ -0,0 +1,58 @@
+// Copyright (c) 2020 DAOT Labs. All rights reserved. Use of this source
+// code is governed by MIT license that can be found in the LICENSE file.
+
+package datastore_test
+
+import (
+	"testing"
+
+	dstore "github.com/daotl/go-datastore"
+	"github.com/daotl/go-datastore/key"
+	dstest "github.com/daotl/go-datastore/test"
+)
+
+func TestFeatures(t *testing.T) {
+	mapds := dstest.NewMapDatastoreForTest(t, key.KeyTypeString)
+	txnds := newTxnMapDatastore(t, key.KeyTypeString)
+
+	cases := []struct {
+		name     string
+		ds       dstore.Datastore
+		expected dstore.Feature
+	}{
+		{"MapDatastore", mapds, dstore.FeatureBatching | dstore.FeatureCAS | dstore.FeatureSnapshot},
+		{"NullDatastore", dstore.NewNullDatastore(), dstore.FeatureBatching},
+		{"TxnMapDatastore", txnds, dstore.FeatureBatching | dstore.FeatureTxn},
+		{"LogDatastore", dstore.NewLogDatastore(mapds, ""), dstore.FeatureBatching},
+		{"StreamingAdapter", dstore.NewStreamingAdapter(mapds), dstore.FeatureBatching},
+		{"TxnCAS", dstore.NewTxnCAS(txnds), dstore.FeatureTxn | dstore.FeatureCAS},
+		{"TestDatastore", dstest.NewTestDatastore(key.KeyTypeString, true),
+			dstore.FeatureBatching | dstore.FeatureChecked | dstore.FeatureScrubbed |
+				dstore.FeatureGC | dstore.FeatureCAS | dstore.FeatureSnapshot},
+	}
+	for _, c := range cases {
+		if f := dstore.Features(c.ds); f != c.expected {
+			t.Errorf("%s: expected features %s, got %s", c.name, c.expected, f)
+		}
+	}
+
+	if dstore.CASFeature(txnds) != dstore.FeatureCAS {
+		t.Error("conditional writes should be supported over transactions")
+	}
+	if dstore.CASFeature(dstore.NewLogDatastore(mapds, "")) != 0 {
+		t.Error("LogDatastore doesn't support conditional writes")
+	}
+}
+
+func TestFeatureString(t *testing.T) {
+	if s := dstore.Feature(0).String(); s != "None" {
+		t.Errorf("unexpected string %q", s)
+	}
+	if s := (dstore.FeatureBatching | dstore.FeatureTTL).String(); s != "Batching|TTL" {
+		t.Errorf("unexpected string %q", s)
+	}
+	if !(dstore.FeatureBatching | dstore.FeatureTTL).Has(dstore.FeatureTTL) ||
+		dstore.FeatureTTL.Has(dstore.FeatureBatching|dstore.FeatureTTL) {
+		t.Error("Feature.Has failed")
+	}
+}
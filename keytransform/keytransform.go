@@ -7,6 +7,7 @@ package keytransform
 
 import (
 	"context"
+	"io"
 
 	ds "github.com/daotl/go-datastore"
 	key "github.com/daotl/go-datastore/key"
@@ -40,6 +41,13 @@ func (d *Datastore) Children() []ds.Datastore {
 	return []ds.Datastore{d.child}
 }
 
+// Capabilities implements ds.Capable
+func (d *Datastore) Capabilities() ds.Feature {
+	return ds.Features(d.child)&(ds.FeatureBatching|ds.FeatureMaintenance|
+		ds.FeatureStreaming|ds.FeatureBulk|ds.FeatureSnapshot|ds.FeatureWatch) |
+		ds.CASFeature(d.child)
+}
+
 // Put stores the given value, transforming the key first.
 func (d *Datastore) Put(ctx context.Context, key key.Key, value []byte) (err error) {
 	return d.child.Put(ctx, d.ConvertKey(key), value)
@@ -72,6 +80,61 @@ func (d *Datastore) Delete(ctx context.Context, key key.Key) (err error) {
 	return d.child.Delete(ctx, d.ConvertKey(key))
 }
 
+// GetReader returns a reader of the value for given key, transforming the key
+// first.
+func (d *Datastore) GetReader(ctx context.Context, key key.Key) (io.ReadCloser, error) {
+	return ds.GetReader(ctx, d.child, d.ConvertKey(key))
+}
+
+// PutReader stores the value read from `r`, transforming the key first.
+func (d *Datastore) PutReader(ctx context.Context, key key.Key, r io.Reader) error {
+	return ds.PutReader(ctx, d.child, d.ConvertKey(key), r)
+}
+
+// convertKeys transforms all the given keys.
+func (d *Datastore) convertKeys(keys []key.Key) []key.Key {
+	ck := make([]key.Key, len(keys))
+	for i, k := range keys {
+		ck[i] = d.ConvertKey(k)
+	}
+	return ck
+}
+
+// GetMany implements BulkRead.GetMany, transforming the keys first.
+func (d *Datastore) GetMany(ctx context.Context, keys []key.Key) ([]ds.ValueResult, error) {
+	return ds.GetMany(ctx, d.child, d.convertKeys(keys))
+}
+
+// HasMany implements BulkRead.HasMany, transforming the keys first.
+func (d *Datastore) HasMany(ctx context.Context, keys []key.Key) ([]ds.HasResult, error) {
+	return ds.HasMany(ctx, d.child, d.convertKeys(keys))
+}
+
+// GetSizeMany implements BulkRead.GetSizeMany, transforming the keys first.
+func (d *Datastore) GetSizeMany(ctx context.Context, keys []key.Key) ([]ds.SizeResult, error) {
+	return ds.GetSizeMany(ctx, d.child, d.convertKeys(keys))
+}
+
+// PutMany implements BulkWrite.PutMany, transforming the keys first.
+func (d *Datastore) PutMany(ctx context.Context, keys []key.Key, values [][]byte) error {
+	return ds.PutMany(ctx, d.child, d.convertKeys(keys), values)
+}
+
+// PutIfAbsent implements CAS.PutIfAbsent, transforming the key first.
+func (d *Datastore) PutIfAbsent(ctx context.Context, key key.Key, value []byte) (ok bool, err error) {
+	return ds.PutIfAbsent(ctx, d.child, d.ConvertKey(key), value)
+}
+
+// CompareAndSwap implements CAS.CompareAndSwap, transforming the key first.
+func (d *Datastore) CompareAndSwap(ctx context.Context, key key.Key, old, new []byte) (ok bool, err error) {
+	return ds.CompareAndSwap(ctx, d.child, d.ConvertKey(key), old, new)
+}
+
+// DeleteIfEqual implements CAS.DeleteIfEqual, transforming the key first.
+func (d *Datastore) DeleteIfEqual(ctx context.Context, key key.Key, value []byte) (ok bool, err error) {
+	return ds.DeleteIfEqual(ctx, d.child, d.ConvertKey(key), value)
+}
+
 // Query implements Query, inverting keys on the way back out.
 func (d *Datastore) Query(ctx context.Context, q dsq.Query) (dsq.Results, error) {
 	nq, cq := d.prepareQuery(q)
@@ -206,6 +269,39 @@ orders:
 	return
 }
 
+// Snapshot implements SnapshotDatastore.Snapshot, the snapshot of the child
+// is wrapped with the same KeyTransform.
+func (d *Datastore) Snapshot(ctx context.Context) (ds.Snapshot, error) {
+	s, err := ds.NewSnapshot(ctx, d.child)
+	if err != nil {
+		return nil, err
+	}
+	return Wrap(ds.NewSnapshotAdapter(s), d.KeyTransform), nil
+}
+
+// Watch implements Watcher.Watch, the keys of the events of the child are
+// inverted.
+func (d *Datastore) Watch(ctx context.Context, prefix key.Key) (<-chan ds.Event, error) {
+	events, err := ds.Watch(ctx, d.child, d.ConvertKey(key.Clean(prefix)))
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(chan ds.Event)
+	go func() {
+		defer close(out)
+		for e := range events {
+			e.Key = d.InvertKey(e.Key)
+			select {
+			case out <- e:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
 func (d *Datastore) Close() error {
 	return d.child.Close()
 }
@@ -275,3 +371,8 @@ var _ ds.GCDatastore = (*Datastore)(nil)
 var _ ds.Batching = (*Datastore)(nil)
 var _ ds.PersistentDatastore = (*Datastore)(nil)
 var _ ds.ScrubbedDatastore = (*Datastore)(nil)
+var _ ds.StreamingDatastore = (*Datastore)(nil)
+var _ ds.CASDatastore = (*Datastore)(nil)
+var _ ds.BulkDatastore = (*Datastore)(nil)
+var _ ds.SnapshotDatastore = (*Datastore)(nil)
+var _ ds.WatchDatastore = (*Datastore)(nil)
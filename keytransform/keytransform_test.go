@@ -18,6 +18,7 @@ import (
 	kt "github.com/daotl/go-datastore/keytransform"
 	dsq "github.com/daotl/go-datastore/query"
 	dstest "github.com/daotl/go-datastore/test"
+	"github.com/daotl/go-datastore/watch"
 )
 
 // Hook up gocheck into the "go test" runner.
@@ -223,3 +224,38 @@ func TestSuiteBytesKeyPrefixTransform(t *testing.T) {
 	testSuiteStrKeyPrefixTransform(t, key.KeyTypeString)
 	testSuiteStrKeyPrefixTransform(t, key.KeyTypeBytes)
 }
+
+func TestWatch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	child := watch.Wrap(dstest.NewMapDatastoreForTest(t, key.KeyTypeString), watch.Options{})
+	ktds := kt.Wrap(child, kt.PrefixTransform{Prefix: key.NewStrKey("/abc")})
+
+	events, err := ktds.Watch(ctx, key.NewStrKey("/foo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := child.Put(ctx, key.NewStrKey("/foo/bar"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := ktds.Put(ctx, key.NewStrKey("/bar"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := ktds.Put(ctx, key.NewStrKey("/foo/bar"), []byte("baz")); err != nil {
+		t.Fatal(err)
+	}
+	e := <-events
+	if e.Type != ds.EventPut || e.Key.String() != "/foo/bar" || string(e.Value) != "baz" {
+		t.Fatalf("unexpected event %s %s %q", e.Type, e.Key, e.Value)
+	}
+
+	cancel()
+	if _, ok := <-events; ok {
+		t.Fatal("expected the channel to be closed")
+	}
+
+	ktds = kt.Wrap(dstest.NewMapDatastoreForTest(t, key.KeyTypeString), kt.PrefixTransform{Prefix: key.NewStrKey("/abc")})
+	if _, err := ktds.Watch(ctx, nil); err != ds.ErrWatchUnsupported {
+		t.Fatal("expected ErrWatchUnsupported, got: ", err)
+	}
+}
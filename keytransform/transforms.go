@@ -51,3 +51,30 @@ func (p PrefixTransform) InvertKey(k key.Key) key.Key {
 }
 
 var _ KeyTransform = (*PrefixTransform)(nil)
+
+// KeyTypeTransform constructs a KeyTransform which converts keys of type
+// From to keys of type To by their bytes, like from StrKey("/foo") to
+// BytesKey("/foo"). Note that StrKeys are cleaned when converted back, so
+// BytesKey("foo") is converted to StrKey("/foo").
+type KeyTypeTransform struct {
+	From key.KeyType
+	To   key.KeyType
+}
+
+// ConvertKey converts the key to type To.
+func (t KeyTypeTransform) ConvertKey(k key.Key) key.Key {
+	if k.KeyType() == t.To {
+		return k
+	}
+	return key.NewKeyFromTypeAndBytes(t.To, k.Bytes())
+}
+
+// InvertKey converts the key back to type From.
+func (t KeyTypeTransform) InvertKey(k key.Key) key.Key {
+	if k.KeyType() == t.From {
+		return k
+	}
+	return key.NewKeyFromTypeAndBytes(t.From, k.Bytes())
+}
+
+var _ KeyTransform = (*KeyTypeTransform)(nil)
@@ -10,7 +10,9 @@ import (
 	"github.com/daotl/go-datastore/query"
 )
 
-var ErrClosed = errors.New("Datastore is closed")
+// ErrClosed is returned when using a closed LazyDatastore, it's the same as
+// datastore.ErrClosed.
+var ErrClosed = datastore.ErrClosed
 
 // LazyDatastore wraps a datastore with three states: active, inactive and closed.
 // State can be changed by three StateChangeFuncs: activateFn, deactivateFn and closeFn.
@@ -37,6 +39,7 @@ type LazyDatastore struct {
 }
 
 var _ datastore.Datastore = (*LazyDatastore)(nil)
+var _ datastore.Shim = (*LazyDatastore)(nil)
 
 // StateChangeFunc is a function that change a LazyStore's state
 type StateChangeFunc func(ds datastore.Datastore) error
@@ -66,6 +69,11 @@ func NewLazyDataStore(wrapped datastore.Datastore, activateFn, deactivateFn, clo
 	}, nil
 }
 
+// Children implements datastore.Shim
+func (d *LazyDatastore) Children() []datastore.Datastore {
+	return []datastore.Datastore{d.wrapped}
+}
+
 // EnsureActive makes sure that LazyDatastore is in active state and runs a DataStoreOp.
 // It returns an error if it fails to activate the wrapped Datastore or `op` returns an error.
 func (d *LazyDatastore) EnsureActive(op DataStoreOp) error {
@@ -0,0 +1,88 @@
+// Copyright (c) 2020 DAOT Labs. All rights reserved. Use of this source
+// code is governed by MIT license that can be found in the LICENSE file.
+
+package logging
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// Level is the severity of a log entry.
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	case LevelError:
+		return "ERROR"
+	default:
+		return "Level(" + strconv.Itoa(int(l)) + ")"
+	}
+}
+
+// Field is a key-value pair of a structured log entry.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// Logger is a structured, leveled logger. Adapters for most structured
+// logging libraries are a few lines long. It must be safe for concurrent
+// use.
+type Logger interface {
+	Log(level Level, msg string, fields ...Field)
+}
+
+type stdLogger struct {
+	l     *log.Logger
+	level Level
+}
+
+// NewStdLogger returns a Logger which writes the entries of at least the
+// given level to `l` as text, like:
+//
+//	INFO Get datastore=blocks key=/foo size=42 duration=1.2ms
+//
+// The standard logger of the log package is used if `l` is nil.
+func NewStdLogger(l *log.Logger, level Level) Logger {
+	if l == nil {
+		l = log.Default()
+	}
+	return &stdLogger{l: l, level: level}
+}
+
+func (s *stdLogger) Log(level Level, msg string, fields ...Field) {
+	if level < s.level {
+		return
+	}
+	var sb strings.Builder
+	sb.WriteString(level.String())
+	sb.WriteByte(' ')
+	sb.WriteString(msg)
+	for _, f := range fields {
+		v := fmt.Sprint(f.Value)
+		if v == "" || strings.ContainsAny(v, " \t\n\"=") {
+			v = strconv.Quote(v)
+		}
+		sb.WriteByte(' ')
+		sb.WriteString(f.Key)
+		sb.WriteByte('=')
+		sb.WriteString(v)
+	}
+	s.l.Print(sb.String())
+}
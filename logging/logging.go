@@ -0,0 +1,580 @@
+// Copyright (c) 2020 DAOT Labs. All rights reserved. Use of this source
+// code is governed by MIT license that can be found in the LICENSE file.
+
+// Package logging provides a datastore wrapper which logs the operations of
+// its child to a structured, leveled Logger. It's a more capable
+// replacement for datastore.LogDatastore.
+package logging
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"sync/atomic"
+	"time"
+
+	ds "github.com/daotl/go-datastore"
+	key "github.com/daotl/go-datastore/key"
+	dsq "github.com/daotl/go-datastore/query"
+)
+
+// Options are the options of a logging datastore.
+type Options struct {
+	// Name is logged with each entry as the "datastore" field.
+	Name string
+
+	// Logger is the logger entries are written to, NewStdLogger(nil,
+	// LevelDebug) is used if it's nil.
+	Logger Logger
+
+	// Level is the level of the entries of successful operations, LevelDebug
+	// if not set. Operations which fail with an error other than ErrNotFound
+	// are logged at LevelError, and slow ones at LevelWarn.
+	Level Level
+
+	// SampleEvery, if greater than 1, only logs one in SampleEvery
+	// successful operations which are not slow. Failed and slow operations
+	// are always logged.
+	SampleEvery int
+
+	// SlowThreshold, if set, is the duration above which operations are
+	// logged at LevelWarn with the "slow" field.
+	SlowThreshold time.Duration
+
+	// Redact, if set, is applied to the keys before they are logged, see
+	// HashKey.
+	Redact func(key.Key) string
+}
+
+// HashKey can be used as Options.Redact to log short hashes of the keys
+// instead of the keys themselves, so that operations on the same key can
+// still be correlated.
+func HashKey(k key.Key) string {
+	h := sha256.Sum256(k.Bytes())
+	return hex.EncodeToString(h[:8])
+}
+
+// Datastore wraps a datastore and logs its operations. The entry of each
+// operation has the fields:
+//
+//	datastore  Options.Name
+//	key        the key, redacted with Options.Redact
+//	size       the size of the value read or written
+//	query      the query, as returned by query.Query.String, or its
+//	           prefix, start and end keys if Options.Redact is set
+//	prefix     the prefix of Sync and Watch
+//	results    the number of results returned by a query
+//	txn, batch the id of the transaction or batch the operation is part of
+//	duration   the duration of the operation
+//	error      the error returned, if any
+//
+// where relevant. Queries are logged once their results are closed.
+type Datastore struct {
+	child ds.Datastore
+	opts  Options
+
+	ops uint64
+	ids uint64
+}
+
+var _ ds.Batching = (*Datastore)(nil)
+var _ ds.TxnDatastore = (*Datastore)(nil)
+var _ ds.TTLDatastore = (*Datastore)(nil)
+var _ ds.PersistentDatastore = (*Datastore)(nil)
+var _ ds.CheckedDatastore = (*Datastore)(nil)
+var _ ds.ScrubbedDatastore = (*Datastore)(nil)
+var _ ds.GCDatastore = (*Datastore)(nil)
+var _ ds.StreamingDatastore = (*Datastore)(nil)
+var _ ds.CASDatastore = (*Datastore)(nil)
+var _ ds.BulkDatastore = (*Datastore)(nil)
+var _ ds.SnapshotDatastore = (*Datastore)(nil)
+var _ ds.WatchDatastore = (*Datastore)(nil)
+var _ ds.Shim = (*Datastore)(nil)
+
+// Wrap wraps the given datastore to log its operations.
+func Wrap(child ds.Datastore, opts Options) *Datastore {
+	if child == nil {
+		panic("child (ds.Datastore) is nil")
+	}
+	if opts.Logger == nil {
+		opts.Logger = NewStdLogger(nil, LevelDebug)
+	}
+	return &Datastore{child: child, opts: opts}
+}
+
+// log logs the operation `op` started at `start`.
+func (d *Datastore) log(op string, start time.Time, err error, fields ...Field) {
+	dur := time.Since(start)
+	slow := d.opts.SlowThreshold > 0 && dur >= d.opts.SlowThreshold
+
+	level := d.opts.Level
+	switch {
+	case err != nil && !ds.IsNotFound(err):
+		level = LevelError
+	case slow:
+		level = LevelWarn
+	case d.opts.SampleEvery > 1:
+		if atomic.AddUint64(&d.ops, 1)%uint64(d.opts.SampleEvery) != 1 {
+			return
+		}
+	}
+
+	fs := make([]Field, 0, len(fields)+4)
+	fs = append(fs, Field{"datastore", d.opts.Name})
+	fs = append(fs, fields...)
+	fs = append(fs, Field{"duration", dur})
+	if err != nil {
+		fs = append(fs, Field{"error", err})
+	}
+	if slow {
+		fs = append(fs, Field{"slow", true})
+	}
+	d.opts.Logger.Log(level, op, fs...)
+}
+
+func (d *Datastore) key(k key.Key) Field {
+	return d.keyField("key", k)
+}
+
+// keyField returns the field `name` of the key `k`, redacted with
+// Options.Redact.
+func (d *Datastore) keyField(name string, k key.Key) Field {
+	if d.opts.Redact != nil && k != nil {
+		return Field{name, d.opts.Redact(k)}
+	}
+	return Field{name, k}
+}
+
+// queryFields returns the fields of the query `q`. When Options.Redact is
+// set, the query string, whose filters may contain keys and values, is
+// replaced by the redacted prefix and range.
+func (d *Datastore) queryFields(q dsq.Query) []Field {
+	if d.opts.Redact == nil {
+		return []Field{{"query", q.String()}}
+	}
+	return []Field{
+		d.keyField("prefix", q.Prefix),
+		d.keyField("start", q.Range.Start),
+		d.keyField("end", q.Range.End),
+	}
+}
+
+// Children implements Shim
+func (d *Datastore) Children() []ds.Datastore {
+	return []ds.Datastore{d.child}
+}
+
+// Capabilities implements Capable
+func (d *Datastore) Capabilities() ds.Feature {
+	return ds.Features(d.child)&(ds.FeatureBatching|ds.FeatureTxn|ds.FeatureTTL|
+		ds.FeatureMaintenance|ds.FeatureStreaming|ds.FeatureBulk|ds.FeatureSnapshot|
+		ds.FeatureWatch) | ds.CASFeature(d.child)
+}
+
+// Get implements Datastore.Get
+func (d *Datastore) Get(ctx context.Context, key key.Key) (value []byte, err error) {
+	return get(ctx, d, d.child, key)
+}
+
+// Has implements Datastore.Has
+func (d *Datastore) Has(ctx context.Context, key key.Key) (exists bool, err error) {
+	return has(ctx, d, d.child, key)
+}
+
+// GetSize implements Datastore.GetSize
+func (d *Datastore) GetSize(ctx context.Context, key key.Key) (size int, err error) {
+	return getSize(ctx, d, d.child, key)
+}
+
+// Query implements Datastore.Query
+func (d *Datastore) Query(ctx context.Context, q dsq.Query) (dsq.Results, error) {
+	return query(ctx, d, d.child, q)
+}
+
+// Put implements Datastore.Put
+func (d *Datastore) Put(ctx context.Context, key key.Key, value []byte) error {
+	return put(ctx, d, d.child, key, value)
+}
+
+// Delete implements Datastore.Delete
+func (d *Datastore) Delete(ctx context.Context, key key.Key) error {
+	return del(ctx, d, d.child, key)
+}
+
+// Sync implements Datastore.Sync
+func (d *Datastore) Sync(ctx context.Context, prefix key.Key) error {
+	start := time.Now()
+	err := d.child.Sync(ctx, prefix)
+	d.log("Sync", start, err, d.keyField("prefix", prefix))
+	return err
+}
+
+// The operations shared by the datastore, its transactions and batches,
+// `fields` identify the transaction or batch.
+
+func get(ctx context.Context, d *Datastore, r ds.Read, key key.Key, fields ...Field) ([]byte, error) {
+	start := time.Now()
+	value, err := r.Get(ctx, key)
+	d.log("Get", start, err, append(fields, d.key(key), Field{"size", len(value)})...)
+	return value, err
+}
+
+func has(ctx context.Context, d *Datastore, r ds.Read, key key.Key, fields ...Field) (bool, error) {
+	start := time.Now()
+	exists, err := r.Has(ctx, key)
+	d.log("Has", start, err, append(fields, d.key(key), Field{"exists", exists})...)
+	return exists, err
+}
+
+func getSize(ctx context.Context, d *Datastore, r ds.Read, key key.Key, fields ...Field) (int, error) {
+	start := time.Now()
+	size, err := r.GetSize(ctx, key)
+	d.log("GetSize", start, err, append(fields, d.key(key), Field{"size", size})...)
+	return size, err
+}
+
+func put(ctx context.Context, d *Datastore, w ds.Write, key key.Key, value []byte, fields ...Field) error {
+	start := time.Now()
+	err := w.Put(ctx, key, value)
+	d.log("Put", start, err, append(fields, d.key(key), Field{"size", len(value)})...)
+	return err
+}
+
+func del(ctx context.Context, d *Datastore, w ds.Write, key key.Key, fields ...Field) error {
+	start := time.Now()
+	err := w.Delete(ctx, key)
+	d.log("Delete", start, err, append(fields, d.key(key))...)
+	return err
+}
+
+func query(ctx context.Context, d *Datastore, r ds.Read, q dsq.Query, fields ...Field) (dsq.Results, error) {
+	start := time.Now()
+	fields = append(fields, d.queryFields(q)...)
+	cqr, err := r.Query(ctx, q)
+	if err != nil {
+		d.log("Query", start, err, fields...)
+		return nil, err
+	}
+
+	var (
+		results int
+		qerr    error
+		logged  bool
+	)
+	return dsq.ResultsFromIterator(q, dsq.Iterator{
+		Next: func() (dsq.Result, bool) {
+			r, ok := cqr.NextSync()
+			if !ok {
+				return r, false
+			}
+			if r.Error != nil {
+				qerr = r.Error
+			} else {
+				results++
+			}
+			return r, true
+		},
+		Close: func() error {
+			err := cqr.Close()
+			// Close is called again by the results once they run out.
+			if logged {
+				return err
+			}
+			logged = true
+			if err != nil {
+				qerr = err
+			}
+			d.log("Query", start, qerr, append(fields, Field{"results", results})...)
+			return err
+		},
+	}), nil
+}
+
+// PutWithTTL implements TTL.PutWithTTL
+func (d *Datastore) PutWithTTL(ctx context.Context, key key.Key, value []byte, ttl time.Duration) error {
+	t, ok := d.child.(ds.TTL)
+	if !ok {
+		return ds.ErrTTLUnsupported
+	}
+	start := time.Now()
+	err := t.PutWithTTL(ctx, key, value, ttl)
+	d.log("PutWithTTL", start, err, d.key(key), Field{"size", len(value)}, Field{"ttl", ttl})
+	return err
+}
+
+// SetTTL implements TTL.SetTTL
+func (d *Datastore) SetTTL(ctx context.Context, key key.Key, ttl time.Duration) error {
+	t, ok := d.child.(ds.TTL)
+	if !ok {
+		return ds.ErrTTLUnsupported
+	}
+	start := time.Now()
+	err := t.SetTTL(ctx, key, ttl)
+	d.log("SetTTL", start, err, d.key(key), Field{"ttl", ttl})
+	return err
+}
+
+// GetExpiration implements TTL.GetExpiration
+func (d *Datastore) GetExpiration(ctx context.Context, key key.Key) (time.Time, error) {
+	t, ok := d.child.(ds.TTL)
+	if !ok {
+		return time.Time{}, ds.ErrTTLUnsupported
+	}
+	start := time.Now()
+	exp, err := t.GetExpiration(ctx, key)
+	d.log("GetExpiration", start, err, d.key(key))
+	return exp, err
+}
+
+// GetReader implements StreamingRead.GetReader
+func (d *Datastore) GetReader(ctx context.Context, key key.Key) (io.ReadCloser, error) {
+	start := time.Now()
+	r, err := ds.GetReader(ctx, d.child, key)
+	d.log("GetReader", start, err, d.key(key))
+	return r, err
+}
+
+// PutReader implements StreamingWrite.PutReader
+func (d *Datastore) PutReader(ctx context.Context, key key.Key, r io.Reader) error {
+	start := time.Now()
+	err := ds.PutReader(ctx, d.child, key, r)
+	d.log("PutReader", start, err, d.key(key))
+	return err
+}
+
+// PutIfAbsent implements CAS.PutIfAbsent
+func (d *Datastore) PutIfAbsent(ctx context.Context, key key.Key, value []byte) (bool, error) {
+	start := time.Now()
+	ok, err := ds.PutIfAbsent(ctx, d.child, key, value)
+	d.log("PutIfAbsent", start, err, d.key(key), Field{"size", len(value)}, Field{"ok", ok})
+	return ok, err
+}
+
+// CompareAndSwap implements CAS.CompareAndSwap
+func (d *Datastore) CompareAndSwap(ctx context.Context, key key.Key, old, new []byte) (bool, error) {
+	start := time.Now()
+	ok, err := ds.CompareAndSwap(ctx, d.child, key, old, new)
+	d.log("CompareAndSwap", start, err, d.key(key), Field{"size", len(new)}, Field{"ok", ok})
+	return ok, err
+}
+
+// DeleteIfEqual implements CAS.DeleteIfEqual
+func (d *Datastore) DeleteIfEqual(ctx context.Context, key key.Key, value []byte) (bool, error) {
+	start := time.Now()
+	ok, err := ds.DeleteIfEqual(ctx, d.child, key, value)
+	d.log("DeleteIfEqual", start, err, d.key(key), Field{"ok", ok})
+	return ok, err
+}
+
+// GetMany implements BulkRead.GetMany
+func (d *Datastore) GetMany(ctx context.Context, keys []key.Key) ([]ds.ValueResult, error) {
+	start := time.Now()
+	res, err := ds.GetMany(ctx, d.child, keys)
+	d.log("GetMany", start, err, Field{"keys", len(keys)})
+	return res, err
+}
+
+// HasMany implements BulkRead.HasMany
+func (d *Datastore) HasMany(ctx context.Context, keys []key.Key) ([]ds.HasResult, error) {
+	start := time.Now()
+	res, err := ds.HasMany(ctx, d.child, keys)
+	d.log("HasMany", start, err, Field{"keys", len(keys)})
+	return res, err
+}
+
+// GetSizeMany implements BulkRead.GetSizeMany
+func (d *Datastore) GetSizeMany(ctx context.Context, keys []key.Key) ([]ds.SizeResult, error) {
+	start := time.Now()
+	res, err := ds.GetSizeMany(ctx, d.child, keys)
+	d.log("GetSizeMany", start, err, Field{"keys", len(keys)})
+	return res, err
+}
+
+// PutMany implements BulkWrite.PutMany
+func (d *Datastore) PutMany(ctx context.Context, keys []key.Key, values [][]byte) error {
+	start := time.Now()
+	err := ds.PutMany(ctx, d.child, keys, values)
+	d.log("PutMany", start, err, Field{"keys", len(keys)})
+	return err
+}
+
+// Snapshot implements SnapshotDatastore.Snapshot
+func (d *Datastore) Snapshot(ctx context.Context) (ds.Snapshot, error) {
+	start := time.Now()
+	s, err := ds.NewSnapshot(ctx, d.child)
+	d.log("Snapshot", start, err)
+	return s, err
+}
+
+// Watch implements Watcher.Watch
+func (d *Datastore) Watch(ctx context.Context, prefix key.Key) (<-chan ds.Event, error) {
+	start := time.Now()
+	events, err := ds.Watch(ctx, d.child, prefix)
+	d.log("Watch", start, err, d.keyField("prefix", prefix))
+	return events, err
+}
+
+// DiskUsage implements the PersistentDatastore interface.
+func (d *Datastore) DiskUsage(ctx context.Context) (uint64, error) {
+	start := time.Now()
+	du, err := ds.DiskUsage(ctx, d.child)
+	d.log("DiskUsage", start, err, Field{"size", du})
+	return du, err
+}
+
+// Check implements CheckedDatastore.Check
+func (d *Datastore) Check(ctx context.Context) error {
+	c, ok := d.child.(ds.CheckedDatastore)
+	if !ok {
+		return nil
+	}
+	start := time.Now()
+	err := c.Check(ctx)
+	d.log("Check", start, err)
+	return err
+}
+
+// Scrub implements ScrubbedDatastore.Scrub
+func (d *Datastore) Scrub(ctx context.Context) error {
+	c, ok := d.child.(ds.ScrubbedDatastore)
+	if !ok {
+		return nil
+	}
+	start := time.Now()
+	err := c.Scrub(ctx)
+	d.log("Scrub", start, err)
+	return err
+}
+
+// CollectGarbage implements GCDatastore.CollectGarbage
+func (d *Datastore) CollectGarbage(ctx context.Context) error {
+	c, ok := d.child.(ds.GCDatastore)
+	if !ok {
+		return nil
+	}
+	start := time.Now()
+	err := c.CollectGarbage(ctx)
+	d.log("CollectGarbage", start, err)
+	return err
+}
+
+// Close implements Datastore.Close
+func (d *Datastore) Close() error {
+	start := time.Now()
+	err := d.child.Close()
+	d.log("Close", start, err)
+	return err
+}
+
+// Batch implements Batching.Batch, the operations of the batch are logged
+// with the "batch" field.
+func (d *Datastore) Batch(ctx context.Context) (ds.Batch, error) {
+	bds, ok := d.child.(ds.Batching)
+	if !ok {
+		return nil, ds.ErrBatchUnsupported
+	}
+	b, err := bds.Batch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &logBatch{
+		child: b,
+		id:    Field{"batch", atomic.AddUint64(&d.ids, 1)},
+		d:     d,
+	}, nil
+}
+
+type logBatch struct {
+	child ds.Batch
+	id    Field
+	ops   int
+	size  int
+
+	d *Datastore
+}
+
+func (b *logBatch) Put(ctx context.Context, key key.Key, value []byte) error {
+	err := put(ctx, b.d, b.child, key, value, b.id)
+	if err == nil {
+		b.ops++
+		b.size += len(value)
+	}
+	return err
+}
+
+func (b *logBatch) Delete(ctx context.Context, key key.Key) error {
+	err := del(ctx, b.d, b.child, key, b.id)
+	if err == nil {
+		b.ops++
+	}
+	return err
+}
+
+func (b *logBatch) Commit(ctx context.Context) error {
+	start := time.Now()
+	err := b.child.Commit(ctx)
+	b.d.log("Commit", start, err, b.id, Field{"ops", b.ops}, Field{"size", b.size})
+	return err
+}
+
+// NewTransaction implements TxnDatastore.NewTransaction, the operations of
+// the transaction are logged with the "txn" field.
+func (d *Datastore) NewTransaction(ctx context.Context, readOnly bool) (ds.Txn, error) {
+	tds, ok := d.child.(ds.TxnDatastore)
+	if !ok {
+		return nil, ds.ErrTxnUnsupported
+	}
+	start := time.Now()
+	id := Field{"txn", atomic.AddUint64(&d.ids, 1)}
+	t, err := tds.NewTransaction(ctx, readOnly)
+	d.log("NewTransaction", start, err, id, Field{"readOnly", readOnly})
+	if err != nil {
+		return nil, err
+	}
+	return &logTxn{child: t, id: id, d: d}, nil
+}
+
+type logTxn struct {
+	child ds.Txn
+	id    Field
+
+	d *Datastore
+}
+
+func (t *logTxn) Get(ctx context.Context, key key.Key) ([]byte, error) {
+	return get(ctx, t.d, t.child, key, t.id)
+}
+
+func (t *logTxn) Has(ctx context.Context, key key.Key) (bool, error) {
+	return has(ctx, t.d, t.child, key, t.id)
+}
+
+func (t *logTxn) GetSize(ctx context.Context, key key.Key) (int, error) {
+	return getSize(ctx, t.d, t.child, key, t.id)
+}
+
+func (t *logTxn) Query(ctx context.Context, q dsq.Query) (dsq.Results, error) {
+	return query(ctx, t.d, t.child, q, t.id)
+}
+
+func (t *logTxn) Put(ctx context.Context, key key.Key, value []byte) error {
+	return put(ctx, t.d, t.child, key, value, t.id)
+}
+
+func (t *logTxn) Delete(ctx context.Context, key key.Key) error {
+	return del(ctx, t.d, t.child, key, t.id)
+}
+
+func (t *logTxn) Commit(ctx context.Context) error {
+	start := time.Now()
+	err := t.child.Commit(ctx)
+	t.d.log("Commit", start, err, t.id)
+	return err
+}
+
+func (t *logTxn) Discard(ctx context.Context) {
+	start := time.Now()
+	t.child.Discard(ctx)
+	t.d.log("Discard", start, nil, t.id)
+}
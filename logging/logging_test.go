@@ -0,0 +1,298 @@
+// Copyright (c) 2020 DAOT Labs. All rights reserved. Use of this source
+// code is governed by MIT license that can be found in the LICENSE file.
+
+package logging
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	ds "github.com/daotl/go-datastore"
+	key "github.com/daotl/go-datastore/key"
+	dsq "github.com/daotl/go-datastore/query"
+	dstest "github.com/daotl/go-datastore/test"
+)
+
+type entry struct {
+	level  Level
+	msg    string
+	fields map[string]interface{}
+}
+
+// recorder is a Logger which records the entries.
+type recorder struct {
+	lk      sync.Mutex
+	entries []entry
+}
+
+func (r *recorder) Log(level Level, msg string, fields ...Field) {
+	e := entry{level: level, msg: msg, fields: make(map[string]interface{})}
+	for _, f := range fields {
+		e.fields[f.Key] = f.Value
+	}
+	r.lk.Lock()
+	r.entries = append(r.entries, e)
+	r.lk.Unlock()
+}
+
+func (r *recorder) last(t *testing.T) entry {
+	t.Helper()
+	if len(r.entries) == 0 {
+		t.Fatal("expected an entry to be logged")
+	}
+	return r.entries[len(r.entries)-1]
+}
+
+func testSuite(t *testing.T, ktype key.KeyType) {
+	d := Wrap(dstest.NewMapDatastoreForTest(t, ktype), Options{Logger: &recorder{}})
+	dstest.SubtestAll(t, ktype, d)
+}
+
+func TestSuite(t *testing.T) {
+	testSuite(t, key.KeyTypeString)
+	testSuite(t, key.KeyTypeBytes)
+}
+
+func TestLogging(t *testing.T) {
+	ctx := context.Background()
+	r := &recorder{}
+	d := Wrap(dstest.NewMapDatastoreForTest(t, key.KeyTypeString), Options{
+		Name:   "test",
+		Logger: r,
+		Level:  LevelInfo,
+	})
+
+	if err := d.Put(ctx, key.NewStrKey("/a"), []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	e := r.last(t)
+	if e.level != LevelInfo || e.msg != "Put" || e.fields["datastore"] != "test" ||
+		!key.NewStrKey("/a").Equal(e.fields["key"].(key.Key)) || e.fields["size"] != 5 {
+		t.Fatalf("unexpected entry: %+v", e)
+	}
+	if _, ok := e.fields["duration"].(time.Duration); !ok {
+		t.Fatalf("expected a duration: %+v", e)
+	}
+
+	if _, err := d.Get(ctx, key.NewStrKey("/missing")); !ds.IsNotFound(err) {
+		t.Fatal("expected ErrNotFound, got: ", err)
+	}
+	if e := r.last(t); e.level != LevelInfo || e.fields["error"] != ds.ErrNotFound {
+		t.Fatalf("unexpected entry: %+v", e)
+	}
+
+	d.Close()
+	d = Wrap(failDatastore{dstest.NewMapDatastoreForTest(t, key.KeyTypeString)}, Options{Logger: r})
+	if err := d.Put(ctx, key.NewStrKey("/a"), nil); err != errFail {
+		t.Fatal("expected the put to fail, got: ", err)
+	}
+	if e := r.last(t); e.level != LevelError || e.fields["error"] != errFail {
+		t.Fatalf("unexpected entry: %+v", e)
+	}
+}
+
+var errFail = errors.New("fail")
+
+type failDatastore struct {
+	*ds.MapDatastore
+}
+
+func (d failDatastore) Put(ctx context.Context, key key.Key, value []byte) error {
+	return errFail
+}
+
+func TestQuery(t *testing.T) {
+	ctx := context.Background()
+	r := &recorder{}
+	d := Wrap(dstest.NewMapDatastoreForTest(t, key.KeyTypeString), Options{Logger: r})
+	for _, k := range []string{"/a/1", "/a/2", "/b/1"} {
+		d.Put(ctx, key.NewStrKey(k), []byte(k))
+	}
+
+	q := dsq.Query{Prefix: key.NewStrKey("/a")}
+	res, err := d.Query(ctx, q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Close()
+	n := len(r.entries)
+	if _, err := res.Rest(); err != nil {
+		t.Fatal(err)
+	}
+	if err := res.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.entries) != n+1 {
+		t.Fatal("expected the query to be logged once its results are closed")
+	}
+	if e := r.last(t); e.msg != "Query" || e.fields["query"] != q.String() || e.fields["results"] != 2 {
+		t.Fatalf("unexpected entry: %+v", e)
+	}
+}
+
+func TestSampling(t *testing.T) {
+	ctx := context.Background()
+	r := &recorder{}
+	d := Wrap(dstest.NewMapDatastoreForTest(t, key.KeyTypeString), Options{
+		Logger:      r,
+		SampleEvery: 10,
+	})
+	for i := 0; i < 100; i++ {
+		d.Put(ctx, key.NewStrKey("/a"), nil)
+	}
+	if len(r.entries) != 10 {
+		t.Fatalf("expected 10 entries to be logged, got %d", len(r.entries))
+	}
+
+	d = Wrap(failDatastore{dstest.NewMapDatastoreForTest(t, key.KeyTypeString)}, Options{
+		Logger:      r,
+		SampleEvery: 10,
+	})
+	for i := 0; i < 5; i++ {
+		d.Put(ctx, key.NewStrKey("/a"), nil)
+	}
+	if len(r.entries) != 15 {
+		t.Fatal("expected errors to always be logged")
+	}
+}
+
+func TestSlowThreshold(t *testing.T) {
+	ctx := context.Background()
+	r := &recorder{}
+	d := Wrap(dstest.NewMapDatastoreForTest(t, key.KeyTypeString), Options{
+		Logger:        r,
+		SampleEvery:   1000,
+		SlowThreshold: time.Nanosecond,
+	})
+	for i := 0; i < 3; i++ {
+		d.Put(ctx, key.NewStrKey("/a"), nil)
+	}
+	if len(r.entries) != 3 {
+		t.Fatal("expected slow operations to always be logged")
+	}
+	if e := r.last(t); e.level != LevelWarn || e.fields["slow"] != true {
+		t.Fatalf("unexpected entry: %+v", e)
+	}
+}
+
+func TestRedact(t *testing.T) {
+	ctx := context.Background()
+	r := &recorder{}
+	d := Wrap(dstest.NewMapDatastoreForTest(t, key.KeyTypeString), Options{
+		Logger: r,
+		Redact: HashKey,
+	})
+	k := key.NewStrKey("/secret")
+	d.Put(ctx, k, nil)
+	d.Has(ctx, k)
+	put, has := r.entries[0], r.entries[1]
+	if put.fields["key"] != HashKey(k) || has.fields["key"] != put.fields["key"] {
+		t.Fatalf("unexpected keys: %v, %v", put.fields["key"], has.fields["key"])
+	}
+	if strings.Contains(HashKey(k), "secret") || HashKey(k) == HashKey(key.NewStrKey("/other")) {
+		t.Fatal("unexpected hash: ", HashKey(k))
+	}
+
+	d.Sync(ctx, k)
+	res, err := d.Query(ctx, dsq.Query{Prefix: k, Range: dsq.Range{Start: k}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := res.Rest(); err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range r.entries[2:] {
+		for name, v := range e.fields {
+			if strings.Contains(fmt.Sprint(v), "secret") {
+				t.Fatalf("%s: unredacted field %s: %v", e.msg, name, v)
+			}
+		}
+	}
+	if q := r.last(t); q.msg != "Query" || q.fields["prefix"] != HashKey(k) || q.fields["start"] != HashKey(k) {
+		t.Fatalf("unexpected entry: %+v", q)
+	}
+}
+
+func TestBatch(t *testing.T) {
+	ctx := context.Background()
+	r := &recorder{}
+	d := Wrap(dstest.NewMapDatastoreForTest(t, key.KeyTypeString), Options{Logger: r})
+
+	b, err := d.Batch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Put(ctx, key.NewStrKey("/a"), []byte("12"))
+	b.Put(ctx, key.NewStrKey("/b"), []byte("345"))
+	b.Delete(ctx, key.NewStrKey("/c"))
+	if err := b.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.entries) != 4 {
+		t.Fatalf("expected 4 entries, got %d", len(r.entries))
+	}
+	id := r.entries[0].fields["batch"]
+	for _, e := range r.entries {
+		if e.fields["batch"] != id {
+			t.Fatalf("expected all the entries to have the batch id: %+v", e)
+		}
+	}
+	if e := r.last(t); e.msg != "Commit" || e.fields["ops"] != 3 || e.fields["size"] != 5 {
+		t.Fatalf("unexpected entry: %+v", e)
+	}
+}
+
+func TestTxn(t *testing.T) {
+	ctx := context.Background()
+	child, err := ds.NewTxnMapDatastore(key.KeyTypeString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &recorder{}
+	d := Wrap(child, Options{Logger: r})
+	if !ds.Features(d).Has(ds.FeatureTxn) {
+		t.Fatal("expected the datastore to support transactions, got: ", ds.Features(d))
+	}
+
+	txn, err := d.NewTransaction(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	txn.Put(ctx, key.NewStrKey("/a"), []byte("a"))
+	txn.Get(ctx, key.NewStrKey("/a"))
+	if err := txn.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+	var msgs []string
+	for _, e := range r.entries {
+		if e.fields["txn"] != r.entries[0].fields["txn"] {
+			t.Fatalf("expected all the entries to have the txn id: %+v", e)
+		}
+		msgs = append(msgs, e.msg)
+	}
+	if strings.Join(msgs, ",") != "NewTransaction,Put,Get,Commit" {
+		t.Fatal("unexpected entries: ", msgs)
+	}
+
+	d = Wrap(dstest.NewMapDatastoreForTest(t, key.KeyTypeString), Options{Logger: r})
+	if _, err := d.NewTransaction(ctx, false); err != ds.ErrTxnUnsupported {
+		t.Fatal("expected ErrTxnUnsupported, got: ", err)
+	}
+}
+
+func TestStdLogger(t *testing.T) {
+	var buf bytes.Buffer
+	l := NewStdLogger(log.New(&buf, "", 0), LevelInfo)
+	l.Log(LevelDebug, "Get")
+	l.Log(LevelWarn, "Put", Field{"key", key.NewStrKey("/a b")}, Field{"size", 3})
+	if out := buf.String(); out != "WARN Put key=\"/a b\" size=3\n" {
+		t.Fatalf("unexpected output: %q", out)
+	}
+}
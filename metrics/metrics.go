@@ -0,0 +1,465 @@
+// Copyright (c) 2020 DAOT Labs. All rights reserved. Use of this source
+// code is governed by MIT license that can be found in the LICENSE file.
+
+// Package metrics provides a datastore wrapper which records metrics about
+// the operations of its child into a pluggable Sink, and a Registry sink
+// which exposes them in the Prometheus text format.
+package metrics
+
+import (
+	"context"
+	"io"
+	"time"
+
+	ds "github.com/daotl/go-datastore"
+	key "github.com/daotl/go-datastore/key"
+	dsq "github.com/daotl/go-datastore/query"
+)
+
+// Op is an operation recorded by the wrapper.
+type Op string
+
+const (
+	OpGet         Op = "get"
+	OpHas         Op = "has"
+	OpGetSize     Op = "get_size"
+	OpPut         Op = "put"
+	OpDelete      Op = "delete"
+	OpQuery       Op = "query"
+	OpSync        Op = "sync"
+	OpBatchCommit Op = "batch_commit"
+	OpTxnCommit   Op = "txn_commit"
+)
+
+// Outcome is the outcome of an operation.
+type Outcome int
+
+const (
+	// OutcomeOK means the operation succeeded.
+	OutcomeOK Outcome = iota
+	// OutcomeNotFound means the operation failed with ErrNotFound.
+	OutcomeNotFound
+	// OutcomeError means the operation failed with any other error.
+	OutcomeError
+)
+
+func outcome(err error) Outcome {
+	switch {
+	case err == nil:
+		return OutcomeOK
+	case ds.IsNotFound(err):
+		return OutcomeNotFound
+	default:
+		return OutcomeError
+	}
+}
+
+// Observation is the record of a single operation.
+type Observation struct {
+	Outcome  Outcome
+	Duration time.Duration
+	// BytesRead and BytesWritten are the sizes of the values read and
+	// written by the operation.
+	BytesRead    int64
+	BytesWritten int64
+	// Results is the number of results returned by a query.
+	Results int64
+}
+
+// Sink records the observations of the wrapper, it must be safe for
+// concurrent use.
+type Sink interface {
+	// Observe records an operation of the datastore named `name`.
+	Observe(name string, op Op, o Observation)
+}
+
+// Datastore wraps a datastore and records an Observation for each of its
+// operations listed in Op. The operations of transactions are recorded too,
+// while the other optional interfaces are passed through without being
+// recorded.
+//
+// A query is recorded once its results are closed, with the time taken to
+// iterate over them.
+type Datastore struct {
+	child ds.Datastore
+	name  string
+	sink  Sink
+}
+
+var _ ds.Batching = (*Datastore)(nil)
+var _ ds.TxnDatastore = (*Datastore)(nil)
+var _ ds.TTLDatastore = (*Datastore)(nil)
+var _ ds.PersistentDatastore = (*Datastore)(nil)
+var _ ds.CheckedDatastore = (*Datastore)(nil)
+var _ ds.ScrubbedDatastore = (*Datastore)(nil)
+var _ ds.GCDatastore = (*Datastore)(nil)
+var _ ds.StreamingDatastore = (*Datastore)(nil)
+var _ ds.CASDatastore = (*Datastore)(nil)
+var _ ds.BulkDatastore = (*Datastore)(nil)
+var _ ds.SnapshotDatastore = (*Datastore)(nil)
+var _ ds.WatchDatastore = (*Datastore)(nil)
+var _ ds.Shim = (*Datastore)(nil)
+
+// Wrap wraps the given datastore to record its operations into `sink`,
+// labelled with `name`.
+func Wrap(child ds.Datastore, name string, sink Sink) *Datastore {
+	if child == nil {
+		panic("child (ds.Datastore) is nil")
+	}
+	if sink == nil {
+		panic("sink (Sink) is nil")
+	}
+	return &Datastore{child: child, name: name, sink: sink}
+}
+
+func (d *Datastore) observe(op Op, start time.Time, err error, read, written int) {
+	d.sink.Observe(d.name, op, Observation{
+		Outcome:      outcome(err),
+		Duration:     time.Since(start),
+		BytesRead:    int64(read),
+		BytesWritten: int64(written),
+	})
+}
+
+// Children implements Shim
+func (d *Datastore) Children() []ds.Datastore {
+	return []ds.Datastore{d.child}
+}
+
+// Capabilities implements Capable
+func (d *Datastore) Capabilities() ds.Feature {
+	return ds.Features(d.child)&(ds.FeatureBatching|ds.FeatureTxn|ds.FeatureTTL|
+		ds.FeatureMaintenance|ds.FeatureStreaming|ds.FeatureBulk|ds.FeatureSnapshot|
+		ds.FeatureWatch) | ds.CASFeature(d.child)
+}
+
+// Get implements Datastore.Get
+func (d *Datastore) Get(ctx context.Context, key key.Key) (value []byte, err error) {
+	return get(ctx, d, d.child, key)
+}
+
+// Has implements Datastore.Has
+func (d *Datastore) Has(ctx context.Context, key key.Key) (exists bool, err error) {
+	return has(ctx, d, d.child, key)
+}
+
+// GetSize implements Datastore.GetSize
+func (d *Datastore) GetSize(ctx context.Context, key key.Key) (size int, err error) {
+	return getSize(ctx, d, d.child, key)
+}
+
+// Query implements Datastore.Query
+func (d *Datastore) Query(ctx context.Context, q dsq.Query) (dsq.Results, error) {
+	return query(ctx, d, d.child, q)
+}
+
+// Put implements Datastore.Put
+func (d *Datastore) Put(ctx context.Context, key key.Key, value []byte) error {
+	return put(ctx, d, d.child, key, value)
+}
+
+// Delete implements Datastore.Delete
+func (d *Datastore) Delete(ctx context.Context, key key.Key) error {
+	return del(ctx, d, d.child, key)
+}
+
+// Sync implements Datastore.Sync
+func (d *Datastore) Sync(ctx context.Context, prefix key.Key) error {
+	start := time.Now()
+	err := d.child.Sync(ctx, prefix)
+	d.observe(OpSync, start, err, 0, 0)
+	return err
+}
+
+// The operations shared by the datastore and its transactions.
+
+func get(ctx context.Context, d *Datastore, r ds.Read, key key.Key) ([]byte, error) {
+	start := time.Now()
+	value, err := r.Get(ctx, key)
+	d.observe(OpGet, start, err, len(value), 0)
+	return value, err
+}
+
+func has(ctx context.Context, d *Datastore, r ds.Read, key key.Key) (bool, error) {
+	start := time.Now()
+	exists, err := r.Has(ctx, key)
+	d.observe(OpHas, start, err, 0, 0)
+	return exists, err
+}
+
+func getSize(ctx context.Context, d *Datastore, r ds.Read, key key.Key) (int, error) {
+	start := time.Now()
+	size, err := r.GetSize(ctx, key)
+	d.observe(OpGetSize, start, err, 0, 0)
+	return size, err
+}
+
+func put(ctx context.Context, d *Datastore, w ds.Write, key key.Key, value []byte) error {
+	start := time.Now()
+	err := w.Put(ctx, key, value)
+	d.observe(OpPut, start, err, 0, len(value))
+	return err
+}
+
+func del(ctx context.Context, d *Datastore, w ds.Write, key key.Key) error {
+	start := time.Now()
+	err := w.Delete(ctx, key)
+	d.observe(OpDelete, start, err, 0, 0)
+	return err
+}
+
+func query(ctx context.Context, d *Datastore, r ds.Read, q dsq.Query) (dsq.Results, error) {
+	start := time.Now()
+	cqr, err := r.Query(ctx, q)
+	if err != nil {
+		d.observe(OpQuery, start, err, 0, 0)
+		return nil, err
+	}
+
+	var o Observation
+	observed := false
+	return dsq.ResultsFromIterator(q, dsq.Iterator{
+		Next: func() (dsq.Result, bool) {
+			r, ok := cqr.NextSync()
+			if !ok {
+				return r, false
+			}
+			if r.Error != nil {
+				o.Outcome = OutcomeError
+			} else {
+				o.Results++
+				o.BytesRead += int64(len(r.Value))
+			}
+			return r, true
+		},
+		Close: func() error {
+			err := cqr.Close()
+			// Close is called again by the results once they run out.
+			if observed {
+				return err
+			}
+			observed = true
+			if err != nil {
+				o.Outcome = OutcomeError
+			}
+			o.Duration = time.Since(start)
+			d.sink.Observe(d.name, OpQuery, o)
+			return err
+		},
+	}), nil
+}
+
+// PutWithTTL implements TTL.PutWithTTL, it's recorded as a Put.
+func (d *Datastore) PutWithTTL(ctx context.Context, key key.Key, value []byte, ttl time.Duration) error {
+	t, ok := d.child.(ds.TTL)
+	if !ok {
+		return ds.ErrTTLUnsupported
+	}
+	start := time.Now()
+	err := t.PutWithTTL(ctx, key, value, ttl)
+	d.observe(OpPut, start, err, 0, len(value))
+	return err
+}
+
+// SetTTL implements TTL.SetTTL
+func (d *Datastore) SetTTL(ctx context.Context, key key.Key, ttl time.Duration) error {
+	t, ok := d.child.(ds.TTL)
+	if !ok {
+		return ds.ErrTTLUnsupported
+	}
+	return t.SetTTL(ctx, key, ttl)
+}
+
+// GetExpiration implements TTL.GetExpiration
+func (d *Datastore) GetExpiration(ctx context.Context, key key.Key) (time.Time, error) {
+	t, ok := d.child.(ds.TTL)
+	if !ok {
+		return time.Time{}, ds.ErrTTLUnsupported
+	}
+	return t.GetExpiration(ctx, key)
+}
+
+// GetReader implements StreamingRead.GetReader
+func (d *Datastore) GetReader(ctx context.Context, key key.Key) (io.ReadCloser, error) {
+	return ds.GetReader(ctx, d.child, key)
+}
+
+// PutReader implements StreamingWrite.PutReader
+func (d *Datastore) PutReader(ctx context.Context, key key.Key, r io.Reader) error {
+	return ds.PutReader(ctx, d.child, key, r)
+}
+
+// PutIfAbsent implements CAS.PutIfAbsent
+func (d *Datastore) PutIfAbsent(ctx context.Context, key key.Key, value []byte) (bool, error) {
+	return ds.PutIfAbsent(ctx, d.child, key, value)
+}
+
+// CompareAndSwap implements CAS.CompareAndSwap
+func (d *Datastore) CompareAndSwap(ctx context.Context, key key.Key, old, new []byte) (bool, error) {
+	return ds.CompareAndSwap(ctx, d.child, key, old, new)
+}
+
+// DeleteIfEqual implements CAS.DeleteIfEqual
+func (d *Datastore) DeleteIfEqual(ctx context.Context, key key.Key, value []byte) (bool, error) {
+	return ds.DeleteIfEqual(ctx, d.child, key, value)
+}
+
+// GetMany implements BulkRead.GetMany
+func (d *Datastore) GetMany(ctx context.Context, keys []key.Key) ([]ds.ValueResult, error) {
+	return ds.GetMany(ctx, d.child, keys)
+}
+
+// HasMany implements BulkRead.HasMany
+func (d *Datastore) HasMany(ctx context.Context, keys []key.Key) ([]ds.HasResult, error) {
+	return ds.HasMany(ctx, d.child, keys)
+}
+
+// GetSizeMany implements BulkRead.GetSizeMany
+func (d *Datastore) GetSizeMany(ctx context.Context, keys []key.Key) ([]ds.SizeResult, error) {
+	return ds.GetSizeMany(ctx, d.child, keys)
+}
+
+// PutMany implements BulkWrite.PutMany
+func (d *Datastore) PutMany(ctx context.Context, keys []key.Key, values [][]byte) error {
+	return ds.PutMany(ctx, d.child, keys, values)
+}
+
+// Snapshot implements SnapshotDatastore.Snapshot
+func (d *Datastore) Snapshot(ctx context.Context) (ds.Snapshot, error) {
+	return ds.NewSnapshot(ctx, d.child)
+}
+
+// Watch implements Watcher.Watch
+func (d *Datastore) Watch(ctx context.Context, prefix key.Key) (<-chan ds.Event, error) {
+	return ds.Watch(ctx, d.child, prefix)
+}
+
+// DiskUsage implements the PersistentDatastore interface.
+func (d *Datastore) DiskUsage(ctx context.Context) (uint64, error) {
+	return ds.DiskUsage(ctx, d.child)
+}
+
+// Check implements CheckedDatastore.Check
+func (d *Datastore) Check(ctx context.Context) error {
+	if c, ok := d.child.(ds.CheckedDatastore); ok {
+		return c.Check(ctx)
+	}
+	return nil
+}
+
+// Scrub implements ScrubbedDatastore.Scrub
+func (d *Datastore) Scrub(ctx context.Context) error {
+	if c, ok := d.child.(ds.ScrubbedDatastore); ok {
+		return c.Scrub(ctx)
+	}
+	return nil
+}
+
+// CollectGarbage implements GCDatastore.CollectGarbage
+func (d *Datastore) CollectGarbage(ctx context.Context) error {
+	if c, ok := d.child.(ds.GCDatastore); ok {
+		return c.CollectGarbage(ctx)
+	}
+	return nil
+}
+
+// Close implements Datastore.Close
+func (d *Datastore) Close() error {
+	return d.child.Close()
+}
+
+// Batch implements Batching.Batch, only the commits of the batches are
+// recorded, with the sizes of all the values put.
+func (d *Datastore) Batch(ctx context.Context) (ds.Batch, error) {
+	bds, ok := d.child.(ds.Batching)
+	if !ok {
+		return nil, ds.ErrBatchUnsupported
+	}
+	b, err := bds.Batch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &metricsBatch{child: b, d: d}, nil
+}
+
+type metricsBatch struct {
+	child   ds.Batch
+	written int
+
+	d *Datastore
+}
+
+func (b *metricsBatch) Put(ctx context.Context, key key.Key, value []byte) error {
+	if err := b.child.Put(ctx, key, value); err != nil {
+		return err
+	}
+	b.written += len(value)
+	return nil
+}
+
+func (b *metricsBatch) Delete(ctx context.Context, key key.Key) error {
+	return b.child.Delete(ctx, key)
+}
+
+func (b *metricsBatch) Commit(ctx context.Context) error {
+	start := time.Now()
+	err := b.child.Commit(ctx)
+	b.d.observe(OpBatchCommit, start, err, 0, b.written)
+	return err
+}
+
+// NewTransaction implements TxnDatastore.NewTransaction, the operations of
+// the transaction are recorded like those of the datastore, while its
+// commit is recorded as OpTxnCommit.
+func (d *Datastore) NewTransaction(ctx context.Context, readOnly bool) (ds.Txn, error) {
+	tds, ok := d.child.(ds.TxnDatastore)
+	if !ok {
+		return nil, ds.ErrTxnUnsupported
+	}
+	t, err := tds.NewTransaction(ctx, readOnly)
+	if err != nil {
+		return nil, err
+	}
+	return &metricsTxn{child: t, d: d}, nil
+}
+
+type metricsTxn struct {
+	child ds.Txn
+
+	d *Datastore
+}
+
+func (t *metricsTxn) Get(ctx context.Context, key key.Key) ([]byte, error) {
+	return get(ctx, t.d, t.child, key)
+}
+
+func (t *metricsTxn) Has(ctx context.Context, key key.Key) (bool, error) {
+	return has(ctx, t.d, t.child, key)
+}
+
+func (t *metricsTxn) GetSize(ctx context.Context, key key.Key) (int, error) {
+	return getSize(ctx, t.d, t.child, key)
+}
+
+func (t *metricsTxn) Query(ctx context.Context, q dsq.Query) (dsq.Results, error) {
+	return query(ctx, t.d, t.child, q)
+}
+
+func (t *metricsTxn) Put(ctx context.Context, key key.Key, value []byte) error {
+	return put(ctx, t.d, t.child, key, value)
+}
+
+func (t *metricsTxn) Delete(ctx context.Context, key key.Key) error {
+	return del(ctx, t.d, t.child, key)
+}
+
+func (t *metricsTxn) Commit(ctx context.Context) error {
+	start := time.Now()
+	err := t.child.Commit(ctx)
+	t.d.observe(OpTxnCommit, start, err, 0, 0)
+	return err
+}
+
+func (t *metricsTxn) Discard(ctx context.Context) {
+	t.child.Discard(ctx)
+}
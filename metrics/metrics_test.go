@@ -0,0 +1,150 @@
+// Copyright (c) 2020 DAOT Labs. All rights reserved. Use of this source
+// code is governed by MIT license that can be found in the LICENSE file.
+
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	ds "github.com/daotl/go-datastore"
+	key "github.com/daotl/go-datastore/key"
+	dsq "github.com/daotl/go-datastore/query"
+	dstest "github.com/daotl/go-datastore/test"
+)
+
+func testSuite(t *testing.T, ktype key.KeyType) {
+	d := Wrap(dstest.NewMapDatastoreForTest(t, ktype), "test", NewRegistry(nil))
+	dstest.SubtestAll(t, ktype, d)
+}
+
+func TestSuite(t *testing.T) {
+	testSuite(t, key.KeyTypeString)
+	testSuite(t, key.KeyTypeBytes)
+}
+
+func TestMetrics(t *testing.T) {
+	ctx := context.Background()
+	r := NewRegistry(nil)
+	d := Wrap(dstest.NewMapDatastoreForTest(t, key.KeyTypeString), "test", r)
+
+	for _, k := range []string{"/a", "/b", "/c"} {
+		if err := d.Put(ctx, key.NewStrKey(k), []byte("value")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := d.Get(ctx, key.NewStrKey("/a")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Get(ctx, key.NewStrKey("/missing")); !ds.IsNotFound(err) {
+		t.Fatal("expected ErrNotFound, got: ", err)
+	}
+
+	res, err := d.Query(ctx, dsq.Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Close()
+	if _, err := res.Rest(); err != nil {
+		t.Fatal(err)
+	}
+	// The query is recorded once, though the results were closed by Rest.
+	if err := res.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := d.Batch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Put(ctx, key.NewStrKey("/d"), []byte("1234"))
+	b.Delete(ctx, key.NewStrKey("/a"))
+	if err := b.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if s := r.Stats("test", OpPut); s.Calls != 3 || s.BytesWritten != 15 || s.Errors != 0 {
+		t.Fatalf("unexpected put stats: %+v", s)
+	}
+	if s := r.Stats("test", OpGet); s.Calls != 2 || s.NotFound != 1 || s.BytesRead != 5 {
+		t.Fatalf("unexpected get stats: %+v", s)
+	}
+	if s := r.Stats("test", OpQuery); s.Calls != 1 || s.Results != 3 || s.BytesRead != 15 {
+		t.Fatalf("unexpected query stats: %+v", s)
+	}
+	if s := r.Stats("test", OpBatchCommit); s.Calls != 1 || s.BytesWritten != 4 {
+		t.Fatalf("unexpected batch commit stats: %+v", s)
+	}
+	if s := r.Stats("other", OpGet); s.Calls != 0 {
+		t.Fatalf("unexpected stats for another name: %+v", s)
+	}
+}
+
+func TestTxn(t *testing.T) {
+	ctx := context.Background()
+	child, err := ds.NewTxnMapDatastore(key.KeyTypeString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewRegistry(nil)
+	d := Wrap(child, "txn", r)
+	if !ds.Features(d).Has(ds.FeatureTxn) {
+		t.Fatal("expected the datastore to support transactions, got: ", ds.Features(d))
+	}
+
+	txn, err := d.NewTransaction(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Put(ctx, key.NewStrKey("/a"), []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if s := r.Stats("txn", OpPut); s.Calls != 1 || s.BytesWritten != 1 {
+		t.Fatalf("unexpected put stats: %+v", s)
+	}
+	if s := r.Stats("txn", OpTxnCommit); s.Calls != 1 {
+		t.Fatalf("unexpected commit stats: %+v", s)
+	}
+
+	d = Wrap(dstest.NewMapDatastoreForTest(t, key.KeyTypeString), "map", r)
+	if ds.Features(d).Has(ds.FeatureTxn) {
+		t.Fatal("the datastore should not support transactions")
+	}
+	if _, err := d.NewTransaction(ctx, false); err != ds.ErrTxnUnsupported {
+		t.Fatal("expected ErrTxnUnsupported, got: ", err)
+	}
+}
+
+func TestPrometheus(t *testing.T) {
+	ctx := context.Background()
+	r := NewRegistry([]float64{1, 10})
+	d := Wrap(dstest.NewMapDatastoreForTest(t, key.KeyTypeString), `a "quoted" name`, r)
+	d.Put(ctx, key.NewStrKey("/a"), []byte("value"))
+	d.Get(ctx, key.NewStrKey("/missing"))
+
+	var buf bytes.Buffer
+	if err := r.WritePrometheus(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, line := range []string{
+		"# TYPE datastore_operations_total counter",
+		`datastore_operations_total{name="a \"quoted\" name",op="put"} 1`,
+		`datastore_errors_total{name="a \"quoted\" name",op="get",kind="not_found"} 1`,
+		`datastore_errors_total{name="a \"quoted\" name",op="get",kind="other"} 0`,
+		`datastore_written_bytes_total{name="a \"quoted\" name",op="put"} 5`,
+		"# TYPE datastore_operation_duration_seconds histogram",
+		`datastore_operation_duration_seconds_bucket{name="a \"quoted\" name",op="put",le="1"} 1`,
+		`datastore_operation_duration_seconds_bucket{name="a \"quoted\" name",op="put",le="+Inf"} 1`,
+		`datastore_operation_duration_seconds_count{name="a \"quoted\" name",op="get"} 1`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("missing line %s in:\n%s", line, out)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+// Copyright (c) 2020 DAOT Labs. All rights reserved. Use of this source
+// code is governed by MIT license that can be found in the LICENSE file.
+
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultBuckets are the default upper bounds of the latency histograms, in
+// seconds.
+var DefaultBuckets = []float64{
+	.00001, .00005, .0001, .0005, .001, .005, .01, .05, .1, .5, 1, 5,
+}
+
+// Stats are the metrics of an operation of a datastore.
+type Stats struct {
+	Calls        int64
+	NotFound     int64
+	Errors       int64
+	BytesRead    int64
+	BytesWritten int64
+	Results      int64
+	// Duration is the total duration of the calls.
+	Duration time.Duration
+}
+
+type series struct {
+	name string
+	op   Op
+}
+
+type histogram struct {
+	Stats
+	// counts are the numbers of calls per bucket, the last one being +Inf.
+	counts []uint64
+}
+
+// Registry is a Sink which aggregates the observations into counters and
+// latency histograms per datastore name and operation, and writes them in
+// the Prometheus text exposition format.
+type Registry struct {
+	buckets []float64
+
+	lk     sync.Mutex
+	series map[series]*histogram
+}
+
+var _ Sink = (*Registry)(nil)
+
+// NewRegistry creates a Registry whose latency histograms have the given
+// bucket upper bounds in seconds, in increasing order. DefaultBuckets are
+// used if `buckets` is nil.
+func NewRegistry(buckets []float64) *Registry {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	return &Registry{
+		buckets: buckets,
+		series:  make(map[series]*histogram),
+	}
+}
+
+// Observe implements Sink.Observe
+func (r *Registry) Observe(name string, op Op, o Observation) {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+
+	h, ok := r.series[series{name, op}]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(r.buckets)+1)}
+		r.series[series{name, op}] = h
+	}
+	h.Calls++
+	switch o.Outcome {
+	case OutcomeNotFound:
+		h.NotFound++
+	case OutcomeError:
+		h.Errors++
+	}
+	h.BytesRead += o.BytesRead
+	h.BytesWritten += o.BytesWritten
+	h.Results += o.Results
+	h.Duration += o.Duration
+	h.counts[sort.SearchFloat64s(r.buckets, o.Duration.Seconds())]++
+}
+
+// Stats returns the metrics of the operation `op` of the datastore named
+// `name`.
+func (r *Registry) Stats(name string, op Op) Stats {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+	if h, ok := r.series[series{name, op}]; ok {
+		return h.Stats
+	}
+	return Stats{}
+}
+
+// WritePrometheus writes all the metrics in the Prometheus text exposition
+// format.
+func (r *Registry) WritePrometheus(w io.Writer) error {
+	r.lk.Lock()
+	keys := make([]series, 0, len(r.series))
+	hs := make(map[series]histogram, len(r.series))
+	for s, h := range r.series {
+		keys = append(keys, s)
+		hs[s] = histogram{Stats: h.Stats, counts: append([]uint64(nil), h.counts...)}
+	}
+	r.lk.Unlock()
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].name != keys[j].name {
+			return keys[i].name < keys[j].name
+		}
+		return keys[i].op < keys[j].op
+	})
+
+	bw := bufio.NewWriter(w)
+	counter := func(metric, help string, value func(h histogram) (int64, bool)) {
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s counter\n", metric, help, metric)
+		for _, s := range keys {
+			if v, ok := value(hs[s]); ok {
+				fmt.Fprintf(bw, "%s{%s} %d\n", metric, labels(s), v)
+			}
+		}
+	}
+
+	counter("datastore_operations_total", "Number of datastore operations.",
+		func(h histogram) (int64, bool) { return h.Calls, true })
+
+	fmt.Fprintf(bw, "# HELP datastore_errors_total Number of failed datastore operations.\n")
+	fmt.Fprintf(bw, "# TYPE datastore_errors_total counter\n")
+	for _, s := range keys {
+		h := hs[s]
+		fmt.Fprintf(bw, "datastore_errors_total{%s,kind=\"not_found\"} %d\n", labels(s), h.NotFound)
+		fmt.Fprintf(bw, "datastore_errors_total{%s,kind=\"other\"} %d\n", labels(s), h.Errors)
+	}
+
+	counter("datastore_read_bytes_total", "Number of bytes of values read.",
+		func(h histogram) (int64, bool) { return h.BytesRead, true })
+	counter("datastore_written_bytes_total", "Number of bytes of values written.",
+		func(h histogram) (int64, bool) { return h.BytesWritten, true })
+	counter("datastore_query_results_total", "Number of query results returned.",
+		func(h histogram) (int64, bool) { return h.Results, h.Results > 0 })
+
+	fmt.Fprintf(bw, "# HELP datastore_operation_duration_seconds Latency of datastore operations.\n")
+	fmt.Fprintf(bw, "# TYPE datastore_operation_duration_seconds histogram\n")
+	for _, s := range keys {
+		h := hs[s]
+		var cumulative uint64
+		for i, c := range h.counts {
+			cumulative += c
+			le := "+Inf"
+			if i < len(r.buckets) {
+				le = strconv.FormatFloat(r.buckets[i], 'g', -1, 64)
+			}
+			fmt.Fprintf(bw, "datastore_operation_duration_seconds_bucket{%s,le=%q} %d\n",
+				labels(s), le, cumulative)
+		}
+		fmt.Fprintf(bw, "datastore_operation_duration_seconds_sum{%s} %s\n",
+			labels(s), strconv.FormatFloat(h.Duration.Seconds(), 'g', -1, 64))
+		fmt.Fprintf(bw, "datastore_operation_duration_seconds_count{%s} %d\n", labels(s), h.Calls)
+	}
+	return bw.Flush()
+}
+
+// ServeHTTP serves the metrics in the Prometheus text exposition format.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.WritePrometheus(w)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func labels(s series) string {
+	return fmt.Sprintf(`name="%s",op="%s"`, labelEscaper.Replace(s.name), s.op)
+}
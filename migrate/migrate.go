@@ -0,0 +1,310 @@
+// Copyright (c) 2020 DAOT Labs. All rights reserved. Use of this source
+// code is governed by MIT license that can be found in the LICENSE file.
+
+// Package migrate copies entries from one datastore to another, to migrate
+// data between backends.
+package migrate
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+
+	ds "github.com/daotl/go-datastore"
+	key "github.com/daotl/go-datastore/key"
+	"github.com/daotl/go-datastore/keytransform"
+	dsq "github.com/daotl/go-datastore/query"
+)
+
+// DefaultBatchSize is the number of entries written at once if
+// Options.BatchSize is not set.
+const DefaultBatchSize = 1000
+
+// Options are the options of Copy.
+type Options struct {
+	// Prefix and Range select the entries of the source to copy, like in
+	// query.Query.
+	Prefix key.Key
+	Range  dsq.Range
+
+	// Transform, if set, converts the keys of the source to the keys of the
+	// destination with ConvertKey. Use keytransform.KeyTypeTransform to copy
+	// between datastores with different key types.
+	Transform keytransform.KeyTransform
+
+	// BatchSize is the number of entries written at once, through a Batch if
+	// the destination is Batching. It's DefaultBatchSize if not set.
+	BatchSize int
+
+	// Concurrency is the number of batches written concurrently, 1 if not
+	// set. The destination must be safe for concurrent use if it's more
+	// than 1.
+	Concurrency int
+
+	// Progress, if set, is called after each batch has been written, with
+	// the progress of the copy. Batches are reported in the order of their
+	// keys, even if they are written concurrently.
+	Progress func(Progress)
+
+	// Checkpoint, if set, is called with the last source key of each batch
+	// once it and all the batches before it have been written. The copy can
+	// be resumed from there with ResumeAfter. If Checkpoint returns an error,
+	// the copy is aborted.
+	Checkpoint func(ctx context.Context, last key.Key) error
+
+	// ResumeAfter, if set, skips the source keys up to and including it,
+	// typically the last checkpoint of an interrupted copy.
+	ResumeAfter key.Key
+
+	// Verify, if set, re-reads each batch from the destination after writing
+	// it and checks the values, returning a *VerifyError on mismatch.
+	Verify bool
+}
+
+// Progress is the progress of a copy.
+type Progress struct {
+	// Entries and Bytes are the number of entries and bytes of values
+	// copied so far.
+	Entries int64
+	Bytes   int64
+	// LastKey is the last source key copied, all the keys before it have
+	// been copied too.
+	LastKey key.Key
+}
+
+// VerifyError is returned by Copy when a value read back from the
+// destination doesn't match the source.
+type VerifyError struct {
+	// Key is the key in the destination.
+	Key key.Key
+	// Err is the error reading the key back, if any.
+	Err error
+}
+
+func (e *VerifyError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("migrate: verifying %s: %s", e.Key, e.Err)
+	}
+	return fmt.Sprintf("migrate: verifying %s: value mismatch", e.Key)
+}
+
+func (e *VerifyError) Unwrap() error {
+	return e.Err
+}
+
+// batch is a batch of entries to copy, seq is its position in the copy.
+type batch struct {
+	seq     int
+	last    key.Key
+	keys    []key.Key
+	values  [][]byte
+	entries int64
+	bytes   int64
+}
+
+type copier struct {
+	dst    ds.Datastore
+	opts   Options
+	cancel context.CancelFunc
+
+	lk       sync.Mutex
+	err      error
+	next     int
+	finished map[int]*batch
+	progress Progress
+}
+
+// Copy copies the entries selected by `opts` from `src` to `dst`, and
+// returns the progress made, which is complete if the error is nil.
+//
+// Entries are read from `src` in key order so that the copy can be
+// resumed from a checkpoint, see Options.Checkpoint.
+func Copy(ctx context.Context, src, dst ds.Datastore, opts Options) (Progress, error) {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultBatchSize
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 1
+	}
+
+	q := dsq.Query{
+		Prefix: opts.Prefix,
+		Range:  opts.Range,
+		Orders: []dsq.Order{dsq.OrderByKey{}},
+	}
+	if opts.ResumeAfter != nil &&
+		(q.Range.Start == nil || q.Range.Start.Less(opts.ResumeAfter)) {
+		q.Range.Start = opts.ResumeAfter
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	res, err := src.Query(ctx, q)
+	if err != nil {
+		return Progress{}, err
+	}
+	defer res.Close()
+
+	c := &copier{
+		dst:      dst,
+		opts:     opts,
+		cancel:   cancel,
+		finished: make(map[int]*batch),
+	}
+
+	batches := make(chan *batch)
+	var wg sync.WaitGroup
+	for i := 0; i < opts.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for b := range batches {
+				c.write(ctx, b)
+			}
+		}()
+	}
+
+	readErr := c.read(ctx, res, batches)
+	close(batches)
+	wg.Wait()
+
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	switch {
+	case c.err != nil:
+		return c.progress, c.err
+	case readErr != nil:
+		return c.progress, readErr
+	}
+	return c.progress, nil
+}
+
+// read reads the results into batches and sends them to the writers.
+func (c *copier) read(ctx context.Context, res dsq.Results, batches chan<- *batch) error {
+	b := &batch{}
+	send := func() bool {
+		select {
+		case batches <- b:
+			b = &batch{seq: b.seq + 1}
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	for {
+		r, ok := res.NextSync()
+		if !ok {
+			break
+		}
+		if r.Error != nil {
+			return r.Error
+		}
+		if c.opts.ResumeAfter != nil && r.Key.Equal(c.opts.ResumeAfter) {
+			continue
+		}
+
+		k := r.Key
+		if c.opts.Transform != nil {
+			k = c.opts.Transform.ConvertKey(k)
+		}
+		b.last = r.Key
+		b.keys = append(b.keys, k)
+		b.values = append(b.values, r.Value)
+		b.entries++
+		b.bytes += int64(len(r.Value))
+
+		if len(b.keys) == c.opts.BatchSize && !send() {
+			return ctx.Err()
+		}
+	}
+	if len(b.keys) > 0 && !send() {
+		return ctx.Err()
+	}
+	return ctx.Err()
+}
+
+// write writes and verifies a batch.
+func (c *copier) write(ctx context.Context, b *batch) {
+	if err := ctx.Err(); err != nil {
+		// The copy has failed or was canceled, drain the batches. The
+		// error is recorded in case the batch was read before `ctx` was
+		// canceled, so that the copy doesn't succeed without it.
+		c.fail(err)
+		return
+	}
+	if err := ds.PutMany(ctx, c.dst, b.keys, b.values); err != nil {
+		c.fail(err)
+		return
+	}
+	if c.opts.Verify {
+		if err := c.verify(ctx, b); err != nil {
+			c.fail(err)
+			return
+		}
+	}
+	c.done(ctx, b)
+}
+
+func (c *copier) verify(ctx context.Context, b *batch) error {
+	res, err := ds.GetMany(ctx, c.dst, b.keys)
+	if err != nil {
+		return err
+	}
+	for i, r := range res {
+		if r.Error != nil {
+			return &VerifyError{Key: b.keys[i], Err: r.Error}
+		}
+		if !bytes.Equal(r.Value, b.values[i]) {
+			return &VerifyError{Key: b.keys[i]}
+		}
+	}
+	return nil
+}
+
+// done records a written batch and reports the progress for all the batches
+// written so far without gaps.
+func (c *copier) done(ctx context.Context, b *batch) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	c.finished[b.seq] = b
+	for {
+		b, ok := c.finished[c.next]
+		if !ok || c.err != nil {
+			return
+		}
+		delete(c.finished, c.next)
+		c.next++
+
+		c.progress.Entries += b.entries
+		c.progress.Bytes += b.bytes
+		c.progress.LastKey = b.last
+		if c.opts.Progress != nil {
+			c.opts.Progress(c.progress)
+		}
+		if c.opts.Checkpoint != nil {
+			if err := c.opts.Checkpoint(ctx, b.last); err != nil {
+				c.failLocked(err)
+				return
+			}
+		}
+	}
+}
+
+func (c *copier) fail(err error) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	c.failLocked(err)
+}
+
+// failLocked records the first error and aborts the copy, the caller must
+// hold c.lk.
+func (c *copier) failLocked(err error) {
+	if c.err == nil {
+		c.err = err
+		c.cancel()
+	}
+}
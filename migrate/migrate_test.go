@@ -0,0 +1,211 @@
+// Copyright (c) 2020 DAOT Labs. All rights reserved. Use of this source
+// code is governed by MIT license that can be found in the LICENSE file.
+
+package migrate
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	ds "github.com/daotl/go-datastore"
+	key "github.com/daotl/go-datastore/key"
+	"github.com/daotl/go-datastore/keytransform"
+	dsq "github.com/daotl/go-datastore/query"
+	dssync "github.com/daotl/go-datastore/sync"
+	dstest "github.com/daotl/go-datastore/test"
+)
+
+func fill(t *testing.T, ktype key.KeyType, n int) *ds.MapDatastore {
+	ctx := context.Background()
+	d := dstest.NewMapDatastoreForTest(t, ktype)
+	for i := 0; i < n; i++ {
+		s := fmt.Sprintf("/a/%04d", i)
+		if i%2 == 1 {
+			s = fmt.Sprintf("/b/%04d", i)
+		}
+		if err := d.Put(ctx, key.NewKeyFromTypeAndString(ktype, s), []byte(s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return d
+}
+
+func entries(t *testing.T, d ds.Datastore) []dsq.Entry {
+	res, err := d.Query(context.Background(), dsq.Query{Orders: []dsq.Order{dsq.OrderByKey{}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	es, err := res.Rest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return es
+}
+
+func testCopy(t *testing.T, ktype key.KeyType) {
+	ctx := context.Background()
+	src := fill(t, ktype, 100)
+	dst := dssync.MutexWrap(dstest.NewMapDatastoreForTest(t, ktype))
+
+	var reports []Progress
+	p, err := Copy(ctx, src, dst, Options{
+		BatchSize:   7,
+		Concurrency: 4,
+		Verify:      true,
+		Progress: func(p Progress) {
+			reports = append(reports, p)
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Entries != 100 || p.Bytes != 100*7 {
+		t.Fatalf("unexpected progress: %+v", p)
+	}
+	if len(reports) != 15 {
+		t.Fatalf("expected 15 progress reports, got %d", len(reports))
+	}
+	for i := 1; i < len(reports); i++ {
+		if reports[i].Entries <= reports[i-1].Entries || !reports[i-1].LastKey.Less(reports[i].LastKey) {
+			t.Fatal("progress should be reported in order")
+		}
+	}
+
+	se, de := entries(t, src), entries(t, dst)
+	if len(se) != len(de) {
+		t.Fatalf("expected %d entries, got %d", len(se), len(de))
+	}
+	for i := range se {
+		if !se[i].Key.Equal(de[i].Key) || !bytes.Equal(se[i].Value, de[i].Value) {
+			t.Fatalf("entry %d differs: %s != %s", i, se[i].Key, de[i].Key)
+		}
+	}
+}
+
+func TestCopy(t *testing.T) {
+	testCopy(t, key.KeyTypeString)
+	testCopy(t, key.KeyTypeBytes)
+}
+
+func TestSelection(t *testing.T) {
+	ctx := context.Background()
+	src := fill(t, key.KeyTypeString, 100)
+
+	dst := dstest.NewMapDatastoreForTest(t, key.KeyTypeString)
+	p, err := Copy(ctx, src, dst, Options{Prefix: key.NewStrKey("/b")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Entries != 50 {
+		t.Fatalf("expected to copy 50 entries, copied %d", p.Entries)
+	}
+	for _, e := range entries(t, dst) {
+		if !key.NewStrKey("/b").IsAncestorOf(e.Key) {
+			t.Fatalf("unexpected key %s", e.Key)
+		}
+	}
+
+	dst = dstest.NewMapDatastoreForTest(t, key.KeyTypeString)
+	p, err = Copy(ctx, src, dst, Options{Range: dsq.Range{
+		Start: key.NewStrKey("/a/0010"),
+		End:   key.NewStrKey("/a/0020"),
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Entries != 5 {
+		t.Fatalf("expected to copy 5 entries, copied %d", p.Entries)
+	}
+}
+
+func TestKeyTypeTransform(t *testing.T) {
+	ctx := context.Background()
+	src := fill(t, key.KeyTypeString, 10)
+	dst := dstest.NewMapDatastoreForTest(t, key.KeyTypeBytes)
+
+	_, err := Copy(ctx, src, dst, Options{
+		Transform: keytransform.KeyTypeTransform{From: key.KeyTypeString, To: key.KeyTypeBytes},
+		Verify:    true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := dst.Get(ctx, key.NewBytesKeyFromString("/b/0003"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(v) != "/b/0003" {
+		t.Fatalf("unexpected value %q", v)
+	}
+}
+
+func TestResume(t *testing.T) {
+	ctx := context.Background()
+	src := fill(t, key.KeyTypeBytes, 100)
+	dst := dssync.MutexWrap(dstest.NewMapDatastoreForTest(t, key.KeyTypeBytes))
+
+	// Interrupt the copy after 3 batches.
+	interrupted := errors.New("interrupted")
+	var checkpoint key.Key
+	n := 0
+	p, err := Copy(ctx, src, dst, Options{
+		BatchSize:   10,
+		Concurrency: 3,
+		Checkpoint: func(ctx context.Context, last key.Key) error {
+			if n == 3 {
+				return interrupted
+			}
+			n++
+			checkpoint = last
+			return nil
+		},
+	})
+	if err != interrupted {
+		t.Fatal("expected the copy to be interrupted, got: ", err)
+	}
+	if p.Entries < 30 || !p.LastKey.Equal(entries(t, src)[p.Entries-1].Key) {
+		t.Fatalf("unexpected progress: %+v", p)
+	}
+
+	p, err = Copy(ctx, src, dst, Options{BatchSize: 10, ResumeAfter: checkpoint, Verify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Entries != 70 {
+		t.Fatalf("expected to copy the 70 remaining entries, copied %d", p.Entries)
+	}
+	if len(entries(t, dst)) != 100 {
+		t.Fatal("expected all the entries to be copied")
+	}
+}
+
+// corruptDatastore returns wrong values.
+type corruptDatastore struct {
+	*ds.MapDatastore
+}
+
+func (d corruptDatastore) Get(ctx context.Context, key key.Key) ([]byte, error) {
+	v, err := d.MapDatastore.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return append(v, 'x'), nil
+}
+
+func TestVerify(t *testing.T) {
+	ctx := context.Background()
+	src := fill(t, key.KeyTypeString, 10)
+	dst := corruptDatastore{dstest.NewMapDatastoreForTest(t, key.KeyTypeString)}
+
+	_, err := Copy(ctx, src, dst, Options{Verify: true})
+	var verr *VerifyError
+	if !errors.As(err, &verr) {
+		t.Fatal("expected a VerifyError, got: ", err)
+	}
+	if _, err := Copy(ctx, src, dst, Options{}); err != nil {
+		t.Fatal("expected the copy to succeed without verification, got: ", err)
+	}
+}
@@ -8,10 +8,10 @@
 package mount
 
 import (
-	"container/heap"
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"sort"
 	"sync"
 
@@ -75,115 +75,60 @@ type Datastore struct {
 }
 
 var _ ds.Datastore = (*Datastore)(nil)
-
-// lookup looks up the datastore in which the given key lives.
-func (d *Datastore) lookup(k key.Key) (ds.Datastore, key.Key, key.Key) {
-	for _, m := range d.mounts {
-		if m.Prefix.IsAncestorOf(k) {
-			found := k.TrimPrefix(m.Prefix)
-			return m.Datastore, m.Prefix, found
-		}
+var _ ds.PrefixedShim = (*Datastore)(nil)
+var _ ds.StreamingDatastore = (*Datastore)(nil)
+var _ ds.CASDatastore = (*Datastore)(nil)
+var _ ds.BulkDatastore = (*Datastore)(nil)
+var _ ds.SnapshotDatastore = (*Datastore)(nil)
+var _ ds.WatchDatastore = (*Datastore)(nil)
+
+// Children implements ds.Shim, the mounted datastores are returned from the
+// most specific prefix to the least specific one.
+func (d *Datastore) Children() []ds.Datastore {
+	children := make([]ds.Datastore, len(d.mounts))
+	for i, m := range d.mounts {
+		children[i] = m.Datastore
 	}
-	return nil, key.EmptyKeyFromType(k.KeyType()), k
-}
-
-type queryResults struct {
-	mount   key.Key
-	results query.Results
-	next    query.Result
+	return children
 }
 
-func (qr *queryResults) advance() bool {
-	if qr.results == nil {
-		return false
-	}
-
-	qr.next = query.Result{}
-	r, more := qr.results.NextSync()
-	if !more {
-		err := qr.results.Close()
-		qr.results = nil
-		if err != nil {
-			// One more result, the error.
-			qr.next = query.Result{Error: err}
-			return true
-		}
-		return false
+// ChildPrefixes implements ds.PrefixedShim
+func (d *Datastore) ChildPrefixes() []key.Key {
+	prefixes := make([]key.Key, len(d.mounts))
+	for i, m := range d.mounts {
+		prefixes[i] = m.Prefix
 	}
-
-	r.Key = qr.mount.Child(r.Key)
-	qr.next = r
-	return true
-}
-
-type querySet struct {
-	query query.Query
-	heads []*queryResults
-}
-
-func (h *querySet) Len() int {
-	return len(h.heads)
+	return prefixes
 }
 
-func (h *querySet) Less(i, j int) bool {
-	return query.Less(h.query.Orders, h.heads[i].next.Entry, h.heads[j].next.Entry)
-}
-
-func (h *querySet) Swap(i, j int) {
-	h.heads[i], h.heads[j] = h.heads[j], h.heads[i]
-}
-
-func (h *querySet) Push(x interface{}) {
-	h.heads = append(h.heads, x.(*queryResults))
-}
-
-func (h *querySet) Pop() interface{} {
-	i := len(h.heads) - 1
-	last := h.heads[i]
-	h.heads[i] = nil
-	h.heads = h.heads[:i]
-	return last
-}
-
-func (h *querySet) close() error {
-	var errs []error
-	for _, qr := range h.heads {
-		err := qr.results.Close()
-		if err != nil {
-			errs = append(errs, err)
-		}
+// Capabilities implements ds.Capable. Batching, streaming, conditional
+// writes, snapshots and watching are supported if all the mounted datastores
+// support them, while the maintenance features are supported if any of them
+// does. Bulk operations are always supported as keys are grouped by mount.
+func (d *Datastore) Capabilities() ds.Feature {
+	if len(d.mounts) == 0 {
+		return ds.FeatureBulk
 	}
-	h.heads = nil
-	if len(errs) > 0 {
-		return errs[0]
-	}
-	return nil
-}
-
-func (h *querySet) addResults(mount key.Key, results query.Results) {
-	r := &queryResults{
-		results: results,
-		mount:   mount,
-	}
-	if r.advance() {
-		heap.Push(h, r)
+	all := ds.FeatureBatching | ds.FeatureStreaming | ds.FeatureCAS | ds.FeatureSnapshot |
+		ds.FeatureWatch
+	var any ds.Feature
+	for _, m := range d.mounts {
+		f := ds.Features(m.Datastore)
+		all &= f | ds.CASFeature(m.Datastore)
+		any |= f & ds.FeatureMaintenance
 	}
+	return all | any | ds.FeatureBulk
 }
 
-func (h *querySet) next() (query.Result, bool) {
-	if len(h.heads) == 0 {
-		return query.Result{}, false
-	}
-	head := h.heads[0]
-	next := head.next
-
-	if head.advance() {
-		heap.Fix(h, 0)
-	} else {
-		heap.Remove(h, 0)
+// lookup looks up the datastore in which the given key lives.
+func (d *Datastore) lookup(k key.Key) (ds.Datastore, key.Key, key.Key) {
+	for _, m := range d.mounts {
+		if m.Prefix.IsAncestorOf(k) {
+			found := k.TrimPrefix(m.Prefix)
+			return m.Datastore, m.Prefix, found
+		}
 	}
-
-	return next, true
+	return nil, key.EmptyKeyFromType(k.KeyType()), k
 }
 
 // lookupAll returns all mounts that might contain keys that are strict
@@ -335,6 +280,232 @@ func (d *Datastore) Delete(ctx context.Context, key key.Key) error {
 	return cds.Delete(ctx, k)
 }
 
+// GetReader returns a reader of the value associated with the key from the
+// appropriate datastore.
+func (d *Datastore) GetReader(ctx context.Context, key key.Key) (io.ReadCloser, error) {
+	cds, _, k := d.lookup(key)
+	if cds == nil {
+		return nil, ds.ErrNotFound
+	}
+	return ds.GetReader(ctx, cds, k)
+}
+
+// PutReader stores the value read from `r` into the datastore at the given
+// key.
+//
+// Returns ErrNoMount if there no datastores are mounted at the appropriate
+// prefix for the given key.
+func (d *Datastore) PutReader(ctx context.Context, key key.Key, r io.Reader) error {
+	cds, _, k := d.lookup(key)
+	if cds == nil {
+		return ErrNoMount
+	}
+	return ds.PutReader(ctx, cds, k, r)
+}
+
+// PutIfAbsent implements CAS.PutIfAbsent on the appropriate datastore.
+//
+// Returns ErrNoMount if there no datastores are mounted at the appropriate
+// prefix for the given key.
+func (d *Datastore) PutIfAbsent(ctx context.Context, key key.Key, value []byte) (ok bool, err error) {
+	cds, _, k := d.lookup(key)
+	if cds == nil {
+		return false, ErrNoMount
+	}
+	return ds.PutIfAbsent(ctx, cds, k, value)
+}
+
+// CompareAndSwap implements CAS.CompareAndSwap on the appropriate datastore.
+func (d *Datastore) CompareAndSwap(ctx context.Context, key key.Key, old, new []byte) (ok bool, err error) {
+	cds, _, k := d.lookup(key)
+	if cds == nil {
+		return false, nil
+	}
+	return ds.CompareAndSwap(ctx, cds, k, old, new)
+}
+
+// DeleteIfEqual implements CAS.DeleteIfEqual on the appropriate datastore.
+func (d *Datastore) DeleteIfEqual(ctx context.Context, key key.Key, value []byte) (ok bool, err error) {
+	cds, _, k := d.lookup(key)
+	if cds == nil {
+		return false, nil
+	}
+	return ds.DeleteIfEqual(ctx, cds, k, value)
+}
+
+// mountGroup is a group of keys living in the same mounted datastore.
+type mountGroup struct {
+	prefix key.Key
+	ds     ds.Datastore
+	// indexes are the indexes of the keys in the slice they were grouped
+	// from, keys are the keys within the mounted datastore.
+	indexes []int
+	keys    []key.Key
+}
+
+// group groups the given keys by the datastore they live in, `missing` holds
+// the indexes of the keys which don't live in any datastore.
+func (d *Datastore) group(keys []key.Key) (groups []*mountGroup, missing []int) {
+	byPrefix := make(map[string]*mountGroup)
+	for i, k := range keys {
+		cds, mount, rest := d.lookup(k)
+		if cds == nil {
+			missing = append(missing, i)
+			continue
+		}
+		g, ok := byPrefix[mount.String()]
+		if !ok {
+			g = &mountGroup{prefix: mount, ds: cds}
+			byPrefix[mount.String()] = g
+			groups = append(groups, g)
+		}
+		g.indexes = append(g.indexes, i)
+		g.keys = append(g.keys, rest)
+	}
+	return groups, missing
+}
+
+// fanOut calls `fn` for each group in parallel, and returns the errors for
+// each group.
+func fanOut(groups []*mountGroup, fn func(g *mountGroup) error) []error {
+	errs := make([]error, len(groups))
+	if len(groups) == 1 {
+		errs[0] = fn(groups[0])
+		return errs
+	}
+	var wg sync.WaitGroup
+	for i, g := range groups {
+		wg.Add(1)
+		go func(i int, g *mountGroup) {
+			defer wg.Done()
+			errs[i] = fn(g)
+		}(i, g)
+	}
+	wg.Wait()
+	return errs
+}
+
+// GetMany implements BulkRead.GetMany, the keys are grouped by the datastore
+// they live in and the groups are read in parallel. If reading a group fails,
+// the error is reported in the result of each of its keys.
+func (d *Datastore) GetMany(ctx context.Context, keys []key.Key) ([]ds.ValueResult, error) {
+	res := make([]ds.ValueResult, len(keys))
+	groups, missing := d.group(keys)
+	for _, i := range missing {
+		res[i].Error = ds.ErrNotFound
+	}
+	errs := fanOut(groups, func(g *mountGroup) error {
+		gres, err := ds.GetMany(ctx, g.ds, g.keys)
+		if err != nil {
+			return err
+		}
+		for j, i := range g.indexes {
+			res[i] = gres[j]
+		}
+		return nil
+	})
+	for gi, err := range errs {
+		if err == nil {
+			continue
+		}
+		for _, i := range groups[gi].indexes {
+			res[i].Error = fmt.Errorf("getting from datastore at %s: %w", groups[gi].prefix, err)
+		}
+	}
+	return res, nil
+}
+
+// HasMany implements BulkRead.HasMany, the keys are grouped by the datastore
+// they live in and the groups are read in parallel.
+func (d *Datastore) HasMany(ctx context.Context, keys []key.Key) ([]ds.HasResult, error) {
+	res := make([]ds.HasResult, len(keys))
+	groups, _ := d.group(keys)
+	errs := fanOut(groups, func(g *mountGroup) error {
+		gres, err := ds.HasMany(ctx, g.ds, g.keys)
+		if err != nil {
+			return err
+		}
+		for j, i := range g.indexes {
+			res[i] = gres[j]
+		}
+		return nil
+	})
+	for gi, err := range errs {
+		if err == nil {
+			continue
+		}
+		for _, i := range groups[gi].indexes {
+			res[i].Error = fmt.Errorf("checking datastore at %s: %w", groups[gi].prefix, err)
+		}
+	}
+	return res, nil
+}
+
+// GetSizeMany implements BulkRead.GetSizeMany, the keys are grouped by the
+// datastore they live in and the groups are read in parallel.
+func (d *Datastore) GetSizeMany(ctx context.Context, keys []key.Key) ([]ds.SizeResult, error) {
+	res := make([]ds.SizeResult, len(keys))
+	groups, missing := d.group(keys)
+	for _, i := range missing {
+		res[i] = ds.SizeResult{Size: -1, Error: ds.ErrNotFound}
+	}
+	errs := fanOut(groups, func(g *mountGroup) error {
+		gres, err := ds.GetSizeMany(ctx, g.ds, g.keys)
+		if err != nil {
+			return err
+		}
+		for j, i := range g.indexes {
+			res[i] = gres[j]
+		}
+		return nil
+	})
+	for gi, err := range errs {
+		if err == nil {
+			continue
+		}
+		for _, i := range groups[gi].indexes {
+			res[i] = ds.SizeResult{
+				Size:  -1,
+				Error: fmt.Errorf("getting size from datastore at %s: %w", groups[gi].prefix, err),
+			}
+		}
+	}
+	return res, nil
+}
+
+// PutMany implements BulkWrite.PutMany, the keys are grouped by the datastore
+// they live in and the groups are written in parallel.
+//
+// Returns ErrNoMount without writing anything if there no datastores are
+// mounted at the appropriate prefix for any of the given keys.
+func (d *Datastore) PutMany(ctx context.Context, keys []key.Key, values [][]byte) error {
+	if len(keys) != len(values) {
+		return ds.ErrBulkLength
+	}
+	groups, missing := d.group(keys)
+	if len(missing) > 0 {
+		return ErrNoMount
+	}
+	errs := fanOut(groups, func(g *mountGroup) error {
+		gvalues := make([][]byte, len(g.indexes))
+		for j, i := range g.indexes {
+			gvalues[j] = values[i]
+		}
+		return ds.PutMany(ctx, g.ds, g.keys, gvalues)
+	})
+	var merr error
+	for gi, err := range errs {
+		if err != nil {
+			merr = multierr.Append(merr, fmt.Errorf(
+				"putting to datastore at %s: %w",
+				groups[gi].prefix.String(),
+				err,
+			))
+		}
+	}
+	return merr
+}
+
 // Query queries the appropriate mounted datastores, merging the results
 // according to the given orders.
 //
@@ -352,11 +523,7 @@ func (d *Datastore) Query(ctx context.Context, master query.Query) (query.Result
 
 	dses, mounts, restPrefixes, restRanges := d.lookupAll(key.Clean(childQuery.Prefix), childQuery.Range)
 
-	queries := &querySet{
-		query: childQuery,
-		heads: make([]*queryResults, 0, len(dses)),
-	}
-
+	sources := make([]query.Results, 0, len(dses))
 	for i := range dses {
 		mount := mounts[i]
 		dstore := dses[i]
@@ -367,32 +534,103 @@ func (d *Datastore) Query(ctx context.Context, master query.Query) (query.Result
 		results, err := dstore.Query(ctx, qi)
 
 		if err != nil {
-			_ = queries.close()
+			for _, r := range sources {
+				_ = r.Close()
+			}
 			return nil, err
 		}
-		queries.addResults(mount, results)
+		sources = append(sources, mountResults(mount, qi, results))
 	}
 
-	qr := query.ResultsFromIterator(master, query.Iterator{
-		Next:  queries.next,
-		Close: queries.close,
+	return query.Merge(master, sources, query.MergeOptions{QueryOrdered: true}), nil
+}
+
+// mountResults adds the mount prefix to the keys of `results`, so that they
+// are merged with the keys of the other mounts.
+func mountResults(mount key.Key, q query.Query, results query.Results) query.Results {
+	return query.ResultsFromIterator(q, query.Iterator{
+		Next: func() (query.Result, bool) {
+			r, ok := results.NextSync()
+			if ok && r.Error == nil {
+				r.Key = mount.Child(r.Key)
+			}
+			return r, ok
+		},
+		Close: results.Close,
 	})
+}
 
-	if len(master.Filters) > 0 {
-		for _, f := range master.Filters {
-			qr = query.NaiveFilter(qr, f)
+// Snapshot implements SnapshotDatastore.Snapshot by taking a snapshot of
+// each mounted datastore and mounting them at the same prefixes. It fails if
+// any of the mounted datastores doesn't support snapshots.
+//
+// The snapshots of the mounted datastores are taken one after the other, so
+// the snapshot is only consistent across mounts if there are no concurrent
+// writes.
+func (d *Datastore) Snapshot(ctx context.Context) (ds.Snapshot, error) {
+	mounts := make([]Mount, 0, len(d.mounts))
+	for _, m := range d.mounts {
+		s, err := ds.NewSnapshot(ctx, m.Datastore)
+		if err != nil {
+			for _, m := range mounts {
+				m.Datastore.Close()
+			}
+			return nil, fmt.Errorf("taking snapshot at %s: %w", m.Prefix.String(), err)
 		}
+		mounts = append(mounts, Mount{Prefix: m.Prefix, Datastore: ds.NewSnapshotAdapter(s)})
 	}
+	return &Datastore{mounts: mounts}, nil
+}
 
-	if master.Offset > 0 {
-		qr = query.NaiveOffset(qr, master.Offset)
+// Watch implements Watcher.Watch by watching the mounted datastores which
+// contain keys under `prefix`, and merging their events with the mount
+// prefixes added to the keys. It fails if any of them doesn't support
+// watching.
+//
+// If the channel of any of the mounted datastores is closed before `ctx` is
+// done, the returned channel is closed too so that the subscriber knows it
+// missed events.
+func (d *Datastore) Watch(ctx context.Context, prefix key.Key) (<-chan ds.Event, error) {
+	dss, mounts, rests, _ := d.lookupAll(prefix, query.Range{})
+
+	ctx, cancel := context.WithCancel(ctx)
+	chs := make([]<-chan ds.Event, len(dss))
+	for i, cd := range dss {
+		ch, err := ds.Watch(ctx, cd, rests[i])
+		if err != nil {
+			cancel()
+			return nil, fmt.Errorf("watching at %s: %w", mounts[i].String(), err)
+		}
+		chs[i] = ch
 	}
 
-	if master.Limit > 0 {
-		qr = query.NaiveLimit(qr, master.Limit)
+	out := make(chan ds.Event)
+	var wg sync.WaitGroup
+	for i, ch := range chs {
+		wg.Add(1)
+		go func(mount key.Key, ch <-chan ds.Event) {
+			defer wg.Done()
+			// Stop watching the other mounts once this one is done.
+			defer cancel()
+			for e := range ch {
+				e.Key = mount.Child(e.Key)
+				select {
+				case out <- e:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(mounts[i], ch)
 	}
-
-	return qr, nil
+	go func() {
+		if len(chs) == 0 {
+			<-ctx.Done()
+		}
+		wg.Wait()
+		cancel()
+		close(out)
+	}()
+	return out, nil
 }
 
 // Close closes all mounted datastores.
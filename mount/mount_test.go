@@ -12,11 +12,13 @@ import (
 
 	"github.com/daotl/go-datastore"
 	"github.com/daotl/go-datastore/autobatch"
+	"github.com/daotl/go-datastore/failstore"
 	"github.com/daotl/go-datastore/key"
 	"github.com/daotl/go-datastore/mount"
 	"github.com/daotl/go-datastore/query"
 	"github.com/daotl/go-datastore/sync"
 	dstest "github.com/daotl/go-datastore/test"
+	"github.com/daotl/go-datastore/watch"
 )
 
 func testPutBadNothing(t *testing.T, ktype key.KeyType) {
@@ -1046,6 +1048,11 @@ func testMaintenanceFunctions(t *testing.T, ktype key.KeyType) {
 	if err := m.Scrub(ctx); err.Error() != "scrubbing datastore at " + path + ": test error" {
 		t.Errorf("Unexpected Scrub() error: %s", err)
 	}
+
+	// The errors of the mounted datastores are preserved.
+	if err := m.Check(ctx); !errors.Is(err, dstest.ErrTest) {
+		t.Errorf("Check() error should wrap ErrTest: %s", err)
+	}
 }
 
 func TestMaintenanceFunctions(t *testing.T) {
@@ -1053,6 +1060,113 @@ func TestMaintenanceFunctions(t *testing.T) {
 	testMaintenanceFunctions(t, key.KeyTypeBytes)
 }
 
+func testBulk(t *testing.T, ktype key.KeyType) {
+	ctx := context.Background()
+
+	mapds1 := dstest.NewMapDatastoreForTest(t, ktype)
+	mapds2 := dstest.NewMapDatastoreForTest(t, ktype)
+	testErr := errors.New("test error")
+	failds := failstore.NewFailstore(dstest.NewMapDatastoreForTest(t, ktype), func(op string) error {
+		if op == "get" || op == "getsize" {
+			return testErr
+		}
+		return nil
+	})
+	m := mount.New([]mount.Mount{
+		{Prefix: key.NewKeyFromTypeAndString(ktype, "/foo"), Datastore: mapds1},
+		{Prefix: key.NewKeyFromTypeAndString(ktype, "/bar"), Datastore: mapds2},
+		{Prefix: key.NewKeyFromTypeAndString(ktype, "/fail"), Datastore: failds},
+	})
+
+	k1 := key.NewKeyFromTypeAndString(ktype, "/foo/1")
+	k2 := key.NewKeyFromTypeAndString(ktype, "/bar/2")
+	k3 := key.NewKeyFromTypeAndString(ktype, "/fail/3")
+	nomount := key.NewKeyFromTypeAndString(ktype, "/baz/4")
+
+	if err := m.PutMany(ctx, []key.Key{k1, nomount}, [][]byte{[]byte("1"), []byte("4")}); err != mount.ErrNoMount {
+		t.Fatal("expected ErrNoMount, got: ", err)
+	}
+	if has, _ := m.Has(ctx, k1); has {
+		t.Fatal("nothing should be written if a key has no mount")
+	}
+	if err := m.PutMany(ctx, []key.Key{k1, k2, k3}, [][]byte{[]byte("1"), []byte("2"), []byte("3")}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each key must have been written to its mount only.
+	if v, err := mapds1.Get(ctx, key.NewKeyFromTypeAndString(ktype, "/1")); err != nil || string(v) != "1" {
+		t.Fatal("expected /foo/1 in the first datastore: ", err)
+	}
+	if v, err := mapds2.Get(ctx, key.NewKeyFromTypeAndString(ktype, "/2")); err != nil || string(v) != "2" {
+		t.Fatal("expected /bar/2 in the second datastore: ", err)
+	}
+
+	res, err := m.GetMany(ctx, []key.Key{k2, nomount, k3, k1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res[0].Error != nil || string(res[0].Value) != "2" {
+		t.Fatal("unexpected result for /bar/2: ", res[0].Error)
+	}
+	if !datastore.IsNotFound(res[1].Error) {
+		t.Fatal("expected ErrNotFound for key without mount, got: ", res[1].Error)
+	}
+	if !errors.Is(res[2].Error, testErr) {
+		t.Fatal("expected the error of the failing mount, got: ", res[2].Error)
+	}
+	if res[3].Error != nil || string(res[3].Value) != "1" {
+		t.Fatal("unexpected result for /foo/1: ", res[3].Error)
+	}
+
+	sres, err := m.GetSizeMany(ctx, []key.Key{k3, k1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !errors.Is(sres[0].Error, testErr) || sres[0].Size != -1 {
+		t.Fatal("expected the error of the failing mount, got: ", sres[0].Error)
+	}
+	if sres[1].Error != nil || sres[1].Size != 1 {
+		t.Fatal("unexpected result for /foo/1: ", sres[1].Error)
+	}
+
+	hres, err := m.HasMany(ctx, []key.Key{nomount, k1, k3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hres[0].Exists || !hres[1].Exists || !hres[2].Exists {
+		t.Fatalf("unexpected results: %v", hres)
+	}
+}
+
+func TestBulk(t *testing.T) {
+	testBulk(t, key.KeyTypeString)
+	testBulk(t, key.KeyTypeBytes)
+}
+
+func TestCapabilities(t *testing.T) {
+	mapds := dstest.NewMapDatastoreForTest(t, key.KeyTypeString)
+	testds := dstest.NewTestDatastore(key.KeyTypeString, true)
+	m := mount.New([]mount.Mount{
+		{Prefix: key.NewStrKey("/foo"), Datastore: mapds},
+		{Prefix: key.NewStrKey("/bar"), Datastore: testds},
+	})
+	expected := datastore.FeatureBatching | datastore.FeatureCAS | datastore.FeatureBulk |
+		datastore.FeatureChecked | datastore.FeatureScrubbed | datastore.FeatureGC |
+		datastore.FeatureSnapshot
+	if f := datastore.Features(m); f != expected {
+		t.Fatalf("expected features %s, got %s", expected, f)
+	}
+
+	// A mount which isn't Batching makes the whole datastore not Batching.
+	m = mount.New([]mount.Mount{
+		{Prefix: key.NewStrKey("/foo"), Datastore: mapds},
+		{Prefix: key.NewStrKey("/bar"), Datastore: autobatch.NewAutoBatching(mapds, 10)},
+	})
+	if f := datastore.Features(m); f != datastore.FeatureBulk {
+		t.Fatalf("expected features %s, got %s", datastore.FeatureBulk, f)
+	}
+}
+
 func testSuite(t *testing.T, ktype key.KeyType) {
 	mapds0 := dstest.NewMapDatastoreForTest(t, ktype)
 	mapds1 := dstest.NewMapDatastoreForTest(t, ktype)
@@ -1067,6 +1181,117 @@ func testSuite(t *testing.T, ktype key.KeyType) {
 	dstest.SubtestAll(t, ktype, m)
 }
 
+func TestSnapshot(t *testing.T) {
+	ctx := context.Background()
+	foo := dstest.NewMapDatastoreForTest(t, key.KeyTypeString)
+	root := dstest.NewMapDatastoreForTest(t, key.KeyTypeString)
+	m := mount.New([]mount.Mount{
+		{Prefix: key.NewStrKey("/foo"), Datastore: foo},
+		{Prefix: key.NewStrKey("/"), Datastore: root},
+	})
+
+	if err := m.Put(ctx, key.NewStrKey("/foo/a"), []byte("old")); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Put(ctx, key.NewStrKey("/bar"), []byte("old")); err != nil {
+		t.Fatal(err)
+	}
+	s, err := m.Snapshot(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err := m.Put(ctx, key.NewStrKey("/foo/a"), []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Delete(ctx, key.NewStrKey("/bar")); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := s.Query(ctx, query.Query{Orders: []query.Order{query.OrderByKey{}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := res.Rest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Key.String() != "/bar" || entries[1].Key.String() != "/foo/a" {
+		t.Fatalf("unexpected entries: %v", entries)
+	}
+	for _, e := range entries {
+		if string(e.Value) != "old" {
+			t.Fatalf("expected the old value of %s, got %q", e.Key, e.Value)
+		}
+	}
+
+	// All the mounts must support snapshots.
+	m = mount.New([]mount.Mount{
+		{Prefix: key.NewStrKey("/foo"), Datastore: foo},
+		{Prefix: key.NewStrKey("/"), Datastore: datastore.NewLogDatastore(root, "")},
+	})
+	if _, err := m.Snapshot(ctx); !errors.Is(err, datastore.ErrSnapshotUnsupported) {
+		t.Fatal("expected ErrSnapshotUnsupported, got: ", err)
+	}
+	// The shared subtest skips on the wrapped error.
+	t.Run("Subtest", func(t *testing.T) {
+		dstest.SubtestSnapshot(t, key.KeyTypeString, m)
+	})
+}
+
+func TestWatch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	foo := watch.Wrap(dstest.NewMapDatastoreForTest(t, key.KeyTypeString), watch.Options{})
+	root := watch.Wrap(dstest.NewMapDatastoreForTest(t, key.KeyTypeString), watch.Options{})
+	m := mount.New([]mount.Mount{
+		{Prefix: key.NewStrKey("/foo"), Datastore: foo},
+		{Prefix: key.NewStrKey("/"), Datastore: root},
+	})
+	defer m.Close()
+
+	all, err := m.Watch(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub, err := m.Watch(ctx, key.NewStrKey("/foo/bar"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, k := range []string{"/foo/bar/a", "/foo/baz", "/qux"} {
+		if err := m.Put(ctx, key.NewStrKey(k), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	seen := make(map[string]bool)
+	for i := 0; i < 3; i++ {
+		e := <-all
+		seen[e.Key.String()] = true
+	}
+	if !seen["/foo/bar/a"] || !seen["/foo/baz"] || !seen["/qux"] {
+		t.Fatalf("unexpected events: %v", seen)
+	}
+	if e := <-sub; e.Key.String() != "/foo/bar/a" {
+		t.Fatalf("unexpected event %s %s", e.Type, e.Key)
+	}
+
+	cancel()
+	for range all {
+	}
+	for range sub {
+	}
+
+	// All the mounts must support watching.
+	m = mount.New([]mount.Mount{
+		{Prefix: key.NewStrKey("/foo"), Datastore: foo},
+		{Prefix: key.NewStrKey("/"), Datastore: dstest.NewMapDatastoreForTest(t, key.KeyTypeString)},
+	})
+	if _, err := m.Watch(context.Background(), nil); !errors.Is(err, datastore.ErrWatchUnsupported) {
+		t.Fatal("expected ErrWatchUnsupported, got: ", err)
+	}
+}
+
 func TestSuite(t *testing.T) {
 	testSuite(t, key.KeyTypeString)
 	testSuite(t, key.KeyTypeBytes)
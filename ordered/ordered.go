@@ -0,0 +1,301 @@
+// Copyright (c) 2020 DAOT Labs. All rights reserved. Use of this source
+// code is governed by MIT license that can be found in the LICENSE file.
+
+// Package ordered provides an in-memory datastore which keeps its entries
+// sorted by key, so that queries by prefix, range, key order, offset and
+// limit seek directly to the matching entries instead of filtering and
+// sorting all the entries like MapDatastore does.
+package ordered
+
+import (
+	"context"
+	"math/rand"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	ds "github.com/daotl/go-datastore"
+	key "github.com/daotl/go-datastore/key"
+	dsq "github.com/daotl/go-datastore/query"
+)
+
+// Datastore is an in-memory datastore backed by a skiplist sorted by key.
+// Unlike MapDatastore, it is thread-safe.
+type Datastore struct {
+	ktype key.KeyType
+
+	lk   sync.RWMutex
+	list *skiplist
+}
+
+var _ ds.Batching = (*Datastore)(nil)
+
+// New constructs an ordered Datastore for keys of the given type.
+func New(ktype key.KeyType) (*Datastore, error) {
+	var compare func(a, b string) int
+	switch ktype {
+	case key.KeyTypeString:
+		compare = compareStrKeys
+	case key.KeyTypeBytes:
+		compare = strings.Compare
+	default:
+		return nil, key.ErrKeyTypeNotSupported
+	}
+	return &Datastore{
+		ktype: ktype,
+		list:  newSkiplist(compare, rand.NewSource(time.Now().UnixNano())),
+	}, nil
+}
+
+// compareStrKeys compares the string representations of two StrKeys in the
+// same order as StrKey.Less, which compares keys namespace by namespace.
+// That's a bytewise comparison where '/' sorts before any other byte.
+func compareStrKeys(a, b string) int {
+	n := len(a)
+	if len(b) < n {
+		n = len(b)
+	}
+	for i := 0; i < n; i++ {
+		ca, cb := a[i], b[i]
+		if ca == cb {
+			continue
+		}
+		switch {
+		case ca == '/':
+			return -1
+		case cb == '/':
+			return 1
+		case ca < cb:
+			return -1
+		default:
+			return 1
+		}
+	}
+	switch {
+	case len(a) < len(b):
+		return -1
+	case len(a) > len(b):
+		return 1
+	default:
+		return 0
+	}
+}
+
+// Put implements Datastore.Put
+func (d *Datastore) Put(ctx context.Context, key key.Key, value []byte) error {
+	d.lk.Lock()
+	defer d.lk.Unlock()
+	d.list.put(key, key.String(), value)
+	return nil
+}
+
+// Sync implements Datastore.Sync
+func (d *Datastore) Sync(ctx context.Context, prefix key.Key) error {
+	return nil
+}
+
+// Get implements Datastore.Get
+func (d *Datastore) Get(ctx context.Context, key key.Key) (value []byte, err error) {
+	d.lk.RLock()
+	defer d.lk.RUnlock()
+	n := d.list.get(key.String())
+	if n == nil {
+		return nil, ds.ErrNotFound
+	}
+	return n.value, nil
+}
+
+// Has implements Datastore.Has
+func (d *Datastore) Has(ctx context.Context, key key.Key) (exists bool, err error) {
+	d.lk.RLock()
+	defer d.lk.RUnlock()
+	return d.list.get(key.String()) != nil, nil
+}
+
+// GetSize implements Datastore.GetSize
+func (d *Datastore) GetSize(ctx context.Context, key key.Key) (size int, err error) {
+	d.lk.RLock()
+	defer d.lk.RUnlock()
+	n := d.list.get(key.String())
+	if n == nil {
+		return -1, ds.ErrNotFound
+	}
+	return len(n.value), nil
+}
+
+// Delete implements Datastore.Delete
+func (d *Datastore) Delete(ctx context.Context, key key.Key) error {
+	d.lk.Lock()
+	defer d.lk.Unlock()
+	d.list.delete(key.String())
+	return nil
+}
+
+// bounds returns the ranks [lo, hi) of the entries matching the prefix and
+// range of `q`, the caller must hold d.lk.
+func (d *Datastore) bounds(q dsq.Query) (lo, hi int) {
+	lo, hi = 0, d.list.length
+
+	if q.Prefix != nil && q.Prefix.String() != "" {
+		switch d.ktype {
+		case key.KeyTypeString:
+			// Clean the prefix the same way as NaiveQueryApply, descendants of
+			// /foo are all the keys in [/foo/, /foo\x00) as '/' sorts first.
+			prefix := q.Prefix.String()
+			if prefix[0] != '/' {
+				prefix = "/" + prefix
+			}
+			prefix = path.Clean(prefix)
+			if prefix != "/" {
+				lo = d.list.rank(prefix + "/")
+				hi = d.list.rank(prefix + "\x00")
+			}
+		case key.KeyTypeBytes:
+			// The prefix itself is excluded like in FilterKeyPrefix.
+			prefix := q.Prefix.String()
+			lo = d.list.rank(prefix)
+			if n := d.list.byRank(lo); n != nil && n.ks == prefix {
+				lo++
+			}
+			if succ, ok := prefixSuccessor(prefix); ok {
+				hi = d.list.rank(succ)
+			}
+		}
+	}
+
+	if q.Range.Start != nil {
+		if r := d.list.rank(q.Range.Start.String()); r > lo {
+			lo = r
+		}
+	}
+	if q.Range.End != nil {
+		if r := d.list.rank(q.Range.End.String()); r < hi {
+			hi = r
+		}
+	}
+	return lo, hi
+}
+
+// prefixSuccessor returns the smallest string greater than all the strings
+// having the given prefix, ok is false if there is no such string.
+func prefixSuccessor(prefix string) (string, bool) {
+	b := []byte(prefix)
+	for i := len(b) - 1; i >= 0; i-- {
+		if b[i] != 0xff {
+			b[i]++
+			return string(b[:i+1]), true
+		}
+	}
+	return "", false
+}
+
+// nativeOrder reports whether the orders of `q` can be answered by walking
+// the skiplist, and in which direction.
+func nativeOrder(q dsq.Query) (native, descending bool) {
+	if len(q.Orders) == 0 {
+		return true, false
+	}
+	// Keys are unique, so the orders after the first one never apply.
+	switch q.Orders[0].(type) {
+	case dsq.OrderByKey, *dsq.OrderByKey:
+		return true, false
+	case dsq.OrderByKeyDescending, *dsq.OrderByKeyDescending:
+		return true, true
+	}
+	return false, false
+}
+
+func entry(n *node, keysOnly bool) dsq.Entry {
+	e := dsq.Entry{Key: n.key, Size: len(n.value)}
+	if !keysOnly {
+		e.Value = n.value
+	}
+	return e
+}
+
+func filter(filters []dsq.Filter, e dsq.Entry) bool {
+	for _, f := range filters {
+		if !f.Filter(e) {
+			return false
+		}
+	}
+	return true
+}
+
+// Query implements Datastore.Query
+func (d *Datastore) Query(ctx context.Context, q dsq.Query) (dsq.Results, error) {
+	d.lk.RLock()
+	defer d.lk.RUnlock()
+
+	lo, hi := d.bounds(q)
+	if lo >= hi {
+		return dsq.ResultsWithEntries(q, nil), nil
+	}
+
+	native, descending := nativeOrder(q)
+	if !native {
+		// Fall back to sorting in memory, but still only the entries in
+		// bounds.
+		var re []dsq.Entry
+		for n, i := d.list.byRank(lo), lo; i < hi; n, i = n.next[0].node, i+1 {
+			if e := entry(n, q.KeysOnly); filter(q.Filters, e) {
+				re = append(re, e)
+			}
+		}
+		r := dsq.ResultsWithEntries(q, re)
+		return dsq.NaiveQueryApply(dsq.Query{
+			Orders: q.Orders,
+			Offset: q.Offset,
+			Limit:  q.Limit,
+		}, r), nil
+	}
+
+	// Without filters, the offset can be skipped by rank.
+	offset := q.Offset
+	if len(q.Filters) == 0 {
+		if descending {
+			hi -= offset
+		} else {
+			lo += offset
+		}
+		offset = 0
+		if lo >= hi {
+			return dsq.ResultsWithEntries(q, nil), nil
+		}
+	}
+
+	var re []dsq.Entry
+	var n *node
+	if descending {
+		n = d.list.byRank(hi - 1)
+	} else {
+		n = d.list.byRank(lo)
+	}
+	for i := 0; i < hi-lo; i++ {
+		if e := entry(n, q.KeysOnly); filter(q.Filters, e) {
+			if offset > 0 {
+				offset--
+			} else {
+				re = append(re, e)
+				if q.Limit > 0 && len(re) == q.Limit {
+					break
+				}
+			}
+		}
+		if descending {
+			n = n.prev
+		} else {
+			n = n.next[0].node
+		}
+	}
+	return dsq.ResultsWithEntries(q, re), nil
+}
+
+func (d *Datastore) Batch(ctx context.Context) (ds.Batch, error) {
+	return ds.NewBasicBatch(d), nil
+}
+
+func (d *Datastore) Close() error {
+	return nil
+}
@@ -0,0 +1,171 @@
+// Copyright (c) 2020 DAOT Labs. All rights reserved. Use of this source
+// code is governed by MIT license that can be found in the LICENSE file.
+
+package ordered
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"testing"
+
+	key "github.com/daotl/go-datastore/key"
+	dsq "github.com/daotl/go-datastore/query"
+	dstest "github.com/daotl/go-datastore/test"
+)
+
+func newDatastore(t *testing.T, ktype key.KeyType) *Datastore {
+	d, err := New(ktype)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestSuite(t *testing.T) {
+	dstest.SubtestAll(t, key.KeyTypeString, newDatastore(t, key.KeyTypeString))
+	dstest.SubtestAll(t, key.KeyTypeBytes, newDatastore(t, key.KeyTypeBytes))
+}
+
+func TestCompareStrKeys(t *testing.T) {
+	strs := []string{
+		"/", "/a", "/a/b", "/a/b/c", "/a-", "/a-/b", "/a\x00", "/ab", "/ab/a",
+		"/b", "/b/a", "/B", "/a/", "/a/b/",
+	}
+	for _, a := range strs {
+		for _, b := range strs {
+			want := key.Compare(key.QueryStrKey(a), key.QueryStrKey(b))
+			if got := compareStrKeys(a, b); got != want {
+				t.Errorf("compareStrKeys(%q, %q) = %d, want %d", a, b, got, want)
+			}
+		}
+	}
+}
+
+func TestSkiplistRanks(t *testing.T) {
+	l := newSkiplist(strings.Compare, rand.NewSource(42))
+	var strs []string
+	for i := 0; i < 1000; i++ {
+		s := fmt.Sprintf("%08d", rand.Intn(100000))
+		l.put(key.NewBytesKeyFromString(s), s, nil)
+		strs = append(strs, s)
+	}
+	for i := 0; i < 500; i++ {
+		l.delete(strs[rand.Intn(len(strs))])
+	}
+
+	var expected []string
+	for n := l.head.next[0].node; n != nil; n = n.next[0].node {
+		expected = append(expected, n.ks)
+	}
+	if !sort.StringsAreSorted(expected) {
+		t.Fatal("skiplist is not sorted")
+	}
+	if len(expected) != l.length {
+		t.Fatalf("expected length %d, got %d", len(expected), l.length)
+	}
+	for i, s := range expected {
+		if n := l.byRank(i); n == nil || n.ks != s {
+			t.Fatalf("byRank(%d) returned the wrong node", i)
+		}
+		if r := l.rank(s); r != i {
+			t.Fatalf("rank(%q) = %d, want %d", s, r, i)
+		}
+	}
+	for i := len(expected) - 1; i > 0; i-- {
+		if l.byRank(i).prev != l.byRank(i-1) {
+			t.Fatalf("wrong prev link at rank %d", i)
+		}
+	}
+}
+
+func testQueries(t *testing.T, ktype key.KeyType) {
+	ctx := context.Background()
+	d := newDatastore(t, ktype)
+
+	var input []dsq.Entry
+	for i := 0; i < 200; i++ {
+		var s string
+		switch i % 3 {
+		case 0:
+			s = fmt.Sprintf("/%d", i)
+		case 1:
+			s = fmt.Sprintf("/a/%d", i)
+		default:
+			s = fmt.Sprintf("/a/b/%d", i)
+		}
+		k := key.NewKeyFromTypeAndString(ktype, s)
+		v := []byte(fmt.Sprint(rand.Intn(1000)))
+		if err := d.Put(ctx, k, v); err != nil {
+			t.Fatal(err)
+		}
+		input = append(input, dsq.Entry{Key: k, Value: v, Size: len(v)})
+	}
+
+	prefixes := []string{"", "/a", "/a/b", "/a/", "/1", "/z"}
+	ranges := [][2]string{{"", ""}, {"/1", "/5"}, {"/a/1", ""}, {"", "/a/b/5"}, {"/9", "/1"}}
+	orders := [][]dsq.Order{
+		nil,
+		{dsq.OrderByKey{}},
+		{dsq.OrderByKeyDescending{}},
+		{dsq.OrderByValue{}, dsq.OrderByKey{}},
+	}
+	filters := [][]dsq.Filter{
+		nil,
+		{dsq.FilterKeyCompare{Op: dsq.GreaterThan, Key: key.QueryKeyFromTypeAndString(ktype, "/a/4")}},
+	}
+	slices := [][2]int{{0, 0}, {0, 5}, {3, 0}, {7, 10}, {500, 0}}
+
+	for _, p := range prefixes {
+		for _, r := range ranges {
+			for _, o := range orders {
+				for _, f := range filters {
+					for _, s := range slices {
+						q := dsq.Query{Orders: o, Filters: f, Offset: s[0], Limit: s[1]}
+						if p != "" {
+							q.Prefix = key.QueryKeyFromTypeAndString(ktype, p)
+						}
+						if r[0] != "" {
+							q.Range.Start = key.QueryKeyFromTypeAndString(ktype, r[0])
+						}
+						if r[1] != "" {
+							q.Range.End = key.QueryKeyFromTypeAndString(ktype, r[1])
+						}
+
+						res, err := d.Query(ctx, q)
+						if err != nil {
+							t.Fatal(err)
+						}
+						actual, err := res.Rest()
+						if err != nil {
+							t.Fatal(err)
+						}
+						if len(o) == 0 {
+							// The natural order of the datastore is by key.
+							q.Orders = []dsq.Order{dsq.OrderByKey{}}
+						}
+						expected, err := dsq.NaiveQueryApply(q, dsq.ResultsWithEntries(q, input)).Rest()
+						if err != nil {
+							t.Fatal(err)
+						}
+						if len(actual) != len(expected) {
+							t.Fatalf("%s: expected %d results, got %d", q, len(expected), len(actual))
+						}
+						for i := range actual {
+							if !actual[i].Key.Equal(expected[i].Key) {
+								t.Fatalf("%s: result %d: expected key %s, got %s", q, i, expected[i].Key, actual[i].Key)
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestQueries(t *testing.T) {
+	testQueries(t, key.KeyTypeString)
+	testQueries(t, key.KeyTypeBytes)
+}
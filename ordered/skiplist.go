@@ -0,0 +1,184 @@
+// Copyright (c) 2020 DAOT Labs. All rights reserved. Use of this source
+// code is governed by MIT license that can be found in the LICENSE file.
+
+package ordered
+
+import (
+	"math/rand"
+
+	key "github.com/daotl/go-datastore/key"
+)
+
+const (
+	maxLevel = 32
+	// p = 1/4, as in Redis' zset.
+	levelFactor = 4
+)
+
+// link is a forward pointer of a node at some level, span is the number of
+// level 0 links it skips over, which makes it possible to seek by rank.
+type link struct {
+	node *node
+	span int
+}
+
+type node struct {
+	ks    string
+	key   key.Key
+	value []byte
+	next  []link
+	prev  *node
+}
+
+// skiplist is an indexable skiplist sorted by a compare function over the
+// string representation of keys.
+type skiplist struct {
+	compare func(a, b string) int
+	rand    *rand.Rand
+
+	head   *node
+	tail   *node
+	level  int
+	length int
+}
+
+func newSkiplist(compare func(a, b string) int, src rand.Source) *skiplist {
+	return &skiplist{
+		compare: compare,
+		rand:    rand.New(src),
+		head:    &node{next: make([]link, maxLevel)},
+		level:   1,
+	}
+}
+
+func (l *skiplist) randomLevel() int {
+	lvl := 1
+	for lvl < maxLevel && l.rand.Intn(levelFactor) == 0 {
+		lvl++
+	}
+	return lvl
+}
+
+// find returns, for each level, the last node whose key is less than `ks`
+// and its rank.
+func (l *skiplist) find(ks string) (update [maxLevel]*node, rank [maxLevel]int) {
+	x := l.head
+	for i := l.level - 1; i >= 0; i-- {
+		if i < l.level-1 {
+			rank[i] = rank[i+1]
+		}
+		for x.next[i].node != nil && l.compare(x.next[i].node.ks, ks) < 0 {
+			rank[i] += x.next[i].span
+			x = x.next[i].node
+		}
+		update[i] = x
+	}
+	return update, rank
+}
+
+// get returns the node named by `ks`, or nil if there is none.
+func (l *skiplist) get(ks string) *node {
+	update, _ := l.find(ks)
+	x := update[0].next[0].node
+	if x != nil && l.compare(x.ks, ks) == 0 {
+		return x
+	}
+	return nil
+}
+
+// put inserts or updates the node named by `k`.
+func (l *skiplist) put(k key.Key, ks string, value []byte) {
+	update, rank := l.find(ks)
+	if x := update[0].next[0].node; x != nil && l.compare(x.ks, ks) == 0 {
+		x.key = k
+		x.value = value
+		return
+	}
+
+	lvl := l.randomLevel()
+	if lvl > l.level {
+		for i := l.level; i < lvl; i++ {
+			rank[i] = 0
+			update[i] = l.head
+			update[i].next[i].span = l.length
+		}
+		l.level = lvl
+	}
+
+	x := &node{ks: ks, key: k, value: value, next: make([]link, lvl)}
+	for i := 0; i < lvl; i++ {
+		x.next[i].node = update[i].next[i].node
+		update[i].next[i].node = x
+
+		x.next[i].span = update[i].next[i].span - (rank[0] - rank[i])
+		update[i].next[i].span = rank[0] - rank[i] + 1
+	}
+	for i := lvl; i < l.level; i++ {
+		update[i].next[i].span++
+	}
+
+	if update[0] != l.head {
+		x.prev = update[0]
+	}
+	if x.next[0].node != nil {
+		x.next[0].node.prev = x
+	} else {
+		l.tail = x
+	}
+	l.length++
+}
+
+// delete removes the node named by `ks` if any.
+func (l *skiplist) delete(ks string) {
+	update, _ := l.find(ks)
+	x := update[0].next[0].node
+	if x == nil || l.compare(x.ks, ks) != 0 {
+		return
+	}
+
+	for i := 0; i < l.level; i++ {
+		if update[i].next[i].node == x {
+			update[i].next[i].span += x.next[i].span - 1
+			update[i].next[i].node = x.next[i].node
+		} else {
+			update[i].next[i].span--
+		}
+	}
+	if x.next[0].node != nil {
+		x.next[0].node.prev = x.prev
+	} else {
+		l.tail = x.prev
+	}
+	for l.level > 1 && l.head.next[l.level-1].node == nil {
+		l.level--
+	}
+	l.length--
+}
+
+// rank returns the 0-based rank of the first node whose key is not less
+// than `ks`, it's l.length if there is none.
+func (l *skiplist) rank(ks string) int {
+	_, rank := l.find(ks)
+	return rank[0]
+}
+
+// byRank returns the node at the given 0-based rank.
+func (l *skiplist) byRank(r int) *node {
+	if r < 0 || r >= l.length {
+		return nil
+	}
+	// Ranks are 1-based while traversing, as the head has rank 0.
+	r++
+	traversed := 0
+	x := l.head
+	for i := l.level - 1; i >= 0; i-- {
+		for x.next[i].node != nil && traversed+x.next[i].span <= r {
+			traversed += x.next[i].span
+			x = x.next[i].node
+		}
+		if traversed == r {
+			return x
+		}
+	}
+	return nil
+}
@@ -0,0 +1,249 @@
+// Copyright (c) 2020 DAOT Labs. All rights reserved. Use of this source
+// code is governed by MIT license that can be found in the LICENSE file.
+
+// Package overlay provides a copy-on-write datastore which stacks a
+// writable upper datastore over a read-only lower one, for dry-runs and
+// tests. The writes can then be committed to the lower datastore or
+// discarded.
+package overlay
+
+import (
+	"context"
+	"errors"
+
+	"go.uber.org/multierr"
+
+	ds "github.com/daotl/go-datastore"
+	key "github.com/daotl/go-datastore/key"
+	dsq "github.com/daotl/go-datastore/query"
+)
+
+// ErrCorruptValue is returned when a value of the upper datastore is not a
+// valid overlay value.
+var ErrCorruptValue = errors.New("overlay: corrupt value")
+
+// The markers prefixing the values of the upper datastore.
+const (
+	markerValue     byte = 0
+	markerTombstone byte = 1
+)
+
+// Datastore is a copy-on-write overlay. Writes and deletes land in the upper
+// datastore, deletes as tombstones, and reads present the merged view of
+// both datastores. The lower datastore is only written by Commit.
+//
+// The values of the upper datastore are prefixed with a marker byte to tell
+// tombstones apart, so it should only be used by the overlay.
+//
+// Commit and Discard must not be called concurrently with writes.
+type Datastore struct {
+	lower ds.Datastore
+	upper ds.Datastore
+}
+
+var _ ds.Batching = (*Datastore)(nil)
+var _ ds.Shim = (*Datastore)(nil)
+
+// New creates an overlay of `upper` over `lower`, `upper` should be empty
+// or hold the writes of a previous overlay of `lower`.
+func New(lower, upper ds.Datastore) *Datastore {
+	if lower == nil || upper == nil {
+		panic("lower or upper (ds.Datastore) is nil")
+	}
+	return &Datastore{lower: lower, upper: upper}
+}
+
+func encode(value []byte) []byte {
+	b := make([]byte, 1+len(value))
+	b[0] = markerValue
+	copy(b[1:], value)
+	return b
+}
+
+var tombstone = []byte{markerTombstone}
+
+// decode decodes a value of the upper datastore, and returns whether it's a
+// tombstone.
+func decode(b []byte) ([]byte, bool, error) {
+	if len(b) == 0 || b[0] > markerTombstone {
+		return nil, false, ErrCorruptValue
+	}
+	return b[1:], b[0] == markerTombstone, nil
+}
+
+// Children implements Shim
+func (d *Datastore) Children() []ds.Datastore {
+	return []ds.Datastore{d.upper, d.lower}
+}
+
+// Capabilities implements Capable
+func (d *Datastore) Capabilities() ds.Feature {
+	return ds.FeatureBatching
+}
+
+// Get implements Datastore.Get
+func (d *Datastore) Get(ctx context.Context, key key.Key) (value []byte, err error) {
+	raw, err := d.upper.Get(ctx, key)
+	switch {
+	case ds.IsNotFound(err):
+		return d.lower.Get(ctx, key)
+	case err != nil:
+		return nil, err
+	}
+	value, deleted, err := decode(raw)
+	if err != nil {
+		return nil, err
+	}
+	if deleted {
+		return nil, ds.ErrNotFound
+	}
+	return value, nil
+}
+
+// Has implements Datastore.Has
+func (d *Datastore) Has(ctx context.Context, key key.Key) (exists bool, err error) {
+	raw, err := d.upper.Get(ctx, key)
+	switch {
+	case ds.IsNotFound(err):
+		return d.lower.Has(ctx, key)
+	case err != nil:
+		return false, err
+	}
+	_, deleted, err := decode(raw)
+	return !deleted && err == nil, err
+}
+
+// GetSize implements Datastore.GetSize
+func (d *Datastore) GetSize(ctx context.Context, key key.Key) (size int, err error) {
+	raw, err := d.upper.Get(ctx, key)
+	switch {
+	case ds.IsNotFound(err):
+		return d.lower.GetSize(ctx, key)
+	case err != nil:
+		return -1, err
+	}
+	value, deleted, err := decode(raw)
+	if err != nil {
+		return -1, err
+	}
+	if deleted {
+		return -1, ds.ErrNotFound
+	}
+	return len(value), nil
+}
+
+// Put implements Datastore.Put
+func (d *Datastore) Put(ctx context.Context, key key.Key, value []byte) error {
+	return d.upper.Put(ctx, key, encode(value))
+}
+
+// Delete implements Datastore.Delete by writing a tombstone.
+func (d *Datastore) Delete(ctx context.Context, key key.Key) error {
+	return d.upper.Put(ctx, key, tombstone)
+}
+
+// Sync implements Datastore.Sync by syncing the upper datastore.
+func (d *Datastore) Sync(ctx context.Context, prefix key.Key) error {
+	return d.upper.Sync(ctx, prefix)
+}
+
+// Close closes both datastores.
+func (d *Datastore) Close() error {
+	return multierr.Append(d.upper.Close(), d.lower.Close())
+}
+
+// Batch implements Batching.Batch, the writes are batched in the upper
+// datastore, with ds.NewBasicBatch if it isn't Batching.
+func (d *Datastore) Batch(ctx context.Context) (ds.Batch, error) {
+	b, err := batch(ctx, d.upper)
+	if err != nil {
+		return nil, err
+	}
+	return &overlayBatch{child: b}, nil
+}
+
+func batch(ctx context.Context, d ds.Datastore) (ds.Batch, error) {
+	if bds, ok := d.(ds.Batching); ok {
+		return bds.Batch(ctx)
+	}
+	return ds.NewBasicBatch(d), nil
+}
+
+type overlayBatch struct {
+	child ds.Batch
+}
+
+func (b *overlayBatch) Put(ctx context.Context, key key.Key, value []byte) error {
+	return b.child.Put(ctx, key, encode(value))
+}
+
+func (b *overlayBatch) Delete(ctx context.Context, key key.Key) error {
+	return b.child.Put(ctx, key, tombstone)
+}
+
+func (b *overlayBatch) Commit(ctx context.Context) error {
+	return b.child.Commit(ctx)
+}
+
+// upperEntries returns all the entries of the upper datastore.
+func (d *Datastore) upperEntries(ctx context.Context) ([]dsq.Entry, error) {
+	res, err := d.upper.Query(ctx, dsq.Query{})
+	if err != nil {
+		return nil, err
+	}
+	return res.Rest()
+}
+
+// Commit applies the writes and deletes of the overlay to the lower
+// datastore, in a batch if it's Batching, then clears the upper datastore.
+func (d *Datastore) Commit(ctx context.Context) error {
+	es, err := d.upperEntries(ctx)
+	if err != nil {
+		return err
+	}
+	b, err := batch(ctx, d.lower)
+	if err != nil {
+		return err
+	}
+	for _, e := range es {
+		value, deleted, err := decode(e.Value)
+		if err != nil {
+			return err
+		}
+		if deleted {
+			err = b.Delete(ctx, e.Key)
+		} else {
+			err = b.Put(ctx, e.Key, value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := b.Commit(ctx); err != nil {
+		return err
+	}
+	return d.clear(ctx, es)
+}
+
+// Discard drops the writes and deletes of the overlay by clearing the upper
+// datastore.
+func (d *Datastore) Discard(ctx context.Context) error {
+	es, err := d.upperEntries(ctx)
+	if err != nil {
+		return err
+	}
+	return d.clear(ctx, es)
+}
+
+func (d *Datastore) clear(ctx context.Context, es []dsq.Entry) error {
+	b, err := batch(ctx, d.upper)
+	if err != nil {
+		return err
+	}
+	for _, e := range es {
+		if err := b.Delete(ctx, e.Key); err != nil {
+			return err
+		}
+	}
+	return b.Commit(ctx)
+}
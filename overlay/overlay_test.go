@@ -0,0 +1,155 @@
+// Copyright (c) 2020 DAOT Labs. All rights reserved. Use of this source
+// code is governed by MIT license that can be found in the LICENSE file.
+
+package overlay
+
+import (
+	"context"
+	"testing"
+
+	ds "github.com/daotl/go-datastore"
+	key "github.com/daotl/go-datastore/key"
+	dsq "github.com/daotl/go-datastore/query"
+	"github.com/daotl/go-datastore/readonly"
+	dstest "github.com/daotl/go-datastore/test"
+)
+
+func testSuite(t *testing.T, ktype key.KeyType) {
+	d := New(dstest.NewMapDatastoreForTest(t, ktype), dstest.NewMapDatastoreForTest(t, ktype))
+	dstest.SubtestAll(t, ktype, d)
+}
+
+func TestSuite(t *testing.T) {
+	testSuite(t, key.KeyTypeString)
+	testSuite(t, key.KeyTypeBytes)
+}
+
+// newOverlay returns an overlay over a lower datastore with /a, /b and /c.
+func newOverlay(t *testing.T) (*Datastore, *ds.MapDatastore) {
+	ctx := context.Background()
+	lower := dstest.NewMapDatastoreForTest(t, key.KeyTypeString)
+	for _, k := range []string{"/a", "/b", "/c"} {
+		lower.Put(ctx, key.NewStrKey(k), []byte("lower"+k))
+	}
+	d := New(lower, dstest.NewMapDatastoreForTest(t, key.KeyTypeString))
+	d.Put(ctx, key.NewStrKey("/b"), []byte("upper/b"))
+	d.Put(ctx, key.NewStrKey("/d"), []byte("upper/d"))
+	d.Delete(ctx, key.NewStrKey("/c"))
+	return d, lower
+}
+
+func entries(t *testing.T, d ds.Read, q dsq.Query) []dsq.Entry {
+	t.Helper()
+	res, err := d.Query(context.Background(), q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	es, err := res.Rest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return es
+}
+
+func expectEntries(t *testing.T, es []dsq.Entry, expected ...string) {
+	t.Helper()
+	if len(es) != len(expected)/2 {
+		t.Fatalf("expected %d entries, got %v", len(expected)/2, es)
+	}
+	for i, e := range es {
+		if e.Key.String() != expected[2*i] || string(e.Value) != expected[2*i+1] {
+			t.Fatalf("expected %s=%s at %d, got %s=%s", expected[2*i], expected[2*i+1], i, e.Key, e.Value)
+		}
+	}
+}
+
+func TestMergedView(t *testing.T) {
+	ctx := context.Background()
+	d, lower := newOverlay(t)
+
+	for k, expected := range map[string]string{"/a": "lower/a", "/b": "upper/b", "/d": "upper/d"} {
+		if v, err := d.Get(ctx, key.NewStrKey(k)); err != nil || string(v) != expected {
+			t.Fatalf("unexpected value %q for %s, error: %v", v, k, err)
+		}
+	}
+	if _, err := d.Get(ctx, key.NewStrKey("/c")); !ds.IsNotFound(err) {
+		t.Fatal("expected ErrNotFound, got: ", err)
+	}
+	if has, _ := d.Has(ctx, key.NewStrKey("/c")); has {
+		t.Fatal("expected /c to be deleted")
+	}
+	if size, err := d.GetSize(ctx, key.NewStrKey("/b")); err != nil || size != 7 {
+		t.Fatalf("unexpected size %d, error: %v", size, err)
+	}
+
+	// The lower datastore is untouched.
+	if v, _ := lower.Get(ctx, key.NewStrKey("/b")); string(v) != "lower/b" {
+		t.Fatalf("unexpected value %q", v)
+	}
+	if has, _ := lower.Has(ctx, key.NewStrKey("/c")); !has {
+		t.Fatal("expected /c to stay in the lower datastore")
+	}
+}
+
+func TestQuery(t *testing.T) {
+	d, _ := newOverlay(t)
+	expectEntries(t, entries(t, d, dsq.Query{}),
+		"/a", "lower/a", "/b", "upper/b", "/d", "upper/d")
+	expectEntries(t, entries(t, d, dsq.Query{Orders: []dsq.Order{dsq.OrderByKeyDescending{}}, Limit: 2}),
+		"/d", "upper/d", "/b", "upper/b")
+	expectEntries(t, entries(t, d, dsq.Query{Orders: []dsq.Order{dsq.OrderByValue{}}, Offset: 1}),
+		"/b", "upper/b", "/d", "upper/d")
+
+	es := entries(t, d, dsq.Query{KeysOnly: true, ReturnsSizes: true})
+	if len(es) != 3 || es[1].Value != nil || es[1].Size != 7 {
+		t.Fatalf("unexpected entries: %v", es)
+	}
+}
+
+func TestCommit(t *testing.T) {
+	ctx := context.Background()
+	d, lower := newOverlay(t)
+	if err := d.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+	expectEntries(t, entries(t, lower, dsq.Query{Orders: []dsq.Order{dsq.OrderByKey{}}}),
+		"/a", "lower/a", "/b", "upper/b", "/d", "upper/d")
+	if es := entries(t, d.upper, dsq.Query{}); len(es) != 0 {
+		t.Fatal("expected the upper datastore to be cleared")
+	}
+	expectEntries(t, entries(t, d, dsq.Query{}),
+		"/a", "lower/a", "/b", "upper/b", "/d", "upper/d")
+}
+
+func TestDiscard(t *testing.T) {
+	ctx := context.Background()
+	d, lower := newOverlay(t)
+	if err := d.Discard(ctx); err != nil {
+		t.Fatal(err)
+	}
+	expectEntries(t, entries(t, d, dsq.Query{}),
+		"/a", "lower/a", "/b", "lower/b", "/c", "lower/c")
+	expectEntries(t, entries(t, lower, dsq.Query{Orders: []dsq.Order{dsq.OrderByKey{}}}),
+		"/a", "lower/a", "/b", "lower/b", "/c", "lower/c")
+}
+
+func TestReadOnlyLower(t *testing.T) {
+	ctx := context.Background()
+	lower := dstest.NewMapDatastoreForTest(t, key.KeyTypeString)
+	d := New(readonly.Wrap(lower), dstest.NewMapDatastoreForTest(t, key.KeyTypeString))
+
+	b, err := d.Batch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Put(ctx, key.NewStrKey("/a"), []byte("a"))
+	if err := b.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := d.Get(ctx, key.NewStrKey("/a")); err != nil || string(v) != "a" {
+		t.Fatalf("unexpected value %q, error: %v", v, err)
+	}
+	if err := d.Commit(ctx); !ds.IsReadOnly(err) {
+		t.Fatal("expected ErrReadOnly committing to a read-only datastore, got: ", err)
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright (c) 2020 DAOT Labs. All rights reserved. Use of this source
+// code is governed by MIT license that can be found in the LICENSE file.
+
+package overlay
+
+import (
+	"context"
+
+	dsq "github.com/daotl/go-datastore/query"
+)
+
+// The layers of the results, the upper layer shadows the lower one.
+const (
+	upperLayer = iota
+	lowerLayer
+)
+
+// Query implements Datastore.Query by querying both datastores ordered by
+// key and merging the results, the filters, orders, offset and limit are
+// applied to the merged results.
+func (d *Datastore) Query(ctx context.Context, master dsq.Query) (dsq.Results, error) {
+	lowerQuery := dsq.Query{
+		Prefix:            master.Prefix,
+		Range:             master.Range,
+		Orders:            []dsq.Order{dsq.OrderByKey{}},
+		KeysOnly:          master.KeysOnly,
+		ReturnExpirations: master.ReturnExpirations,
+		ReturnsSizes:      master.ReturnsSizes,
+	}
+	// The values of the upper datastore tell the tombstones apart.
+	upperQuery := lowerQuery
+	upperQuery.KeysOnly = false
+	upperQuery.ReturnsSizes = false
+
+	upper, err := d.upper.Query(ctx, upperQuery)
+	if err != nil {
+		return nil, err
+	}
+	lower, err := d.lower.Query(ctx, lowerQuery)
+	if err != nil {
+		_ = upper.Close()
+		return nil, err
+	}
+
+	return dsq.Merge(master, []dsq.Results{upperLayer: upper, lowerLayer: lower}, dsq.MergeOptions{
+		// The upper entries shadow the lower ones.
+		Dedup: true,
+		Map: func(layer int, r dsq.Result) (dsq.Result, bool) {
+			if layer == lowerLayer {
+				return r, true
+			}
+			value, deleted, err := decode(r.Value)
+			if err != nil {
+				return dsq.Result{Error: err}, true
+			}
+			if deleted {
+				return r, false
+			}
+			r.Size = len(value)
+			r.Value = value
+			if master.KeysOnly {
+				r.Value = nil
+			}
+			return r, true
+		},
+	}), nil
+}
@@ -0,0 +1,204 @@
+// Copyright (c) 2020 DAOT Labs. All rights reserved. Use of this source
+// code is governed by MIT license that can be found in the LICENSE file.
+
+package query
+
+import (
+	"container/heap"
+)
+
+// MergeOptions are the options of Merge.
+type MergeOptions struct {
+	// QueryOrdered means the results of the sources are sorted by the orders
+	// of the query. Otherwise they must be sorted by key, and the orders of
+	// the query are applied to the merged results.
+	QueryOrdered bool
+
+	// Dedup, if set, returns the entries found in several sources once,
+	// from the source which comes first. It requires the sources to be
+	// sorted by key.
+	Dedup bool
+
+	// Map, if set, is applied to the merged results with the index of their
+	// source, after Dedup. The result is skipped if it returns false.
+	Map func(source int, r Result) (Result, bool)
+}
+
+// Merge merges the results of several sources of a query into one, sorted
+// by the orders of the query or by key, see MergeOptions.QueryOrdered.
+// Entries which sort equally are returned in the order of their sources.
+// The filters, offset and limit of the query are applied to the merged
+// results, its prefix and range are left to the sources.
+//
+// The merged results close the sources when they're closed.
+func Merge(q Query, sources []Results, opts MergeOptions) Results {
+	m := &mergeSet{opts: opts, heads: make([]*mergeResults, 0, len(sources))}
+	if opts.QueryOrdered {
+		m.orders = q.Orders
+	}
+	for i, r := range sources {
+		m.addResults(i, r)
+	}
+
+	qr := ResultsFromIterator(q, Iterator{
+		Next:  m.next,
+		Close: m.close,
+	})
+
+	for _, f := range q.Filters {
+		qr = NaiveFilter(qr, f)
+	}
+	if !opts.QueryOrdered && !orderedByKey(q.Orders) {
+		qr = NaiveOrder(qr, q.Orders...)
+	}
+	if q.Offset > 0 {
+		qr = NaiveOffset(qr, q.Offset)
+	}
+	if q.Limit > 0 {
+		qr = NaiveLimit(qr, q.Limit)
+	}
+	return qr
+}
+
+// orderedByKey returns whether results ordered by key satisfy `orders`.
+func orderedByKey(orders []Order) bool {
+	if len(orders) == 0 {
+		return true
+	}
+	switch orders[0].(type) {
+	case OrderByKey, *OrderByKey:
+		return true
+	}
+	return false
+}
+
+type mergeResults struct {
+	source  int
+	results Results
+	next    Result
+}
+
+func (mr *mergeResults) advance() bool {
+	if mr.results == nil {
+		return false
+	}
+
+	mr.next = Result{}
+	r, more := mr.results.NextSync()
+	if !more {
+		err := mr.results.Close()
+		mr.results = nil
+		if err != nil {
+			// One more result, the error.
+			mr.next = Result{Error: err}
+			return true
+		}
+		return false
+	}
+
+	mr.next = r
+	return true
+}
+
+// mergeSet is a heap of the heads of the results being merged.
+type mergeSet struct {
+	opts   MergeOptions
+	orders []Order
+	heads  []*mergeResults
+}
+
+func (h *mergeSet) Len() int {
+	return len(h.heads)
+}
+
+func (h *mergeSet) Less(i, j int) bool {
+	a, b := h.heads[i], h.heads[j]
+	// Errors first.
+	switch {
+	case a.next.Error != nil || b.next.Error != nil:
+		return a.next.Error != nil && b.next.Error == nil
+	case Less(h.orders, a.next.Entry, b.next.Entry):
+		return true
+	case Less(h.orders, b.next.Entry, a.next.Entry):
+		return false
+	}
+	return a.source < b.source
+}
+
+func (h *mergeSet) Swap(i, j int) {
+	h.heads[i], h.heads[j] = h.heads[j], h.heads[i]
+}
+
+func (h *mergeSet) Push(x interface{}) {
+	h.heads = append(h.heads, x.(*mergeResults))
+}
+
+func (h *mergeSet) Pop() interface{} {
+	i := len(h.heads) - 1
+	last := h.heads[i]
+	h.heads[i] = nil
+	h.heads = h.heads[:i]
+	return last
+}
+
+func (h *mergeSet) close() error {
+	var errs []error
+	for _, mr := range h.heads {
+		if mr.results == nil {
+			continue
+		}
+		if err := mr.results.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	h.heads = nil
+	if len(errs) > 0 {
+		return errs[0]
+	}
+	return nil
+}
+
+func (h *mergeSet) addResults(source int, results Results) {
+	r := &mergeResults{
+		source:  source,
+		results: results,
+	}
+	if r.advance() {
+		heap.Push(h, r)
+	}
+}
+
+// pop returns the head result, its source, and advances its results.
+func (h *mergeSet) pop() (Result, int) {
+	head := h.heads[0]
+	next, source := head.next, head.source
+	if head.advance() {
+		heap.Fix(h, 0)
+	} else {
+		heap.Remove(h, 0)
+	}
+	return next, source
+}
+
+func (h *mergeSet) next() (Result, bool) {
+	for len(h.heads) > 0 {
+		next, source := h.pop()
+		if next.Error != nil {
+			return next, true
+		}
+		if h.opts.Dedup {
+			// Skip the copies of the entry in the next sources.
+			for len(h.heads) > 0 && h.heads[0].next.Error == nil &&
+				h.heads[0].next.Key.Equal(next.Key) {
+				h.pop()
+			}
+		}
+		if h.opts.Map == nil {
+			return next, true
+		}
+		if r, ok := h.opts.Map(source, next); ok {
+			return r, true
+		}
+	}
+	return Result{}, false
+}
@@ -0,0 +1,89 @@
+// Copyright (c) 2020 DAOT Labs. All rights reserved. Use of this source
+// code is governed by MIT license that can be found in the LICENSE file.
+
+package query
+
+import (
+	"errors"
+	"testing"
+
+	key "github.com/daotl/go-datastore/key"
+)
+
+// sortedResults returns results with the given keys and values, in order.
+func sortedResults(kvs ...string) Results {
+	es := make([]Entry, 0, len(kvs)/2)
+	for i := 0; i < len(kvs); i += 2 {
+		es = append(es, Entry{Key: key.NewStrKey(kvs[i]), Value: []byte(kvs[i+1])})
+	}
+	return ResultsWithEntries(Query{}, es)
+}
+
+func expectEntries(t *testing.T, res Results, kvs ...string) {
+	t.Helper()
+	es, err := res.Rest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(es) != len(kvs)/2 {
+		t.Fatalf("expected %d entries, got %v", len(kvs)/2, es)
+	}
+	for i, e := range es {
+		if e.Key.String() != kvs[2*i] || string(e.Value) != kvs[2*i+1] {
+			t.Fatalf("expected %s=%s at %d, got %s=%s", kvs[2*i], kvs[2*i+1], i, e.Key, e.Value)
+		}
+	}
+}
+
+func TestMerge(t *testing.T) {
+	sources := func() []Results {
+		return []Results{
+			sortedResults("/b", "0", "/d", "0"),
+			sortedResults("/a", "1", "/b", "1", "/c", "1"),
+		}
+	}
+
+	expectEntries(t, Merge(Query{}, sources(), MergeOptions{}),
+		"/a", "1", "/b", "0", "/b", "1", "/c", "1", "/d", "0")
+	expectEntries(t, Merge(Query{}, sources(), MergeOptions{Dedup: true}),
+		"/a", "1", "/b", "0", "/c", "1", "/d", "0")
+
+	// Map can skip and change the results.
+	expectEntries(t, Merge(Query{}, sources(), MergeOptions{
+		Dedup: true,
+		Map: func(source int, r Result) (Result, bool) {
+			r.Value = append(r.Value, '!')
+			return r, source == 1
+		},
+	}), "/a", "1!", "/c", "1!")
+
+	// The query is applied to the merged results.
+	expectEntries(t, Merge(Query{
+		Filters: []Filter{FilterKeyCompare{Op: NotEqual, Key: key.NewStrKey("/c")}},
+		Orders:  []Order{OrderByKeyDescending{}},
+		Offset:  1,
+		Limit:   2,
+	}, sources(), MergeOptions{Dedup: true}), "/b", "0", "/a", "1")
+
+	// Sources sorted by the orders of the query.
+	expectEntries(t, Merge(Query{Orders: []Order{OrderByValue{}}}, []Results{
+		sortedResults("/b", "1", "/a", "3"),
+		sortedResults("/c", "2"),
+	}, MergeOptions{QueryOrdered: true}), "/b", "1", "/c", "2", "/a", "3")
+}
+
+func TestMergeErrors(t *testing.T) {
+	errFail := errors.New("fail")
+	failing := ResultsFromIterator(Query{}, Iterator{
+		Next: func() (Result, bool) {
+			return Result{}, false
+		},
+		Close: func() error {
+			return errFail
+		},
+	})
+	res := Merge(Query{}, []Results{sortedResults("/a", "0"), failing}, MergeOptions{})
+	if _, err := res.Rest(); err != errFail {
+		t.Fatal("expected the error closing a source, got: ", err)
+	}
+}
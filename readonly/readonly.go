@@ -0,0 +1,385 @@
+// Copyright (c) 2020 DAOT Labs. All rights reserved. Use of this source
+// code is governed by MIT license that can be found in the LICENSE file.
+
+// Package readonly provides a datastore wrapper which rejects writes to the
+// whole datastore or to some of its prefixes, for example to mount a
+// shipped seed database in a mount.Datastore.
+package readonly
+
+import (
+	"context"
+	"io"
+	"time"
+
+	ds "github.com/daotl/go-datastore"
+	key "github.com/daotl/go-datastore/key"
+	dsq "github.com/daotl/go-datastore/query"
+)
+
+// Datastore wraps a datastore and fails the writes to read-only keys with
+// ds.ErrReadOnly. Reads, queries, snapshots, watches and DiskUsage and Check
+// pass through.
+type Datastore struct {
+	child ds.Datastore
+
+	// prefixes are the read-only prefixes, the whole datastore is
+	// read-only if it's nil.
+	prefixes []key.Key
+}
+
+var _ ds.Batching = (*Datastore)(nil)
+var _ ds.TxnDatastore = (*Datastore)(nil)
+var _ ds.TTLDatastore = (*Datastore)(nil)
+var _ ds.PersistentDatastore = (*Datastore)(nil)
+var _ ds.CheckedDatastore = (*Datastore)(nil)
+var _ ds.ScrubbedDatastore = (*Datastore)(nil)
+var _ ds.GCDatastore = (*Datastore)(nil)
+var _ ds.StreamingDatastore = (*Datastore)(nil)
+var _ ds.CASDatastore = (*Datastore)(nil)
+var _ ds.BulkDatastore = (*Datastore)(nil)
+var _ ds.SnapshotDatastore = (*Datastore)(nil)
+var _ ds.WatchDatastore = (*Datastore)(nil)
+var _ ds.Shim = (*Datastore)(nil)
+
+// Wrap wraps the given datastore to make it read-only.
+func Wrap(child ds.Datastore) *Datastore {
+	if child == nil {
+		panic("child (ds.Datastore) is nil")
+	}
+	return &Datastore{child: child}
+}
+
+// WrapPrefixes wraps the given datastore to make the given prefixes, and
+// the keys under them, read-only. The rest of the datastore stays writable.
+func WrapPrefixes(child ds.Datastore, prefixes ...key.Key) *Datastore {
+	if child == nil {
+		panic("child (ds.Datastore) is nil")
+	}
+	ps := make([]key.Key, 0, len(prefixes))
+	for _, p := range prefixes {
+		ps = append(ps, key.Clean(p))
+	}
+	return &Datastore{child: child, prefixes: ps}
+}
+
+// ReadOnly returns whether writes to `k` are rejected.
+func (d *Datastore) ReadOnly(k key.Key) bool {
+	if d.prefixes == nil {
+		return true
+	}
+	for _, p := range d.prefixes {
+		if p.Equal(k) || p.IsAncestorOf(k) {
+			return true
+		}
+	}
+	return false
+}
+
+// check returns ds.ErrReadOnly if any of the keys is read-only.
+func (d *Datastore) check(keys ...key.Key) error {
+	for _, k := range keys {
+		if d.ReadOnly(k) {
+			return ds.ErrReadOnly
+		}
+	}
+	return nil
+}
+
+// Children implements Shim
+func (d *Datastore) Children() []ds.Datastore {
+	return []ds.Datastore{d.child}
+}
+
+// Capabilities implements Capable
+func (d *Datastore) Capabilities() ds.Feature {
+	f := ds.Features(d.child)&(ds.FeatureBatching|ds.FeatureTxn|ds.FeatureTTL|
+		ds.FeatureMaintenance|ds.FeatureStreaming|ds.FeatureBulk|ds.FeatureSnapshot|
+		ds.FeatureWatch) | ds.CASFeature(d.child)
+	if d.prefixes == nil {
+		// Nothing can be compare-and-swapped, scrubbed or collected.
+		f &^= ds.FeatureCAS | ds.FeatureScrubbed | ds.FeatureGC
+	}
+	return f
+}
+
+// Get implements Datastore.Get
+func (d *Datastore) Get(ctx context.Context, key key.Key) (value []byte, err error) {
+	return d.child.Get(ctx, key)
+}
+
+// Has implements Datastore.Has
+func (d *Datastore) Has(ctx context.Context, key key.Key) (exists bool, err error) {
+	return d.child.Has(ctx, key)
+}
+
+// GetSize implements Datastore.GetSize
+func (d *Datastore) GetSize(ctx context.Context, key key.Key) (size int, err error) {
+	return d.child.GetSize(ctx, key)
+}
+
+// Query implements Datastore.Query
+func (d *Datastore) Query(ctx context.Context, q dsq.Query) (dsq.Results, error) {
+	return d.child.Query(ctx, q)
+}
+
+// Put implements Datastore.Put
+func (d *Datastore) Put(ctx context.Context, key key.Key, value []byte) error {
+	if err := d.check(key); err != nil {
+		return err
+	}
+	return d.child.Put(ctx, key, value)
+}
+
+// Delete implements Datastore.Delete
+func (d *Datastore) Delete(ctx context.Context, key key.Key) error {
+	if err := d.check(key); err != nil {
+		return err
+	}
+	return d.child.Delete(ctx, key)
+}
+
+// Sync implements Datastore.Sync
+func (d *Datastore) Sync(ctx context.Context, prefix key.Key) error {
+	return d.child.Sync(ctx, prefix)
+}
+
+// Close implements Datastore.Close
+func (d *Datastore) Close() error {
+	return d.child.Close()
+}
+
+// PutWithTTL implements TTL.PutWithTTL
+func (d *Datastore) PutWithTTL(ctx context.Context, key key.Key, value []byte, ttl time.Duration) error {
+	if err := d.check(key); err != nil {
+		return err
+	}
+	t, ok := d.child.(ds.TTL)
+	if !ok {
+		return ds.ErrTTLUnsupported
+	}
+	return t.PutWithTTL(ctx, key, value, ttl)
+}
+
+// SetTTL implements TTL.SetTTL
+func (d *Datastore) SetTTL(ctx context.Context, key key.Key, ttl time.Duration) error {
+	if err := d.check(key); err != nil {
+		return err
+	}
+	t, ok := d.child.(ds.TTL)
+	if !ok {
+		return ds.ErrTTLUnsupported
+	}
+	return t.SetTTL(ctx, key, ttl)
+}
+
+// GetExpiration implements TTL.GetExpiration
+func (d *Datastore) GetExpiration(ctx context.Context, key key.Key) (time.Time, error) {
+	t, ok := d.child.(ds.TTL)
+	if !ok {
+		return time.Time{}, ds.ErrTTLUnsupported
+	}
+	return t.GetExpiration(ctx, key)
+}
+
+// GetReader implements StreamingRead.GetReader
+func (d *Datastore) GetReader(ctx context.Context, key key.Key) (io.ReadCloser, error) {
+	return ds.GetReader(ctx, d.child, key)
+}
+
+// PutReader implements StreamingWrite.PutReader
+func (d *Datastore) PutReader(ctx context.Context, key key.Key, r io.Reader) error {
+	if err := d.check(key); err != nil {
+		return err
+	}
+	return ds.PutReader(ctx, d.child, key, r)
+}
+
+// PutIfAbsent implements CAS.PutIfAbsent
+func (d *Datastore) PutIfAbsent(ctx context.Context, key key.Key, value []byte) (bool, error) {
+	if err := d.check(key); err != nil {
+		return false, err
+	}
+	return ds.PutIfAbsent(ctx, d.child, key, value)
+}
+
+// CompareAndSwap implements CAS.CompareAndSwap
+func (d *Datastore) CompareAndSwap(ctx context.Context, key key.Key, old, new []byte) (bool, error) {
+	if err := d.check(key); err != nil {
+		return false, err
+	}
+	return ds.CompareAndSwap(ctx, d.child, key, old, new)
+}
+
+// DeleteIfEqual implements CAS.DeleteIfEqual
+func (d *Datastore) DeleteIfEqual(ctx context.Context, key key.Key, value []byte) (bool, error) {
+	if err := d.check(key); err != nil {
+		return false, err
+	}
+	return ds.DeleteIfEqual(ctx, d.child, key, value)
+}
+
+// GetMany implements BulkRead.GetMany
+func (d *Datastore) GetMany(ctx context.Context, keys []key.Key) ([]ds.ValueResult, error) {
+	return ds.GetMany(ctx, d.child, keys)
+}
+
+// HasMany implements BulkRead.HasMany
+func (d *Datastore) HasMany(ctx context.Context, keys []key.Key) ([]ds.HasResult, error) {
+	return ds.HasMany(ctx, d.child, keys)
+}
+
+// GetSizeMany implements BulkRead.GetSizeMany
+func (d *Datastore) GetSizeMany(ctx context.Context, keys []key.Key) ([]ds.SizeResult, error) {
+	return ds.GetSizeMany(ctx, d.child, keys)
+}
+
+// PutMany implements BulkWrite.PutMany, nothing is written if any of the
+// keys is read-only.
+func (d *Datastore) PutMany(ctx context.Context, keys []key.Key, values [][]byte) error {
+	if err := d.check(keys...); err != nil {
+		return err
+	}
+	return ds.PutMany(ctx, d.child, keys, values)
+}
+
+// Snapshot implements SnapshotDatastore.Snapshot
+func (d *Datastore) Snapshot(ctx context.Context) (ds.Snapshot, error) {
+	return ds.NewSnapshot(ctx, d.child)
+}
+
+// Watch implements Watcher.Watch
+func (d *Datastore) Watch(ctx context.Context, prefix key.Key) (<-chan ds.Event, error) {
+	return ds.Watch(ctx, d.child, prefix)
+}
+
+// DiskUsage implements the PersistentDatastore interface.
+func (d *Datastore) DiskUsage(ctx context.Context) (uint64, error) {
+	return ds.DiskUsage(ctx, d.child)
+}
+
+// Check implements CheckedDatastore.Check
+func (d *Datastore) Check(ctx context.Context) error {
+	if c, ok := d.child.(ds.CheckedDatastore); ok {
+		return c.Check(ctx)
+	}
+	return nil
+}
+
+// Scrub implements ScrubbedDatastore.Scrub, it fails with ds.ErrReadOnly if
+// the whole datastore is read-only as scrubbing may repair entries.
+func (d *Datastore) Scrub(ctx context.Context) error {
+	if d.prefixes == nil {
+		return ds.ErrReadOnly
+	}
+	if c, ok := d.child.(ds.ScrubbedDatastore); ok {
+		return c.Scrub(ctx)
+	}
+	return nil
+}
+
+// CollectGarbage implements GCDatastore.CollectGarbage, it fails with
+// ds.ErrReadOnly if the whole datastore is read-only.
+func (d *Datastore) CollectGarbage(ctx context.Context) error {
+	if d.prefixes == nil {
+		return ds.ErrReadOnly
+	}
+	if c, ok := d.child.(ds.GCDatastore); ok {
+		return c.CollectGarbage(ctx)
+	}
+	return nil
+}
+
+// Batch implements Batching.Batch, writes to read-only keys fail when they
+// are added to the batch.
+func (d *Datastore) Batch(ctx context.Context) (ds.Batch, error) {
+	bds, ok := d.child.(ds.Batching)
+	if !ok {
+		return nil, ds.ErrBatchUnsupported
+	}
+	b, err := bds.Batch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &roBatch{child: b, d: d}, nil
+}
+
+type roBatch struct {
+	child ds.Batch
+	d     *Datastore
+}
+
+func (b *roBatch) Put(ctx context.Context, key key.Key, value []byte) error {
+	if err := b.d.check(key); err != nil {
+		return err
+	}
+	return b.child.Put(ctx, key, value)
+}
+
+func (b *roBatch) Delete(ctx context.Context, key key.Key) error {
+	if err := b.d.check(key); err != nil {
+		return err
+	}
+	return b.child.Delete(ctx, key)
+}
+
+func (b *roBatch) Commit(ctx context.Context) error {
+	return b.child.Commit(ctx)
+}
+
+// NewTransaction implements TxnDatastore.NewTransaction, writes to read-only
+// keys fail. The transactions of the child are always read-only if the
+// whole datastore is.
+func (d *Datastore) NewTransaction(ctx context.Context, readOnly bool) (ds.Txn, error) {
+	tds, ok := d.child.(ds.TxnDatastore)
+	if !ok {
+		return nil, ds.ErrTxnUnsupported
+	}
+	t, err := tds.NewTransaction(ctx, readOnly || d.prefixes == nil)
+	if err != nil {
+		return nil, err
+	}
+	return &roTxn{child: t, d: d}, nil
+}
+
+type roTxn struct {
+	child ds.Txn
+	d     *Datastore
+}
+
+func (t *roTxn) Get(ctx context.Context, key key.Key) ([]byte, error) {
+	return t.child.Get(ctx, key)
+}
+
+func (t *roTxn) Has(ctx context.Context, key key.Key) (bool, error) {
+	return t.child.Has(ctx, key)
+}
+
+func (t *roTxn) GetSize(ctx context.Context, key key.Key) (int, error) {
+	return t.child.GetSize(ctx, key)
+}
+
+func (t *roTxn) Query(ctx context.Context, q dsq.Query) (dsq.Results, error) {
+	return t.child.Query(ctx, q)
+}
+
+func (t *roTxn) Put(ctx context.Context, key key.Key, value []byte) error {
+	if err := t.d.check(key); err != nil {
+		return err
+	}
+	return t.child.Put(ctx, key, value)
+}
+
+func (t *roTxn) Delete(ctx context.Context, key key.Key) error {
+	if err := t.d.check(key); err != nil {
+		return err
+	}
+	return t.child.Delete(ctx, key)
+}
+
+func (t *roTxn) Commit(ctx context.Context) error {
+	return t.child.Commit(ctx)
+}
+
+func (t *roTxn) Discard(ctx context.Context) {
+	t.child.Discard(ctx)
+}
@@ -0,0 +1,202 @@
+// Copyright (c) 2020 DAOT Labs. All rights reserved. Use of this source
+// code is governed by MIT license that can be found in the LICENSE file.
+
+package readonly
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	ds "github.com/daotl/go-datastore"
+	key "github.com/daotl/go-datastore/key"
+	"github.com/daotl/go-datastore/mount"
+	dsq "github.com/daotl/go-datastore/query"
+	dstest "github.com/daotl/go-datastore/test"
+	"github.com/daotl/go-datastore/ttl"
+)
+
+func testSuite(t *testing.T, ktype key.KeyType) {
+	// The suite doesn't write under the read-only prefix.
+	p := key.NewKeyFromTypeAndString(ktype, "/readonly-prefix")
+	d := WrapPrefixes(dstest.NewMapDatastoreForTest(t, ktype), p)
+	dstest.SubtestAll(t, ktype, d)
+}
+
+func TestSuite(t *testing.T) {
+	testSuite(t, key.KeyTypeString)
+	testSuite(t, key.KeyTypeBytes)
+}
+
+func TestReadOnly(t *testing.T) {
+	ctx := context.Background()
+	child := dstest.NewMapDatastoreForTest(t, key.KeyTypeString)
+	k := key.NewStrKey("/a")
+	if err := child.Put(ctx, k, []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	d := Wrap(child)
+
+	if err := d.Put(ctx, k, []byte("b")); err != ds.ErrReadOnly {
+		t.Fatal("expected ErrReadOnly, got: ", err)
+	}
+	if err := d.Delete(ctx, k); !ds.IsReadOnly(err) {
+		t.Fatal("expected ErrReadOnly, got: ", err)
+	}
+	if err := d.PutMany(ctx, []key.Key{key.NewStrKey("/b")}, [][]byte{nil}); err != ds.ErrReadOnly {
+		t.Fatal("expected ErrReadOnly, got: ", err)
+	}
+	if _, err := d.PutIfAbsent(ctx, key.NewStrKey("/b"), nil); err != ds.ErrReadOnly {
+		t.Fatal("expected ErrReadOnly, got: ", err)
+	}
+	b, err := d.Batch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Put(ctx, key.NewStrKey("/b"), nil); err != ds.ErrReadOnly {
+		t.Fatal("expected ErrReadOnly, got: ", err)
+	}
+	if err := b.Delete(ctx, k); err != ds.ErrReadOnly {
+		t.Fatal("expected ErrReadOnly, got: ", err)
+	}
+
+	v, err := d.Get(ctx, k)
+	if err != nil || string(v) != "a" {
+		t.Fatalf("unexpected value %q, error: %v", v, err)
+	}
+	res, err := d.Query(ctx, dsq.Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if es, err := res.Rest(); err != nil || len(es) != 1 {
+		t.Fatalf("unexpected query results %v, error: %v", es, err)
+	}
+	if _, err := d.DiskUsage(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Check(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if f := ds.Features(d); f.Has(ds.FeatureCAS) || f.Has(ds.FeatureGC) {
+		t.Fatal("unexpected features: ", f)
+	}
+}
+
+func TestPrefixes(t *testing.T) {
+	ctx := context.Background()
+	d := WrapPrefixes(dstest.NewMapDatastoreForTest(t, key.KeyTypeString), key.NewStrKey("/seed"))
+
+	for _, k := range []string{"/seed", "/seed/a", "/seed/a/b"} {
+		if err := d.Put(ctx, key.NewStrKey(k), nil); err != ds.ErrReadOnly {
+			t.Fatalf("expected ErrReadOnly writing %s, got: %v", k, err)
+		}
+	}
+	for _, k := range []string{"/", "/seeds", "/a/seed"} {
+		if err := d.Put(ctx, key.NewStrKey(k), nil); err != nil {
+			t.Fatalf("writing %s: %v", k, err)
+		}
+	}
+
+	// Nothing is written if any key is read-only.
+	err := d.PutMany(ctx, []key.Key{key.NewStrKey("/b"), key.NewStrKey("/seed/b")}, [][]byte{nil, nil})
+	if err != ds.ErrReadOnly {
+		t.Fatal("expected ErrReadOnly, got: ", err)
+	}
+	if has, _ := d.Has(ctx, key.NewStrKey("/b")); has {
+		t.Fatal("expected nothing to be written")
+	}
+
+	bd := WrapPrefixes(dstest.NewMapDatastoreForTest(t, key.KeyTypeBytes), key.NewBytesKeyFromString("ro"))
+	if err := bd.Put(ctx, key.NewBytesKeyFromString("rows"), nil); err != ds.ErrReadOnly {
+		t.Fatal("expected ErrReadOnly, got: ", err)
+	}
+	if err := bd.Put(ctx, key.NewBytesKeyFromString("r"), nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTxn(t *testing.T) {
+	ctx := context.Background()
+	child, err := ds.NewTxnMapDatastore(key.KeyTypeString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := WrapPrefixes(child, key.NewStrKey("/seed"))
+
+	txn, err := d.NewTransaction(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Put(ctx, key.NewStrKey("/seed/a"), nil); err != ds.ErrReadOnly {
+		t.Fatal("expected ErrReadOnly, got: ", err)
+	}
+	if err := txn.Put(ctx, key.NewStrKey("/a"), []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := d.Get(ctx, key.NewStrKey("/a")); err != nil || string(v) != "a" {
+		t.Fatalf("unexpected value %q, error: %v", v, err)
+	}
+
+	txn, err = Wrap(child).NewTransaction(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer txn.Discard(ctx)
+	if err := txn.Delete(ctx, key.NewStrKey("/a")); err != ds.ErrReadOnly {
+		t.Fatal("expected ErrReadOnly, got: ", err)
+	}
+}
+
+func TestTTL(t *testing.T) {
+	ctx := context.Background()
+	child := ttl.New(dstest.NewMapDatastoreForTest(t, key.KeyTypeString), ttl.Options{SweepInterval: -1})
+	defer child.Close()
+	k := key.NewStrKey("/a")
+	if err := child.PutWithTTL(ctx, k, nil, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	d := Wrap(child)
+	if err := d.PutWithTTL(ctx, key.NewStrKey("/b"), nil, time.Hour); err != ds.ErrReadOnly {
+		t.Fatal("expected ErrReadOnly, got: ", err)
+	}
+	if err := d.SetTTL(ctx, k, time.Minute); err != ds.ErrReadOnly {
+		t.Fatal("expected ErrReadOnly, got: ", err)
+	}
+	if _, err := d.GetExpiration(ctx, k); err != nil {
+		t.Fatal(err)
+	}
+
+	// Read-only keys are reported as such even without TTL support.
+	d = Wrap(dstest.NewMapDatastoreForTest(t, key.KeyTypeString))
+	if err := d.PutWithTTL(ctx, k, nil, time.Hour); err != ds.ErrReadOnly {
+		t.Fatal("expected ErrReadOnly, got: ", err)
+	}
+	if err := d.SetTTL(ctx, k, time.Hour); err != ds.ErrReadOnly {
+		t.Fatal("expected ErrReadOnly, got: ", err)
+	}
+}
+
+func TestMount(t *testing.T) {
+	ctx := context.Background()
+	seed := dstest.NewMapDatastoreForTest(t, key.KeyTypeString)
+	seed.Put(ctx, key.NewStrKey("/a"), []byte("seed"))
+	m := mount.New([]mount.Mount{
+		{Prefix: key.NewStrKey("/seed"), Datastore: Wrap(seed)},
+		{Prefix: key.NewStrKey("/"), Datastore: dstest.NewMapDatastoreForTest(t, key.KeyTypeString)},
+	})
+
+	if v, err := m.Get(ctx, key.NewStrKey("/seed/a")); err != nil || string(v) != "seed" {
+		t.Fatalf("unexpected value %q, error: %v", v, err)
+	}
+	if err := m.Put(ctx, key.NewStrKey("/seed/b"), nil); !ds.IsReadOnly(err) {
+		t.Fatal("expected ErrReadOnly, got: ", err)
+	}
+	if err := m.Put(ctx, key.NewStrKey("/b"), nil); err != nil {
+		t.Fatal(err)
+	}
+}
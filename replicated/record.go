@@ -0,0 +1,51 @@
+// Copyright (c) 2020 DAOT Labs. All rights reserved. Use of this source
+// code is governed by MIT license that can be found in the LICENSE file.
+
+package replicated
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// ErrCorruptRecord is returned when a value stored in a replica is not a
+// valid record.
+var ErrCorruptRecord = errors.New("replicated: corrupt record")
+
+const (
+	// flagTombstone marks the records of deleted keys.
+	flagTombstone byte = 1 << iota
+)
+
+// headerSize is the size of the header of the records: the flags and the
+// version.
+const headerSize = 1 + 8
+
+// record is the value of a key stored in the replicas: a header with the
+// flags and the big-endian version of the value, followed by the value.
+type record struct {
+	version   uint64
+	tombstone bool
+	value     []byte
+}
+
+func (r record) encode() []byte {
+	b := make([]byte, headerSize+len(r.value))
+	if r.tombstone {
+		b[0] = flagTombstone
+	}
+	binary.BigEndian.PutUint64(b[1:headerSize], r.version)
+	copy(b[headerSize:], r.value)
+	return b
+}
+
+func decode(b []byte) (record, error) {
+	if len(b) < headerSize {
+		return record{}, ErrCorruptRecord
+	}
+	return record{
+		version:   binary.BigEndian.Uint64(b[1:headerSize]),
+		tombstone: b[0]&flagTombstone != 0,
+		value:     b[headerSize:],
+	}, nil
+}
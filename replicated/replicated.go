@@ -0,0 +1,483 @@
+// Copyright (c) 2020 DAOT Labs. All rights reserved. Use of this source
+// code is governed by MIT license that can be found in the LICENSE file.
+
+// Package replicated provides a datastore which replicates its entries over
+// several child datastores, with configurable read and write quorums, read
+// repair and resynchronization of failed replicas.
+package replicated
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/multierr"
+
+	ds "github.com/daotl/go-datastore"
+	key "github.com/daotl/go-datastore/key"
+	dsq "github.com/daotl/go-datastore/query"
+)
+
+// ErrQuorum is returned when too few replicas succeeded to reach the read or
+// write quorum, combined with the errors of the replicas.
+var ErrQuorum = errors.New("replicated: quorum not reached")
+
+// Options are the options of a replicated datastore.
+type Options struct {
+	// WriteQuorum is the number of replicas which must succeed for a write
+	// to succeed, all the replicas if it's zero.
+	WriteQuorum int
+	// ReadQuorum is the number of replicas read by Get, Has and GetSize, 1
+	// if it's zero. The most recent value read is returned, and the replicas
+	// read which are missing it are repaired. Reads see the latest writes if
+	// ReadQuorum + WriteQuorum is greater than the number of replicas.
+	ReadQuorum int
+}
+
+// Datastore is a replicated datastore.
+//
+// The replicas store versioned records rather than plain values, so that
+// stale copies can be detected, and deletions are recorded as tombstones
+// until CollectGarbage is called with all the replicas healthy.
+//
+// A replica which fails an operation is marked degraded, and isn't read nor
+// written until it's restored by Resync. Failures caused by the context of
+// the operation being canceled or timing out don't degrade replicas.
+type Datastore struct {
+	replicas []ds.Datastore
+	w, r     int
+
+	// lk guards the states of the replicas, it's also read-locked by the
+	// writes and locked by the resynchronizations, so that they don't
+	// overwrite concurrent writes.
+	lk       sync.RWMutex
+	degraded []bool
+	// resyncing are the replicas being resynchronized, which are written
+	// to but not read.
+	resyncing []bool
+
+	versionLk   sync.Mutex
+	lastVersion uint64
+}
+
+var _ ds.Batching = (*Datastore)(nil)
+var _ ds.PersistentDatastore = (*Datastore)(nil)
+var _ ds.CheckedDatastore = (*Datastore)(nil)
+var _ ds.ScrubbedDatastore = (*Datastore)(nil)
+var _ ds.GCDatastore = (*Datastore)(nil)
+var _ ds.Shim = (*Datastore)(nil)
+
+// New creates a replicated datastore over the given replicas.
+func New(replicas []ds.Datastore, opts Options) *Datastore {
+	if len(replicas) == 0 {
+		panic("no replicas")
+	}
+	if opts.WriteQuorum <= 0 {
+		opts.WriteQuorum = len(replicas)
+	}
+	if opts.ReadQuorum <= 0 {
+		opts.ReadQuorum = 1
+	}
+	if opts.WriteQuorum > len(replicas) || opts.ReadQuorum > len(replicas) {
+		panic("quorum larger than the number of replicas")
+	}
+	return &Datastore{
+		replicas:  append([]ds.Datastore(nil), replicas...),
+		w:         opts.WriteQuorum,
+		r:         opts.ReadQuorum,
+		degraded:  make([]bool, len(replicas)),
+		resyncing: make([]bool, len(replicas)),
+	}
+}
+
+// Children implements Shim
+func (d *Datastore) Children() []ds.Datastore {
+	return append([]ds.Datastore(nil), d.replicas...)
+}
+
+// Capabilities implements Capable
+func (d *Datastore) Capabilities() ds.Feature {
+	var any ds.Feature
+	for _, r := range d.replicas {
+		any |= ds.Features(r) & (ds.FeaturePersistent | ds.FeatureChecked | ds.FeatureScrubbed)
+	}
+	return ds.FeatureBatching | ds.FeatureGC | any
+}
+
+// Degraded returns the indexes of the degraded replicas.
+func (d *Datastore) Degraded() []int {
+	d.lk.RLock()
+	defer d.lk.RUnlock()
+	var degraded []int
+	for i, dg := range d.degraded {
+		if dg {
+			degraded = append(degraded, i)
+		}
+	}
+	return degraded
+}
+
+// degrade marks a replica degraded.
+func (d *Datastore) degrade(i int) {
+	d.lk.Lock()
+	defer d.lk.Unlock()
+	d.degraded[i] = true
+	d.resyncing[i] = false
+}
+
+// fail degrades replica `i` which failed an operation with `err`, unless the
+// operation failed because `ctx` was canceled or timed out, which says
+// nothing about the replica.
+func (d *Datastore) fail(ctx context.Context, i int, err error) {
+	if ctx.Err() != nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return
+	}
+	d.degrade(i)
+}
+
+// readable returns the indexes of the replicas which can be read.
+func (d *Datastore) readable() []int {
+	d.lk.RLock()
+	defer d.lk.RUnlock()
+	return d.readableLocked()
+}
+
+func (d *Datastore) readableLocked() []int {
+	var rs []int
+	for i, dg := range d.degraded {
+		if !dg {
+			rs = append(rs, i)
+		}
+	}
+	return rs
+}
+
+// writableLocked returns the indexes of the replicas which must be written,
+// the caller must hold d.lk.
+func (d *Datastore) writableLocked() []int {
+	var ws []int
+	for i, dg := range d.degraded {
+		if !dg || d.resyncing[i] {
+			ws = append(ws, i)
+		}
+	}
+	return ws
+}
+
+// nextVersion returns a version greater than all the versions returned so
+// far, from the current time so that versions also increase across
+// restarts.
+func (d *Datastore) nextVersion() uint64 {
+	d.versionLk.Lock()
+	defer d.versionLk.Unlock()
+	v := uint64(time.Now().UnixNano())
+	if v <= d.lastVersion {
+		v = d.lastVersion + 1
+	}
+	d.lastVersion = v
+	return v
+}
+
+func replicaErr(i int, op string, err error) error {
+	return fmt.Errorf("%s replica %d: %w", op, i, err)
+}
+
+// write applies `fn` to the writable replicas in parallel, degrading the
+// ones which fail, and checks the write quorum. The resyncing replicas
+// don't count towards the quorum.
+func (d *Datastore) write(ctx context.Context, op string, fn func(r ds.Datastore) error) error {
+	d.lk.RLock()
+	ws := d.writableLocked()
+	errs := make([]error, len(ws))
+	var wg sync.WaitGroup
+	for j, i := range ws {
+		wg.Add(1)
+		go func(j, i int) {
+			defer wg.Done()
+			errs[j] = fn(d.replicas[i])
+		}(j, i)
+	}
+	wg.Wait()
+
+	ok := 0
+	var merr error
+	failed := make(map[int]error)
+	for j, i := range ws {
+		switch {
+		case errs[j] != nil:
+			merr = multierr.Append(merr, replicaErr(i, op, errs[j]))
+			failed[i] = errs[j]
+		case !d.resyncing[i]:
+			ok++
+		}
+	}
+	d.lk.RUnlock()
+
+	for i, err := range failed {
+		d.fail(ctx, i, err)
+	}
+	if ok < d.w {
+		return multierr.Append(ErrQuorum, merr)
+	}
+	return nil
+}
+
+// response is the record of a key read from a replica.
+type response struct {
+	replica int
+	found   bool
+	rec     record
+}
+
+// read reads a key from the read quorum, repairs the stale replicas read and
+// returns the most recent record.
+func (d *Datastore) read(ctx context.Context, k key.Key) (record, bool, error) {
+	var (
+		resps []response
+		merr  error
+	)
+	for _, i := range d.readable() {
+		if len(resps) == d.r {
+			break
+		}
+		raw, err := d.replicas[i].Get(ctx, k)
+		if ds.IsNotFound(err) {
+			resps = append(resps, response{replica: i})
+			continue
+		}
+		if err == nil {
+			var rec record
+			if rec, err = decode(raw); err == nil {
+				resps = append(resps, response{replica: i, found: true, rec: rec})
+				continue
+			}
+		}
+		merr = multierr.Append(merr, replicaErr(i, "reading from", err))
+		d.fail(ctx, i, err)
+	}
+	if len(resps) < d.r {
+		return record{}, false, multierr.Append(ErrQuorum, merr)
+	}
+
+	var latest response
+	for _, r := range resps {
+		if r.found && (!latest.found || r.rec.version > latest.rec.version) {
+			latest = r
+		}
+	}
+	if !latest.found {
+		return record{}, false, nil
+	}
+
+	// Repair the stale replicas, missing tombstones don't need repairs.
+	for _, r := range resps {
+		stale := r.found && r.rec.version < latest.rec.version ||
+			!r.found && !latest.rec.tombstone
+		if stale {
+			d.repair(ctx, r.replica, k, latest.rec)
+		}
+	}
+	return latest.rec, !latest.rec.tombstone, nil
+}
+
+// repair writes a record to a replica, the replica is degraded if it fails.
+// Like the resynchronizations, it excludes concurrent writes, and the record
+// isn't written if the replica has a more recent one by then.
+func (d *Datastore) repair(ctx context.Context, i int, k key.Key, rec record) {
+	d.lk.Lock()
+	err := d.repairLocked(ctx, i, k, rec)
+	d.lk.Unlock()
+	if err != nil {
+		d.fail(ctx, i, err)
+	}
+}
+
+// repairLocked is repair, the caller must hold d.lk.
+func (d *Datastore) repairLocked(ctx context.Context, i int, k key.Key, rec record) error {
+	raw, err := d.replicas[i].Get(ctx, k)
+	switch {
+	case ds.IsNotFound(err):
+	case err != nil:
+		return err
+	default:
+		cur, err := decode(raw)
+		if err != nil {
+			return err
+		}
+		if cur.version >= rec.version {
+			return nil
+		}
+	}
+	return d.replicas[i].Put(ctx, k, rec.encode())
+}
+
+// Get implements Datastore.Get
+func (d *Datastore) Get(ctx context.Context, key key.Key) (value []byte, err error) {
+	rec, found, err := d.read(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, ds.ErrNotFound
+	}
+	return rec.value, nil
+}
+
+// Has implements Datastore.Has
+func (d *Datastore) Has(ctx context.Context, key key.Key) (exists bool, err error) {
+	_, found, err := d.read(ctx, key)
+	return found, err
+}
+
+// GetSize implements Datastore.GetSize
+func (d *Datastore) GetSize(ctx context.Context, key key.Key) (size int, err error) {
+	rec, found, err := d.read(ctx, key)
+	if err != nil {
+		return -1, err
+	}
+	if !found {
+		return -1, ds.ErrNotFound
+	}
+	return len(rec.value), nil
+}
+
+// Put implements Datastore.Put
+func (d *Datastore) Put(ctx context.Context, key key.Key, value []byte) error {
+	raw := record{version: d.nextVersion(), value: value}.encode()
+	return d.write(ctx, "putting to", func(r ds.Datastore) error {
+		return r.Put(ctx, key, raw)
+	})
+}
+
+// Delete implements Datastore.Delete by writing a tombstone.
+func (d *Datastore) Delete(ctx context.Context, key key.Key) error {
+	raw := record{version: d.nextVersion(), tombstone: true}.encode()
+	return d.write(ctx, "deleting from", func(r ds.Datastore) error {
+		return r.Put(ctx, key, raw)
+	})
+}
+
+// Query implements Datastore.Query by querying the first healthy replica,
+// the replicas which fail are degraded and the next one is tried. Except for
+// the prefix and range, the query is applied to the decoded records.
+func (d *Datastore) Query(ctx context.Context, q dsq.Query) (dsq.Results, error) {
+	childQuery := dsq.Query{
+		Prefix:            q.Prefix,
+		Range:             q.Range,
+		ReturnExpirations: q.ReturnExpirations,
+	}
+
+	var merr error
+	for _, i := range d.readable() {
+		res, err := d.replicas[i].Query(ctx, childQuery)
+		if err != nil {
+			merr = multierr.Append(merr, replicaErr(i, "querying", err))
+			d.fail(ctx, i, err)
+			continue
+		}
+		return dsq.NaiveQueryApply(dsq.Query{
+			Filters: q.Filters,
+			Orders:  q.Orders,
+			Offset:  q.Offset,
+			Limit:   q.Limit,
+		}, d.decodeResults(q, i, res)), nil
+	}
+	return nil, multierr.Append(ErrQuorum, merr)
+}
+
+// decodeResults decodes the records of the results of replica `i` and skips
+// the tombstones.
+func (d *Datastore) decodeResults(q dsq.Query, i int, res dsq.Results) dsq.Results {
+	return dsq.ResultsFromIterator(q, dsq.Iterator{
+		Next: func() (dsq.Result, bool) {
+			for {
+				r, ok := res.NextSync()
+				if !ok || r.Error != nil {
+					return r, ok
+				}
+				rec, err := decode(r.Value)
+				if err != nil {
+					d.degrade(i)
+					return dsq.Result{Error: replicaErr(i, "querying", err)}, true
+				}
+				if rec.tombstone {
+					continue
+				}
+				r.Size = len(rec.value)
+				r.Value = rec.value
+				if q.KeysOnly {
+					r.Value = nil
+				}
+				return r, true
+			}
+		},
+		Close: res.Close,
+	})
+}
+
+// Sync implements Datastore.Sync by syncing the healthy replicas.
+func (d *Datastore) Sync(ctx context.Context, prefix key.Key) error {
+	return d.write(ctx, "syncing", func(r ds.Datastore) error {
+		return r.Sync(ctx, prefix)
+	})
+}
+
+// Close closes all the replicas.
+func (d *Datastore) Close() error {
+	var merr error
+	for i, r := range d.replicas {
+		if err := r.Close(); err != nil {
+			merr = multierr.Append(merr, replicaErr(i, "closing", err))
+		}
+	}
+	return merr
+}
+
+// DiskUsage implements the PersistentDatastore interface, it returns the sum
+// of the disk usages of the replicas.
+func (d *Datastore) DiskUsage(ctx context.Context) (uint64, error) {
+	var (
+		merr    error
+		duTotal uint64
+	)
+	for i, r := range d.replicas {
+		du, err := ds.DiskUsage(ctx, r)
+		duTotal += du
+		if err != nil {
+			merr = multierr.Append(merr, replicaErr(i, "getting disk usage of", err))
+		}
+	}
+	return duTotal, merr
+}
+
+// Check implements CheckedDatastore.Check, the replicas which fail are
+// degraded.
+func (d *Datastore) Check(ctx context.Context) error {
+	var merr error
+	for _, i := range d.readable() {
+		if c, ok := d.replicas[i].(ds.CheckedDatastore); ok {
+			if err := c.Check(ctx); err != nil {
+				merr = multierr.Append(merr, replicaErr(i, "checking", err))
+				d.fail(ctx, i, err)
+			}
+		}
+	}
+	return merr
+}
+
+// Scrub implements ScrubbedDatastore.Scrub, the replicas which fail are
+// degraded.
+func (d *Datastore) Scrub(ctx context.Context) error {
+	var merr error
+	for _, i := range d.readable() {
+		if c, ok := d.replicas[i].(ds.ScrubbedDatastore); ok {
+			if err := c.Scrub(ctx); err != nil {
+				merr = multierr.Append(merr, replicaErr(i, "scrubbing", err))
+				d.fail(ctx, i, err)
+			}
+		}
+	}
+	return merr
+}
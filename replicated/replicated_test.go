@@ -0,0 +1,316 @@
+// Copyright (c) 2020 DAOT Labs. All rights reserved. Use of this source
+// code is governed by MIT license that can be found in the LICENSE file.
+
+package replicated
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+
+	"go.uber.org/multierr"
+
+	ds "github.com/daotl/go-datastore"
+	"github.com/daotl/go-datastore/failstore"
+	key "github.com/daotl/go-datastore/key"
+	dsq "github.com/daotl/go-datastore/query"
+	dssync "github.com/daotl/go-datastore/sync"
+	dstest "github.com/daotl/go-datastore/test"
+)
+
+func newReplicas(t *testing.T, ktype key.KeyType, n int) []ds.Datastore {
+	replicas := make([]ds.Datastore, n)
+	for i := range replicas {
+		replicas[i] = dssync.MutexWrap(dstest.NewMapDatastoreForTest(t, ktype))
+	}
+	return replicas
+}
+
+func testSuite(t *testing.T, ktype key.KeyType) {
+	d := New(newReplicas(t, ktype, 3), Options{WriteQuorum: 2, ReadQuorum: 2})
+	dstest.SubtestAll(t, ktype, d)
+}
+
+func TestSuite(t *testing.T) {
+	testSuite(t, key.KeyTypeString)
+	testSuite(t, key.KeyTypeBytes)
+}
+
+var errFail = errors.New("fail")
+
+// failing wraps a replica to fail all the operations while failing is set.
+func failing(r ds.Datastore, fail *int32) ds.Datastore {
+	return failstore.NewFailstore(r, func(string) error {
+		if atomic.LoadInt32(fail) != 0 {
+			return errFail
+		}
+		return nil
+	})
+}
+
+func k(i int) key.Key {
+	return key.NewStrKey(fmt.Sprintf("/%03d", i))
+}
+
+func mustGet(t *testing.T, d ds.Datastore, k key.Key, expected string) {
+	t.Helper()
+	v, err := d.Get(context.Background(), k)
+	if err != nil || string(v) != expected {
+		t.Fatalf("unexpected value %q for %s, expected %q, error: %v", v, k, expected, err)
+	}
+}
+
+func TestReadRepair(t *testing.T) {
+	ctx := context.Background()
+	replicas := newReplicas(t, key.KeyTypeString, 3)
+	d := New(replicas, Options{ReadQuorum: 3})
+	d.Put(ctx, k(1), []byte("old"))
+	stale, _ := replicas[2].Get(ctx, k(1))
+	d.Put(ctx, k(1), []byte("new"))
+
+	// Replica 1 misses the key and replica 2 holds a stale copy.
+	replicas[1].Delete(ctx, k(1))
+	replicas[2].Put(ctx, k(1), stale)
+
+	mustGet(t, d, k(1), "new")
+	latest, _ := replicas[0].Get(ctx, k(1))
+	for i, r := range replicas {
+		if raw, err := r.Get(ctx, k(1)); err != nil || string(raw) != string(latest) {
+			t.Fatalf("expected replica %d to be repaired, error: %v", i, err)
+		}
+	}
+
+	// A missed deletion is repaired too.
+	live, _ := replicas[0].Get(ctx, k(1))
+	d.Delete(ctx, k(1))
+	replicas[1].Put(ctx, k(1), live)
+	if has, err := d.Has(ctx, k(1)); err != nil || has {
+		t.Fatal("expected the key to be deleted, error: ", err)
+	}
+	raw, _ := replicas[1].Get(ctx, k(1))
+	if rec, _ := decode(raw); !rec.tombstone {
+		t.Fatal("expected the tombstone to be repaired")
+	}
+
+	// A repair with a record older than the replica's, such as one racing
+	// with a write, doesn't revert the replica.
+	d.Put(ctx, k(2), []byte("old"))
+	raw, _ = replicas[0].Get(ctx, k(2))
+	old, _ := decode(raw)
+	d.Put(ctx, k(2), []byte("new"))
+	d.repair(ctx, 0, k(2), old)
+	d = New(replicas[:1], Options{})
+	mustGet(t, d, k(2), "new")
+}
+
+func TestQuorum(t *testing.T) {
+	ctx := context.Background()
+	var fail int32
+	replicas := newReplicas(t, key.KeyTypeString, 3)
+	replicas[0] = failing(replicas[0], &fail)
+	atomic.StoreInt32(&fail, 1)
+
+	d := New(replicas, Options{})
+	err := d.Put(ctx, k(1), []byte("v"))
+	if !errors.Is(err, ErrQuorum) || !errors.Is(err, errFail) {
+		t.Fatal("expected ErrQuorum, got: ", err)
+	}
+	if dg := d.Degraded(); len(dg) != 1 || dg[0] != 0 {
+		t.Fatal("expected replica 0 to be degraded, got: ", dg)
+	}
+
+	d = New(replicas, Options{WriteQuorum: 2})
+	if err := d.Put(ctx, k(1), []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	// The degraded replica isn't read.
+	mustGet(t, d, k(1), "v")
+
+	d = New(replicas, Options{ReadQuorum: 3})
+	if _, err := d.Get(ctx, k(1)); !errors.Is(err, ErrQuorum) {
+		t.Fatal("expected ErrQuorum, got: ", err)
+	}
+}
+
+func TestCanceled(t *testing.T) {
+	ctx := context.Background()
+	replicas := newReplicas(t, key.KeyTypeString, 2)
+	// Replica 0 fails with the error of a canceled context.
+	replicas[0] = failstore.NewFailstore(replicas[0], func(string) error {
+		return fmt.Errorf("waiting: %w", context.Canceled)
+	})
+	d := New(replicas, Options{WriteQuorum: 1})
+
+	if err := d.Put(ctx, k(1), []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	mustGet(t, d, k(1), "v")
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := d.Query(cctx, dsq.Query{}); err != nil {
+		t.Fatal(err)
+	}
+	if dg := d.Degraded(); len(dg) != 0 {
+		t.Fatal("expected no replica to be degraded by canceled operations, got: ", dg)
+	}
+}
+
+func TestQuery(t *testing.T) {
+	ctx := context.Background()
+	var fail int32
+	replicas := newReplicas(t, key.KeyTypeString, 2)
+	replicas[0] = failing(replicas[0], &fail)
+	d := New(replicas, Options{WriteQuorum: 1})
+	for i := 0; i < 10; i++ {
+		d.Put(ctx, k(i), []byte(fmt.Sprintf("%d", 9-i)))
+	}
+	d.Delete(ctx, k(0))
+
+	atomic.StoreInt32(&fail, 1)
+	res, err := d.Query(ctx, dsq.Query{Orders: []dsq.Order{dsq.OrderByValue{}}, Limit: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	es, err := res.Rest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(es) != 3 || !es[0].Key.Equal(k(9)) || string(es[2].Value) != "2" {
+		t.Fatalf("unexpected entries: %v", es)
+	}
+	if dg := d.Degraded(); len(dg) != 1 || dg[0] != 0 {
+		t.Fatal("expected replica 0 to be degraded, got: ", dg)
+	}
+}
+
+func TestResync(t *testing.T) {
+	ctx := context.Background()
+	var fail int32
+	replicas := newReplicas(t, key.KeyTypeString, 3)
+	raw := replicas[2]
+	replicas[2] = failing(raw, &fail)
+	d := New(replicas, Options{WriteQuorum: 2})
+	for i := 0; i < 10; i++ {
+		d.Put(ctx, k(i), []byte("old"))
+	}
+
+	atomic.StoreInt32(&fail, 1)
+	d.Put(ctx, k(0), []byte("new"))
+	atomic.StoreInt32(&fail, 0)
+	if dg := d.Degraded(); len(dg) != 1 || dg[0] != 2 {
+		t.Fatal("expected replica 2 to be degraded, got: ", dg)
+	}
+
+	// Missed while degraded.
+	d.Put(ctx, k(1), []byte("new"))
+	d.Delete(ctx, k(2))
+	d.Put(ctx, k(10), []byte("new"))
+	// Only in the degraded replica.
+	raw.Put(ctx, k(11), record{version: 1, value: []byte("extra")}.encode())
+
+	if err := d.Resync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if dg := d.Degraded(); len(dg) != 0 {
+		t.Fatal("expected no degraded replica, got: ", dg)
+	}
+
+	// Only read from the resynced replica.
+	r := New([]ds.Datastore{raw}, Options{})
+	mustGet(t, r, k(0), "new")
+	mustGet(t, r, k(1), "new")
+	mustGet(t, r, k(3), "old")
+	mustGet(t, r, k(10), "new")
+	for _, i := range []int{2, 11} {
+		if has, _ := r.Has(ctx, k(i)); has {
+			t.Fatalf("expected %s not to exist", k(i))
+		}
+	}
+}
+
+func TestResyncFailure(t *testing.T) {
+	ctx := context.Background()
+	var fail int32
+	replicas := newReplicas(t, key.KeyTypeString, 2)
+	replicas[1] = failing(replicas[1], &fail)
+	d := New(replicas, Options{WriteQuorum: 1})
+
+	atomic.StoreInt32(&fail, 1)
+	d.Put(ctx, k(0), []byte("v"))
+	err := d.Resync(ctx)
+	if !errors.Is(err, errFail) {
+		t.Fatal("expected the resync to fail, got: ", err)
+	}
+	if dg := d.Degraded(); len(dg) != 1 {
+		t.Fatal("expected replica 1 to stay degraded, got: ", dg)
+	}
+}
+
+func TestBatch(t *testing.T) {
+	ctx := context.Background()
+	replicas := newReplicas(t, key.KeyTypeString, 2)
+	d := New(replicas, Options{})
+	d.Put(ctx, k(0), []byte("v"))
+
+	b, err := d.Batch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Put(ctx, k(1), []byte("v"))
+	b.Delete(ctx, k(0))
+	if err := b.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range replicas {
+		r := New([]ds.Datastore{r}, Options{})
+		mustGet(t, r, k(1), "v")
+		if has, _ := r.Has(ctx, k(0)); has {
+			t.Fatal("expected the key to be deleted")
+		}
+	}
+}
+
+func TestCollectGarbage(t *testing.T) {
+	ctx := context.Background()
+	replicas := newReplicas(t, key.KeyTypeString, 2)
+	d := New(replicas, Options{})
+	d.Put(ctx, k(0), []byte("v"))
+	d.Put(ctx, k(1), []byte("v"))
+	d.Delete(ctx, k(0))
+
+	if has, _ := replicas[0].Has(ctx, k(0)); !has {
+		t.Fatal("expected a tombstone")
+	}
+	if err := d.CollectGarbage(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range replicas {
+		if has, _ := r.Has(ctx, k(0)); has {
+			t.Fatal("expected the tombstone to be collected")
+		}
+		if has, _ := r.Has(ctx, k(1)); !has {
+			t.Fatal("expected the live entry to be kept")
+		}
+	}
+}
+
+type closeFailing struct {
+	ds.Datastore
+}
+
+func (closeFailing) Close() error {
+	return errFail
+}
+
+func TestClose(t *testing.T) {
+	replicas := newReplicas(t, key.KeyTypeString, 2)
+	for i := range replicas {
+		replicas[i] = closeFailing{replicas[i]}
+	}
+	err := New(replicas, Options{}).Close()
+	if len(multierr.Errors(err)) != 2 || !errors.Is(err, errFail) {
+		t.Fatal("expected the errors of both replicas, got: ", err)
+	}
+}
@@ -0,0 +1,278 @@
+// Copyright (c) 2020 DAOT Labs. All rights reserved. Use of this source
+// code is governed by MIT license that can be found in the LICENSE file.
+
+package replicated
+
+import (
+	"bytes"
+	"context"
+
+	"go.uber.org/multierr"
+
+	ds "github.com/daotl/go-datastore"
+	key "github.com/daotl/go-datastore/key"
+	dsq "github.com/daotl/go-datastore/query"
+)
+
+// Resync restores the degraded replicas from the healthy ones: the records
+// missing or stale in a degraded replica are copied from the most recent
+// healthy copy, and the keys missing from all the healthy replicas are
+// deleted. The datastore can be used meanwhile, the degraded replicas are
+// written to but not read until they're restored.
+func (d *Datastore) Resync(ctx context.Context) error {
+	d.lk.Lock()
+	var targets []int
+	for i, dg := range d.degraded {
+		if dg && !d.resyncing[i] {
+			d.resyncing[i] = true
+			targets = append(targets, i)
+		}
+	}
+	healthy := d.readableLocked()
+	d.lk.Unlock()
+
+	var merr error
+	for _, i := range targets {
+		err := ErrQuorum
+		if len(healthy) > 0 {
+			err = d.resync(ctx, i, healthy)
+		}
+
+		d.lk.Lock()
+		if err == nil && d.resyncing[i] {
+			d.degraded[i] = false
+		} else if err == nil {
+			// Degraded again by a failed write.
+			err = ErrQuorum
+		}
+		d.resyncing[i] = false
+		d.lk.Unlock()
+
+		if err != nil {
+			merr = multierr.Append(merr, replicaErr(i, "resyncing", err))
+		}
+	}
+	return merr
+}
+
+// keys returns all the keys of a replica, including the tombstones.
+func keys(ctx context.Context, r ds.Datastore) ([]key.Key, error) {
+	res, err := r.Query(ctx, dsq.Query{KeysOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	var ks []key.Key
+	for {
+		e, ok := res.NextSync()
+		if !ok {
+			break
+		}
+		if e.Error != nil {
+			res.Close()
+			return nil, e.Error
+		}
+		ks = append(ks, e.Key)
+	}
+	return ks, res.Close()
+}
+
+func (d *Datastore) resync(ctx context.Context, i int, healthy []int) error {
+	// Collect the keys first, replicas may not support writes during queries.
+	seen := make(map[string]bool)
+	for _, h := range healthy {
+		ks, err := keys(ctx, d.replicas[h])
+		if err != nil {
+			return replicaErr(h, "querying", err)
+		}
+		for _, k := range ks {
+			if seen[k.String()] {
+				continue
+			}
+			seen[k.String()] = true
+			if err := d.resyncKey(ctx, i, healthy, k); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Delete the keys which are only in the degraded replica.
+	ks, err := keys(ctx, d.replicas[i])
+	if err != nil {
+		return err
+	}
+	for _, k := range ks {
+		if !seen[k.String()] {
+			if err := d.resyncKey(ctx, i, healthy, k); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// resyncKey copies the most recent healthy record of a key to replica `i`,
+// or deletes the key from it if no healthy replica has it.
+func (d *Datastore) resyncKey(ctx context.Context, i int, healthy []int, k key.Key) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// Exclude concurrent writes.
+	d.lk.Lock()
+	defer d.lk.Unlock()
+
+	var latest []byte
+	var version uint64
+	for _, h := range healthy {
+		raw, err := d.replicas[h].Get(ctx, k)
+		if ds.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return replicaErr(h, "reading from", err)
+		}
+		rec, err := decode(raw)
+		if err != nil {
+			return replicaErr(h, "reading from", err)
+		}
+		if latest == nil || rec.version > version {
+			latest, version = raw, rec.version
+		}
+	}
+
+	raw, err := d.replicas[i].Get(ctx, k)
+	switch {
+	case ds.IsNotFound(err):
+		if latest == nil {
+			return nil
+		}
+	case err != nil:
+		return err
+	case latest == nil:
+		return d.replicas[i].Delete(ctx, k)
+	case bytes.Equal(raw, latest):
+		return nil
+	}
+	return d.replicas[i].Put(ctx, k, latest)
+}
+
+// CollectGarbage implements GCDatastore.CollectGarbage by deleting the
+// tombstones from all the replicas, then collecting the garbage of the
+// replicas. The tombstones are kept if any replica is degraded, as it may
+// miss the deletions.
+func (d *Datastore) CollectGarbage(ctx context.Context) error {
+	if len(d.Degraded()) == 0 {
+		seen := make(map[string]bool)
+		for i, r := range d.replicas {
+			ks, err := keys(ctx, r)
+			if err != nil {
+				return replicaErr(i, "querying", err)
+			}
+			for _, k := range ks {
+				if seen[k.String()] {
+					continue
+				}
+				seen[k.String()] = true
+				if err := d.collectKey(ctx, k); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	var merr error
+	for i, r := range d.replicas {
+		if c, ok := r.(ds.GCDatastore); ok {
+			if err := c.CollectGarbage(ctx); err != nil {
+				merr = multierr.Append(merr, replicaErr(i, "collecting garbage of", err))
+			}
+		}
+	}
+	return merr
+}
+
+// collectKey deletes a key from all the replicas if they all have a
+// tombstone or nothing for it.
+func (d *Datastore) collectKey(ctx context.Context, k key.Key) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	d.lk.Lock()
+	defer d.lk.Unlock()
+	for i, r := range d.replicas {
+		raw, err := r.Get(ctx, k)
+		if ds.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return replicaErr(i, "reading from", err)
+		}
+		if rec, err := decode(raw); err != nil || !rec.tombstone {
+			return nil
+		}
+	}
+	var merr error
+	for i, r := range d.replicas {
+		if err := r.Delete(ctx, k); err != nil {
+			merr = multierr.Append(merr, replicaErr(i, "deleting from", err))
+		}
+	}
+	return merr
+}
+
+// Batch implements Batching.Batch, the operations are written to each
+// replica in a batch on Commit, using ds.NewBasicBatch for the replicas
+// which aren't Batching.
+func (d *Datastore) Batch(ctx context.Context) (ds.Batch, error) {
+	return &replicatedBatch{d: d}, nil
+}
+
+type batchOp struct {
+	key key.Key
+	rec record
+}
+
+type replicatedBatch struct {
+	d   *Datastore
+	ops []batchOp
+}
+
+func (b *replicatedBatch) Put(ctx context.Context, key key.Key, value []byte) error {
+	b.ops = append(b.ops, batchOp{key: key, rec: record{value: value}})
+	return nil
+}
+
+func (b *replicatedBatch) Delete(ctx context.Context, key key.Key) error {
+	b.ops = append(b.ops, batchOp{key: key, rec: record{tombstone: true}})
+	return nil
+}
+
+func (b *replicatedBatch) Commit(ctx context.Context) error {
+	raws := make([][]byte, len(b.ops))
+	for i, op := range b.ops {
+		op.rec.version = b.d.nextVersion()
+		raws[i] = op.rec.encode()
+	}
+	err := b.d.write(ctx, "committing to", func(r ds.Datastore) error {
+		var rb ds.Batch
+		if bds, ok := r.(ds.Batching); ok {
+			var err error
+			if rb, err = bds.Batch(ctx); err != nil {
+				return err
+			}
+		} else {
+			rb = ds.NewBasicBatch(r)
+		}
+		for i, op := range b.ops {
+			if err := rb.Put(ctx, op.key, raws[i]); err != nil {
+				return err
+			}
+		}
+		return rb.Commit(ctx)
+	})
+	if err == nil {
+		b.ops = nil
+	}
+	return err
+}
@@ -51,6 +51,16 @@ func (d *Datastore) runOp(op func() error) error {
 	return xerrors.Errorf(errFmtString, err)
 }
 
+// Children implements the Shim interface.
+func (d *Datastore) Children() []ds.Datastore {
+	return []ds.Datastore{d.Batching}
+}
+
+// Capabilities implements the Capable interface.
+func (d *Datastore) Capabilities() ds.Feature {
+	return ds.FeatureBatching | ds.Features(d.Batching)&ds.FeaturePersistent
+}
+
 // DiskUsage implements the PersistentDatastore interface.
 func (d *Datastore) DiskUsage(ctx context.Context) (uint64, error) {
 	var size uint64
@@ -11,6 +11,7 @@ import (
 	"strings"
 	"testing"
 
+	ds "github.com/daotl/go-datastore"
 	"github.com/daotl/go-datastore/failstore"
 	"github.com/daotl/go-datastore/key"
 	dstest "github.com/daotl/go-datastore/test"
@@ -165,3 +166,38 @@ func TestSuccessAfterTemp(t *testing.T) {
 		t.Fatal("got wrong value")
 	}
 }
+
+func TestWrappedErrorsPreserved(t *testing.T) {
+	ctx := context.Background()
+
+	fstore := failstore.NewFailstore(dstest.NewMapDatastoreForTest(t, key.KeyTypeString),
+		func(op string) error {
+			return nil
+		})
+
+	rds := &Datastore{
+		Batching: fstore,
+		Retries:  2,
+		TempErrFunc: func(err error) bool {
+			return true
+		},
+	}
+
+	k := key.NewStrKey("test")
+	_, err := rds.Get(ctx, k)
+	if !strings.Contains(err.Error(), "ran out of retries") {
+		t.Fatal("got different error than expected: ", err)
+	}
+	if !ds.IsNotFound(err) {
+		t.Fatal("expected the wrapped error to be not found, got: ", err)
+	}
+
+	// Has is backed by Get and must still see the key as missing.
+	has, err := ds.GetBackedHas(ctx, rds, k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if has {
+		t.Fatal("should not have this thing")
+	}
+}
@@ -0,0 +1,42 @@
+// Copyright (c) 2020 DAOT Labs. All rights reserved. Use of this source
+// code is governed by MIT license that can be found in the LICENSE file.
+
+package shard
+
+import (
+	"context"
+	"fmt"
+
+	dsq "github.com/daotl/go-datastore/query"
+)
+
+// Query implements Datastore.Query by querying all the shards and
+// merge-sorting the results by the query orders. The filters, offset and
+// limit are applied to the merged results.
+func (d *Datastore) Query(ctx context.Context, master dsq.Query) (dsq.Results, error) {
+	childQuery := dsq.Query{
+		Prefix:            master.Prefix,
+		Range:             master.Range,
+		Orders:            master.Orders,
+		KeysOnly:          master.KeysOnly,
+		ReturnExpirations: master.ReturnExpirations,
+		ReturnsSizes:      master.ReturnsSizes,
+	}
+
+	d.lk.RLock()
+	shards := d.shards
+	d.lk.RUnlock()
+
+	sources := make([]dsq.Results, 0, len(shards))
+	for _, s := range shards {
+		results, err := s.Datastore.Query(ctx, childQuery)
+		if err != nil {
+			for _, r := range sources {
+				_ = r.Close()
+			}
+			return nil, fmt.Errorf("querying shard %s: %w", s.Name, err)
+		}
+		sources = append(sources, results)
+	}
+	return dsq.Merge(master, sources, dsq.MergeOptions{QueryOrdered: true}), nil
+}
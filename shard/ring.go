@@ -0,0 +1,75 @@
+// Copyright (c) 2020 DAOT Labs. All rights reserved. Use of this source
+// code is governed by MIT license that can be found in the LICENSE file.
+
+package shard
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+)
+
+// ring is a consistent hashing ring, each shard owns the keys hashed between
+// the points of its virtual nodes and the previous points.
+type ring struct {
+	points []uint64
+	// owners are the indexes of the shards owning the points.
+	owners []int
+}
+
+func newRing(names []string, vnodes int) *ring {
+	r := &ring{
+		points: make([]uint64, 0, len(names)*vnodes),
+		owners: make([]int, 0, len(names)*vnodes),
+	}
+	type point struct {
+		hash  uint64
+		owner int
+	}
+	points := make([]point, 0, len(names)*vnodes)
+	for i, name := range names {
+		for v := 0; v < vnodes; v++ {
+			points = append(points, point{hash(name + "#" + strconv.Itoa(v)), i})
+		}
+	}
+	sort.Slice(points, func(i, j int) bool {
+		if points[i].hash == points[j].hash {
+			// Resolve the collisions deterministically.
+			return names[points[i].owner] < names[points[j].owner]
+		}
+		return points[i].hash < points[j].hash
+	})
+	for _, p := range points {
+		r.points = append(r.points, p.hash)
+		r.owners = append(r.owners, p.owner)
+	}
+	return r
+}
+
+// lookup returns the index of the shard owning the given key bytes.
+func (r *ring) lookup(k []byte) int {
+	h := hashBytes(k)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.owners[i]
+}
+
+func hash(s string) uint64 {
+	return hashBytes([]byte(s))
+}
+
+// hashBytes returns the FNV-1a hash of `b`, finalized like in MurmurHash3
+// to spread similar inputs over the ring.
+func hashBytes(b []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(b)
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
@@ -0,0 +1,482 @@
+// Copyright (c) 2020 DAOT Labs. All rights reserved. Use of this source
+// code is governed by MIT license that can be found in the LICENSE file.
+
+// Package shard provides a datastore which spreads its keys over several
+// child datastores by consistent hashing, and supports adding shards online.
+package shard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"go.uber.org/multierr"
+
+	ds "github.com/daotl/go-datastore"
+	key "github.com/daotl/go-datastore/key"
+	dsq "github.com/daotl/go-datastore/query"
+)
+
+// DefaultVirtualNodes is the default number of points of each shard on the
+// hashing ring.
+const DefaultVirtualNodes = 128
+
+var (
+	// ErrResharding is returned by AddShard when another shard is being
+	// added.
+	ErrResharding = errors.New("shard: resharding in progress")
+	// ErrDuplicateShard is returned by AddShard when a shard with the same
+	// name exists.
+	ErrDuplicateShard = errors.New("shard: duplicate shard name")
+)
+
+// Shard is a child datastore of a sharded datastore. The keys are assigned to
+// the shards by the hashes of their names, which must be unique and stable
+// across restarts.
+type Shard struct {
+	Name      string
+	Datastore ds.Datastore
+}
+
+// Options are the options of a sharded datastore.
+type Options struct {
+	// VirtualNodes is the number of points of each shard on the hashing
+	// ring, DefaultVirtualNodes if it's zero. More points spread the keys
+	// more evenly.
+	VirtualNodes int
+}
+
+// Datastore is a sharded datastore, each key lives in exactly one shard.
+//
+// While a shard is being added, the keys which move to it are looked up in
+// both their new and old shards, and written to their new shard.
+type Datastore struct {
+	vnodes int
+
+	// lk guards the shards and rings, it's also read-locked by the writes
+	// and locked while moving each key, so that moves don't overwrite
+	// concurrent writes.
+	lk     sync.RWMutex
+	shards []Shard
+	ring   *ring
+	// prev is the ring before the shard being added, nil if no shard is
+	// being added.
+	prev *ring
+}
+
+var _ ds.Batching = (*Datastore)(nil)
+var _ ds.PersistentDatastore = (*Datastore)(nil)
+var _ ds.CheckedDatastore = (*Datastore)(nil)
+var _ ds.ScrubbedDatastore = (*Datastore)(nil)
+var _ ds.GCDatastore = (*Datastore)(nil)
+var _ ds.Shim = (*Datastore)(nil)
+
+// New creates a sharded datastore from the given shards.
+func New(shards []Shard, opts Options) *Datastore {
+	if len(shards) == 0 {
+		panic("no shards")
+	}
+	if opts.VirtualNodes <= 0 {
+		opts.VirtualNodes = DefaultVirtualNodes
+	}
+	seen := make(map[string]bool, len(shards))
+	for _, s := range shards {
+		if seen[s.Name] {
+			panic(fmt.Sprintf("duplicate shard %q", s.Name))
+		}
+		seen[s.Name] = true
+	}
+
+	d := &Datastore{
+		vnodes: opts.VirtualNodes,
+		shards: append([]Shard(nil), shards...),
+	}
+	d.ring = newRing(d.names(), d.vnodes)
+	return d
+}
+
+func (d *Datastore) names() []string {
+	names := make([]string, len(d.shards))
+	for i, s := range d.shards {
+		names[i] = s.Name
+	}
+	return names
+}
+
+// Children implements Shim
+func (d *Datastore) Children() []ds.Datastore {
+	d.lk.RLock()
+	defer d.lk.RUnlock()
+	children := make([]ds.Datastore, len(d.shards))
+	for i, s := range d.shards {
+		children[i] = s.Datastore
+	}
+	return children
+}
+
+// Capabilities implements Capable
+func (d *Datastore) Capabilities() ds.Feature {
+	var any ds.Feature
+	for _, c := range d.Children() {
+		any |= ds.Features(c) & ds.FeatureMaintenance
+	}
+	return ds.FeatureBatching | any
+}
+
+// ShardFor returns the name of the shard the given key lives in.
+func (d *Datastore) ShardFor(k key.Key) string {
+	d.lk.RLock()
+	defer d.lk.RUnlock()
+	return d.shards[d.ring.lookup(k.Bytes())].Name
+}
+
+// route returns the shard a key lives in, and the shard it's moving from if
+// it's moving, -1 otherwise. The caller must hold d.lk.
+func (d *Datastore) route(k key.Key) (int, int) {
+	owner := d.ring.lookup(k.Bytes())
+	if d.prev != nil {
+		if old := d.prev.lookup(k.Bytes()); old != owner {
+			return owner, old
+		}
+	}
+	return owner, -1
+}
+
+// Get implements Datastore.Get
+func (d *Datastore) Get(ctx context.Context, key key.Key) (value []byte, err error) {
+	d.lk.RLock()
+	defer d.lk.RUnlock()
+	owner, old := d.route(key)
+	value, err = d.shards[owner].Datastore.Get(ctx, key)
+	if old >= 0 && ds.IsNotFound(err) {
+		value, err = d.shards[old].Datastore.Get(ctx, key)
+	}
+	return value, err
+}
+
+// Has implements Datastore.Has
+func (d *Datastore) Has(ctx context.Context, key key.Key) (exists bool, err error) {
+	d.lk.RLock()
+	defer d.lk.RUnlock()
+	owner, old := d.route(key)
+	exists, err = d.shards[owner].Datastore.Has(ctx, key)
+	if old >= 0 && err == nil && !exists {
+		exists, err = d.shards[old].Datastore.Has(ctx, key)
+	}
+	return exists, err
+}
+
+// GetSize implements Datastore.GetSize
+func (d *Datastore) GetSize(ctx context.Context, key key.Key) (size int, err error) {
+	d.lk.RLock()
+	defer d.lk.RUnlock()
+	owner, old := d.route(key)
+	size, err = d.shards[owner].Datastore.GetSize(ctx, key)
+	if old >= 0 && ds.IsNotFound(err) {
+		size, err = d.shards[old].Datastore.GetSize(ctx, key)
+	}
+	return size, err
+}
+
+// Put implements Datastore.Put
+func (d *Datastore) Put(ctx context.Context, key key.Key, value []byte) error {
+	d.lk.RLock()
+	defer d.lk.RUnlock()
+	owner, old := d.route(key)
+	if err := d.shards[owner].Datastore.Put(ctx, key, value); err != nil {
+		return err
+	}
+	if old >= 0 {
+		return d.shards[old].Datastore.Delete(ctx, key)
+	}
+	return nil
+}
+
+// Delete implements Datastore.Delete
+func (d *Datastore) Delete(ctx context.Context, key key.Key) error {
+	d.lk.RLock()
+	defer d.lk.RUnlock()
+	owner, old := d.route(key)
+	if err := d.shards[owner].Datastore.Delete(ctx, key); err != nil {
+		return err
+	}
+	if old >= 0 {
+		return d.shards[old].Datastore.Delete(ctx, key)
+	}
+	return nil
+}
+
+// Sync implements Datastore.Sync by syncing all the shards.
+func (d *Datastore) Sync(ctx context.Context, prefix key.Key) error {
+	return d.each(func(s Shard) error {
+		if err := s.Datastore.Sync(ctx, prefix); err != nil {
+			return fmt.Errorf("syncing shard %s: %w", s.Name, err)
+		}
+		return nil
+	})
+}
+
+// Close closes all the shards.
+func (d *Datastore) Close() error {
+	return d.each(func(s Shard) error {
+		if err := s.Datastore.Close(); err != nil {
+			return fmt.Errorf("closing shard %s: %w", s.Name, err)
+		}
+		return nil
+	})
+}
+
+// each calls `fn` for each shard and combines the errors.
+func (d *Datastore) each(fn func(s Shard) error) error {
+	d.lk.RLock()
+	shards := d.shards
+	d.lk.RUnlock()
+
+	var merr error
+	for _, s := range shards {
+		merr = multierr.Append(merr, fn(s))
+	}
+	return merr
+}
+
+// DiskUsage implements the PersistentDatastore interface, it returns the sum
+// of the disk usages of the shards.
+func (d *Datastore) DiskUsage(ctx context.Context) (uint64, error) {
+	var duTotal uint64
+	err := d.each(func(s Shard) error {
+		du, err := ds.DiskUsage(ctx, s.Datastore)
+		duTotal += du
+		if err != nil {
+			return fmt.Errorf("getting disk usage of shard %s: %w", s.Name, err)
+		}
+		return nil
+	})
+	return duTotal, err
+}
+
+// Check implements CheckedDatastore.Check
+func (d *Datastore) Check(ctx context.Context) error {
+	return d.each(func(s Shard) error {
+		if c, ok := s.Datastore.(ds.CheckedDatastore); ok {
+			if err := c.Check(ctx); err != nil {
+				return fmt.Errorf("checking shard %s: %w", s.Name, err)
+			}
+		}
+		return nil
+	})
+}
+
+// Scrub implements ScrubbedDatastore.Scrub
+func (d *Datastore) Scrub(ctx context.Context) error {
+	return d.each(func(s Shard) error {
+		if c, ok := s.Datastore.(ds.ScrubbedDatastore); ok {
+			if err := c.Scrub(ctx); err != nil {
+				return fmt.Errorf("scrubbing shard %s: %w", s.Name, err)
+			}
+		}
+		return nil
+	})
+}
+
+// CollectGarbage implements GCDatastore.CollectGarbage
+func (d *Datastore) CollectGarbage(ctx context.Context) error {
+	return d.each(func(s Shard) error {
+		if c, ok := s.Datastore.(ds.GCDatastore); ok {
+			if err := c.CollectGarbage(ctx); err != nil {
+				return fmt.Errorf("collecting garbage of shard %s: %w", s.Name, err)
+			}
+		}
+		return nil
+	})
+}
+
+// AddShard adds a shard and moves to it the keys it now owns from the other
+// shards, which are the only keys that move. The datastore can be used
+// while the keys are being moved, but queries may miss or return twice the
+// entries moved during the query.
+//
+// If AddShard fails, the shard stays added and the remaining keys can be
+// moved by calling AddShard again with the same shard name, other shards
+// can't be added meanwhile.
+func (d *Datastore) AddShard(ctx context.Context, s Shard) error {
+	d.lk.Lock()
+	if d.prev != nil {
+		if d.shards[len(d.shards)-1].Name != s.Name {
+			d.lk.Unlock()
+			return ErrResharding
+		}
+		// Resume.
+	} else {
+		for _, c := range d.shards {
+			if c.Name == s.Name {
+				d.lk.Unlock()
+				return ErrDuplicateShard
+			}
+		}
+		d.shards = append(d.shards, s)
+		d.prev = d.ring
+		d.ring = newRing(d.names(), d.vnodes)
+	}
+	shards := d.shards
+	d.lk.Unlock()
+
+	to := len(shards) - 1
+	for from := 0; from < to; from++ {
+		if err := d.moveFrom(ctx, from, to); err != nil {
+			return fmt.Errorf("moving keys from shard %s: %w", shards[from].Name, err)
+		}
+	}
+
+	d.lk.Lock()
+	d.prev = nil
+	d.lk.Unlock()
+	return nil
+}
+
+// moveFrom moves the keys of shard `from` which moved to shard `to`.
+func (d *Datastore) moveFrom(ctx context.Context, from, to int) error {
+	d.lk.RLock()
+	src, dst := d.shards[from].Datastore, d.shards[to].Datastore
+	ring := d.ring
+	d.lk.RUnlock()
+
+	res, err := src.Query(ctx, dsq.Query{KeysOnly: true})
+	if err != nil {
+		return err
+	}
+	// Collect the keys first, shards may not support writes during queries.
+	var keys []key.Key
+	for {
+		r, ok := res.NextSync()
+		if !ok {
+			break
+		}
+		if r.Error != nil {
+			res.Close()
+			return r.Error
+		}
+		if ring.lookup(r.Key.Bytes()) == to {
+			keys = append(keys, r.Key)
+		}
+	}
+	if err := res.Close(); err != nil {
+		return err
+	}
+
+	for _, k := range keys {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := d.move(ctx, k, src, dst); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *Datastore) move(ctx context.Context, k key.Key, src, dst ds.Datastore) error {
+	d.lk.Lock()
+	defer d.lk.Unlock()
+	value, err := src.Get(ctx, k)
+	switch {
+	case ds.IsNotFound(err):
+		// Written to its new shard or deleted meanwhile.
+		return nil
+	case err != nil:
+		return err
+	}
+	if err := dst.Put(ctx, k, value); err != nil {
+		return err
+	}
+	return src.Delete(ctx, k)
+}
+
+// Batch implements Batching.Batch, the operations are split per shard on
+// Commit, using ds.NewBasicBatch for the shards which aren't Batching.
+func (d *Datastore) Batch(ctx context.Context) (ds.Batch, error) {
+	return &shardBatch{d: d}, nil
+}
+
+type batchOp struct {
+	key    key.Key
+	value  []byte
+	delete bool
+}
+
+// shardBatch records the operations and splits them on Commit, as the shards
+// of the keys may change meanwhile.
+type shardBatch struct {
+	d   *Datastore
+	ops []batchOp
+}
+
+func (b *shardBatch) Put(ctx context.Context, key key.Key, value []byte) error {
+	b.ops = append(b.ops, batchOp{key: key, value: value})
+	return nil
+}
+
+func (b *shardBatch) Delete(ctx context.Context, key key.Key) error {
+	b.ops = append(b.ops, batchOp{key: key, delete: true})
+	return nil
+}
+
+func (b *shardBatch) Commit(ctx context.Context) error {
+	d := b.d
+	d.lk.RLock()
+	defer d.lk.RUnlock()
+
+	batches := make(map[int]ds.Batch)
+	batch := func(i int) (ds.Batch, error) {
+		if sb, ok := batches[i]; ok {
+			return sb, nil
+		}
+		var sb ds.Batch
+		if bds, ok := d.shards[i].Datastore.(ds.Batching); ok {
+			var err error
+			if sb, err = bds.Batch(ctx); err != nil {
+				return nil, fmt.Errorf("creating batch of shard %s: %w", d.shards[i].Name, err)
+			}
+		} else {
+			sb = ds.NewBasicBatch(d.shards[i].Datastore)
+		}
+		batches[i] = sb
+		return sb, nil
+	}
+
+	for _, op := range b.ops {
+		owner, old := d.route(op.key)
+		sb, err := batch(owner)
+		if err != nil {
+			return err
+		}
+		if op.delete {
+			err = sb.Delete(ctx, op.key)
+		} else {
+			err = sb.Put(ctx, op.key, op.value)
+		}
+		if err != nil {
+			return err
+		}
+		if old >= 0 {
+			ob, err := batch(old)
+			if err != nil {
+				return err
+			}
+			if err := ob.Delete(ctx, op.key); err != nil {
+				return err
+			}
+		}
+	}
+
+	var merr error
+	for i, sb := range batches {
+		if err := sb.Commit(ctx); err != nil {
+			merr = multierr.Append(merr, fmt.Errorf("committing to shard %s: %w", d.shards[i].Name, err))
+		}
+	}
+	if merr == nil {
+		b.ops = nil
+	}
+	return merr
+}
@@ -0,0 +1,270 @@
+// Copyright (c) 2020 DAOT Labs. All rights reserved. Use of this source
+// code is governed by MIT license that can be found in the LICENSE file.
+
+package shard
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	ds "github.com/daotl/go-datastore"
+	key "github.com/daotl/go-datastore/key"
+	dsq "github.com/daotl/go-datastore/query"
+	dssync "github.com/daotl/go-datastore/sync"
+	dstest "github.com/daotl/go-datastore/test"
+)
+
+func newShards(t *testing.T, ktype key.KeyType, n int) []Shard {
+	shards := make([]Shard, n)
+	for i := range shards {
+		shards[i] = Shard{
+			Name:      fmt.Sprintf("shard%d", i),
+			Datastore: dssync.MutexWrap(dstest.NewMapDatastoreForTest(t, ktype)),
+		}
+	}
+	return shards
+}
+
+func testSuite(t *testing.T, ktype key.KeyType) {
+	d := New(newShards(t, ktype, 4), Options{})
+	dstest.SubtestAll(t, ktype, d)
+}
+
+func TestSuite(t *testing.T) {
+	testSuite(t, key.KeyTypeString)
+	testSuite(t, key.KeyTypeBytes)
+}
+
+func k(i int) key.Key {
+	return key.NewStrKey(fmt.Sprintf("/%04d", i))
+}
+
+func count(t *testing.T, d ds.Datastore) int {
+	t.Helper()
+	res, err := d.Query(context.Background(), dsq.Query{KeysOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	es, err := res.Rest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(es)
+}
+
+func TestDistribution(t *testing.T) {
+	ctx := context.Background()
+	shards := newShards(t, key.KeyTypeString, 4)
+	d := New(shards, Options{})
+	for i := 0; i < 1000; i++ {
+		if err := d.Put(ctx, k(i), []byte("v")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, s := range shards {
+		// 250 on average.
+		if n := count(t, s.Datastore); n < 150 || n > 350 {
+			t.Fatalf("unbalanced shard %s with %d keys", s.Name, n)
+		}
+	}
+	for i := 0; i < 1000; i++ {
+		name := d.ShardFor(k(i))
+		for _, s := range shards {
+			if has, _ := s.Datastore.Has(ctx, k(i)); has != (s.Name == name) {
+				t.Fatalf("key %s should only be in shard %s", k(i), name)
+			}
+		}
+	}
+}
+
+func TestQuery(t *testing.T) {
+	ctx := context.Background()
+	d := New(newShards(t, key.KeyTypeString, 3), Options{})
+	for i := 0; i < 100; i++ {
+		d.Put(ctx, k(i), []byte(fmt.Sprintf("%03d", 99-i)))
+	}
+
+	res, err := d.Query(ctx, dsq.Query{
+		Orders: []dsq.Order{dsq.OrderByValue{}},
+		Offset: 10,
+		Limit:  20,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	es, err := res.Rest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(es) != 20 {
+		t.Fatalf("expected 20 entries, got %d", len(es))
+	}
+	for i, e := range es {
+		if !e.Key.Equal(k(89 - i)) {
+			t.Fatalf("expected %s at %d, got %s", k(89-i), i, e.Key)
+		}
+	}
+}
+
+func TestBatch(t *testing.T) {
+	ctx := context.Background()
+	shards := newShards(t, key.KeyTypeString, 3)
+	d := New(shards, Options{})
+
+	b, err := d.Batch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 30; i++ {
+		b.Put(ctx, k(i), []byte("v"))
+	}
+	b.Delete(ctx, k(0))
+	if count(t, d) != 0 {
+		t.Fatal("nothing should be written before Commit")
+	}
+	if err := b.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if n := count(t, d); n != 29 {
+		t.Fatalf("expected 29 entries, got %d", n)
+	}
+	for _, s := range shards {
+		if count(t, s.Datastore) == 0 {
+			t.Fatalf("expected shard %s to be written to", s.Name)
+		}
+	}
+}
+
+func TestAddShard(t *testing.T) {
+	ctx := context.Background()
+	shards := newShards(t, key.KeyTypeString, 4)
+	d := New(shards[:3], Options{})
+	for i := 0; i < 1000; i++ {
+		d.Put(ctx, k(i), []byte("v"))
+	}
+	before := make(map[string]string)
+	for i := 0; i < 1000; i++ {
+		before[k(i).String()] = d.ShardFor(k(i))
+	}
+
+	if err := d.AddShard(ctx, shards[3]); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.AddShard(ctx, shards[3]); err != ErrDuplicateShard {
+		t.Fatal("expected ErrDuplicateShard, got: ", err)
+	}
+
+	moved := 0
+	for i := 0; i < 1000; i++ {
+		after := d.ShardFor(k(i))
+		if after != before[k(i).String()] {
+			if after != "shard3" {
+				t.Fatalf("key %s moved between old shards", k(i))
+			}
+			moved++
+		}
+		if v, err := d.Get(ctx, k(i)); err != nil || string(v) != "v" {
+			t.Fatalf("unexpected value %q, error: %v", v, err)
+		}
+	}
+	if n := count(t, shards[3].Datastore); n != moved || moved < 150 || moved > 350 {
+		t.Fatalf("expected about a quarter of the keys to move, moved %d, shard has %d", moved, n)
+	}
+	if n := count(t, d); n != 1000 {
+		t.Fatalf("expected 1000 entries, got %d", n)
+	}
+}
+
+func TestResumeAddShard(t *testing.T) {
+	ctx := context.Background()
+	shards := newShards(t, key.KeyTypeString, 3)
+	d := New(shards[:2], Options{})
+	for i := 0; i < 100; i++ {
+		d.Put(ctx, k(i), []byte("v"))
+	}
+
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := d.AddShard(cctx, shards[2]); err == nil {
+		t.Fatal("expected the resharding to be interrupted")
+	}
+	if err := d.AddShard(ctx, Shard{Name: "other"}); err != ErrResharding {
+		t.Fatal("expected ErrResharding, got: ", err)
+	}
+
+	// The keys can be read and written while resharding.
+	for i := 0; i < 100; i++ {
+		if v, err := d.Get(ctx, k(i)); err != nil || string(v) != "v" {
+			t.Fatalf("unexpected value %q, error: %v", v, err)
+		}
+	}
+	for i := 0; i < 100; i += 2 {
+		d.Put(ctx, k(i), []byte("new"))
+	}
+
+	if err := d.AddShard(ctx, shards[2]); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		expected := "v"
+		if i%2 == 0 {
+			expected = "new"
+		}
+		if v, err := d.Get(ctx, k(i)); err != nil || string(v) != expected {
+			t.Fatalf("unexpected value %q, error: %v", v, err)
+		}
+	}
+	if n := count(t, d); n != 100 {
+		t.Fatalf("expected 100 entries, got %d", n)
+	}
+}
+
+func TestConcurrentAddShard(t *testing.T) {
+	ctx := context.Background()
+	shards := newShards(t, key.KeyTypeString, 3)
+	d := New(shards[:2], Options{})
+	for i := 0; i < 1000; i++ {
+		d.Put(ctx, k(i), []byte("old"))
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			if err := d.Put(ctx, k(i), []byte("new")); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	if err := d.AddShard(ctx, shards[2]); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+
+	for i := 0; i < 1000; i++ {
+		if v, err := d.Get(ctx, k(i)); err != nil || string(v) != "new" {
+			t.Fatalf("unexpected value %q for %s, error: %v", v, k(i), err)
+		}
+	}
+	if n := count(t, d); n != 1000 {
+		t.Fatalf("expected 1000 entries, got %d", n)
+	}
+}
+
+func TestDiskUsage(t *testing.T) {
+	ctx := context.Background()
+	d := New(newShards(t, key.KeyTypeString, 2), Options{})
+	if _, err := d.DiskUsage(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Sync(ctx, key.NewStrKey("/")); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
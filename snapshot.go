@@ -0,0 +1,148 @@
+// Copyright (c) 2020 DAOT Labs. All rights reserved. Use of this source
+// code is governed by MIT license that can be found in the LICENSE file.
+
+package datastore
+
+import (
+	"context"
+	"errors"
+
+	"github.com/daotl/go-datastore/key"
+	dsq "github.com/daotl/go-datastore/query"
+)
+
+// Snapshot is a read-only, point-in-time view of a datastore: it sees the
+// entries of the datastore at the time it was taken, regardless of the
+// writes made to the datastore afterwards. A Snapshot can be used
+// concurrently with writes to its datastore, but like the datastore itself
+// it isn't necessarily safe for concurrent use.
+type Snapshot interface {
+	Read
+
+	// Close releases the resources held by the snapshot, which can't be used
+	// anymore.
+	Close() error
+}
+
+// SnapshotDatastore is an interface that should be implemented by datastores
+// which can take consistent point-in-time snapshots of their entries, to
+// make backups or consistent exports.
+type SnapshotDatastore interface {
+	Datastore
+
+	// Snapshot takes a snapshot of the datastore, which must be closed once
+	// done with.
+	Snapshot(ctx context.Context) (Snapshot, error)
+}
+
+// ErrSnapshotUnsupported is returned by Snapshot on wrappers whose child
+// doesn't support snapshots.
+var ErrSnapshotUnsupported = errors.New("this datastore does not support snapshots")
+
+// NewSnapshot takes a snapshot of `d` if it's a SnapshotDatastore, otherwise
+// it returns ErrSnapshotUnsupported.
+func NewSnapshot(ctx context.Context, d Datastore) (Snapshot, error) {
+	if sds, ok := d.(SnapshotDatastore); ok {
+		return sds.Snapshot(ctx)
+	}
+	return nil, ErrSnapshotUnsupported
+}
+
+// SnapshotAdapter makes a Datastore out of a Snapshot, so that wrappers can
+// be stacked on top of snapshots. All the writes fail with ErrReadOnly.
+type SnapshotAdapter struct {
+	Snapshot
+}
+
+var _ Datastore = (*SnapshotAdapter)(nil)
+
+// NewSnapshotAdapter wraps `s` in a SnapshotAdapter.
+func NewSnapshotAdapter(s Snapshot) *SnapshotAdapter {
+	return &SnapshotAdapter{Snapshot: s}
+}
+
+// Put implements Datastore.Put
+func (d *SnapshotAdapter) Put(ctx context.Context, key key.Key, value []byte) error {
+	return ErrReadOnly
+}
+
+// Delete implements Datastore.Delete
+func (d *SnapshotAdapter) Delete(ctx context.Context, key key.Key) error {
+	return ErrReadOnly
+}
+
+// Sync implements Datastore.Sync
+func (d *SnapshotAdapter) Sync(ctx context.Context, prefix key.Key) error {
+	return nil
+}
+
+// mapSnapshot is the Snapshot of a MapDatastore, it shares the values of the
+// datastore until the datastore is written to.
+type mapSnapshot struct {
+	d *MapDatastore
+}
+
+var _ Snapshot = (*mapSnapshot)(nil)
+
+// Snapshot implements SnapshotDatastore.Snapshot. It's constant time: the
+// values are shared with the snapshot and only copied by the next write.
+func (d *MapDatastore) Snapshot(ctx context.Context) (Snapshot, error) {
+	d.shared = true
+	return &mapSnapshot{d: &MapDatastore{
+		ktype:  d.ktype,
+		values: d.values,
+		shared: true,
+	}}, nil
+}
+
+// writable returns the values of the datastore to modify them, after
+// copying them if they're shared with a snapshot.
+func (d *MapDatastore) writable() map[string][]byte {
+	if d.shared {
+		values := make(map[string][]byte, len(d.values))
+		for k, v := range d.values {
+			values[k] = v
+		}
+		d.values = values
+		d.shared = false
+	}
+	return d.values
+}
+
+// Get implements Read.Get
+func (s *mapSnapshot) Get(ctx context.Context, key key.Key) ([]byte, error) {
+	if s.d == nil {
+		return nil, ErrClosed
+	}
+	return s.d.Get(ctx, key)
+}
+
+// Has implements Read.Has
+func (s *mapSnapshot) Has(ctx context.Context, key key.Key) (bool, error) {
+	if s.d == nil {
+		return false, ErrClosed
+	}
+	return s.d.Has(ctx, key)
+}
+
+// GetSize implements Read.GetSize
+func (s *mapSnapshot) GetSize(ctx context.Context, key key.Key) (int, error) {
+	if s.d == nil {
+		return -1, ErrClosed
+	}
+	return s.d.GetSize(ctx, key)
+}
+
+// Query implements Read.Query
+func (s *mapSnapshot) Query(ctx context.Context, q dsq.Query) (dsq.Results, error) {
+	if s.d == nil {
+		return nil, ErrClosed
+	}
+	return s.d.Query(ctx, q)
+}
+
+// Close implements Snapshot.Close
+func (s *mapSnapshot) Close() error {
+	s.d = nil
+	return nil
+}
@@ -0,0 +1,74 @@
+// Copyright (c) 2020 DAOT Labs. All rights reserved. Use of this source
+// code is governed by MIT license that can be found in the LICENSE file.
+
+package datastore_test
+
+import (
+	"context"
+	"testing"
+
+	dstore "github.com/daotl/go-datastore"
+	"github.com/daotl/go-datastore/key"
+	dstest "github.com/daotl/go-datastore/test"
+)
+
+func TestMapSnapshots(t *testing.T) {
+	ctx := context.Background()
+	ds := dstest.NewMapDatastoreForTest(t, key.KeyTypeString)
+	k := key.NewStrKey("/foo")
+
+	var snapshots []dstore.Snapshot
+	for _, v := range []string{"a", "b", "c"} {
+		if err := ds.Put(ctx, k, []byte(v)); err != nil {
+			t.Fatal(err)
+		}
+		s, err := ds.Snapshot(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		snapshots = append(snapshots, s)
+	}
+	if err := ds.Delete(ctx, k); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, v := range []string{"a", "b", "c"} {
+		got, err := snapshots[i].Get(ctx, k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != v {
+			t.Fatalf("snapshot %d: expected %q, got %q", i, v, got)
+		}
+	}
+
+	if err := snapshots[0].Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := snapshots[0].Get(ctx, k); !dstore.IsClosed(err) {
+		t.Fatal("expected ErrClosed from a closed snapshot, got: ", err)
+	}
+}
+
+func TestSnapshotAdapter(t *testing.T) {
+	ctx := context.Background()
+	ds := dstest.NewMapDatastoreForTest(t, key.KeyTypeString)
+
+	s, err := dstore.NewSnapshot(ctx, ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := dstore.NewSnapshotAdapter(s)
+	defer a.Close()
+	if err := a.Put(ctx, key.NewStrKey("/foo"), nil); !dstore.IsReadOnly(err) {
+		t.Fatal("expected ErrReadOnly, got: ", err)
+	}
+	if err := a.Delete(ctx, key.NewStrKey("/foo")); !dstore.IsReadOnly(err) {
+		t.Fatal("expected ErrReadOnly, got: ", err)
+	}
+
+	txnds := newTxnMapDatastore(t, key.KeyTypeString)
+	if _, err := dstore.NewSnapshot(ctx, txnds); err != dstore.ErrSnapshotUnsupported {
+		t.Fatal("expected ErrSnapshotUnsupported, got: ", err)
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright (c) 2020 DAOT Labs. All rights reserved. Use of this source
+// code is governed by MIT license that can be found in the LICENSE file.
+
+package datastore
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+
+	"github.com/daotl/go-datastore/key"
+)
+
+// StreamingRead is implemented by datastores which can stream values out
+// instead of loading them into memory as a whole.
+type StreamingRead interface {
+	// GetReader returns a reader of the object named by `key`, which must be
+	// closed by the caller. Like Get, it returns ErrNotFound if the key is
+	// not mapped to a value.
+	GetReader(ctx context.Context, key key.Key) (io.ReadCloser, error)
+}
+
+// StreamingWrite is implemented by datastores which can stream values in
+// instead of requiring them to be loaded into memory as a whole.
+type StreamingWrite interface {
+	// PutReader stores the object read from `r` until EOF under `key`. The
+	// value is not stored if reading from `r` fails.
+	PutReader(ctx context.Context, key key.Key, r io.Reader) error
+}
+
+// StreamingDatastore is an interface that should be implemented by
+// datastores which can store and retrieve large values without buffering
+// them in memory.
+type StreamingDatastore interface {
+	Datastore
+	StreamingRead
+	StreamingWrite
+}
+
+// GetReader calls d.GetReader if `d` is a StreamingRead, otherwise it falls
+// back to d.Get and returns a reader over the value.
+func GetReader(ctx context.Context, d Read, key key.Key) (io.ReadCloser, error) {
+	if sd, ok := d.(StreamingRead); ok {
+		return sd.GetReader(ctx, key)
+	}
+	value, err := d.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(bytes.NewReader(value)), nil
+}
+
+// PutReader calls d.PutReader if `d` is a StreamingWrite, otherwise it reads
+// `r` into memory and falls back to d.Put.
+func PutReader(ctx context.Context, d Write, key key.Key, r io.Reader) error {
+	if sd, ok := d.(StreamingWrite); ok {
+		return sd.PutReader(ctx, key, r)
+	}
+	value, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return d.Put(ctx, key, value)
+}
+
+// StreamingAdapter adds GetReader and PutReader to a datastore which only
+// supports []byte values, by buffering the values in memory. If the child
+// is itself a StreamingDatastore, the calls are passed through.
+type StreamingAdapter struct {
+	Datastore
+}
+
+var _ StreamingDatastore = (*StreamingAdapter)(nil)
+var _ Batching = (*StreamingAdapter)(nil)
+var _ Shim = (*StreamingAdapter)(nil)
+
+// NewStreamingAdapter returns `d` if it's already a StreamingDatastore,
+// otherwise it wraps `d` in a StreamingAdapter.
+func NewStreamingAdapter(d Datastore) StreamingDatastore {
+	if sd, ok := d.(StreamingDatastore); ok {
+		return sd
+	}
+	return &StreamingAdapter{Datastore: d}
+}
+
+// Children implements Shim
+func (d *StreamingAdapter) Children() []Datastore {
+	return []Datastore{d.Datastore}
+}
+
+// Capabilities implements Capable, FeatureStreaming isn't reported as the
+// values are buffered in memory.
+func (d *StreamingAdapter) Capabilities() Feature {
+	return Features(d.Datastore) & (FeatureBatching | FeaturePersistent)
+}
+
+// GetReader implements StreamingRead.GetReader
+func (d *StreamingAdapter) GetReader(ctx context.Context, key key.Key) (io.ReadCloser, error) {
+	return GetReader(ctx, d.Datastore, key)
+}
+
+// PutReader implements StreamingWrite.PutReader
+func (d *StreamingAdapter) PutReader(ctx context.Context, key key.Key, r io.Reader) error {
+	return PutReader(ctx, d.Datastore, key, r)
+}
+
+// Batch implements Batching.Batch
+func (d *StreamingAdapter) Batch(ctx context.Context) (Batch, error) {
+	if bds, ok := d.Datastore.(Batching); ok {
+		return bds.Batch(ctx)
+	}
+	return nil, ErrBatchUnsupported
+}
+
+// DiskUsage implements the PersistentDatastore interface.
+func (d *StreamingAdapter) DiskUsage(ctx context.Context) (uint64, error) {
+	return DiskUsage(ctx, d.Datastore)
+}
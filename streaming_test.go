@@ -0,0 +1,59 @@
+// Copyright (c) 2020 DAOT Labs. All rights reserved. Use of this source
+// code is governed by MIT license that can be found in the LICENSE file.
+
+package datastore_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"testing"
+
+	dstore "github.com/daotl/go-datastore"
+	"github.com/daotl/go-datastore/key"
+	dstest "github.com/daotl/go-datastore/test"
+)
+
+func testStreamingAdapter(t *testing.T, ktype key.KeyType) {
+	ds := dstore.NewStreamingAdapter(dstest.NewMapDatastoreForTest(t, ktype))
+	if _, ok := ds.(*dstore.StreamingAdapter); !ok {
+		t.Fatal("expected MapDatastore to be wrapped in a StreamingAdapter")
+	}
+	dstest.SubtestAll(t, ktype, ds)
+
+	// Datastores which already stream are not wrapped again.
+	if dstore.NewStreamingAdapter(ds) != ds {
+		t.Fatal("expected StreamingDatastore to be returned as is")
+	}
+}
+
+func TestStreamingAdapter(t *testing.T) {
+	testStreamingAdapter(t, key.KeyTypeString)
+	testStreamingAdapter(t, key.KeyTypeBytes)
+}
+
+func TestStreamingHelpers(t *testing.T) {
+	ctx := context.Background()
+	ds := dstest.NewMapDatastoreForTest(t, key.KeyTypeString)
+
+	k := key.NewStrKey("/foo")
+	if err := dstore.PutReader(ctx, ds, k, bytes.NewReader([]byte("bar"))); err != nil {
+		t.Fatal(err)
+	}
+	r, err := dstore.GetReader(ctx, ds, k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	v, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(v) != "bar" {
+		t.Fatalf("expected to read %q, got %q", "bar", v)
+	}
+
+	if _, err := dstore.GetReader(ctx, ds, key.NewStrKey("/missing")); !dstore.IsNotFound(err) {
+		t.Fatal("expected ErrNotFound, got: ", err)
+	}
+}
@@ -6,7 +6,10 @@
 package sync
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"io"
 	"sync"
 
 	ds "github.com/daotl/go-datastore"
@@ -33,6 +36,14 @@ func (d *MutexDatastore) Children() []ds.Datastore {
 	return []ds.Datastore{d.child}
 }
 
+// Capabilities implements Capable, conditional writes are always supported
+// as they are emulated while holding the lock if the child doesn't support
+// them.
+func (d *MutexDatastore) Capabilities() ds.Feature {
+	return ds.Features(d.child)&(ds.FeatureBatching|ds.FeatureMaintenance|
+		ds.FeatureStreaming|ds.FeatureBulk|ds.FeatureSnapshot) | ds.FeatureCAS
+}
+
 // Put implements Datastore.Put
 func (d *MutexDatastore) Put(ctx context.Context, key key.Key, value []byte) (err error) {
 	d.Lock()
@@ -75,6 +86,110 @@ func (d *MutexDatastore) Delete(ctx context.Context, key key.Key) (err error) {
 	return d.child.Delete(ctx, key)
 }
 
+// GetReader implements StreamingRead.GetReader, the lock is only held while
+// opening the reader, not while reading from it.
+func (d *MutexDatastore) GetReader(ctx context.Context, key key.Key) (io.ReadCloser, error) {
+	d.RLock()
+	defer d.RUnlock()
+	return ds.GetReader(ctx, d.child, key)
+}
+
+// PutReader implements StreamingWrite.PutReader, the lock is held until `r`
+// has been fully consumed.
+func (d *MutexDatastore) PutReader(ctx context.Context, key key.Key, r io.Reader) error {
+	d.Lock()
+	defer d.Unlock()
+	return ds.PutReader(ctx, d.child, key, r)
+}
+
+// GetMany implements BulkRead.GetMany
+func (d *MutexDatastore) GetMany(ctx context.Context, keys []key.Key) ([]ds.ValueResult, error) {
+	d.RLock()
+	defer d.RUnlock()
+	return ds.GetMany(ctx, d.child, keys)
+}
+
+// HasMany implements BulkRead.HasMany
+func (d *MutexDatastore) HasMany(ctx context.Context, keys []key.Key) ([]ds.HasResult, error) {
+	d.RLock()
+	defer d.RUnlock()
+	return ds.HasMany(ctx, d.child, keys)
+}
+
+// GetSizeMany implements BulkRead.GetSizeMany
+func (d *MutexDatastore) GetSizeMany(ctx context.Context, keys []key.Key) ([]ds.SizeResult, error) {
+	d.RLock()
+	defer d.RUnlock()
+	return ds.GetSizeMany(ctx, d.child, keys)
+}
+
+// PutMany implements BulkWrite.PutMany
+func (d *MutexDatastore) PutMany(ctx context.Context, keys []key.Key, values [][]byte) error {
+	d.Lock()
+	defer d.Unlock()
+	return ds.PutMany(ctx, d.child, keys, values)
+}
+
+// PutIfAbsent implements CAS.PutIfAbsent. If the child doesn't support
+// conditional writes, they are emulated while holding the lock, which is
+// only atomic as long as the child isn't accessed by other means.
+func (d *MutexDatastore) PutIfAbsent(ctx context.Context, key key.Key, value []byte) (ok bool, err error) {
+	d.Lock()
+	defer d.Unlock()
+	ok, err = ds.PutIfAbsent(ctx, d.child, key, value)
+	if !errors.Is(err, ds.ErrCASUnsupported) {
+		return ok, err
+	}
+	if found, err := d.child.Has(ctx, key); err != nil || found {
+		return false, err
+	}
+	return true, d.child.Put(ctx, key, value)
+}
+
+// CompareAndSwap implements CAS.CompareAndSwap, emulating it while holding
+// the lock if the child doesn't support conditional writes.
+func (d *MutexDatastore) CompareAndSwap(ctx context.Context, key key.Key, old, new []byte) (ok bool, err error) {
+	d.Lock()
+	defer d.Unlock()
+	ok, err = ds.CompareAndSwap(ctx, d.child, key, old, new)
+	if !errors.Is(err, ds.ErrCASUnsupported) {
+		return ok, err
+	}
+	if equal, err := d.equal(ctx, key, old); err != nil || !equal {
+		return false, err
+	}
+	return true, d.child.Put(ctx, key, new)
+}
+
+// DeleteIfEqual implements CAS.DeleteIfEqual, emulating it while holding the
+// lock if the child doesn't support conditional writes.
+func (d *MutexDatastore) DeleteIfEqual(ctx context.Context, key key.Key, value []byte) (ok bool, err error) {
+	d.Lock()
+	defer d.Unlock()
+	ok, err = ds.DeleteIfEqual(ctx, d.child, key, value)
+	if !errors.Is(err, ds.ErrCASUnsupported) {
+		return ok, err
+	}
+	if equal, err := d.equal(ctx, key, value); err != nil || !equal {
+		return false, err
+	}
+	return true, d.child.Delete(ctx, key)
+}
+
+// equal reports whether `key` is mapped to `value`, the caller must hold the
+// lock.
+func (d *MutexDatastore) equal(ctx context.Context, key key.Key, value []byte) (bool, error) {
+	current, err := d.child.Get(ctx, key)
+	switch {
+	case err == nil:
+		return bytes.Equal(current, value), nil
+	case ds.IsNotFound(err):
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
 // Query implements Datastore.Query
 func (d *MutexDatastore) Query(ctx context.Context, q dsq.Query) (dsq.Results, error) {
 	d.RLock()
@@ -99,6 +214,18 @@ func (d *MutexDatastore) Query(ctx context.Context, q dsq.Query) (dsq.Results, e
 	return dsq.ResultsWithEntries(q, entries), nil
 }
 
+// Snapshot implements SnapshotDatastore.Snapshot, the snapshot of the child
+// is taken while holding the lock and is itself wrapped with a lock.
+func (d *MutexDatastore) Snapshot(ctx context.Context) (ds.Snapshot, error) {
+	d.Lock()
+	defer d.Unlock()
+	s, err := ds.NewSnapshot(ctx, d.child)
+	if err != nil {
+		return nil, err
+	}
+	return MutexWrap(ds.NewSnapshotAdapter(s)), nil
+}
+
 func (d *MutexDatastore) Batch(ctx context.Context) (ds.Batch, error) {
 	d.RLock()
 	defer d.RUnlock()
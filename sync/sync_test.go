@@ -6,8 +6,13 @@
 package sync
 
 import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
 	"testing"
 
+	ds "github.com/daotl/go-datastore"
 	"github.com/daotl/go-datastore/key"
 	dstest "github.com/daotl/go-datastore/test"
 )
@@ -16,3 +21,45 @@ func TestSync(t *testing.T) {
 	dstest.SubtestAll(t, key.KeyTypeString,
 		MutexWrap(dstest.NewMapDatastoreForTest(t, key.KeyTypeString)))
 }
+
+func TestSyncCASEmulation(t *testing.T) {
+	// LogDatastore doesn't support conditional writes, so MutexDatastore
+	// emulates them.
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(ioutil.Discard)
+	dstest.SubtestAll(t, key.KeyTypeString, MutexWrap(
+		ds.NewLogDatastore(dstest.NewMapDatastoreForTest(t, key.KeyTypeString), "")))
+}
+
+// wrappedCAS implements the conditional writes by returning a wrapped
+// ErrCASUnsupported, like a wrapper whose child doesn't support them.
+type wrappedCAS struct {
+	*ds.MapDatastore
+}
+
+var errWrappedCAS = fmt.Errorf("child: %w", ds.ErrCASUnsupported)
+
+func (wrappedCAS) PutIfAbsent(ctx context.Context, key key.Key, value []byte) (bool, error) {
+	return false, errWrappedCAS
+}
+
+func (wrappedCAS) CompareAndSwap(ctx context.Context, key key.Key, old, new []byte) (bool, error) {
+	return false, errWrappedCAS
+}
+
+func (wrappedCAS) DeleteIfEqual(ctx context.Context, key key.Key, value []byte) (bool, error) {
+	return false, errWrappedCAS
+}
+
+func TestSyncCASEmulationWrapped(t *testing.T) {
+	dstest.SubtestCAS(t, key.KeyTypeString,
+		MutexWrap(wrappedCAS{dstest.NewMapDatastoreForTest(t, key.KeyTypeString)}))
+}
+
+func TestCapabilities(t *testing.T) {
+	child := ds.NewLogDatastore(dstest.NewMapDatastoreForTest(t, key.KeyTypeString), "")
+	expected := ds.FeatureBatching | ds.FeatureCAS
+	if f := ds.Features(MutexWrap(child)); f != expected {
+		t.Fatalf("expected features %s, got %s", expected, f)
+	}
+}
@@ -8,7 +8,9 @@ package dstest
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"math/rand"
 	"reflect"
 	"strings"
@@ -109,9 +111,9 @@ func SubtestBasicPutGet(t *testing.T, ktype key.KeyType, ds dstore.Datastore) {
 	}
 
 	size, err = ds.GetSize(ctx, k)
-	switch err {
-	case dstore.ErrNotFound:
-	case nil:
+	switch {
+	case dstore.IsNotFound(err):
+	case err == nil:
 		t.Fatal("expected error getting size after delete")
 	default:
 		t.Fatal("wrong error getting size after delete: ", err)
@@ -127,7 +129,7 @@ func SubtestNotFounds(t *testing.T, ktype key.KeyType, ds dstore.Datastore) {
 	badk := key.NewKeyFromTypeAndString(ktype, "notreal")
 
 	val, err := ds.Get(ctx, badk)
-	if err != dstore.ErrNotFound {
+	if !dstore.IsNotFound(err) {
 		t.Fatal("expected ErrNotFound for key that doesnt exist, got: ", err)
 	}
 
@@ -144,9 +146,9 @@ func SubtestNotFounds(t *testing.T, ktype key.KeyType, ds dstore.Datastore) {
 	}
 
 	size, err := ds.GetSize(ctx, badk)
-	switch err {
-	case dstore.ErrNotFound:
-	case nil:
+	switch {
+	case dstore.IsNotFound(err):
+	case err == nil:
 		t.Fatal("expected error getting size of not found key")
 	default:
 		t.Fatal("wrong error getting size of not found key", err)
@@ -404,6 +406,251 @@ func SubtestPrefix(t *testing.T, ktype key.KeyType, ds dstore.Datastore) {
 	test("/bad/")
 }
 
+type errReader struct{}
+
+func (errReader) Read([]byte) (int, error) {
+	return 0, fmt.Errorf("read failed")
+}
+
+func SubtestStreaming(t *testing.T, ktype key.KeyType, ds dstore.StreamingDatastore) {
+	ctx := context.Background()
+
+	k := key.NewKeyFromTypeAndString(ktype, "foo")
+	val := make([]byte, 1<<16)
+	rand.Read(val)
+
+	if err := ds.PutReader(ctx, k, bytes.NewReader(val)); err != nil {
+		t.Fatal("error putting to datastore: ", err)
+	}
+
+	out, err := ds.Get(ctx, k)
+	if err != nil {
+		t.Fatal("error getting value after put: ", err)
+	}
+	if !bytes.Equal(out, val) {
+		t.Fatal("value received on get wasn't what we expected")
+	}
+
+	r, err := ds.GetReader(ctx, k)
+	if err != nil {
+		t.Fatal("error getting reader after put: ", err)
+	}
+	out, err = ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal("error reading value: ", err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal("error closing reader: ", err)
+	}
+	if !bytes.Equal(out, val) {
+		t.Fatal("value read wasn't what we expected")
+	}
+
+	badk := key.NewKeyFromTypeAndString(ktype, "notreal")
+	if _, err := ds.GetReader(ctx, badk); !dstore.IsNotFound(err) {
+		t.Fatal("expected ErrNotFound for key that doesnt exist, got: ", err)
+	}
+
+	if err := ds.PutReader(ctx, badk, errReader{}); err == nil {
+		t.Fatal("expected error putting from a failing reader")
+	}
+	if have, err := ds.Has(ctx, badk); err != nil || have {
+		t.Fatal("value should not be stored when reading fails: ", err)
+	}
+}
+
+func SubtestBulk(t *testing.T, ktype key.KeyType, ds dstore.BulkDatastore) {
+	ctx := context.Background()
+
+	var keys []key.Key
+	var values [][]byte
+	for i := 0; i < ElemCount; i++ {
+		keys = append(keys, key.NewKeyFromTypeAndString(ktype, fmt.Sprintf("/bulk/%d", i)))
+		values = append(values, randValue())
+	}
+	if err := ds.PutMany(ctx, keys, values); err != nil {
+		t.Fatal("error putting many to datastore: ", err)
+	}
+	if err := ds.PutMany(ctx, keys, values[1:]); err == nil {
+		t.Fatal("expected error putting more keys than values")
+	}
+
+	// Interleave keys which don't exist.
+	var query []key.Key
+	for i, k := range keys {
+		query = append(query, k, key.NewKeyFromTypeAndString(ktype, fmt.Sprintf("/notreal/%d", i)))
+	}
+
+	vres, err := ds.GetMany(ctx, query)
+	if err != nil {
+		t.Fatal("error getting many from datastore: ", err)
+	}
+	hres, err := ds.HasMany(ctx, query)
+	if err != nil {
+		t.Fatal("error calling has many on datastore: ", err)
+	}
+	sres, err := ds.GetSizeMany(ctx, query)
+	if err != nil {
+		t.Fatal("error getting many sizes from datastore: ", err)
+	}
+	if len(vres) != len(query) || len(hres) != len(query) || len(sres) != len(query) {
+		t.Fatal("expected one result per key")
+	}
+
+	for i := range query {
+		if i%2 == 1 {
+			if !dstore.IsNotFound(vres[i].Error) || vres[i].Value != nil {
+				t.Fatal("expected ErrNotFound for key that doesnt exist, got: ", vres[i].Error)
+			}
+			if hres[i].Error != nil || hres[i].Exists {
+				t.Fatal("has returned true for key we don't have: ", hres[i].Error)
+			}
+			if !dstore.IsNotFound(sres[i].Error) || sres[i].Size != -1 {
+				t.Fatal("expected ErrNotFound and -1 size for key that doesnt exist, got: ", sres[i].Error)
+			}
+			continue
+		}
+
+		expected := values[i/2]
+		if vres[i].Error != nil || !bytes.Equal(vres[i].Value, expected) {
+			t.Fatalf("wrong value for key %s: %s", query[i], vres[i].Error)
+		}
+		if hres[i].Error != nil || !hres[i].Exists {
+			t.Fatalf("should have key %s: %s", query[i], hres[i].Error)
+		}
+		if sres[i].Error != nil || sres[i].Size != len(expected) {
+			t.Fatalf("wrong size for key %s: %s", query[i], sres[i].Error)
+		}
+	}
+}
+
+func SubtestCAS(t *testing.T, ktype key.KeyType, ds dstore.CASDatastore) {
+	ctx := context.Background()
+
+	k := key.NewKeyFromTypeAndString(ktype, "foo")
+	check := func(ok bool, err error, expected bool, op string) {
+		t.Helper()
+		if err != nil {
+			t.Fatalf("error calling %s: %s", op, err)
+		}
+		if ok != expected {
+			t.Fatalf("expected %s to return %v", op, expected)
+		}
+	}
+	checkValue := func(expected []byte) {
+		t.Helper()
+		v, err := ds.Get(ctx, k)
+		if expected == nil {
+			if !dstore.IsNotFound(err) {
+				t.Fatal("expected ErrNotFound, got: ", err)
+			}
+			return
+		}
+		if err != nil {
+			t.Fatal("error getting value: ", err)
+		}
+		if !bytes.Equal(v, expected) {
+			t.Fatalf("expected value %q, got %q", expected, v)
+		}
+	}
+
+	ok, err := ds.CompareAndSwap(ctx, k, []byte("a"), []byte("b"))
+	check(ok, err, false, "CompareAndSwap on missing key")
+	ok, err = ds.DeleteIfEqual(ctx, k, []byte("a"))
+	check(ok, err, false, "DeleteIfEqual on missing key")
+	checkValue(nil)
+
+	ok, err = ds.PutIfAbsent(ctx, k, []byte("a"))
+	check(ok, err, true, "PutIfAbsent on missing key")
+	ok, err = ds.PutIfAbsent(ctx, k, []byte("b"))
+	check(ok, err, false, "PutIfAbsent on existing key")
+	checkValue([]byte("a"))
+
+	ok, err = ds.CompareAndSwap(ctx, k, []byte("b"), []byte("c"))
+	check(ok, err, false, "CompareAndSwap with wrong old value")
+	checkValue([]byte("a"))
+	ok, err = ds.CompareAndSwap(ctx, k, []byte("a"), []byte("c"))
+	check(ok, err, true, "CompareAndSwap with right old value")
+	checkValue([]byte("c"))
+
+	ok, err = ds.DeleteIfEqual(ctx, k, []byte("a"))
+	check(ok, err, false, "DeleteIfEqual with wrong value")
+	checkValue([]byte("c"))
+	ok, err = ds.DeleteIfEqual(ctx, k, []byte("c"))
+	check(ok, err, true, "DeleteIfEqual with right value")
+	checkValue(nil)
+}
+
+func SubtestSnapshot(t *testing.T, ktype key.KeyType, ds dstore.SnapshotDatastore) {
+	ctx := context.Background()
+
+	ka := key.NewKeyFromTypeAndString(ktype, "/snap/a")
+	kb := key.NewKeyFromTypeAndString(ktype, "/snap/b")
+	kc := key.NewKeyFromTypeAndString(ktype, "/snap/c")
+	for _, k := range []key.Key{ka, kb} {
+		if err := ds.Put(ctx, k, []byte("old")); err != nil {
+			t.Fatal("error putting to datastore: ", err)
+		}
+	}
+
+	s, err := ds.Snapshot(ctx)
+	if errors.Is(err, dstore.ErrSnapshotUnsupported) {
+		t.Skip("snapshots are not supported by the child datastore")
+	}
+	if err != nil {
+		t.Fatal("error taking snapshot: ", err)
+	}
+	defer s.Close()
+
+	if err := ds.Put(ctx, ka, []byte("new")); err != nil {
+		t.Fatal("error putting to datastore: ", err)
+	}
+	if err := ds.Delete(ctx, kb); err != nil {
+		t.Fatal("error deleting from datastore: ", err)
+	}
+	if err := ds.Put(ctx, kc, []byte("new")); err != nil {
+		t.Fatal("error putting to datastore: ", err)
+	}
+
+	for _, k := range []key.Key{ka, kb} {
+		v, err := s.Get(ctx, k)
+		if err != nil {
+			t.Fatalf("error getting %s from snapshot: %s", k, err)
+		}
+		if string(v) != "old" {
+			t.Fatalf("expected the snapshot to see the old value of %s, got %q", k, v)
+		}
+	}
+	if have, err := s.Has(ctx, kc); err != nil || have {
+		t.Fatal("the snapshot should not see keys put after it was taken: ", err)
+	}
+	if _, err := s.GetSize(ctx, kc); !dstore.IsNotFound(err) {
+		t.Fatal("expected ErrNotFound from the snapshot, got: ", err)
+	}
+
+	res, err := s.Query(ctx, dsq.Query{Prefix: key.NewKeyFromTypeAndString(ktype, "/snap")})
+	if err != nil {
+		t.Fatal("error querying snapshot: ", err)
+	}
+	entries, err := res.Rest()
+	if err != nil {
+		t.Fatal("error querying snapshot: ", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries in the snapshot, got %d", len(entries))
+	}
+	for _, e := range entries {
+		if string(e.Value) != "old" {
+			t.Fatalf("expected the snapshot to see the old value of %s, got %q", e.Key, e.Value)
+		}
+	}
+
+	v, err := ds.Get(ctx, ka)
+	if err != nil || string(v) != "new" {
+		t.Fatal("the datastore should see the new value: ", err)
+	}
+}
+
 func randValue() []byte {
 	value := make([]byte, 64)
 	rand.Read(value)
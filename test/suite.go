@@ -45,6 +45,26 @@ var BatchSubtests = []func(t *testing.T, ktype key.KeyType, ds dstore.Batching){
 	RunBatchPutAndDeleteTest,
 }
 
+// StreamingSubtests is a list of all streaming datastore tests.
+var StreamingSubtests = []func(t *testing.T, ktype key.KeyType, ds dstore.StreamingDatastore){
+	SubtestStreaming,
+}
+
+// CASSubtests is a list of all conditional write datastore tests.
+var CASSubtests = []func(t *testing.T, ktype key.KeyType, ds dstore.CASDatastore){
+	SubtestCAS,
+}
+
+// BulkSubtests is a list of all bulk datastore tests.
+var BulkSubtests = []func(t *testing.T, ktype key.KeyType, ds dstore.BulkDatastore){
+	SubtestBulk,
+}
+
+// SnapshotSubtests is a list of all snapshot datastore tests.
+var SnapshotSubtests = []func(t *testing.T, ktype key.KeyType, ds dstore.SnapshotDatastore){
+	SubtestSnapshot,
+}
+
 func getFunctionName(i interface{}) string {
 	return runtime.FuncForPC(reflect.ValueOf(i).Pointer()).Name()
 }
@@ -83,4 +103,36 @@ func SubtestAll(t *testing.T, ktype key.KeyType, ds dstore.Datastore) {
 			})
 		}
 	}
+	if ds, ok := ds.(dstore.BulkDatastore); ok {
+		for _, f := range BulkSubtests {
+			t.Run(getFunctionName(f), func(t *testing.T) {
+				f(t, ktype, ds)
+				clearDs(t, ds)
+			})
+		}
+	}
+	if ds, ok := ds.(dstore.CASDatastore); ok {
+		for _, f := range CASSubtests {
+			t.Run(getFunctionName(f), func(t *testing.T) {
+				f(t, ktype, ds)
+				clearDs(t, ds)
+			})
+		}
+	}
+	if ds, ok := ds.(dstore.StreamingDatastore); ok {
+		for _, f := range StreamingSubtests {
+			t.Run(getFunctionName(f), func(t *testing.T) {
+				f(t, ktype, ds)
+				clearDs(t, ds)
+			})
+		}
+	}
+	if ds, ok := ds.(dstore.SnapshotDatastore); ok {
+		for _, f := range SnapshotSubtests {
+			t.Run(getFunctionName(f), func(t *testing.T) {
+				f(t, ktype, ds)
+				clearDs(t, ds)
+			})
+		}
+	}
 }
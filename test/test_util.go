@@ -145,9 +145,9 @@ func RunBatchPutAndDeleteTest(t *testing.T, ktype key.KeyType, ds dstore.Batchin
 		t.Error(err)
 	}
 
-	switch _, err := ds.Get(ctx, ka); err {
-	case dstore.ErrNotFound:
-	case nil:
+	switch _, err := ds.Get(ctx, ka); {
+	case dstore.IsNotFound(err):
+	case err == nil:
 		t.Errorf("expected to not find %s", ka)
 	default:
 		t.Error(err)
@@ -0,0 +1,39 @@
+// Copyright (c) 2020 DAOT Labs. All rights reserved. Use of this source
+// code is governed by MIT license that can be found in the LICENSE file.
+
+package tiered
+
+import (
+	"context"
+	"fmt"
+
+	dsq "github.com/daotl/go-datastore/query"
+)
+
+// Query implements Datastore.Query by querying all the tiers ordered by key
+// and merging the results, the entries found in several tiers are returned
+// once, from the fastest tier. The filters, orders, offset and limit are
+// applied to the merged results.
+func (d *Datastore) Query(ctx context.Context, master dsq.Query) (dsq.Results, error) {
+	childQuery := dsq.Query{
+		Prefix:            master.Prefix,
+		Range:             master.Range,
+		Orders:            []dsq.Order{dsq.OrderByKey{}},
+		KeysOnly:          master.KeysOnly,
+		ReturnExpirations: master.ReturnExpirations,
+		ReturnsSizes:      master.ReturnsSizes,
+	}
+
+	sources := make([]dsq.Results, 0, len(d.tiers))
+	for i, t := range d.tiers {
+		results, err := t.Query(ctx, childQuery)
+		if err != nil {
+			for _, r := range sources {
+				_ = r.Close()
+			}
+			return nil, fmt.Errorf("querying tier %d: %w", i, err)
+		}
+		sources = append(sources, results)
+	}
+	return dsq.Merge(master, sources, dsq.MergeOptions{Dedup: true}), nil
+}
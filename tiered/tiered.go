@@ -0,0 +1,580 @@
+// Copyright (c) 2020 DAOT Labs. All rights reserved. Use of this source
+// code is governed by MIT license that can be found in the LICENSE file.
+
+// Package tiered provides a datastore which stores its entries across an
+// ordered list of datastores, from the fastest to the slowest, promoting the
+// entries read to the faster tiers and demoting the cold ones to the slower
+// tiers.
+package tiered
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/multierr"
+
+	ds "github.com/daotl/go-datastore"
+	key "github.com/daotl/go-datastore/key"
+	dsq "github.com/daotl/go-datastore/query"
+)
+
+// DefaultDemoteInterval is the default interval between two demotions of
+// cold entries.
+const DefaultDemoteInterval = time.Minute
+
+// DefaultColdAfter is the default duration after which entries which have not
+// been accessed are demoted.
+const DefaultColdAfter = time.Hour
+
+// Clock tells the current time.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// Options are the options of a tiered datastore.
+type Options struct {
+	// Promote, if set, copies the values found by Get in a tier to all the
+	// faster tiers.
+	Promote bool
+
+	// WriteTiers are the indexes of the tiers Put writes to, all the tiers
+	// if it's empty. The entries written are deleted from the other tiers.
+	WriteTiers []int
+
+	// Clock is used to track the accesses, the system clock is used if it's
+	// nil.
+	Clock Clock
+	// ColdAfter is the duration after which entries which have not been
+	// accessed are demoted to the next tier, DefaultColdAfter if it's zero.
+	ColdAfter time.Duration
+	// DemoteInterval is the interval between two demotions done in
+	// background. DefaultDemoteInterval is used if it's zero, and background
+	// demotion is disabled if it's negative, in which case Demote should be
+	// called manually.
+	DemoteInterval time.Duration
+}
+
+// access is the access statistics of a key.
+type access struct {
+	key  key.Key
+	last time.Time
+	hits uint64
+}
+
+// Datastore is a tiered datastore. Reads check each tier in order and return
+// the first value found, deletes apply to all the tiers.
+//
+// The tiers must be safe for concurrent use if the background demotion is
+// enabled, and the tiered datastore must be the only writer of the tiers.
+type Datastore struct {
+	tiers  []ds.Datastore
+	opts   Options
+	writes []bool
+
+	// lk is read-locked by the writes and locked by the promotions and
+	// demotions, so that they don't overwrite concurrent writes.
+	lk  sync.RWMutex
+	gen uint64
+
+	accessLk sync.Mutex
+	accesses map[string]*access
+
+	closeOnce sync.Once
+	closing   chan struct{}
+	closed    chan struct{}
+}
+
+var _ ds.Batching = (*Datastore)(nil)
+var _ ds.PersistentDatastore = (*Datastore)(nil)
+var _ ds.CheckedDatastore = (*Datastore)(nil)
+var _ ds.ScrubbedDatastore = (*Datastore)(nil)
+var _ ds.GCDatastore = (*Datastore)(nil)
+var _ ds.Shim = (*Datastore)(nil)
+
+// New creates a tiered datastore from the given tiers, ordered from the
+// fastest to the slowest.
+func New(tiers []ds.Datastore, opts Options) *Datastore {
+	if len(tiers) == 0 {
+		panic("no tiers")
+	}
+	if opts.Clock == nil {
+		opts.Clock = systemClock{}
+	}
+	if opts.ColdAfter == 0 {
+		opts.ColdAfter = DefaultColdAfter
+	}
+	if opts.DemoteInterval == 0 {
+		opts.DemoteInterval = DefaultDemoteInterval
+	}
+
+	writes := make([]bool, len(tiers))
+	for _, i := range opts.WriteTiers {
+		if i < 0 || i >= len(tiers) {
+			panic(fmt.Sprintf("write tier %d out of range", i))
+		}
+		writes[i] = true
+	}
+	if len(opts.WriteTiers) == 0 {
+		for i := range writes {
+			writes[i] = true
+		}
+	}
+
+	d := &Datastore{
+		tiers:    append([]ds.Datastore(nil), tiers...),
+		opts:     opts,
+		writes:   writes,
+		accesses: make(map[string]*access),
+		closing:  make(chan struct{}),
+		closed:   make(chan struct{}),
+	}
+	if opts.DemoteInterval > 0 && len(tiers) > 1 {
+		go d.demoter(opts.DemoteInterval)
+	} else {
+		close(d.closed)
+	}
+	return d
+}
+
+// Children implements Shim
+func (d *Datastore) Children() []ds.Datastore {
+	return append([]ds.Datastore(nil), d.tiers...)
+}
+
+// Capabilities implements Capable
+func (d *Datastore) Capabilities() ds.Feature {
+	var any ds.Feature
+	for _, t := range d.tiers {
+		any |= ds.Features(t) & ds.FeatureMaintenance
+	}
+	return ds.FeatureBatching | any
+}
+
+// touch records an access to a key.
+func (d *Datastore) touch(k key.Key) {
+	d.accessLk.Lock()
+	defer d.accessLk.Unlock()
+	a, ok := d.accesses[k.String()]
+	if !ok {
+		a = &access{key: k}
+		d.accesses[k.String()] = a
+	}
+	a.last = d.opts.Clock.Now()
+	a.hits++
+}
+
+func (d *Datastore) forget(k key.Key) {
+	d.accessLk.Lock()
+	defer d.accessLk.Unlock()
+	delete(d.accesses, k.String())
+}
+
+// AccessStats returns the time of the last access to a key and the number of
+// accesses since it was last written or demoted. Only the accesses to the
+// keys in the tiers above the last one are tracked.
+func (d *Datastore) AccessStats(k key.Key) (last time.Time, hits uint64, ok bool) {
+	d.accessLk.Lock()
+	defer d.accessLk.Unlock()
+	a, ok := d.accesses[k.String()]
+	if !ok {
+		return time.Time{}, 0, false
+	}
+	return a.last, a.hits, true
+}
+
+// Get implements Datastore.Get, it returns the value from the first tier
+// which has the key and promotes it if Options.Promote is set.
+func (d *Datastore) Get(ctx context.Context, key key.Key) (value []byte, err error) {
+	gen := atomic.LoadUint64(&d.gen)
+	for i, t := range d.tiers {
+		value, err = t.Get(ctx, key)
+		if ds.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if i > 0 && d.opts.Promote {
+			if err := d.promote(ctx, gen, key, value, i); err != nil {
+				return nil, err
+			}
+			i = 0
+		}
+		if i < len(d.tiers)-1 {
+			d.touch(key)
+		}
+		return value, nil
+	}
+	return nil, ds.ErrNotFound
+}
+
+// promote writes a value read from tier `from` to the faster tiers, unless
+// it has been written since generation `gen`.
+func (d *Datastore) promote(ctx context.Context, gen uint64, k key.Key, value []byte, from int) error {
+	d.lk.Lock()
+	defer d.lk.Unlock()
+	if atomic.LoadUint64(&d.gen) != gen {
+		return nil
+	}
+	for i := 0; i < from; i++ {
+		if err := d.tiers[i].Put(ctx, k, value); err != nil {
+			return fmt.Errorf("promoting to tier %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// Has implements Datastore.Has
+func (d *Datastore) Has(ctx context.Context, key key.Key) (exists bool, err error) {
+	for _, t := range d.tiers {
+		if exists, err = t.Has(ctx, key); err != nil || exists {
+			return exists, err
+		}
+	}
+	return false, nil
+}
+
+// GetSize implements Datastore.GetSize
+func (d *Datastore) GetSize(ctx context.Context, key key.Key) (size int, err error) {
+	for _, t := range d.tiers {
+		size, err = t.GetSize(ctx, key)
+		if !ds.IsNotFound(err) {
+			return size, err
+		}
+	}
+	return -1, ds.ErrNotFound
+}
+
+// Put implements Datastore.Put, it writes to the write tiers and deletes the
+// key from the other tiers.
+func (d *Datastore) Put(ctx context.Context, key key.Key, value []byte) error {
+	d.lk.RLock()
+	defer d.lk.RUnlock()
+	defer atomic.AddUint64(&d.gen, 1)
+
+	var merr error
+	for i, t := range d.tiers {
+		if d.writes[i] {
+			if err := t.Put(ctx, key, value); err != nil {
+				merr = multierr.Append(merr, fmt.Errorf("putting to tier %d: %w", i, err))
+			}
+		} else if err := t.Delete(ctx, key); err != nil {
+			merr = multierr.Append(merr, fmt.Errorf("deleting from tier %d: %w", i, err))
+		}
+	}
+	if merr == nil && d.writesAbove(len(d.tiers)-1) {
+		d.touch(key)
+	}
+	return merr
+}
+
+// writesAbove returns whether any of the tiers above `n` is a write tier.
+func (d *Datastore) writesAbove(n int) bool {
+	for i := 0; i < n; i++ {
+		if d.writes[i] {
+			return true
+		}
+	}
+	return false
+}
+
+// Delete implements Datastore.Delete, it deletes the key from all the tiers.
+func (d *Datastore) Delete(ctx context.Context, key key.Key) error {
+	d.lk.RLock()
+	defer d.lk.RUnlock()
+	defer atomic.AddUint64(&d.gen, 1)
+
+	var merr error
+	for i, t := range d.tiers {
+		if err := t.Delete(ctx, key); err != nil {
+			merr = multierr.Append(merr, fmt.Errorf("deleting from tier %d: %w", i, err))
+		}
+	}
+	d.forget(key)
+	return merr
+}
+
+// Sync implements Datastore.Sync
+func (d *Datastore) Sync(ctx context.Context, prefix key.Key) error {
+	var merr error
+	for i, t := range d.tiers {
+		if err := t.Sync(ctx, prefix); err != nil {
+			merr = multierr.Append(merr, fmt.Errorf("syncing tier %d: %w", i, err))
+		}
+	}
+	return merr
+}
+
+// Close stops the background demotion and closes all the tiers.
+func (d *Datastore) Close() error {
+	d.closeOnce.Do(func() {
+		close(d.closing)
+	})
+	<-d.closed
+
+	var merr error
+	for i, t := range d.tiers {
+		if err := t.Close(); err != nil {
+			merr = multierr.Append(merr, fmt.Errorf("closing tier %d: %w", i, err))
+		}
+	}
+	return merr
+}
+
+func (d *Datastore) demoter(interval time.Duration) {
+	defer close(d.closed)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.closing:
+			return
+		case <-ticker.C:
+			// Errors are retried on the next demotion.
+			_, _ = d.Demote(context.Background())
+		}
+	}
+}
+
+// Demote moves the entries which have not been accessed for
+// Options.ColdAfter to the next tier, and returns the number of entries
+// moved. The entries of a tier which have never been accessed since the
+// datastore was created start being tracked, and are demoted once they're
+// cold.
+//
+// Each entry moves down one tier at most per call.
+func (d *Datastore) Demote(ctx context.Context) (int, error) {
+	moved := 0
+	// From the slowest tiers so that entries aren't moved twice.
+	for i := len(d.tiers) - 2; i >= 0; i-- {
+		n, err := d.demoteTier(ctx, i)
+		moved += n
+		if err != nil {
+			return moved, fmt.Errorf("demoting from tier %d: %w", i, err)
+		}
+	}
+	return moved, nil
+}
+
+func (d *Datastore) demoteTier(ctx context.Context, i int) (int, error) {
+	res, err := d.tiers[i].Query(ctx, dsq.Query{KeysOnly: true})
+	if err != nil {
+		return 0, err
+	}
+	// Collect the keys first, tiers may not support writes during queries.
+	// They're classified afterwards so that the accesses aren't locked
+	// during the whole query.
+	var keys []key.Key
+	for {
+		r, ok := res.NextSync()
+		if !ok {
+			break
+		}
+		if r.Error != nil {
+			res.Close()
+			return 0, r.Error
+		}
+		keys = append(keys, r.Key)
+	}
+	if err := res.Close(); err != nil {
+		return 0, err
+	}
+
+	var cold []key.Key
+	now := d.opts.Clock.Now()
+	d.accessLk.Lock()
+	for _, k := range keys {
+		a, ok := d.accesses[k.String()]
+		if !ok {
+			d.accesses[k.String()] = &access{key: k, last: now}
+			continue
+		}
+		if now.Sub(a.last) >= d.opts.ColdAfter {
+			cold = append(cold, k)
+		}
+	}
+	d.accessLk.Unlock()
+
+	for n, k := range cold {
+		if err := ctx.Err(); err != nil {
+			return n, err
+		}
+		if err := d.move(ctx, k, i); err != nil {
+			return n, err
+		}
+	}
+	return len(cold), nil
+}
+
+// move moves a key from tier `i` to tier `i+1`.
+func (d *Datastore) move(ctx context.Context, k key.Key, i int) error {
+	d.lk.Lock()
+	defer d.lk.Unlock()
+
+	value, err := d.tiers[i].Get(ctx, k)
+	switch {
+	case ds.IsNotFound(err):
+		// Deleted meanwhile.
+		d.forget(k)
+		return nil
+	case err != nil:
+		return err
+	}
+	if err := d.tiers[i+1].Put(ctx, k, value); err != nil {
+		return err
+	}
+	if err := d.tiers[i].Delete(ctx, k); err != nil {
+		return err
+	}
+
+	d.accessLk.Lock()
+	defer d.accessLk.Unlock()
+	if i+1 == len(d.tiers)-1 {
+		delete(d.accesses, k.String())
+	} else {
+		// Give the entry time in the next tier.
+		d.accesses[k.String()] = &access{key: k, last: d.opts.Clock.Now()}
+	}
+	return nil
+}
+
+// DiskUsage implements the PersistentDatastore interface, it returns the sum
+// of the disk usages of the tiers.
+func (d *Datastore) DiskUsage(ctx context.Context) (uint64, error) {
+	var (
+		merr    error
+		duTotal uint64
+	)
+	for i, t := range d.tiers {
+		du, err := ds.DiskUsage(ctx, t)
+		duTotal += du
+		if err != nil {
+			merr = multierr.Append(merr, fmt.Errorf("getting disk usage of tier %d: %w", i, err))
+		}
+	}
+	return duTotal, merr
+}
+
+// Check implements CheckedDatastore.Check
+func (d *Datastore) Check(ctx context.Context) error {
+	var merr error
+	for i, t := range d.tiers {
+		if c, ok := t.(ds.CheckedDatastore); ok {
+			if err := c.Check(ctx); err != nil {
+				merr = multierr.Append(merr, fmt.Errorf("checking tier %d: %w", i, err))
+			}
+		}
+	}
+	return merr
+}
+
+// Scrub implements ScrubbedDatastore.Scrub
+func (d *Datastore) Scrub(ctx context.Context) error {
+	var merr error
+	for i, t := range d.tiers {
+		if c, ok := t.(ds.ScrubbedDatastore); ok {
+			if err := c.Scrub(ctx); err != nil {
+				merr = multierr.Append(merr, fmt.Errorf("scrubbing tier %d: %w", i, err))
+			}
+		}
+	}
+	return merr
+}
+
+// CollectGarbage implements GCDatastore.CollectGarbage
+func (d *Datastore) CollectGarbage(ctx context.Context) error {
+	var merr error
+	for i, t := range d.tiers {
+		if c, ok := t.(ds.GCDatastore); ok {
+			if err := c.CollectGarbage(ctx); err != nil {
+				merr = multierr.Append(merr, fmt.Errorf("collecting garbage of tier %d: %w", i, err))
+			}
+		}
+	}
+	return merr
+}
+
+// Batch implements Batching.Batch, the operations are batched per tier, with
+// ds.NewBasicBatch for the tiers which aren't Batching.
+func (d *Datastore) Batch(ctx context.Context) (ds.Batch, error) {
+	batches := make([]ds.Batch, len(d.tiers))
+	for i, t := range d.tiers {
+		if bds, ok := t.(ds.Batching); ok {
+			b, err := bds.Batch(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("creating batch of tier %d: %w", i, err)
+			}
+			batches[i] = b
+		} else {
+			batches[i] = ds.NewBasicBatch(t)
+		}
+	}
+	return &tieredBatch{d: d, batches: batches}, nil
+}
+
+type tieredBatch struct {
+	d       *Datastore
+	batches []ds.Batch
+
+	puts, deletes []key.Key
+}
+
+func (b *tieredBatch) Put(ctx context.Context, key key.Key, value []byte) error {
+	for i, tb := range b.batches {
+		var err error
+		if b.d.writes[i] {
+			err = tb.Put(ctx, key, value)
+		} else {
+			err = tb.Delete(ctx, key)
+		}
+		if err != nil {
+			return fmt.Errorf("batching for tier %d: %w", i, err)
+		}
+	}
+	b.puts = append(b.puts, key)
+	return nil
+}
+
+func (b *tieredBatch) Delete(ctx context.Context, key key.Key) error {
+	for i, tb := range b.batches {
+		if err := tb.Delete(ctx, key); err != nil {
+			return fmt.Errorf("batching for tier %d: %w", i, err)
+		}
+	}
+	b.deletes = append(b.deletes, key)
+	return nil
+}
+
+func (b *tieredBatch) Commit(ctx context.Context) error {
+	b.d.lk.RLock()
+	defer b.d.lk.RUnlock()
+	defer atomic.AddUint64(&b.d.gen, 1)
+
+	var merr error
+	for i, tb := range b.batches {
+		if err := tb.Commit(ctx); err != nil {
+			merr = multierr.Append(merr, fmt.Errorf("committing to tier %d: %w", i, err))
+		}
+	}
+	if merr == nil && b.d.writesAbove(len(b.d.tiers)-1) {
+		for _, k := range b.puts {
+			b.d.touch(k)
+		}
+	}
+	for _, k := range b.deletes {
+		b.d.forget(k)
+	}
+	b.puts, b.deletes = nil, nil
+	return merr
+}
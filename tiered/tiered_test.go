@@ -0,0 +1,227 @@
+// Copyright (c) 2020 DAOT Labs. All rights reserved. Use of this source
+// code is governed by MIT license that can be found in the LICENSE file.
+
+package tiered
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	ds "github.com/daotl/go-datastore"
+	key "github.com/daotl/go-datastore/key"
+	dsq "github.com/daotl/go-datastore/query"
+	dssync "github.com/daotl/go-datastore/sync"
+	dstest "github.com/daotl/go-datastore/test"
+)
+
+func newTiers(t *testing.T, ktype key.KeyType, n int) []ds.Datastore {
+	tiers := make([]ds.Datastore, n)
+	for i := range tiers {
+		tiers[i] = dstest.NewMapDatastoreForTest(t, ktype)
+	}
+	return tiers
+}
+
+func testSuite(t *testing.T, ktype key.KeyType) {
+	for _, opts := range []Options{
+		{DemoteInterval: -1},
+		{Promote: true, WriteTiers: []int{2}, DemoteInterval: -1},
+	} {
+		d := New(newTiers(t, ktype, 3), opts)
+		dstest.SubtestAll(t, ktype, d)
+	}
+}
+
+func TestSuite(t *testing.T) {
+	testSuite(t, key.KeyTypeString)
+	testSuite(t, key.KeyTypeBytes)
+}
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func has(t *testing.T, d ds.Datastore, k string) bool {
+	t.Helper()
+	exists, err := d.Has(context.Background(), key.NewStrKey(k))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return exists
+}
+
+func TestPromote(t *testing.T) {
+	ctx := context.Background()
+	tiers := newTiers(t, key.KeyTypeString, 3)
+	tiers[2].Put(ctx, key.NewStrKey("/a"), []byte("a"))
+
+	d := New(tiers, Options{DemoteInterval: -1})
+	if v, err := d.Get(ctx, key.NewStrKey("/a")); err != nil || string(v) != "a" {
+		t.Fatalf("unexpected value %q, error: %v", v, err)
+	}
+	if has(t, tiers[0], "/a") {
+		t.Fatal("the entry should not be promoted")
+	}
+
+	d = New(tiers, Options{Promote: true, DemoteInterval: -1})
+	if v, err := d.Get(ctx, key.NewStrKey("/a")); err != nil || string(v) != "a" {
+		t.Fatalf("unexpected value %q, error: %v", v, err)
+	}
+	if !has(t, tiers[0], "/a") || !has(t, tiers[1], "/a") {
+		t.Fatal("expected the entry to be promoted")
+	}
+	if _, hits, ok := d.AccessStats(key.NewStrKey("/a")); !ok || hits != 1 {
+		t.Fatal("expected the access to be tracked")
+	}
+}
+
+func TestWriteTiers(t *testing.T) {
+	ctx := context.Background()
+	tiers := newTiers(t, key.KeyTypeString, 3)
+	tiers[0].Put(ctx, key.NewStrKey("/a"), []byte("old"))
+
+	d := New(tiers, Options{WriteTiers: []int{1, 2}, DemoteInterval: -1})
+	if err := d.Put(ctx, key.NewStrKey("/a"), []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	if has(t, tiers[0], "/a") || !has(t, tiers[1], "/a") || !has(t, tiers[2], "/a") {
+		t.Fatal("expected the entry to be written to the write tiers only")
+	}
+	if v, err := d.Get(ctx, key.NewStrKey("/a")); err != nil || string(v) != "new" {
+		t.Fatalf("unexpected value %q, error: %v", v, err)
+	}
+
+	if err := d.Delete(ctx, key.NewStrKey("/a")); err != nil {
+		t.Fatal(err)
+	}
+	for i, tier := range tiers {
+		if has(t, tier, "/a") {
+			t.Fatalf("expected the entry to be deleted from tier %d", i)
+		}
+	}
+}
+
+func TestQuery(t *testing.T) {
+	ctx := context.Background()
+	tiers := newTiers(t, key.KeyTypeString, 3)
+	tiers[0].Put(ctx, key.NewStrKey("/b"), []byte("b"))
+	tiers[1].Put(ctx, key.NewStrKey("/a"), []byte("a"))
+	tiers[1].Put(ctx, key.NewStrKey("/b"), []byte("b"))
+	tiers[2].Put(ctx, key.NewStrKey("/b"), []byte("b"))
+	tiers[2].Put(ctx, key.NewStrKey("/c"), []byte("c"))
+	d := New(tiers, Options{DemoteInterval: -1})
+
+	res, err := d.Query(ctx, dsq.Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	es, err := res.Rest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(es) != 3 {
+		t.Fatalf("expected 3 entries, got %d", len(es))
+	}
+	for i, k := range []string{"/a", "/b", "/c"} {
+		if es[i].Key.String() != k {
+			t.Fatalf("expected %s, got %s", k, es[i].Key)
+		}
+	}
+
+	res, err = d.Query(ctx, dsq.Query{Orders: []dsq.Order{dsq.OrderByKeyDescending{}}, Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if es, err = res.Rest(); err != nil {
+		t.Fatal(err)
+	}
+	if len(es) != 2 || es[0].Key.String() != "/c" || es[1].Key.String() != "/b" {
+		t.Fatalf("unexpected entries: %v", es)
+	}
+}
+
+func TestDemote(t *testing.T) {
+	ctx := context.Background()
+	clock := &fakeClock{now: time.Now()}
+	tiers := newTiers(t, key.KeyTypeString, 3)
+	d := New(tiers, Options{
+		WriteTiers:     []int{0},
+		Clock:          clock,
+		ColdAfter:      time.Minute,
+		DemoteInterval: -1,
+	})
+	d.Put(ctx, key.NewStrKey("/hot"), []byte("hot"))
+	d.Put(ctx, key.NewStrKey("/cold"), []byte("cold"))
+
+	clock.now = clock.now.Add(2 * time.Minute)
+	d.Get(ctx, key.NewStrKey("/hot"))
+	n, err := d.Demote(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 || !has(t, tiers[0], "/hot") || has(t, tiers[0], "/cold") || !has(t, tiers[1], "/cold") {
+		t.Fatal("expected the cold entry to be demoted to the next tier")
+	}
+
+	// The entries move down one tier per demotion.
+	clock.now = clock.now.Add(2 * time.Minute)
+	if n, err = d.Demote(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 || !has(t, tiers[1], "/hot") || !has(t, tiers[2], "/cold") {
+		t.Fatalf("unexpected demotion of %d entries", n)
+	}
+	if v, err := d.Get(ctx, key.NewStrKey("/cold")); err != nil || string(v) != "cold" {
+		t.Fatalf("unexpected value %q, error: %v", v, err)
+	}
+}
+
+func TestBackgroundDemote(t *testing.T) {
+	ctx := context.Background()
+	tiers := newTiers(t, key.KeyTypeString, 2)
+	for i := range tiers {
+		tiers[i] = dssync.MutexWrap(tiers[i])
+	}
+	d := New(tiers, Options{ColdAfter: time.Nanosecond, DemoteInterval: 10 * time.Millisecond})
+	defer d.Close()
+
+	d.Put(ctx, key.NewStrKey("/a"), []byte("a"))
+	deadline := time.Now().Add(5 * time.Second)
+	for has(t, tiers[0], "/a") {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the entry to be demoted in background")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !has(t, tiers[1], "/a") {
+		t.Fatal("expected the entry to be in the last tier")
+	}
+}
+
+func TestBatch(t *testing.T) {
+	ctx := context.Background()
+	tiers := newTiers(t, key.KeyTypeString, 2)
+	tiers[0].Put(ctx, key.NewStrKey("/b"), []byte("b"))
+	d := New(tiers, Options{WriteTiers: []int{1}, DemoteInterval: -1})
+
+	b, err := d.Batch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Put(ctx, key.NewStrKey("/a"), []byte("a"))
+	b.Put(ctx, key.NewStrKey("/b"), []byte("new"))
+	if err := b.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if has(t, tiers[0], "/a") || has(t, tiers[0], "/b") || !has(t, tiers[1], "/b") {
+		t.Fatal("expected the batch to write to the write tiers only")
+	}
+	if v, err := d.Get(ctx, key.NewStrKey("/b")); err != nil || string(v) != "new" {
+		t.Fatalf("unexpected value %q, error: %v", v, err)
+	}
+}
@@ -0,0 +1,405 @@
+// Copyright (c) 2020 DAOT Labs. All rights reserved. Use of this source
+// code is governed by MIT license that can be found in the LICENSE file.
+
+// Package ttl provides a datastore wrapper which adds support for entries
+// with time-to-live (see datastore.TTLDatastore) on top of any datastore.
+//
+// Expirations are kept in memory, they are lost when the datastore is closed.
+// This makes the wrapper mostly useful for tests and caches.
+//
+// The datastore is a datastore.Watcher which only publishes EventExpire
+// events, wrap it with watch.Wrap to be notified of the writes too.
+package ttl
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	ds "github.com/daotl/go-datastore"
+	key "github.com/daotl/go-datastore/key"
+	dsq "github.com/daotl/go-datastore/query"
+	"github.com/daotl/go-datastore/watch"
+)
+
+// DefaultSweepInterval is the default interval between two sweeps of
+// expired entries.
+const DefaultSweepInterval = time.Minute
+
+// Clock tells the current time.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// Options are the options of a TTL datastore.
+type Options struct {
+	// Clock is used to tell whether entries are expired, the system clock is
+	// used if it's nil.
+	Clock Clock
+	// SweepInterval is the interval between two sweeps of expired entries
+	// done in background. DefaultSweepInterval is used if it's zero, and
+	// background sweeping is disabled if it's negative, in which case Sweep
+	// should be called manually.
+	SweepInterval time.Duration
+	// Watch are the options of the subscribers to expirations.
+	Watch watch.Options
+}
+
+type expiration struct {
+	key key.Key
+	at  time.Time
+}
+
+// Datastore wraps a datastore and keeps track of the expirations of its
+// entries. Expired entries are hidden from reads and queries and deleted from
+// the child datastore by Sweep.
+//
+// The child datastore must be safe for concurrent use when background
+// sweeping is enabled.
+type Datastore struct {
+	child ds.Datastore
+	clock Clock
+	hub   *watch.Hub
+
+	lk          sync.RWMutex
+	expirations map[string]expiration
+
+	closeOnce sync.Once
+	closing   chan struct{}
+	closed    chan struct{}
+}
+
+var _ ds.TTLDatastore = (*Datastore)(nil)
+var _ ds.Batching = (*Datastore)(nil)
+var _ ds.PersistentDatastore = (*Datastore)(nil)
+var _ ds.Shim = (*Datastore)(nil)
+var _ ds.WatchDatastore = (*Datastore)(nil)
+
+// New wraps the given datastore with TTL support.
+func New(child ds.Datastore, opts Options) *Datastore {
+	if child == nil {
+		panic("child (ds.Datastore) is nil")
+	}
+	if opts.Clock == nil {
+		opts.Clock = systemClock{}
+	}
+	if opts.SweepInterval == 0 {
+		opts.SweepInterval = DefaultSweepInterval
+	}
+
+	d := &Datastore{
+		child:       child,
+		clock:       opts.Clock,
+		hub:         watch.NewHub(opts.Watch),
+		expirations: make(map[string]expiration),
+		closing:     make(chan struct{}),
+		closed:      make(chan struct{}),
+	}
+	if opts.SweepInterval > 0 {
+		go d.sweeper(opts.SweepInterval)
+	} else {
+		close(d.closed)
+	}
+	return d
+}
+
+// Capabilities implements Capable
+func (d *Datastore) Capabilities() ds.Feature {
+	return ds.FeatureTTL | ds.FeatureBatching | ds.FeatureWatch |
+		ds.Features(d.child)&ds.FeaturePersistent
+}
+
+// Children implements Shim
+func (d *Datastore) Children() []ds.Datastore {
+	return []ds.Datastore{d.child}
+}
+
+func (d *Datastore) sweeper(interval time.Duration) {
+	defer close(d.closed)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.closing:
+			return
+		case <-ticker.C:
+			// Errors are retried on the next sweep.
+			_ = d.Sweep(context.Background())
+		}
+	}
+}
+
+// Sweep deletes all expired entries from the child datastore, and publishes
+// an EventExpire event for each of them.
+func (d *Datastore) Sweep(ctx context.Context) error {
+	var events []ds.Event
+	// Publish the events without holding the lock, subscribers may block.
+	defer func() {
+		d.hub.Publish(events...)
+	}()
+
+	d.lk.Lock()
+	defer d.lk.Unlock()
+
+	now := d.clock.Now()
+	for ks, exp := range d.expirations {
+		if now.Before(exp.at) {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := d.child.Delete(ctx, exp.key); err != nil {
+			return err
+		}
+		delete(d.expirations, ks)
+		events = append(events, ds.Event{Type: ds.EventExpire, Key: exp.key})
+	}
+	return nil
+}
+
+// Watch implements Watcher.Watch, only EventExpire events are published
+// when expired entries are swept, which may be some time after they expired.
+func (d *Datastore) Watch(ctx context.Context, prefix key.Key) (<-chan ds.Event, error) {
+	return d.hub.Watch(ctx, prefix)
+}
+
+// expired reports whether the entry named by `k` has expired, the caller
+// must hold d.lk.
+func (d *Datastore) expired(k key.Key) bool {
+	exp, ok := d.expirations[k.String()]
+	return ok && !d.clock.Now().Before(exp.at)
+}
+
+// Put implements Datastore.Put, the entry stored won't expire.
+func (d *Datastore) Put(ctx context.Context, key key.Key, value []byte) error {
+	d.lk.Lock()
+	defer d.lk.Unlock()
+	if err := d.child.Put(ctx, key, value); err != nil {
+		return err
+	}
+	delete(d.expirations, key.String())
+	return nil
+}
+
+// PutWithTTL implements TTL.PutWithTTL
+func (d *Datastore) PutWithTTL(ctx context.Context, key key.Key, value []byte, ttl time.Duration) error {
+	d.lk.Lock()
+	defer d.lk.Unlock()
+	if err := d.child.Put(ctx, key, value); err != nil {
+		return err
+	}
+	d.expirations[key.String()] = expiration{key: key, at: d.clock.Now().Add(ttl)}
+	return nil
+}
+
+// SetTTL implements TTL.SetTTL
+func (d *Datastore) SetTTL(ctx context.Context, key key.Key, ttl time.Duration) error {
+	d.lk.Lock()
+	defer d.lk.Unlock()
+	if d.expired(key) {
+		return ds.ErrNotFound
+	}
+	has, err := d.child.Has(ctx, key)
+	if err != nil {
+		return err
+	}
+	if !has {
+		return ds.ErrNotFound
+	}
+	d.expirations[key.String()] = expiration{key: key, at: d.clock.Now().Add(ttl)}
+	return nil
+}
+
+// GetExpiration implements TTL.GetExpiration, it returns the zero time if
+// the entry doesn't expire.
+func (d *Datastore) GetExpiration(ctx context.Context, key key.Key) (time.Time, error) {
+	d.lk.RLock()
+	defer d.lk.RUnlock()
+	if d.expired(key) {
+		return time.Time{}, ds.ErrNotFound
+	}
+	has, err := d.child.Has(ctx, key)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if !has {
+		return time.Time{}, ds.ErrNotFound
+	}
+	return d.expirations[key.String()].at, nil
+}
+
+// Sync implements Datastore.Sync
+func (d *Datastore) Sync(ctx context.Context, prefix key.Key) error {
+	return d.child.Sync(ctx, prefix)
+}
+
+// Get implements Datastore.Get
+func (d *Datastore) Get(ctx context.Context, key key.Key) (value []byte, err error) {
+	d.lk.RLock()
+	defer d.lk.RUnlock()
+	if d.expired(key) {
+		return nil, ds.ErrNotFound
+	}
+	return d.child.Get(ctx, key)
+}
+
+// Has implements Datastore.Has
+func (d *Datastore) Has(ctx context.Context, key key.Key) (exists bool, err error) {
+	d.lk.RLock()
+	defer d.lk.RUnlock()
+	if d.expired(key) {
+		return false, nil
+	}
+	return d.child.Has(ctx, key)
+}
+
+// GetSize implements Datastore.GetSize
+func (d *Datastore) GetSize(ctx context.Context, key key.Key) (size int, err error) {
+	d.lk.RLock()
+	defer d.lk.RUnlock()
+	if d.expired(key) {
+		return -1, ds.ErrNotFound
+	}
+	return d.child.GetSize(ctx, key)
+}
+
+// Delete implements Datastore.Delete
+func (d *Datastore) Delete(ctx context.Context, key key.Key) error {
+	d.lk.Lock()
+	defer d.lk.Unlock()
+	if err := d.child.Delete(ctx, key); err != nil {
+		return err
+	}
+	delete(d.expirations, key.String())
+	return nil
+}
+
+// Query implements Datastore.Query, hiding expired entries.
+func (d *Datastore) Query(ctx context.Context, q dsq.Query) (dsq.Results, error) {
+	// Expired entries are filtered out above the child, so offset and limit
+	// must be applied here too.
+	cq := q
+	cq.ReturnExpirations = false
+	cq.Offset = 0
+	cq.Limit = 0
+
+	cqr, err := d.child.Query(ctx, cq)
+	if err != nil {
+		return nil, err
+	}
+
+	qr := dsq.ResultsFromIterator(q, dsq.Iterator{
+		Next: func() (dsq.Result, bool) {
+			for {
+				r, ok := cqr.NextSync()
+				if !ok || r.Error != nil {
+					return r, ok
+				}
+
+				d.lk.RLock()
+				exp, hasExp := d.expirations[r.Key.String()]
+				d.lk.RUnlock()
+
+				if hasExp {
+					if !d.clock.Now().Before(exp.at) {
+						continue
+					}
+					if q.ReturnExpirations {
+						r.Expiration = exp.at
+					}
+				}
+				return r, true
+			}
+		},
+		Close: func() error {
+			return cqr.Close()
+		},
+	})
+
+	if q.Offset > 0 {
+		qr = dsq.NaiveOffset(qr, q.Offset)
+	}
+	if q.Limit > 0 {
+		qr = dsq.NaiveLimit(qr, q.Limit)
+	}
+	return qr, nil
+}
+
+// DiskUsage implements the PersistentDatastore interface.
+func (d *Datastore) DiskUsage(ctx context.Context) (uint64, error) {
+	return ds.DiskUsage(ctx, d.child)
+}
+
+// Batch implements the Batching interface. Entries put through the batch
+// won't expire.
+func (d *Datastore) Batch(ctx context.Context) (ds.Batch, error) {
+	bds, ok := d.child.(ds.Batching)
+	if !ok {
+		return ds.NewBasicBatch(d), nil
+	}
+
+	b, err := bds.Batch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &ttlBatch{
+		child: b,
+		keys:  make(map[string]struct{}),
+		d:     d,
+	}, nil
+}
+
+// Close stops the background sweeper, closes the channels of the
+// subscribers and the child datastore.
+func (d *Datastore) Close() error {
+	d.closeOnce.Do(func() {
+		close(d.closing)
+	})
+	<-d.closed
+	d.hub.Close()
+	return d.child.Close()
+}
+
+type ttlBatch struct {
+	child ds.Batch
+	keys  map[string]struct{}
+
+	d *Datastore
+}
+
+func (b *ttlBatch) Put(ctx context.Context, key key.Key, value []byte) error {
+	if err := b.child.Put(ctx, key, value); err != nil {
+		return err
+	}
+	b.keys[key.String()] = struct{}{}
+	return nil
+}
+
+func (b *ttlBatch) Delete(ctx context.Context, key key.Key) error {
+	if err := b.child.Delete(ctx, key); err != nil {
+		return err
+	}
+	b.keys[key.String()] = struct{}{}
+	return nil
+}
+
+func (b *ttlBatch) Commit(ctx context.Context) error {
+	b.d.lk.Lock()
+	defer b.d.lk.Unlock()
+	if err := b.child.Commit(ctx); err != nil {
+		return err
+	}
+	for ks := range b.keys {
+		delete(b.d.expirations, ks)
+	}
+	return nil
+}
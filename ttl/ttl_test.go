@@ -0,0 +1,212 @@
+// Copyright (c) 2020 DAOT Labs. All rights reserved. Use of this source
+// code is governed by MIT license that can be found in the LICENSE file.
+
+package ttl
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	ds "github.com/daotl/go-datastore"
+	key "github.com/daotl/go-datastore/key"
+	dsq "github.com/daotl/go-datastore/query"
+	dssync "github.com/daotl/go-datastore/sync"
+	dstest "github.com/daotl/go-datastore/test"
+)
+
+type mockClock struct {
+	lk  sync.Mutex
+	now time.Time
+}
+
+func (c *mockClock) Now() time.Time {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	return c.now
+}
+
+func (c *mockClock) Add(d time.Duration) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func newMockClock() *mockClock {
+	return &mockClock{now: time.Unix(1600000000, 0)}
+}
+
+func testSuite(t *testing.T, ktype key.KeyType) {
+	d := New(dstest.NewMapDatastoreForTest(t, ktype), Options{SweepInterval: -1})
+	defer d.Close()
+	dstest.SubtestAll(t, ktype, d)
+}
+
+func TestSuite(t *testing.T) {
+	testSuite(t, key.KeyTypeString)
+	testSuite(t, key.KeyTypeBytes)
+}
+
+func TestExpiry(t *testing.T) {
+	ctx := context.Background()
+	clock := newMockClock()
+	child := dstest.NewMapDatastoreForTest(t, key.KeyTypeString)
+	d := New(child, Options{Clock: clock, SweepInterval: -1})
+	defer d.Close()
+
+	k := key.NewStrKey("/foo")
+	if err := d.PutWithTTL(ctx, k, []byte("bar"), time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	exp, err := d.GetExpiration(ctx, k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exp.Equal(clock.Now().Add(time.Second)) {
+		t.Fatalf("unexpected expiration: %s", exp)
+	}
+
+	if has, err := d.Has(ctx, k); err != nil || !has {
+		t.Fatal("expected to have key before expiration", err)
+	}
+
+	clock.Add(time.Second)
+
+	if _, err := d.Get(ctx, k); err != ds.ErrNotFound {
+		t.Fatal("expected ErrNotFound after expiration, got: ", err)
+	}
+	if has, err := d.Has(ctx, k); err != nil || has {
+		t.Fatal("expected not to have key after expiration", err)
+	}
+	if size, err := d.GetSize(ctx, k); err != ds.ErrNotFound || size != -1 {
+		t.Fatal("expected ErrNotFound after expiration, got: ", err)
+	}
+	if _, err := d.GetExpiration(ctx, k); err != ds.ErrNotFound {
+		t.Fatal("expected ErrNotFound after expiration, got: ", err)
+	}
+	if err := d.SetTTL(ctx, k, time.Second); err != ds.ErrNotFound {
+		t.Fatal("expected ErrNotFound after expiration, got: ", err)
+	}
+
+	// Still in the child until swept.
+	if has, _ := child.Has(ctx, k); !has {
+		t.Fatal("expired key should not be deleted before sweeping")
+	}
+	if err := d.Sweep(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if has, _ := child.Has(ctx, k); has {
+		t.Fatal("expired key should be deleted after sweeping")
+	}
+}
+
+func TestSetTTL(t *testing.T) {
+	ctx := context.Background()
+	clock := newMockClock()
+	d := New(dstest.NewMapDatastoreForTest(t, key.KeyTypeString), Options{Clock: clock, SweepInterval: -1})
+	defer d.Close()
+
+	k := key.NewStrKey("/foo")
+	if err := d.SetTTL(ctx, k, time.Second); err != ds.ErrNotFound {
+		t.Fatal("expected ErrNotFound setting TTL of missing key, got: ", err)
+	}
+
+	if err := d.Put(ctx, k, []byte("bar")); err != nil {
+		t.Fatal(err)
+	}
+	if exp, err := d.GetExpiration(ctx, k); err != nil || !exp.IsZero() {
+		t.Fatal("expected zero expiration for key without TTL", exp, err)
+	}
+
+	if err := d.SetTTL(ctx, k, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	clock.Add(time.Second)
+	if has, _ := d.Has(ctx, k); !has {
+		t.Fatal("expected to have key before expiration")
+	}
+
+	// A plain Put clears the TTL.
+	if err := d.Put(ctx, k, []byte("baz")); err != nil {
+		t.Fatal(err)
+	}
+	clock.Add(time.Hour)
+	if has, _ := d.Has(ctx, k); !has {
+		t.Fatal("expected Put to clear the TTL")
+	}
+}
+
+func TestQuery(t *testing.T) {
+	ctx := context.Background()
+	clock := newMockClock()
+	d := New(dstest.NewMapDatastoreForTest(t, key.KeyTypeString), Options{Clock: clock, SweepInterval: -1})
+	defer d.Close()
+
+	for i, s := range []string{"/a", "/b", "/c", "/d"} {
+		k := key.NewStrKey(s)
+		if err := d.PutWithTTL(ctx, k, []byte(s), time.Duration(i+1)*time.Second); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := d.Put(ctx, key.NewStrKey("/e"), []byte("/e")); err != nil {
+		t.Fatal(err)
+	}
+
+	clock.Add(2 * time.Second)
+
+	res, err := d.Query(ctx, dsq.Query{
+		Orders:            []dsq.Order{dsq.OrderByKey{}},
+		Offset:            1,
+		ReturnExpirations: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	es, err := res.Rest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(es) != 2 {
+		t.Fatalf("expected 2 results, got %d", len(es))
+	}
+	if es[0].Key.String() != "/d" || es[1].Key.String() != "/e" {
+		t.Fatalf("unexpected results: %v", dsq.EntryKeys(es))
+	}
+	if !es[0].Expiration.Equal(time.Unix(1600000004, 0)) {
+		t.Fatalf("unexpected expiration: %s", es[0].Expiration)
+	}
+	if !es[1].Expiration.IsZero() {
+		t.Fatalf("expected no expiration, got: %s", es[1].Expiration)
+	}
+}
+
+func TestBackgroundSweep(t *testing.T) {
+	ctx := context.Background()
+	clock := newMockClock()
+	child := dssync.MutexWrap(dstest.NewMapDatastoreForTest(t, key.KeyTypeString))
+	d := New(child, Options{Clock: clock, SweepInterval: time.Millisecond})
+	defer d.Close()
+
+	k := key.NewStrKey("/foo")
+	if err := d.PutWithTTL(ctx, k, []byte("bar"), time.Second); err != nil {
+		t.Fatal(err)
+	}
+	clock.Add(time.Second)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		has, err := child.Has(ctx, k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !has {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expired key was not swept")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
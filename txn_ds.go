@@ -0,0 +1,411 @@
+// Copyright (c) 2020 DAOT Labs. All rights reserved. Use of this source
+// code is governed by MIT license that can be found in the LICENSE file.
+
+package datastore
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/daotl/go-datastore/key"
+	dsq "github.com/daotl/go-datastore/query"
+)
+
+// ErrTxnDone is returned when using a transaction which has already been
+// committed or discarded.
+var ErrTxnDone = errors.New("datastore: transaction has already been committed or discarded")
+
+// version is a value of a key committed at a given timestamp.
+type version struct {
+	ts      uint64
+	value   []byte
+	deleted bool
+}
+
+// versions holds all the versions of a key still visible to some
+// transaction, from the oldest to the newest.
+type versions struct {
+	key  key.Key
+	list []version
+}
+
+// at returns the newest version committed at or before `ts`.
+func (vs *versions) at(ts uint64) (version, bool) {
+	for i := len(vs.list) - 1; i >= 0; i-- {
+		if vs.list[i].ts <= ts {
+			return vs.list[i], true
+		}
+	}
+	return version{}, false
+}
+
+// TxnMapDatastore is an in-memory TxnDatastore. Transactions read from a
+// consistent snapshot taken when they are created (snapshot isolation), and
+// fail to commit with ErrTxnConflict if another transaction has written a
+// key they read in the meantime.
+//
+// Unlike MapDatastore, TxnMapDatastore is thread-safe, but a single Txn must
+// not be used concurrently.
+type TxnMapDatastore struct {
+	ktype key.KeyType
+
+	lk     sync.RWMutex
+	ts     uint64
+	values map[string]*versions
+	// active counts the running transactions by read timestamp, so that
+	// versions they may read are not pruned.
+	active map[uint64]int
+}
+
+var _ TxnDatastore = (*TxnMapDatastore)(nil)
+var _ Batching = (*TxnMapDatastore)(nil)
+
+// NewTxnMapDatastore constructs a TxnMapDatastore.
+func NewTxnMapDatastore(ktype key.KeyType) (*TxnMapDatastore, error) {
+	if !(ktype == key.KeyTypeString || ktype == key.KeyTypeBytes) {
+		return nil, key.ErrKeyTypeNotSupported
+	}
+	return &TxnMapDatastore{
+		ktype:  ktype,
+		values: make(map[string]*versions),
+		active: make(map[uint64]int),
+	}, nil
+}
+
+// get returns the value of `key` visible at `ts`, the caller must hold d.lk.
+func (d *TxnMapDatastore) get(k key.Key, ts uint64) ([]byte, bool) {
+	vs, ok := d.values[k.String()]
+	if !ok {
+		return nil, false
+	}
+	v, ok := vs.at(ts)
+	if !ok || v.deleted {
+		return nil, false
+	}
+	return v.value, true
+}
+
+// entries returns all entries visible at `ts`, the caller must hold d.lk.
+func (d *TxnMapDatastore) entries(ts uint64, keysOnly bool) map[string]dsq.Entry {
+	re := make(map[string]dsq.Entry, len(d.values))
+	for ks, vs := range d.values {
+		v, ok := vs.at(ts)
+		if !ok || v.deleted {
+			continue
+		}
+		e := dsq.Entry{Key: vs.key, Size: len(v.value)}
+		if !keysOnly {
+			e.Value = v.value
+		}
+		re[ks] = e
+	}
+	return re
+}
+
+// commit writes `ops` as a new version, the caller must hold d.lk for
+// writing.
+func (d *TxnMapDatastore) commit(ops map[string]op) {
+	d.ts++
+	oldest := d.oldestReadTs()
+	for ks, o := range ops {
+		vs, ok := d.values[ks]
+		if !ok {
+			if o.delete {
+				continue
+			}
+			vs = &versions{key: o.key}
+			d.values[ks] = vs
+		}
+		vs.list = append(vs.list, version{ts: d.ts, value: o.value, deleted: o.delete})
+		d.prune(ks, vs, oldest)
+	}
+}
+
+// oldestReadTs returns the read timestamp of the oldest running transaction,
+// or the current timestamp if there is none. The caller must hold d.lk.
+func (d *TxnMapDatastore) oldestReadTs() uint64 {
+	oldest := d.ts
+	for ts := range d.active {
+		if ts < oldest {
+			oldest = ts
+		}
+	}
+	return oldest
+}
+
+// prune drops the versions of a key which can't be read by any transaction
+// anymore, the caller must hold d.lk for writing.
+func (d *TxnMapDatastore) prune(ks string, vs *versions, oldest uint64) {
+	// Keep the newest version visible to the oldest reader and all the
+	// versions after it.
+	i := len(vs.list) - 1
+	for ; i > 0; i-- {
+		if vs.list[i].ts <= oldest {
+			break
+		}
+	}
+	vs.list = vs.list[i:]
+	if len(vs.list) == 1 && vs.list[0].deleted && vs.list[0].ts <= oldest {
+		delete(d.values, ks)
+	}
+}
+
+// Put implements Datastore.Put
+func (d *TxnMapDatastore) Put(ctx context.Context, key key.Key, value []byte) error {
+	d.lk.Lock()
+	defer d.lk.Unlock()
+	d.commit(map[string]op{key.String(): {key: key, value: value}})
+	return nil
+}
+
+// Delete implements Datastore.Delete
+func (d *TxnMapDatastore) Delete(ctx context.Context, key key.Key) error {
+	d.lk.Lock()
+	defer d.lk.Unlock()
+	d.commit(map[string]op{key.String(): {key: key, delete: true}})
+	return nil
+}
+
+// Sync implements Datastore.Sync
+func (d *TxnMapDatastore) Sync(ctx context.Context, prefix key.Key) error {
+	return nil
+}
+
+// Get implements Datastore.Get
+func (d *TxnMapDatastore) Get(ctx context.Context, key key.Key) (value []byte, err error) {
+	d.lk.RLock()
+	defer d.lk.RUnlock()
+	value, found := d.get(key, d.ts)
+	if !found {
+		return nil, ErrNotFound
+	}
+	return value, nil
+}
+
+// Has implements Datastore.Has
+func (d *TxnMapDatastore) Has(ctx context.Context, key key.Key) (exists bool, err error) {
+	d.lk.RLock()
+	defer d.lk.RUnlock()
+	_, found := d.get(key, d.ts)
+	return found, nil
+}
+
+// GetSize implements Datastore.GetSize
+func (d *TxnMapDatastore) GetSize(ctx context.Context, key key.Key) (size int, err error) {
+	d.lk.RLock()
+	defer d.lk.RUnlock()
+	value, found := d.get(key, d.ts)
+	if !found {
+		return -1, ErrNotFound
+	}
+	return len(value), nil
+}
+
+// Query implements Datastore.Query
+func (d *TxnMapDatastore) Query(ctx context.Context, q dsq.Query) (dsq.Results, error) {
+	d.lk.RLock()
+	entries := d.entries(d.ts, q.KeysOnly)
+	d.lk.RUnlock()
+
+	re := make([]dsq.Entry, 0, len(entries))
+	for _, e := range entries {
+		re = append(re, e)
+	}
+	r := dsq.ResultsWithEntries(q, re)
+	r = dsq.NaiveQueryApply(q, r)
+	return r, nil
+}
+
+func (d *TxnMapDatastore) Batch(ctx context.Context) (Batch, error) {
+	return NewBasicBatch(d), nil
+}
+
+func (d *TxnMapDatastore) Close() error {
+	return nil
+}
+
+// NewTransaction implements TxnDatastore.NewTransaction
+func (d *TxnMapDatastore) NewTransaction(ctx context.Context, readOnly bool) (Txn, error) {
+	d.lk.Lock()
+	defer d.lk.Unlock()
+	d.active[d.ts]++
+	return &mapTxn{
+		d:        d,
+		readTs:   d.ts,
+		readOnly: readOnly,
+		reads:    make(map[string]struct{}),
+		writes:   make(map[string]op),
+	}, nil
+}
+
+// mapTxn is a transaction of a TxnMapDatastore.
+type mapTxn struct {
+	d        *TxnMapDatastore
+	readTs   uint64
+	readOnly bool
+	done     bool
+
+	reads  map[string]struct{}
+	writes map[string]op
+}
+
+// get looks up `key` in the pending writes first, then in the snapshot.
+func (t *mapTxn) get(k key.Key) ([]byte, bool, error) {
+	if t.done {
+		return nil, false, ErrTxnDone
+	}
+	ks := k.String()
+	if o, ok := t.writes[ks]; ok {
+		return o.value, !o.delete, nil
+	}
+	t.reads[ks] = struct{}{}
+
+	t.d.lk.RLock()
+	defer t.d.lk.RUnlock()
+	value, found := t.d.get(k, t.readTs)
+	return value, found, nil
+}
+
+// Get implements Txn.Get
+func (t *mapTxn) Get(ctx context.Context, key key.Key) (value []byte, err error) {
+	value, found, err := t.get(key)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, ErrNotFound
+	}
+	return value, nil
+}
+
+// Has implements Txn.Has
+func (t *mapTxn) Has(ctx context.Context, key key.Key) (exists bool, err error) {
+	_, found, err := t.get(key)
+	return found, err
+}
+
+// GetSize implements Txn.GetSize
+func (t *mapTxn) GetSize(ctx context.Context, key key.Key) (size int, err error) {
+	value, found, err := t.get(key)
+	if err != nil {
+		return -1, err
+	}
+	if !found {
+		return -1, ErrNotFound
+	}
+	return len(value), nil
+}
+
+// Query implements Txn.Query, the results include the pending writes of the
+// transaction.
+func (t *mapTxn) Query(ctx context.Context, q dsq.Query) (dsq.Results, error) {
+	if t.done {
+		return nil, ErrTxnDone
+	}
+
+	t.d.lk.RLock()
+	entries := t.d.entries(t.readTs, q.KeysOnly)
+	t.d.lk.RUnlock()
+
+	for ks, o := range t.writes {
+		if o.delete {
+			delete(entries, ks)
+			continue
+		}
+		e := dsq.Entry{Key: o.key, Size: len(o.value)}
+		if !q.KeysOnly {
+			e.Value = o.value
+		}
+		entries[ks] = e
+	}
+
+	re := make([]dsq.Entry, 0, len(entries))
+	for _, e := range entries {
+		re = append(re, e)
+	}
+	r := dsq.ResultsWithEntries(q, re)
+	r = dsq.NaiveQueryApply(q, r)
+
+	// Record the keys returned as read for conflict detection.
+	return dsq.ResultsFromIterator(q, dsq.Iterator{
+		Next: func() (dsq.Result, bool) {
+			res, ok := r.NextSync()
+			if ok && res.Error == nil {
+				t.reads[res.Key.String()] = struct{}{}
+			}
+			return res, ok
+		},
+		Close: func() error {
+			return r.Close()
+		},
+	}), nil
+}
+
+// Put implements Txn.Put
+func (t *mapTxn) Put(ctx context.Context, key key.Key, value []byte) error {
+	if t.done {
+		return ErrTxnDone
+	}
+	if t.readOnly {
+		return ErrReadOnly
+	}
+	t.writes[key.String()] = op{key: key, value: value}
+	return nil
+}
+
+// Delete implements Txn.Delete
+func (t *mapTxn) Delete(ctx context.Context, key key.Key) error {
+	if t.done {
+		return ErrTxnDone
+	}
+	if t.readOnly {
+		return ErrReadOnly
+	}
+	t.writes[key.String()] = op{key: key, delete: true}
+	return nil
+}
+
+// Commit implements Txn.Commit
+func (t *mapTxn) Commit(ctx context.Context) error {
+	if t.done {
+		return ErrTxnDone
+	}
+
+	t.d.lk.Lock()
+	defer t.d.lk.Unlock()
+	t.finish()
+
+	if len(t.writes) == 0 {
+		return nil
+	}
+	for ks := range t.reads {
+		vs, ok := t.d.values[ks]
+		if ok && vs.list[len(vs.list)-1].ts > t.readTs {
+			return ErrTxnConflict
+		}
+	}
+	t.d.commit(t.writes)
+	return nil
+}
+
+// Discard implements Txn.Discard
+func (t *mapTxn) Discard(ctx context.Context) {
+	if t.done {
+		return
+	}
+
+	t.d.lk.Lock()
+	defer t.d.lk.Unlock()
+	t.finish()
+}
+
+// finish unregisters the transaction, the caller must hold t.d.lk for
+// writing.
+func (t *mapTxn) finish() {
+	t.done = true
+	t.d.active[t.readTs]--
+	if t.d.active[t.readTs] == 0 {
+		delete(t.d.active, t.readTs)
+	}
+}
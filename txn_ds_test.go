@@ -0,0 +1,206 @@
+// Copyright (c) 2020 DAOT Labs. All rights reserved. Use of this source
+// code is governed by MIT license that can be found in the LICENSE file.
+
+package datastore_test
+
+import (
+	"context"
+	"testing"
+
+	dstore "github.com/daotl/go-datastore"
+	"github.com/daotl/go-datastore/key"
+	dsq "github.com/daotl/go-datastore/query"
+	dstest "github.com/daotl/go-datastore/test"
+)
+
+func newTxnMapDatastore(t *testing.T, ktype key.KeyType) *dstore.TxnMapDatastore {
+	ds, err := dstore.NewTxnMapDatastore(ktype)
+	if err != nil {
+		t.Fatal("error creating TxnMapDatastore: ", err)
+	}
+	return ds
+}
+
+func testTxnMapDatastore(t *testing.T, ktype key.KeyType) {
+	dstest.SubtestAll(t, ktype, newTxnMapDatastore(t, ktype))
+}
+
+func TestTxnMapDatastore(t *testing.T) {
+	testTxnMapDatastore(t, key.KeyTypeString)
+	testTxnMapDatastore(t, key.KeyTypeBytes)
+}
+
+func TestTxnSnapshotIsolation(t *testing.T) {
+	ctx := context.Background()
+	ds := newTxnMapDatastore(t, key.KeyTypeString)
+
+	ka := key.NewStrKey("/a")
+	kb := key.NewStrKey("/b")
+	if err := ds.Put(ctx, ka, []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+
+	txn, err := ds.NewTransaction(ctx, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer txn.Discard(ctx)
+
+	if err := ds.Put(ctx, ka, []byte("2")); err != nil {
+		t.Fatal(err)
+	}
+	if err := ds.Put(ctx, kb, []byte("3")); err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := txn.Get(ctx, ka)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(v) != "1" {
+		t.Fatalf("expected to read the snapshot value, got %q", v)
+	}
+	if has, _ := txn.Has(ctx, kb); has {
+		t.Fatal("key written after the transaction started should not be visible")
+	}
+
+	res, err := txn.Query(ctx, dsq.Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	es, err := res.Rest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(es) != 1 || !es[0].Key.Equal(ka) || string(es[0].Value) != "1" {
+		t.Fatalf("unexpected query results: %v", es)
+	}
+
+	if err := txn.Put(ctx, ka, []byte("4")); err != dstore.ErrReadOnly {
+		t.Fatal("expected ErrReadOnly writing to a read-only transaction, got: ", err)
+	}
+	if err := txn.Delete(ctx, ka); err != dstore.ErrReadOnly {
+		t.Fatal("expected ErrReadOnly deleting from a read-only transaction, got: ", err)
+	}
+}
+
+func TestTxnReadYourWrites(t *testing.T) {
+	ctx := context.Background()
+	ds := newTxnMapDatastore(t, key.KeyTypeString)
+
+	ka := key.NewStrKey("/a")
+	kb := key.NewStrKey("/b")
+	if err := ds.Put(ctx, ka, []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+
+	txn, err := ds.NewTransaction(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Delete(ctx, ka); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Put(ctx, kb, []byte("2")); err != nil {
+		t.Fatal(err)
+	}
+
+	if has, _ := txn.Has(ctx, ka); has {
+		t.Fatal("deleted key should not be visible in the transaction")
+	}
+	if size, err := txn.GetSize(ctx, kb); err != nil || size != 1 {
+		t.Fatal("expected to see own write", size, err)
+	}
+	res, err := txn.Query(ctx, dsq.Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	es, err := res.Rest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(es) != 1 || !es[0].Key.Equal(kb) {
+		t.Fatalf("unexpected query results: %v", es)
+	}
+
+	// Not visible outside before commit.
+	if has, _ := ds.Has(ctx, kb); has {
+		t.Fatal("uncommitted write should not be visible")
+	}
+
+	if err := txn.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if has, _ := ds.Has(ctx, ka); has {
+		t.Fatal("committed delete should be visible")
+	}
+	if has, _ := ds.Has(ctx, kb); !has {
+		t.Fatal("committed write should be visible")
+	}
+	if err := txn.Commit(ctx); err != dstore.ErrTxnDone {
+		t.Fatal("expected ErrTxnDone committing twice, got: ", err)
+	}
+}
+
+func TestTxnConflict(t *testing.T) {
+	ctx := context.Background()
+	ds := newTxnMapDatastore(t, key.KeyTypeBytes)
+
+	k := key.NewBytesKeyFromString("counter")
+	if err := ds.Put(ctx, k, []byte{0}); err != nil {
+		t.Fatal(err)
+	}
+
+	increment := func(txn dstore.Txn) {
+		v, err := txn.Get(ctx, k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := txn.Put(ctx, k, []byte{v[0] + 1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	txn1, _ := ds.NewTransaction(ctx, false)
+	txn2, _ := ds.NewTransaction(ctx, false)
+	increment(txn1)
+	increment(txn2)
+
+	if err := txn1.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn2.Commit(ctx); err != dstore.ErrTxnConflict {
+		t.Fatal("expected ErrTxnConflict, got: ", err)
+	}
+
+	v, err := ds.Get(ctx, k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v[0] != 1 {
+		t.Fatalf("expected counter to be 1, got %d", v[0])
+	}
+
+	// Blind writes don't conflict.
+	txn3, _ := ds.NewTransaction(ctx, false)
+	if err := ds.Put(ctx, k, []byte{5}); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn3.Put(ctx, k, []byte{6}); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn3.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Discarded transactions leave nothing behind.
+	txn4, _ := ds.NewTransaction(ctx, false)
+	if err := txn4.Delete(ctx, k); err != nil {
+		t.Fatal(err)
+	}
+	txn4.Discard(ctx)
+	txn4.Discard(ctx)
+	if has, _ := ds.Has(ctx, k); !has {
+		t.Fatal("discarded delete should not be applied")
+	}
+}
@@ -0,0 +1,11 @@
+// Copyright (c) 2020 DAOT Labs. All rights reserved. Use of this source
+// code is governed by MIT license that can be found in the LICENSE file.
+
+// Package valuetransform introduces a Datastore Shim that transforms values
+// before passing them to its child, and inverts them on the way back out. It
+// can be used to compress, encrypt or checksum values transparently.
+//
+// Use the Wrap function to wrap a datastore with any ValueTransform. Value
+// filters and orders of queries are applied to the inverted values by the
+// wrapper, never by the child which only sees transformed values.
+package valuetransform
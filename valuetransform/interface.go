@@ -0,0 +1,20 @@
+// Copyright (c) 2020 DAOT Labs. All rights reserved. Use of this source
+// code is governed by MIT license that can be found in the LICENSE file.
+
+package valuetransform
+
+import key "github.com/daotl/go-datastore/key"
+
+// ValueTransform is an object with a pair of functions for (invertibly)
+// transforming the values stored under keys. The key can be used to bind the
+// transformed value to it, for example as the additional data of an AEAD.
+type ValueTransform interface {
+	ConvertValue(k key.Key, value []byte) ([]byte, error)
+	InvertValue(k key.Key, value []byte) ([]byte, error)
+}
+
+// Sizer is an optional interface of ValueTransforms which can tell the size
+// of the inverted value from the transformed value without inverting it.
+type Sizer interface {
+	InvertedSize(k key.Key, value []byte) (int, error)
+}
@@ -0,0 +1,134 @@
+// Copyright (c) 2020 DAOT Labs. All rights reserved. Use of this source
+// code is governed by MIT license that can be found in the LICENSE file.
+
+package valuetransform
+
+import (
+	"context"
+
+	ds "github.com/daotl/go-datastore"
+	key "github.com/daotl/go-datastore/key"
+	dsq "github.com/daotl/go-datastore/query"
+)
+
+// The operations shared by Datastore and its transactions.
+
+func put(ctx context.Context, w ds.Write, t ValueTransform, k key.Key, value []byte) error {
+	v, err := t.ConvertValue(k, value)
+	if err != nil {
+		return err
+	}
+	return w.Put(ctx, k, v)
+}
+
+func get(ctx context.Context, r ds.Read, t ValueTransform, k key.Key) ([]byte, error) {
+	v, err := r.Get(ctx, k)
+	if err != nil {
+		return nil, err
+	}
+	return t.InvertValue(k, v)
+}
+
+func getSize(ctx context.Context, r ds.Read, t ValueTransform, k key.Key) (int, error) {
+	v, err := r.Get(ctx, k)
+	if err != nil {
+		return -1, err
+	}
+	if s, ok := t.(Sizer); ok {
+		return s.InvertedSize(k, v)
+	}
+	if v, err = t.InvertValue(k, v); err != nil {
+		return -1, err
+	}
+	return len(v), nil
+}
+
+func query(ctx context.Context, r ds.Read, t ValueTransform, q dsq.Query) (dsq.Results, error) {
+	nq, cq := prepareQuery(q)
+
+	cqr, err := r.Query(ctx, cq)
+	if err != nil {
+		return nil, err
+	}
+
+	qr := dsq.ResultsFromIterator(q, dsq.Iterator{
+		Next: func() (dsq.Result, bool) {
+			r, ok := cqr.NextSync()
+			if !ok || r.Error != nil {
+				return r, ok
+			}
+			if cq.KeysOnly {
+				r.Size = -1
+				return r, true
+			}
+			if r.Value, r.Error = t.InvertValue(r.Key, r.Value); r.Error == nil {
+				r.Size = len(r.Value)
+			}
+			return r, true
+		},
+		Close: func() error {
+			return cqr.Close()
+		},
+	})
+	qr = dsq.NaiveQueryApply(nq, qr)
+	if !q.KeysOnly || cq.KeysOnly {
+		return qr, nil
+	}
+
+	// The values were only needed by the wrapper.
+	return dsq.ResultsFromIterator(q, dsq.Iterator{
+		Next: func() (dsq.Result, bool) {
+			r, ok := qr.NextSync()
+			r.Value = nil
+			return r, ok
+		},
+		Close: func() error {
+			return qr.Close()
+		},
+	}), nil
+}
+
+// Split the query into a child query and a naive query. The child only sees
+// transformed values, so the filters and orders which may look at values are
+// left to the naive query.
+func prepareQuery(q dsq.Query) (naive, child dsq.Query) {
+	child = q
+	child.Filters = nil
+
+	for _, f := range q.Filters {
+		switch f.(type) {
+		case dsq.FilterKeyCompare, *dsq.FilterKeyCompare,
+			dsq.FilterKeyPrefix, *dsq.FilterKeyPrefix:
+			child.Filters = append(child.Filters, f)
+		default:
+			naive.Filters = append(naive.Filters, f)
+		}
+	}
+
+	if len(q.Orders) > 0 {
+		switch q.Orders[0].(type) {
+		case dsq.OrderByKey, *dsq.OrderByKey,
+			dsq.OrderByKeyDescending, *dsq.OrderByKeyDescending:
+			// Keys are unique, the other orders are never applied.
+			child.Orders = q.Orders[:1]
+		default:
+			naive.Orders = q.Orders
+			child.Orders = nil
+		}
+	}
+
+	if len(naive.Filters) > 0 || len(naive.Orders) > 0 {
+		naive.Offset = q.Offset
+		child.Offset = 0
+		naive.Limit = q.Limit
+		child.Limit = 0
+		// Value filters and orders need the values.
+		child.KeysOnly = false
+	}
+	if q.ReturnsSizes {
+		// The sizes returned by the child are the sizes of the transformed
+		// values.
+		child.KeysOnly = false
+	}
+	return naive, child
+}
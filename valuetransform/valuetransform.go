@@ -0,0 +1,209 @@
+// Copyright (c) 2020 DAOT Labs. All rights reserved. Use of this source
+// code is governed by MIT license that can be found in the LICENSE file.
+
+package valuetransform
+
+import (
+	"context"
+
+	ds "github.com/daotl/go-datastore"
+	key "github.com/daotl/go-datastore/key"
+	dsq "github.com/daotl/go-datastore/query"
+)
+
+// Wrap wraps a given datastore with a ValueTransform. The resulting wrapped
+// datastore will convert the values written to the child and invert the
+// values read from it.
+func Wrap(child ds.Datastore, t ValueTransform) *Datastore {
+	if t == nil {
+		panic("t (ValueTransform) is nil")
+	}
+
+	if child == nil {
+		panic("child (ds.Datastore) is nil")
+	}
+
+	return &Datastore{child: child, ValueTransform: t}
+}
+
+// Datastore keeps a ValueTransform
+type Datastore struct {
+	child ds.Datastore
+
+	ValueTransform
+}
+
+var _ ds.Datastore = (*Datastore)(nil)
+var _ ds.GCDatastore = (*Datastore)(nil)
+var _ ds.Batching = (*Datastore)(nil)
+var _ ds.TxnDatastore = (*Datastore)(nil)
+var _ ds.PersistentDatastore = (*Datastore)(nil)
+var _ ds.CheckedDatastore = (*Datastore)(nil)
+var _ ds.ScrubbedDatastore = (*Datastore)(nil)
+var _ ds.Shim = (*Datastore)(nil)
+
+// Children implements ds.Shim
+func (d *Datastore) Children() []ds.Datastore {
+	return []ds.Datastore{d.child}
+}
+
+// Capabilities implements ds.Capable
+func (d *Datastore) Capabilities() ds.Feature {
+	return ds.Features(d.child) & (ds.FeatureBatching | ds.FeatureTxn | ds.FeatureMaintenance)
+}
+
+// Put stores the given value, transforming it first.
+func (d *Datastore) Put(ctx context.Context, key key.Key, value []byte) error {
+	return put(ctx, d.child, d.ValueTransform, key, value)
+}
+
+// Get returns the value for given key, inverting it.
+func (d *Datastore) Get(ctx context.Context, key key.Key) (value []byte, err error) {
+	return get(ctx, d.child, d.ValueTransform, key)
+}
+
+// Has implements Datastore.Has
+func (d *Datastore) Has(ctx context.Context, key key.Key) (exists bool, err error) {
+	return d.child.Has(ctx, key)
+}
+
+// GetSize returns the size of the inverted value for given key, which is
+// computed by the ValueTransform if it's a Sizer, or by inverting the value
+// otherwise.
+func (d *Datastore) GetSize(ctx context.Context, key key.Key) (size int, err error) {
+	return getSize(ctx, d.child, d.ValueTransform, key)
+}
+
+// Delete removes the value for given key
+func (d *Datastore) Delete(ctx context.Context, key key.Key) error {
+	return d.child.Delete(ctx, key)
+}
+
+// Sync implements Datastore.Sync
+func (d *Datastore) Sync(ctx context.Context, prefix key.Key) error {
+	return d.child.Sync(ctx, prefix)
+}
+
+// Query implements Query, inverting values on the way back out.
+func (d *Datastore) Query(ctx context.Context, q dsq.Query) (dsq.Results, error) {
+	return query(ctx, d.child, d.ValueTransform, q)
+}
+
+// Close implements Datastore.Close
+func (d *Datastore) Close() error {
+	return d.child.Close()
+}
+
+// DiskUsage implements the PersistentDatastore interface.
+func (d *Datastore) DiskUsage(ctx context.Context) (uint64, error) {
+	return ds.DiskUsage(ctx, d.child)
+}
+
+// Batch implements Batching.Batch, values are transformed when they're added
+// to the batch.
+func (d *Datastore) Batch(ctx context.Context) (ds.Batch, error) {
+	bds, ok := d.child.(ds.Batching)
+	if !ok {
+		return nil, ds.ErrBatchUnsupported
+	}
+
+	childbatch, err := bds.Batch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &transformBatch{dst: childbatch, t: d.ValueTransform}, nil
+}
+
+type transformBatch struct {
+	dst ds.Batch
+
+	t ValueTransform
+}
+
+func (b *transformBatch) Put(ctx context.Context, key key.Key, value []byte) error {
+	return put(ctx, b.dst, b.t, key, value)
+}
+
+func (b *transformBatch) Delete(ctx context.Context, key key.Key) error {
+	return b.dst.Delete(ctx, key)
+}
+
+func (b *transformBatch) Commit(ctx context.Context) error {
+	return b.dst.Commit(ctx)
+}
+
+// Check implements CheckedDatastore.Check
+func (d *Datastore) Check(ctx context.Context) error {
+	if c, ok := d.child.(ds.CheckedDatastore); ok {
+		return c.Check(ctx)
+	}
+	return nil
+}
+
+// Scrub implements ScrubbedDatastore.Scrub
+func (d *Datastore) Scrub(ctx context.Context) error {
+	if c, ok := d.child.(ds.ScrubbedDatastore); ok {
+		return c.Scrub(ctx)
+	}
+	return nil
+}
+
+// CollectGarbage implements GCDatastore.CollectGarbage
+func (d *Datastore) CollectGarbage(ctx context.Context) error {
+	if c, ok := d.child.(ds.GCDatastore); ok {
+		return c.CollectGarbage(ctx)
+	}
+	return nil
+}
+
+// NewTransaction implements TxnDatastore.NewTransaction, values are
+// transformed like in the datastore.
+func (d *Datastore) NewTransaction(ctx context.Context, readOnly bool) (ds.Txn, error) {
+	tds, ok := d.child.(ds.TxnDatastore)
+	if !ok {
+		return nil, ds.ErrTxnUnsupported
+	}
+	t, err := tds.NewTransaction(ctx, readOnly)
+	if err != nil {
+		return nil, err
+	}
+	return &transformTxn{child: t, t: d.ValueTransform}, nil
+}
+
+type transformTxn struct {
+	child ds.Txn
+
+	t ValueTransform
+}
+
+func (t *transformTxn) Get(ctx context.Context, key key.Key) ([]byte, error) {
+	return get(ctx, t.child, t.t, key)
+}
+
+func (t *transformTxn) Has(ctx context.Context, key key.Key) (bool, error) {
+	return t.child.Has(ctx, key)
+}
+
+func (t *transformTxn) GetSize(ctx context.Context, key key.Key) (int, error) {
+	return getSize(ctx, t.child, t.t, key)
+}
+
+func (t *transformTxn) Query(ctx context.Context, q dsq.Query) (dsq.Results, error) {
+	return query(ctx, t.child, t.t, q)
+}
+
+func (t *transformTxn) Put(ctx context.Context, key key.Key, value []byte) error {
+	return put(ctx, t.child, t.t, key, value)
+}
+
+func (t *transformTxn) Delete(ctx context.Context, key key.Key) error {
+	return t.child.Delete(ctx, key)
+}
+
+func (t *transformTxn) Commit(ctx context.Context) error {
+	return t.child.Commit(ctx)
+}
+
+func (t *transformTxn) Discard(ctx context.Context) {
+	t.child.Discard(ctx)
+}
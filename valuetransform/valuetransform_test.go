@@ -0,0 +1,144 @@
+// Copyright (c) 2020 DAOT Labs. All rights reserved. Use of this source
+// code is governed by MIT license that can be found in the LICENSE file.
+
+package valuetransform
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	ds "github.com/daotl/go-datastore"
+	key "github.com/daotl/go-datastore/key"
+	dsq "github.com/daotl/go-datastore/query"
+	dstest "github.com/daotl/go-datastore/test"
+)
+
+// reverse reverses values, which changes their order, and fails to invert
+// the value "!".
+type reverse struct{}
+
+func (reverse) ConvertValue(k key.Key, value []byte) ([]byte, error) {
+	v := make([]byte, len(value))
+	for i, b := range value {
+		v[len(v)-1-i] = b
+	}
+	return v, nil
+}
+
+var errInvalid = errors.New("invalid value")
+
+func (r reverse) InvertValue(k key.Key, value []byte) ([]byte, error) {
+	if string(value) == "!" {
+		return nil, errInvalid
+	}
+	return r.ConvertValue(k, value)
+}
+
+func testSuite(t *testing.T, ktype key.KeyType) {
+	dstest.SubtestAll(t, ktype, Wrap(dstest.NewMapDatastoreForTest(t, ktype), reverse{}))
+}
+
+func TestSuite(t *testing.T) {
+	testSuite(t, key.KeyTypeString)
+	testSuite(t, key.KeyTypeBytes)
+}
+
+func TestTransform(t *testing.T) {
+	ctx := context.Background()
+	child := dstest.NewMapDatastoreForTest(t, key.KeyTypeString)
+	d := Wrap(child, reverse{})
+
+	k := key.NewStrKey("/a")
+	if err := d.Put(ctx, k, []byte("abc")); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := child.Get(ctx, k); err != nil || string(v) != "cba" {
+		t.Fatalf("expected the child to store cba, got %q, error: %v", v, err)
+	}
+	if v, err := d.Get(ctx, k); err != nil || string(v) != "abc" {
+		t.Fatalf("expected abc, got %q, error: %v", v, err)
+	}
+
+	if err := child.Put(ctx, k, []byte("!")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Get(ctx, k); err != errInvalid {
+		t.Fatal("expected the error of InvertValue, got: ", err)
+	}
+	if _, err := d.GetSize(ctx, k); err != errInvalid {
+		t.Fatal("expected the error of InvertValue, got: ", err)
+	}
+}
+
+func TestQuery(t *testing.T) {
+	ctx := context.Background()
+	d := Wrap(dstest.NewMapDatastoreForTest(t, key.KeyTypeString), reverse{})
+	for k, v := range map[string]string{"/a": "ab", "/b": "ba", "/c": "ca", "/d": "ac"} {
+		if err := d.Put(ctx, key.NewStrKey(k), []byte(v)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	res, err := d.Query(ctx, dsq.Query{
+		Filters:  []dsq.Filter{dsq.FilterValueCompare{Op: dsq.LessThan, Value: []byte("c")}},
+		Orders:   []dsq.Order{dsq.OrderByValue{}},
+		Limit:    2,
+		KeysOnly: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	es, err := res.Rest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(es) != 2 || es[0].Key.String() != "/a" || es[1].Key.String() != "/d" {
+		t.Fatalf("expected /a and /d, got %v", es)
+	}
+	for _, e := range es {
+		if e.Value != nil {
+			t.Fatal("expected no values for a KeysOnly query")
+		}
+	}
+
+	res, err = d.Query(ctx, dsq.Query{KeysOnly: true, ReturnsSizes: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	es, err = res.Rest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range es {
+		if e.Value != nil || e.Size != 2 {
+			t.Fatalf("unexpected entry %+v", e)
+		}
+	}
+}
+
+func TestBatch(t *testing.T) {
+	ctx := context.Background()
+	child := dstest.NewMapDatastoreForTest(t, key.KeyTypeString)
+	d := Wrap(child, reverse{})
+
+	b, err := d.Batch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Put(ctx, key.NewStrKey("/a"), []byte("abc")); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := child.Get(ctx, key.NewStrKey("/a")); err != nil || string(v) != "cba" {
+		t.Fatalf("expected the child to store cba, got %q, error: %v", v, err)
+	}
+
+	// Hide the Batch method of the child.
+	plain := struct{ ds.Datastore }{child}
+	if _, err := Wrap(plain, reverse{}).Batch(ctx); err != ds.ErrBatchUnsupported {
+		t.Fatal("expected ErrBatchUnsupported, got: ", err)
+	}
+}
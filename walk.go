@@ -0,0 +1,141 @@
+// Copyright (c) 2020 DAOT Labs. All rights reserved. Use of this source
+// code is governed by MIT license that can be found in the LICENSE file.
+
+package datastore
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/daotl/go-datastore/key"
+)
+
+// PrefixedShim is a Shim whose children are mounted at key prefixes, like
+// mount.Datastore.
+type PrefixedShim interface {
+	Shim
+
+	// ChildPrefixes returns the prefixes of the datastores returned by
+	// Children, in the same order.
+	ChildPrefixes() []key.Key
+}
+
+// SkipChildren is used as a return value from WalkFuncs to indicate that the
+// children of the datastore in the call are to be skipped. It is not
+// returned as an error by any function.
+var SkipChildren = errors.New("skip children")
+
+// WalkFunc is the type of the function called by Walk for each datastore of
+// a stack. `depth` is 0 for the datastore Walk is called with, 1 for its
+// children and so on.
+//
+// If the function returns SkipChildren, Walk skips the children of `d`, any
+// other non-nil error stops Walk which returns it.
+type WalkFunc func(d Datastore, depth int) error
+
+// Walk walks the tree of datastores rooted at `d` depth-first, calling `fn`
+// for each datastore before its children. Children are found through the
+// Shim interface.
+func Walk(d Datastore, fn WalkFunc) error {
+	err := walk(d, 0, fn)
+	if err == SkipChildren {
+		return nil
+	}
+	return err
+}
+
+func walk(d Datastore, depth int, fn WalkFunc) error {
+	if err := fn(d, depth); err != nil {
+		return err
+	}
+	s, ok := d.(Shim)
+	if !ok {
+		return nil
+	}
+	for _, c := range s.Children() {
+		if err := walk(c, depth+1, fn); err != nil && err != SkipChildren {
+			return err
+		}
+	}
+	return nil
+}
+
+// FindInStack finds the first datastore in the tree rooted at `d`, in the
+// order of Walk, which is assignable to the value pointed to by `target`,
+// and if one is found, sets `target` to it and returns true.
+//
+// Like errors.As, FindInStack panics if `target` is not a non-nil pointer
+// to either a type that implements Datastore, or to any interface type.
+func FindInStack(d Datastore, target interface{}) bool {
+	if target == nil {
+		panic("datastore: target cannot be nil")
+	}
+	val := reflect.ValueOf(target)
+	typ := val.Type()
+	if typ.Kind() != reflect.Ptr || val.IsNil() {
+		panic("datastore: target must be a non-nil pointer")
+	}
+	targetType := typ.Elem()
+	if targetType.Kind() != reflect.Interface &&
+		!targetType.Implements(reflect.TypeOf((*Datastore)(nil)).Elem()) {
+		panic("datastore: *target must be interface or implement Datastore")
+	}
+
+	found := false
+	Walk(d, func(d Datastore, _ int) error {
+		if reflect.TypeOf(d).AssignableTo(targetType) {
+			val.Elem().Set(reflect.ValueOf(d))
+			found = true
+			return errStopWalk
+		}
+		return nil
+	})
+	return found
+}
+
+var errStopWalk = errors.New("stop walk")
+
+// Describe returns a human-readable tree of the datastores rooted at `d`,
+// one datastore per line with its type and features, prefixed by its mount
+// prefix if its parent is a PrefixedShim.
+func Describe(d Datastore) string {
+	var sb strings.Builder
+	describe(&sb, d, "", "", "")
+	return sb.String()
+}
+
+func describe(sb *strings.Builder, d Datastore, prefix, indent, childIndent string) {
+	sb.WriteString(indent)
+	if prefix != "" {
+		sb.WriteString(prefix)
+		sb.WriteString(": ")
+	}
+	fmt.Fprintf(sb, "%T [%s]\n", d, Features(d))
+
+	s, ok := d.(Shim)
+	if !ok {
+		return
+	}
+	children := s.Children()
+	var prefixes []key.Key
+	if ps, ok := d.(PrefixedShim); ok {
+		prefixes = ps.ChildPrefixes()
+	}
+	for i, c := range children {
+		var p string
+		if i < len(prefixes) {
+			p = prefixes[i].String()
+			if p == "" {
+				// The empty BytesKey.
+				p = `""`
+			}
+		}
+		if i == len(children)-1 {
+			describe(sb, c, p, childIndent+"└── ", childIndent+"    ")
+		} else {
+			describe(sb, c, p, childIndent+"├── ", childIndent+"│   ")
+		}
+	}
+}
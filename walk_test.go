@@ -0,0 +1,116 @@
+// Copyright (c) 2020 DAOT Labs. All rights reserved. Use of this source
+// code is governed by MIT license that can be found in the LICENSE file.
+
+package datastore_test
+
+import (
+	"fmt"
+	"testing"
+
+	dstore "github.com/daotl/go-datastore"
+	"github.com/daotl/go-datastore/key"
+	"github.com/daotl/go-datastore/mount"
+	dssync "github.com/daotl/go-datastore/sync"
+	dstest "github.com/daotl/go-datastore/test"
+)
+
+func newStack(t *testing.T) (dstore.Datastore, *dstore.MapDatastore, *dstore.TxnMapDatastore) {
+	mapds := dstest.NewMapDatastoreForTest(t, key.KeyTypeString)
+	txnds := newTxnMapDatastore(t, key.KeyTypeString)
+	m := mount.New([]mount.Mount{
+		{Prefix: key.NewStrKey("/"), Datastore: txnds},
+		{Prefix: key.NewStrKey("/foo"), Datastore: dssync.MutexWrap(mapds)},
+	})
+	return dstore.NewLogDatastore(m, "log"), mapds, txnds
+}
+
+func TestWalk(t *testing.T) {
+	stack, _, _ := newStack(t)
+
+	var visited []string
+	err := dstore.Walk(stack, func(d dstore.Datastore, depth int) error {
+		visited = append(visited, fmt.Sprintf("%d %T", depth, d))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"0 *datastore.LogDatastore",
+		"1 *mount.Datastore",
+		"2 *sync.MutexDatastore",
+		"3 *datastore.MapDatastore",
+		"2 *datastore.TxnMapDatastore",
+	}
+	if fmt.Sprint(visited) != fmt.Sprint(expected) {
+		t.Fatalf("expected to visit %v, got %v", expected, visited)
+	}
+
+	visited = nil
+	err = dstore.Walk(stack, func(d dstore.Datastore, depth int) error {
+		visited = append(visited, fmt.Sprintf("%d %T", depth, d))
+		if _, ok := d.(*dssync.MutexDatastore); ok {
+			return dstore.SkipChildren
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(visited) != 4 {
+		t.Fatalf("expected the children of MutexDatastore to be skipped, visited %v", visited)
+	}
+
+	stop := fmt.Errorf("stop")
+	if err := dstore.Walk(stack, func(d dstore.Datastore, depth int) error {
+		return stop
+	}); err != stop {
+		t.Fatal("expected the error of the WalkFunc, got: ", err)
+	}
+}
+
+func TestFindInStack(t *testing.T) {
+	stack, mapds, txnds := newStack(t)
+
+	var found *dstore.MapDatastore
+	if !dstore.FindInStack(stack, &found) || found != mapds {
+		t.Fatal("expected to find the MapDatastore")
+	}
+
+	var txn dstore.TxnDatastore
+	if !dstore.FindInStack(stack, &txn) || txn != txnds {
+		t.Fatal("expected to find the TxnDatastore")
+	}
+
+	var shim dstore.Shim
+	if !dstore.FindInStack(stack, &shim) || shim != stack {
+		t.Fatal("expected to find the LogDatastore first")
+	}
+
+	var ttl dstore.TTLDatastore
+	if dstore.FindInStack(stack, &ttl) {
+		t.Fatal("there is no TTLDatastore in the stack")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected FindInStack to panic on invalid target")
+		}
+	}()
+	var s string
+	dstore.FindInStack(stack, &s)
+}
+
+func TestDescribe(t *testing.T) {
+	stack, _, _ := newStack(t)
+
+	expected := `*datastore.LogDatastore [Batching|Bulk]
+└── *mount.Datastore [Batching|CAS|Bulk]
+    ├── /foo: *sync.MutexDatastore [Batching|CAS|Snapshot]
+    │   └── *datastore.MapDatastore [Batching|CAS|Snapshot]
+    └── /: *datastore.TxnMapDatastore [Batching|Txn]
+`
+	if s := dstore.Describe(stack); s != expected {
+		t.Fatalf("expected:\n%s\ngot:\n%s", expected, s)
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright (c) 2020 DAOT Labs. All rights reserved. Use of this source
+// code is governed by MIT license that can be found in the LICENSE file.
+
+package datastore
+
+import (
+	"context"
+	"errors"
+
+	"github.com/daotl/go-datastore/key"
+)
+
+// EventType is the type of an Event.
+type EventType int
+
+const (
+	// EventPut means a value was stored under the key.
+	EventPut EventType = iota
+	// EventDelete means the key was deleted.
+	EventDelete
+	// EventExpire means the key expired and was deleted, see TTL.
+	EventExpire
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventPut:
+		return "Put"
+	case EventDelete:
+		return "Delete"
+	case EventExpire:
+		return "Expire"
+	default:
+		return "Unknown"
+	}
+}
+
+// Event is a change to a key of a watched datastore.
+type Event struct {
+	Type EventType
+	Key  key.Key
+	// Value is the value stored for EventPut, if it's known.
+	Value []byte
+}
+
+// Watcher encapsulates the Watch method.
+type Watcher interface {
+	// Watch returns a channel of the events on the keys under `prefix`, with
+	// the same semantics as query.Query.Prefix, all the keys are watched if
+	// `prefix` is nil. The channel is closed once `ctx` is done.
+	//
+	// Subscribers which don't keep up with the events are handled according
+	// to the overflow policy of the datastore. Unless documented otherwise,
+	// the channel is closed when events can't be delivered, so if it's
+	// closed before `ctx` is done, the subscriber missed events and should
+	// resync with a Query before watching again.
+	Watch(ctx context.Context, prefix key.Key) (<-chan Event, error)
+}
+
+// WatchDatastore is an interface that should be implemented by datastores
+// which notify of the changes to their keys.
+type WatchDatastore interface {
+	Datastore
+	Watcher
+}
+
+// ErrWatchUnsupported is returned by Watch on wrappers whose child doesn't
+// support watching.
+var ErrWatchUnsupported = errors.New("this datastore does not support watching")
+
+// Watch calls d.Watch if `d` is a WatchDatastore, otherwise it returns
+// ErrWatchUnsupported.
+func Watch(ctx context.Context, d Datastore, prefix key.Key) (<-chan Event, error) {
+	if w, ok := d.(Watcher); ok {
+		return w.Watch(ctx, prefix)
+	}
+	return nil, ErrWatchUnsupported
+}
@@ -0,0 +1,477 @@
+// Copyright (c) 2020 DAOT Labs. All rights reserved. Use of this source
+// code is governed by MIT license that can be found in the LICENSE file.
+
+// Package watch provides a datastore wrapper which notifies subscribers of
+// the writes going through it (see datastore.Watcher), and the Hub it's built
+// on for datastores which implement Watcher natively.
+package watch
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	ds "github.com/daotl/go-datastore"
+	key "github.com/daotl/go-datastore/key"
+	dsq "github.com/daotl/go-datastore/query"
+)
+
+// DefaultBufferSize is the default number of events buffered for each
+// subscriber.
+const DefaultBufferSize = 64
+
+// OverflowPolicy tells what to do when the buffer of a subscriber is full.
+type OverflowPolicy int
+
+const (
+	// OverflowClose closes the channel of the subscriber, which then knows
+	// it missed events and should resync. It's the default.
+	OverflowClose OverflowPolicy = iota
+	// OverflowDrop drops the events silently.
+	OverflowDrop
+	// OverflowBlock blocks the writes until the subscriber catches up, or
+	// stops watching. A single slow subscriber slows down all the writers.
+	OverflowBlock
+)
+
+// Options are the options of a Hub or a watch datastore.
+type Options struct {
+	// BufferSize is the number of events buffered for each subscriber,
+	// DefaultBufferSize is used if it's zero.
+	BufferSize int
+	// Overflow is the policy applied to subscribers whose buffer is full.
+	Overflow OverflowPolicy
+}
+
+type subscriber struct {
+	prefix key.Key
+	done   <-chan struct{}
+
+	lk     sync.Mutex
+	ch     chan ds.Event
+	closed bool
+}
+
+func (s *subscriber) close() {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	if !s.closed {
+		s.closed = true
+		close(s.ch)
+	}
+}
+
+// Hub dispatches events to subscribers by prefix. It's safe for concurrent
+// use.
+type Hub struct {
+	opts Options
+
+	lk      sync.Mutex
+	subs    map[*subscriber]struct{}
+	closed  bool
+	closing chan struct{}
+}
+
+// NewHub creates a Hub.
+func NewHub(opts Options) *Hub {
+	if opts.BufferSize <= 0 {
+		opts.BufferSize = DefaultBufferSize
+	}
+	return &Hub{
+		opts:    opts,
+		subs:    make(map[*subscriber]struct{}),
+		closing: make(chan struct{}),
+	}
+}
+
+// Watch implements Watcher.Watch, it returns ErrClosed if the hub is
+// closed.
+func (h *Hub) Watch(ctx context.Context, prefix key.Key) (<-chan ds.Event, error) {
+	h.lk.Lock()
+	defer h.lk.Unlock()
+	if h.closed {
+		return nil, ds.ErrClosed
+	}
+
+	s := &subscriber{
+		prefix: prefix,
+		done:   ctx.Done(),
+		ch:     make(chan ds.Event, h.opts.BufferSize),
+	}
+	h.subs[s] = struct{}{}
+	go func() {
+		select {
+		case <-ctx.Done():
+			h.remove(s)
+		case <-h.closing:
+		}
+	}()
+	return s.ch, nil
+}
+
+func (h *Hub) remove(s *subscriber) {
+	h.lk.Lock()
+	delete(h.subs, s)
+	h.lk.Unlock()
+	s.close()
+}
+
+// Publish sends the events to the subscribers watching their keys, in
+// order. Events published concurrently may be received in any order.
+func (h *Hub) Publish(events ...ds.Event) {
+	h.lk.Lock()
+	subs := make([]*subscriber, 0, len(h.subs))
+	for s := range h.subs {
+		subs = append(subs, s)
+	}
+	h.lk.Unlock()
+
+	for _, s := range subs {
+		for _, e := range events {
+			if !matches(s.prefix, e.Key) {
+				continue
+			}
+			if !h.send(s, e) {
+				h.remove(s)
+				break
+			}
+		}
+	}
+}
+
+// send sends the event to the subscriber, and returns false if the
+// subscriber overflowed and must be removed.
+func (h *Hub) send(s *subscriber, e ds.Event) bool {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	if s.closed {
+		return true
+	}
+
+	select {
+	case s.ch <- e:
+		return true
+	default:
+	}
+
+	switch h.opts.Overflow {
+	case OverflowDrop:
+		return true
+	case OverflowBlock:
+		select {
+		case s.ch <- e:
+		case <-s.done:
+		case <-h.closing:
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+// Close closes the channels of all the subscribers.
+func (h *Hub) Close() {
+	h.lk.Lock()
+	if h.closed {
+		h.lk.Unlock()
+		return
+	}
+	h.closed = true
+	close(h.closing)
+	subs := h.subs
+	h.subs = nil
+	h.lk.Unlock()
+
+	for s := range subs {
+		s.close()
+	}
+}
+
+// matches reports whether `k` is under `prefix` as defined by
+// query.Query.Prefix.
+func matches(prefix, k key.Key) bool {
+	if prefix == nil || prefix.String() == "" {
+		return true
+	}
+	switch prefix.KeyType() {
+	case key.KeyTypeString:
+		return key.Clean(prefix).String() == "/" || key.Clean(prefix).IsAncestorOf(k)
+	default:
+		return k.HasPrefix(prefix)
+	}
+}
+
+// Datastore wraps a datastore and publishes an event for every write going
+// through it once it succeeded, including the writes of batches once they
+// are committed. Writes made directly to the child datastore are not seen.
+//
+// If the child datastore is itself a Watcher, like a TTL datastore, its
+// EventExpire events are forwarded as expirations don't go through the
+// wrapper.
+type Datastore struct {
+	child ds.Datastore
+	hub   *Hub
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+var _ ds.WatchDatastore = (*Datastore)(nil)
+var _ ds.Batching = (*Datastore)(nil)
+var _ ds.TTLDatastore = (*Datastore)(nil)
+var _ ds.CASDatastore = (*Datastore)(nil)
+var _ ds.BulkDatastore = (*Datastore)(nil)
+var _ ds.PersistentDatastore = (*Datastore)(nil)
+var _ ds.Shim = (*Datastore)(nil)
+
+// Wrap wraps the given datastore to publish events for its writes.
+func Wrap(child ds.Datastore, opts Options) *Datastore {
+	if child == nil {
+		panic("child (ds.Datastore) is nil")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	d := &Datastore{
+		child:  child,
+		hub:    NewHub(opts),
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	if w, ok := child.(ds.Watcher); ok {
+		if events, err := w.Watch(ctx, nil); err == nil {
+			go d.forwardExpirations(ctx, w, events)
+			return d
+		}
+	}
+	close(d.done)
+	return d
+}
+
+func (d *Datastore) forwardExpirations(ctx context.Context, w ds.Watcher, events <-chan ds.Event) {
+	defer close(d.done)
+	for {
+		for e := range events {
+			if e.Type == ds.EventExpire {
+				d.hub.Publish(e)
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		// The child closed the channel because we were too slow, we can
+		// only have missed expirations so just watch again.
+		var err error
+		if events, err = w.Watch(ctx, nil); err != nil {
+			return
+		}
+	}
+}
+
+// Children implements Shim
+func (d *Datastore) Children() []ds.Datastore {
+	return []ds.Datastore{d.child}
+}
+
+// Capabilities implements Capable
+func (d *Datastore) Capabilities() ds.Feature {
+	return ds.FeatureWatch | ds.FeatureBatching |
+		ds.Features(d.child)&(ds.FeaturePersistent|ds.FeatureTTL|ds.FeatureBulk) |
+		ds.CASFeature(d.child)
+}
+
+// Watch implements Watcher.Watch
+func (d *Datastore) Watch(ctx context.Context, prefix key.Key) (<-chan ds.Event, error) {
+	return d.hub.Watch(ctx, prefix)
+}
+
+// Put implements Datastore.Put
+func (d *Datastore) Put(ctx context.Context, key key.Key, value []byte) error {
+	if err := d.child.Put(ctx, key, value); err != nil {
+		return err
+	}
+	d.hub.Publish(ds.Event{Type: ds.EventPut, Key: key, Value: value})
+	return nil
+}
+
+// Delete implements Datastore.Delete, an event is published even if the key
+// didn't exist.
+func (d *Datastore) Delete(ctx context.Context, key key.Key) error {
+	if err := d.child.Delete(ctx, key); err != nil {
+		return err
+	}
+	d.hub.Publish(ds.Event{Type: ds.EventDelete, Key: key})
+	return nil
+}
+
+// Sync implements Datastore.Sync
+func (d *Datastore) Sync(ctx context.Context, prefix key.Key) error {
+	return d.child.Sync(ctx, prefix)
+}
+
+// Get implements Datastore.Get
+func (d *Datastore) Get(ctx context.Context, key key.Key) ([]byte, error) {
+	return d.child.Get(ctx, key)
+}
+
+// Has implements Datastore.Has
+func (d *Datastore) Has(ctx context.Context, key key.Key) (bool, error) {
+	return d.child.Has(ctx, key)
+}
+
+// GetSize implements Datastore.GetSize
+func (d *Datastore) GetSize(ctx context.Context, key key.Key) (int, error) {
+	return d.child.GetSize(ctx, key)
+}
+
+// Query implements Datastore.Query
+func (d *Datastore) Query(ctx context.Context, q dsq.Query) (dsq.Results, error) {
+	return d.child.Query(ctx, q)
+}
+
+// PutWithTTL implements TTL.PutWithTTL
+func (d *Datastore) PutWithTTL(ctx context.Context, key key.Key, value []byte, ttl time.Duration) error {
+	t, ok := d.child.(ds.TTL)
+	if !ok {
+		return ds.ErrTTLUnsupported
+	}
+	if err := t.PutWithTTL(ctx, key, value, ttl); err != nil {
+		return err
+	}
+	d.hub.Publish(ds.Event{Type: ds.EventPut, Key: key, Value: value})
+	return nil
+}
+
+// SetTTL implements TTL.SetTTL
+func (d *Datastore) SetTTL(ctx context.Context, key key.Key, ttl time.Duration) error {
+	t, ok := d.child.(ds.TTL)
+	if !ok {
+		return ds.ErrTTLUnsupported
+	}
+	return t.SetTTL(ctx, key, ttl)
+}
+
+// GetExpiration implements TTL.GetExpiration
+func (d *Datastore) GetExpiration(ctx context.Context, key key.Key) (time.Time, error) {
+	t, ok := d.child.(ds.TTL)
+	if !ok {
+		return time.Time{}, ds.ErrTTLUnsupported
+	}
+	return t.GetExpiration(ctx, key)
+}
+
+// PutIfAbsent implements CAS.PutIfAbsent
+func (d *Datastore) PutIfAbsent(ctx context.Context, key key.Key, value []byte) (bool, error) {
+	ok, err := ds.PutIfAbsent(ctx, d.child, key, value)
+	if ok {
+		d.hub.Publish(ds.Event{Type: ds.EventPut, Key: key, Value: value})
+	}
+	return ok, err
+}
+
+// CompareAndSwap implements CAS.CompareAndSwap
+func (d *Datastore) CompareAndSwap(ctx context.Context, key key.Key, old, new []byte) (bool, error) {
+	ok, err := ds.CompareAndSwap(ctx, d.child, key, old, new)
+	if ok {
+		d.hub.Publish(ds.Event{Type: ds.EventPut, Key: key, Value: new})
+	}
+	return ok, err
+}
+
+// DeleteIfEqual implements CAS.DeleteIfEqual
+func (d *Datastore) DeleteIfEqual(ctx context.Context, key key.Key, value []byte) (bool, error) {
+	ok, err := ds.DeleteIfEqual(ctx, d.child, key, value)
+	if ok {
+		d.hub.Publish(ds.Event{Type: ds.EventDelete, Key: key})
+	}
+	return ok, err
+}
+
+// GetMany implements BulkRead.GetMany
+func (d *Datastore) GetMany(ctx context.Context, keys []key.Key) ([]ds.ValueResult, error) {
+	return ds.GetMany(ctx, d.child, keys)
+}
+
+// HasMany implements BulkRead.HasMany
+func (d *Datastore) HasMany(ctx context.Context, keys []key.Key) ([]ds.HasResult, error) {
+	return ds.HasMany(ctx, d.child, keys)
+}
+
+// GetSizeMany implements BulkRead.GetSizeMany
+func (d *Datastore) GetSizeMany(ctx context.Context, keys []key.Key) ([]ds.SizeResult, error) {
+	return ds.GetSizeMany(ctx, d.child, keys)
+}
+
+// PutMany implements BulkWrite.PutMany, events are only published if all
+// the values were stored.
+func (d *Datastore) PutMany(ctx context.Context, keys []key.Key, values [][]byte) error {
+	if err := ds.PutMany(ctx, d.child, keys, values); err != nil {
+		return err
+	}
+	events := make([]ds.Event, len(keys))
+	for i, k := range keys {
+		events[i] = ds.Event{Type: ds.EventPut, Key: k, Value: values[i]}
+	}
+	d.hub.Publish(events...)
+	return nil
+}
+
+// DiskUsage implements the PersistentDatastore interface.
+func (d *Datastore) DiskUsage(ctx context.Context) (uint64, error) {
+	return ds.DiskUsage(ctx, d.child)
+}
+
+// Batch implements Batching.Batch, the events of the batch are published
+// once it's committed.
+func (d *Datastore) Batch(ctx context.Context) (ds.Batch, error) {
+	bds, ok := d.child.(ds.Batching)
+	if !ok {
+		return ds.NewBasicBatch(d), nil
+	}
+
+	b, err := bds.Batch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &watchBatch{child: b, d: d}, nil
+}
+
+// Close closes the channels of the subscribers and the child datastore.
+func (d *Datastore) Close() error {
+	d.cancel()
+	<-d.done
+	d.hub.Close()
+	return d.child.Close()
+}
+
+type watchBatch struct {
+	child  ds.Batch
+	events []ds.Event
+
+	d *Datastore
+}
+
+func (b *watchBatch) Put(ctx context.Context, key key.Key, value []byte) error {
+	if err := b.child.Put(ctx, key, value); err != nil {
+		return err
+	}
+	b.events = append(b.events, ds.Event{Type: ds.EventPut, Key: key, Value: value})
+	return nil
+}
+
+func (b *watchBatch) Delete(ctx context.Context, key key.Key) error {
+	if err := b.child.Delete(ctx, key); err != nil {
+		return err
+	}
+	b.events = append(b.events, ds.Event{Type: ds.EventDelete, Key: key})
+	return nil
+}
+
+func (b *watchBatch) Commit(ctx context.Context) error {
+	if err := b.child.Commit(ctx); err != nil {
+		return err
+	}
+	b.d.hub.Publish(b.events...)
+	b.events = nil
+	return nil
+}
@@ -0,0 +1,237 @@
+// Copyright (c) 2020 DAOT Labs. All rights reserved. Use of this source
+// code is governed by MIT license that can be found in the LICENSE file.
+
+package watch_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	ds "github.com/daotl/go-datastore"
+	key "github.com/daotl/go-datastore/key"
+	dstest "github.com/daotl/go-datastore/test"
+	"github.com/daotl/go-datastore/ttl"
+	"github.com/daotl/go-datastore/watch"
+)
+
+func testSuite(t *testing.T, ktype key.KeyType) {
+	d := watch.Wrap(dstest.NewMapDatastoreForTest(t, ktype), watch.Options{})
+	defer d.Close()
+	dstest.SubtestAll(t, ktype, d)
+}
+
+func TestSuite(t *testing.T) {
+	testSuite(t, key.KeyTypeString)
+	testSuite(t, key.KeyTypeBytes)
+}
+
+func expect(t *testing.T, events <-chan ds.Event, typ ds.EventType, k string) ds.Event {
+	t.Helper()
+	select {
+	case e, ok := <-events:
+		if !ok {
+			t.Fatal("the channel was closed")
+		}
+		if e.Type != typ || e.Key.String() != k {
+			t.Fatalf("expected %s %s, got %s %s", typ, k, e.Type, e.Key)
+		}
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for %s %s", typ, k)
+	}
+	return ds.Event{}
+}
+
+func expectNone(t *testing.T, events <-chan ds.Event) {
+	t.Helper()
+	select {
+	case e, ok := <-events:
+		if !ok {
+			t.Fatal("the channel was closed")
+		}
+		t.Fatalf("unexpected event %s %s", e.Type, e.Key)
+	default:
+	}
+}
+
+func testEvents(t *testing.T, ktype key.KeyType) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	d := watch.Wrap(dstest.NewMapDatastoreForTest(t, ktype), watch.Options{})
+	defer d.Close()
+
+	k := func(s string) key.Key {
+		return key.NewKeyFromTypeAndString(ktype, s)
+	}
+	all, err := d.Watch(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	foo, err := d.Watch(ctx, k("/foo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.Put(ctx, k("/foo/a"), []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Put(ctx, k("/bar"), []byte("bar")); err != nil {
+		t.Fatal(err)
+	}
+	if e := expect(t, all, ds.EventPut, "/foo/a"); string(e.Value) != "a" {
+		t.Fatalf("unexpected value %q", e.Value)
+	}
+	expect(t, all, ds.EventPut, "/bar")
+	expect(t, foo, ds.EventPut, "/foo/a")
+	expectNone(t, foo)
+
+	b, err := d.Batch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Put(ctx, k("/foo/b"), []byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Delete(ctx, k("/foo/a")); err != nil {
+		t.Fatal(err)
+	}
+	expectNone(t, foo)
+	if err := b.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, foo, ds.EventPut, "/foo/b")
+	expect(t, foo, ds.EventDelete, "/foo/a")
+
+	if ok, err := d.PutIfAbsent(ctx, k("/foo/b"), []byte("c")); ok || err != nil {
+		t.Fatal("PutIfAbsent should have failed: ", err)
+	}
+	if ok, err := d.CompareAndSwap(ctx, k("/foo/b"), []byte("b"), []byte("c")); !ok || err != nil {
+		t.Fatal("CompareAndSwap should have succeeded: ", err)
+	}
+	if e := expect(t, foo, ds.EventPut, "/foo/b"); string(e.Value) != "c" {
+		t.Fatalf("unexpected value %q", e.Value)
+	}
+	expectNone(t, foo)
+
+	cancel()
+	for range foo {
+	}
+}
+
+func TestEvents(t *testing.T) {
+	testEvents(t, key.KeyTypeString)
+	testEvents(t, key.KeyTypeBytes)
+}
+
+func TestOverflow(t *testing.T) {
+	ctx := context.Background()
+	k := key.NewStrKey("/foo")
+
+	// The channel is closed.
+	d := watch.Wrap(dstest.NewMapDatastoreForTest(t, key.KeyTypeString), watch.Options{BufferSize: 1})
+	events, err := d.Watch(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Put(ctx, k, nil)
+	d.Put(ctx, k, nil)
+	expect(t, events, ds.EventPut, "/foo")
+	if _, ok := <-events; ok {
+		t.Fatal("expected the channel to be closed")
+	}
+	d.Close()
+
+	// The events are dropped.
+	d = watch.Wrap(dstest.NewMapDatastoreForTest(t, key.KeyTypeString), watch.Options{BufferSize: 1, Overflow: watch.OverflowDrop})
+	events, err = d.Watch(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Put(ctx, k, nil)
+	d.Put(ctx, k, nil)
+	expect(t, events, ds.EventPut, "/foo")
+	expectNone(t, events)
+	d.Close()
+	if _, ok := <-events; ok {
+		t.Fatal("expected the channel to be closed with the datastore")
+	}
+
+	// The writes are blocked.
+	d = watch.Wrap(dstest.NewMapDatastoreForTest(t, key.KeyTypeString), watch.Options{BufferSize: 1, Overflow: watch.OverflowBlock})
+	defer d.Close()
+	wctx, cancel := context.WithCancel(ctx)
+	events, err = d.Watch(wctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Put(ctx, k, nil)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		d.Put(ctx, k, nil)
+	}()
+	select {
+	case <-done:
+		t.Fatal("the write should be blocked")
+	case <-time.After(50 * time.Millisecond):
+	}
+	expect(t, events, ds.EventPut, "/foo")
+	<-done
+	expect(t, events, ds.EventPut, "/foo")
+
+	// Cancelling the subscriber unblocks the writes.
+	d.Put(ctx, k, nil)
+	done = make(chan struct{})
+	go func() {
+		defer close(done)
+		d.Put(ctx, k, nil)
+	}()
+	cancel()
+	<-done
+}
+
+type mockClock struct {
+	lk  sync.Mutex
+	now time.Time
+}
+
+func (c *mockClock) Now() time.Time {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	return c.now
+}
+
+func (c *mockClock) Add(d time.Duration) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func TestExpire(t *testing.T) {
+	ctx := context.Background()
+	clock := &mockClock{now: time.Unix(1600000000, 0)}
+	child := ttl.New(dstest.NewMapDatastoreForTest(t, key.KeyTypeString), ttl.Options{Clock: clock, SweepInterval: -1})
+	d := watch.Wrap(child, watch.Options{})
+	defer d.Close()
+
+	if !ds.Features(d).Has(ds.FeatureWatch | ds.FeatureTTL) {
+		t.Fatal("expected the datastore to support watching and TTL, got: ", ds.Features(d))
+	}
+
+	events, err := d.Watch(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.PutWithTTL(ctx, key.NewStrKey("/foo"), []byte("bar"), time.Second); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, events, ds.EventPut, "/foo")
+
+	clock.Add(time.Second)
+	if err := child.Sweep(ctx); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, events, ds.EventExpire, "/foo")
+}